@@ -0,0 +1,62 @@
+// Description: Resident chaos agent for the testenv.
+// Installed once by the monitor (`chaos-agent install-agent <host>`), it keeps an authenticated,
+// encrypted channel to the monitor and runs signed break instructions from the compiled-in registry.
+package main
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/agent"
+	"chaos-agent/library/channel"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	id := flag.String("id", "", "agent id (the monitor's name for this host)")
+	monitor := flag.String("monitor", "", "monitor agent listener, host:port")
+	keyPath := flag.String("key", "/etc/chaos-agent/agent.key", "agent identity key")
+	monitorKeyPath := flag.String("monitor-key", "/etc/chaos-agent/monitor.pub", "pinned monitor public key")
+	recovery := flag.String("recover", "resume", "unfinished mutations from a previous run: resume, rollback or off")
+	flag.Parse()
+
+	if *id == "" {
+		log.Fatal("-id is required")
+	}
+	addr, err := agent.ParseMonitorAddr(*monitor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	identity, err := channel.ReadPrivateKey(*keyPath)
+	if err != nil {
+		log.Fatalf("read agent key: %v", err)
+	}
+	monitorKey, err := channel.ReadPublicKey(*monitorKeyPath)
+	if err != nil {
+		log.Fatalf("read monitor key: %v", err)
+	}
+
+	mode := library.RecoveryMode(*recovery)
+	switch mode {
+	case library.RecoverResume, library.RecoverRollback:
+	case "off":
+		mode = ""
+	default:
+		log.Fatalf("-recover must be resume, rollback or off, got %q", *recovery)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := agent.Run(ctx, agent.Config{
+		AgentID:     *id,
+		MonitorAddr: addr,
+		MonitorKey:  monitorKey,
+		Identity:    identity,
+		Recovery:    mode,
+	}); err != nil {
+		log.Fatalf("agent: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Description: `chaos-agent install-agent <host>` installs the resident agent on a testenv.
+// It builds the agent for the host's architecture, issues it an identity key that the
+// monitor authorizes, pins the monitor's public key on the host and enables a systemd service.
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chaos-agent/library"
+	"chaos-agent/library/channel"
+	"chaos-agent/library/remote"
+)
+
+const (
+	agentBinPath     = "/usr/local/sbin/chaos-agentd"
+	agentKeyPath     = "/etc/chaos-agent/agent.key"
+	agentMonitorPub  = "/etc/chaos-agent/monitor.pub"
+	agentUnitPath    = "/etc/systemd/system/chaos-agentd.service"
+	defaultAgentPort = "7443"
+)
+
+// agentAdvertiseAddr is the monitor address agents dial: MONITOR_ADDRESS plus the agent listener's port.
+func agentAdvertiseAddr() (string, error) {
+	host := os.Getenv("MONITOR_ADDRESS")
+	if host == "" {
+		return "", fmt.Errorf("MONITOR_ADDRESS not set")
+	}
+	port := defaultAgentPort
+	if l := os.Getenv("CHAOS_AGENT_LISTEN"); l != "" {
+		_, p, err := net.SplitHostPort(l)
+		if err != nil {
+			return "", fmt.Errorf("CHAOS_AGENT_LISTEN: %w", err)
+		}
+		port = p
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func agentUnitFile(id, monitorAddr string) string {
+	return fmt.Sprintf(`[Unit]
+Description=Chaos resident agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%s -id %s -monitor %s -key %s -monitor-key %s
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, agentBinPath, id, monitorAddr, agentKeyPath, agentMonitorPub)
+}
+
+// installAgent deploys and enables the resident agent on host. The agent id is the host alias.
+func installAgent(ctx context.Context, host string) error {
+	monitorAddr, err := agentAdvertiseAddr()
+	if err != nil {
+		return err
+	}
+	identity, err := monitorIdentity()
+	if err != nil {
+		return fmt.Errorf("monitor identity: %w", err)
+	}
+	plat, err := detectPlatform(ctx, host)
+	if err != nil {
+		return err
+	}
+	bin, err := compileAgentBinary(plat)
+	if err != nil {
+		return fmt.Errorf("build agent: %w", err)
+	}
+
+	agentPub, agentKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	staging, err := os.MkdirTemp("", "chaos-agent-install-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+	files := map[string]string{
+		"key":  channel.EncodePrivateKey(agentKey) + "\n",
+		"pub":  channel.EncodePublicKey(identity.Public().(ed25519.PublicKey)) + "\n",
+		"unit": agentUnitFile(host, monitorAddr),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(staging, name), []byte(content), 0o600); err != nil {
+			return err
+		}
+	}
+
+	c, err := sshPool.Get(ctx, host)
+	if err != nil {
+		return err
+	}
+	token, err := library.GenerateToken(12)
+	if err != nil {
+		return err
+	}
+	tmp := remoteSessionPrefix + "install-" + token
+	uploads := []struct {
+		local, remote string
+		mode          os.FileMode
+	}{
+		{bin, tmp + ".bin", 0o700},
+		{filepath.Join(staging, "key"), tmp + ".key", 0o600},
+		{filepath.Join(staging, "pub"), tmp + ".pub", 0o600},
+		{filepath.Join(staging, "unit"), tmp + ".unit", 0o600},
+	}
+	defer func() {
+		for _, u := range uploads {
+			_ = c.Remove(u.remote)
+		}
+	}()
+	for _, u := range uploads {
+		if err := c.Upload(ctx, u.local, u.remote, u.mode); err != nil {
+			return err
+		}
+	}
+
+	// Authorize before starting the service so its first connection is accepted.
+	if err := authorizeAgent(host, agentPub); err != nil {
+		return fmt.Errorf("authorize agent: %w", err)
+	}
+
+	q := remote.ShellQuote
+	script := strings.Join([]string{
+		"set -e",
+		"install -D -m 0755 " + q(tmp+".bin") + " " + q(agentBinPath),
+		"install -D -m 0600 " + q(tmp+".key") + " " + q(agentKeyPath),
+		"install -D -m 0644 " + q(tmp+".pub") + " " + q(agentMonitorPub),
+		"install -D -m 0644 " + q(tmp+".unit") + " " + q(agentUnitPath),
+		"systemctl daemon-reload",
+		"systemctl enable chaos-agentd",
+		"systemctl restart chaos-agentd",
+	}, "\n")
+	if _, err := runRemote(ctx, nil, host, "sh -s", strings.NewReader(script)); err != nil {
+		return fmt.Errorf("install agent service: %w", err)
+	}
+	fmt.Printf("🛰️ Installed agent on %s (%s, dials %s)\n", host, plat, monitorAddr)
+	return nil
+}
+
+// runInstallAgent handles the install-agent subcommand.
+func runInstallAgent(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: chaos-agent install-agent <host>")
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	defer sshPool.Close()
+	if err := installAgent(ctx, args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "install-agent %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
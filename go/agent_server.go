@@ -0,0 +1,340 @@
+// Description: Monitor side of the resident agent channel.
+// When CHAOS_AGENT_LISTEN is set, the monitor accepts authenticated agent connections,
+// learns each host's facts, and runs cycles by sending signed instructions instead of
+// compiling, uploading and starting a break over SSH. Break messages stream back over the
+// channel and go through the same handleChaosMessage path as TCP reports.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chaos-agent/library"
+	"chaos-agent/library/breaks"
+	"chaos-agent/library/channel"
+	datatypes "chaos-agent/library/types"
+)
+
+// instructionTTL is how long a signed instruction stays valid.
+const instructionTTL = 2 * time.Minute
+
+// agentConn is one connected, authenticated agent.
+type agentConn struct {
+	ID        string
+	Conn      *channel.Conn
+	Facts     channel.Facts
+	Connected time.Time
+}
+
+var (
+	agents   = make(map[string]*agentConn)
+	agentsMu sync.Mutex
+
+	// agentWaiters are closed when the agent reports operation_complete for a token.
+	agentWaiters   = make(map[string]chan struct{})
+	agentWaitersMu sync.Mutex
+)
+
+// stateDir holds the monitor's long-lived keys and the authorized agent list.
+func stateDir() (string, error) {
+	if d := os.Getenv("CHAOS_STATE_DIR"); d != "" {
+		return d, nil
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "chaos-agent"), nil
+}
+
+// monitorIdentity loads (or creates on first use) the monitor's Ed25519 identity key.
+func monitorIdentity() (ed25519.PrivateKey, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	return channel.LoadOrCreatePrivateKey(filepath.Join(dir, "monitor_ed25519"))
+}
+
+func authorizedAgentsPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "authorized_agents"), nil
+}
+
+// loadAuthorizedAgents reads "<agent-id> <base64 ed25519 public key>" lines into pubkey -> id.
+func loadAuthorizedAgents() (map[string]string, error) {
+	p, err := authorizedAgentsPath()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	// #nosec G304 -- monitor's own state file
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		out[fields[1]] = fields[0]
+	}
+	return out, sc.Err()
+}
+
+// authorizeAgent appends id's public key to the authorized agents file.
+func authorizeAgent(id string, pub ed25519.PublicKey) error {
+	p, err := authorizedAgentsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	// #nosec G304 -- monitor's own state file
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", id, channel.EncodePublicKey(pub)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// startAgentListener accepts agent connections on addr until ctx ends.
+func startAgentListener(ctx context.Context, addr string) error {
+	identity, err := monitorIdentity()
+	if err != nil {
+		return fmt.Errorf("monitor identity: %w", err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("agent listener on %s: %w", addr, err)
+	}
+	fmt.Println("AGENT LISTENER:", l.Addr())
+	context.AfterFunc(ctx, func() { _ = l.Close() })
+
+	go func() {
+		for {
+			raw, err := l.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Printf("agent accept: %v", err)
+				continue
+			}
+			go serveAgent(raw, identity)
+		}
+	}()
+	return nil
+}
+
+// serveAgent authenticates one agent and reads its frames until the connection ends.
+func serveAgent(raw net.Conn, identity ed25519.PrivateKey) {
+	allowed, err := loadAuthorizedAgents()
+	if err != nil {
+		log.Printf("agent %s: load authorized agents: %v", raw.RemoteAddr(), err)
+		_ = raw.Close()
+		return
+	}
+	conn, err := channel.Server(raw, identity, func(pub ed25519.PublicKey) bool {
+		_, ok := allowed[channel.EncodePublicKey(pub)]
+		return ok
+	})
+	if err != nil {
+		log.Printf("agent %s: handshake: %v", raw.RemoteAddr(), err)
+		_ = raw.Close()
+		return
+	}
+	// The authorized key, not anything the agent says, decides which host this is.
+	id := allowed[channel.EncodePublicKey(conn.Peer)]
+	ac := &agentConn{ID: id, Conn: conn, Connected: time.Now()}
+
+	agentsMu.Lock()
+	if old, ok := agents[id]; ok {
+		_ = old.Conn.Close()
+	}
+	agents[id] = ac
+	agentsMu.Unlock()
+	fmt.Printf("🛰️ Agent %s connected from %s\n", id, conn.RemoteAddr())
+
+	defer func() {
+		agentsMu.Lock()
+		if agents[id] == ac {
+			delete(agents, id)
+		}
+		agentsMu.Unlock()
+		_ = conn.Close()
+		fmt.Printf("🛰️ Agent %s disconnected\n", id)
+	}()
+
+	for {
+		var f channel.Frame
+		if err := conn.ReadFrame(&f); err != nil {
+			return
+		}
+		switch f.Type {
+		case channel.FrameFacts:
+			if f.Facts == nil {
+				continue
+			}
+			if f.Facts.AgentID != id {
+				log.Printf("agent %s claims id %q; ignoring claim", id, f.Facts.AgentID)
+			}
+			agentsMu.Lock()
+			ac.Facts = *f.Facts
+			agentsMu.Unlock()
+			if _, err := recordHostMachine(id, f.Facts.Machine); err != nil {
+				log.Printf("agent %s: %v", id, err)
+			}
+		case channel.FrameMessage:
+			if f.Message == nil {
+				continue
+			}
+			handleAgentMessage(*f.Message)
+			if f.Message.Status == "unrecovered" {
+				go recoverAgentSession(id, f.Message.Token)
+			}
+		}
+	}
+}
+
+// handleAgentMessage feeds a break message from the channel through the normal message path.
+func handleAgentMessage(msg datatypes.ChaosMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	handleChaosMessage(string(b))
+	if msg.Status != "operation_complete" {
+		return
+	}
+	agentWaitersMu.Lock()
+	if ch, ok := agentWaiters[msg.Token]; ok {
+		close(ch)
+		delete(agentWaiters, msg.Token)
+	}
+	agentWaitersMu.Unlock()
+}
+
+// connectedAgent returns the live agent for host, if any.
+func connectedAgent(host string) *agentConn {
+	agentsMu.Lock()
+	defer agentsMu.Unlock()
+	return agents[host]
+}
+
+// runAgentCycle runs breakName for sess through host's resident agent and waits for it to finish.
+func runAgentCycle(ctx context.Context, ac *agentConn, breakName string, sess *sessionRecord) error {
+	vaultKey, err := newSessionVaultKey(sess.ID)
+	if err != nil {
+		return err
+	}
+	sessionsMu.Lock()
+	sess.Agent = ac.ID
+	sessionsMu.Unlock()
+	return runAgentInstruction(ctx, ac, channel.Instruction{
+		Break:        breakName,
+		Token:        sess.ID,
+		Seed:         sess.Seed,
+		Params:       map[string]string{},
+		DelaySeconds: int64(sess.Delay / time.Second),
+		VaultKey:     vaultKey,
+		VaultMode:    vaultMode(),
+	})
+}
+
+// runAgentInstruction signs in, sends it to ac and waits for its operation_complete.
+func runAgentInstruction(ctx context.Context, ac *agentConn, in channel.Instruction) error {
+	identity, err := monitorIdentity()
+	if err != nil {
+		return err
+	}
+	if in.ID, err = library.GenerateToken(16); err != nil {
+		return err
+	}
+	in.IssuedAt = time.Now()
+	in.ExpiresAt = in.IssuedAt.Add(instructionTTL)
+	if err := in.Sign(identity); err != nil {
+		return fmt.Errorf("sign instruction: %w", err)
+	}
+
+	done := make(chan struct{})
+	agentWaitersMu.Lock()
+	agentWaiters[in.Token] = done
+	agentWaitersMu.Unlock()
+	defer func() {
+		agentWaitersMu.Lock()
+		delete(agentWaiters, in.Token)
+		agentWaitersMu.Unlock()
+	}()
+
+	if err := ac.Conn.WriteFrame(channel.Frame{Type: channel.FrameInstruction, Instruction: &in}); err != nil {
+		return fmt.Errorf("send instruction: %w", err)
+	}
+	fmt.Printf("📨 Sent %s to agent %s (instruction %s)\n", in.Break, ac.ID, in.ID)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("agent %s did not complete %s: %w", ac.ID, in.Token, ctx.Err())
+	}
+}
+
+// recoverAgentSession answers an agent's "unrecovered" report: it puts back any originals the
+// session streamed to the monitor and sends the session's vault key in a recover instruction,
+// so the agent can revert the mutations a restart interrupted.
+func recoverAgentSession(host, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	key, err := sessionVaultKey(token)
+	if err != nil {
+		log.Printf("recover %s on %s: %v", token, host, err)
+		return
+	}
+	if err := uploadMonitorVault(ctx, host, token); err != nil {
+		log.Printf("recover %s on %s: upload vault: %v", token, host, err)
+		return
+	}
+	ac := connectedAgent(host)
+	if ac == nil {
+		log.Printf("recover %s: agent %s is not connected", token, host)
+		return
+	}
+	if err := runAgentInstruction(ctx, ac, channel.Instruction{Break: breaks.RecoverName, Token: token, VaultKey: key}); err != nil {
+		log.Printf("recover %s on %s: %v", token, host, err)
+	}
+}
+
+// cancelAgentBreak asks the agent running s's break to stop it.
+func cancelAgentBreak(_ context.Context, s *sessionRecord) error {
+	ac := connectedAgent(s.Agent)
+	if ac == nil {
+		return fmt.Errorf("agent %s is not connected", s.Agent)
+	}
+	return ac.Conn.WriteFrame(channel.Frame{Type: channel.FrameCancel, Token: s.ID})
+}
@@ -0,0 +1,123 @@
+// Description: Runs a session's break as a transient systemd unit on the testenv.
+// The break is detached from the SSH session, capped in runtime, CPU and memory, and logs
+// to journald. The monitor polls the unit, collects its exit status and journal into the
+// session record, and stops it on abort.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chaos-agent/library"
+	"chaos-agent/library/remote"
+)
+
+const (
+	breakRuntimeMax   = 5 * time.Minute
+	breakCPUQuota     = "50%"
+	breakMemoryMax    = "256M"
+	unitPollInterval  = 2 * time.Second
+	unitAbortDeadline = 30 * time.Second
+)
+
+// unitNameFor returns the transient unit name for a session.
+func unitNameFor(s *sessionRecord) string {
+	return "chaos-break-" + s.ID
+}
+
+// launchBreakUnit uploads the binary, its sealed config and the config key, then starts the
+// break as a transient unit. The key is written to a 0600 file through the SSH session's
+// stdin, so neither the command line nor the unit's properties carry it; the break deletes
+// it and the sealed config once read. ExecStopPost removes every remote file.
+func launchBreakUnit(ctx context.Context, s *sessionRecord, keyB64, blobB64 string) error {
+	localCfg := filepath.Join(s.LocalDir, "config.sealed")
+	if err := os.WriteFile(localCfg, []byte(blobB64), 0o600); err != nil {
+		return fmt.Errorf("write sealed config: %w", err)
+	}
+
+	c, err := sshPool.Get(ctx, s.Host)
+	if err != nil {
+		return err
+	}
+	if err := c.Upload(ctx, s.LocalBin, s.RemoteBin, 0o700); err != nil {
+		return fmt.Errorf("upload binary: %w", err)
+	}
+	if err := c.Upload(ctx, localCfg, s.RemoteConfig, 0o600); err != nil {
+		return fmt.Errorf("upload sealed config: %w", err)
+	}
+	res, err := c.WriteSecret(ctx, s.RemoteKey, library.ConfigEnvelope(keyB64, ""))
+	recordRun(s, res)
+	if err != nil {
+		return fmt.Errorf("write config key: %w", err)
+	}
+
+	spec := remote.UnitSpec{
+		Name:        unitNameFor(s),
+		Description: "chaos break " + filepath.Base(s.Source),
+		Command:     []string{s.RemoteBin, "--config", s.RemoteConfig, "--key", s.RemoteKey},
+		RuntimeMax:  breakRuntimeMax + s.Delay, // a delayed break sleeps inside its unit
+		CPUQuota:    breakCPUQuota,
+		MemoryMax:   breakMemoryMax,
+		Cleanup:     []string{s.RemoteBin, s.RemoteConfig, s.RemoteKey},
+	}
+	res, err = c.StartUnit(ctx, spec)
+	recordRun(s, res)
+	if err != nil {
+		return err
+	}
+	setSessionUnit(s, spec.Name)
+	return nil
+}
+
+// followBreakUnit waits for the session's unit to finish, then records its status and
+// journal and unloads it. If ctx ends first (abort or timeout) the unit is stopped.
+func followBreakUnit(ctx context.Context, s *sessionRecord) (remote.UnitStatus, error) {
+	c, err := sshPool.Get(ctx, s.Host)
+	if err != nil {
+		return remote.UnitStatus{}, err
+	}
+	st, waitErr := c.WaitUnit(ctx, s.Unit, unitPollInterval)
+
+	// Collection must still happen after an abort, so use a fresh deadline.
+	cctx, cancel := context.WithTimeout(context.Background(), unitAbortDeadline)
+	defer cancel()
+	if waitErr != nil {
+		log.Printf("stopping unit %s: %v", s.Unit, waitErr)
+	}
+	if err := stopSessionUnit(cctx, s); err != nil {
+		log.Printf("stop unit %s: %v", s.Unit, err)
+	}
+	if waitErr == nil {
+		// A stopped unit that exited is unloaded; the status captured before stop is authoritative.
+		recordUnitStatus(s, st)
+	}
+	if logs, err := c.UnitLogs(cctx, s.Unit); err == nil {
+		recordUnitLogs(s, logs)
+	} else {
+		log.Printf("collect journal for %s: %v", s.Unit, err)
+	}
+
+	if waitErr != nil {
+		return st, fmt.Errorf("unit %s did not finish: %w", s.Unit, waitErr)
+	}
+	if !st.Succeeded() {
+		return st, fmt.Errorf("unit %s finished with result=%s status=%d", s.Unit, st.Result, st.ExecMainStatus)
+	}
+	return st, nil
+}
+
+// stopSessionUnit stops the session's unit on its host; its ExecStopPost removes the remote artifacts.
+func stopSessionUnit(ctx context.Context, s *sessionRecord) error {
+	if s.Unit == "" {
+		return nil
+	}
+	c, err := sshPool.Get(ctx, s.Host)
+	if err != nil {
+		return err
+	}
+	return c.StopUnit(ctx, s.Unit)
+}
@@ -1,57 +1,10 @@
-// monitor/go/breaks/picker_main.go
+// Description: Exchanges the content of 15–20 randomly chosen system binaries between each other,
+// as one cycle or as pairwise swaps (param "permutation": cycle, pairs, same_dir, similar_size).
+// The implementation lives in the compiled-in break registry (chaos-agent/library/breaks).
 package main
 
-import (
-	// Replace "yourmodule" with the module path from your go.mod
-	"chaos-agent/library"
-	"fmt"
-	"log"
-	"os"
-	"strconv"
-)
-
-var (
-	MonitorIP      string
-	MonitorPortStr string
-	MonitorPort    int
-	Token          string
-	EncryptionKey  string
-)
-
-func init() {
-	if MonitorPortStr != "" {
-		p, err := strconv.Atoi(MonitorPortStr)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid MonitorPortStr %q: %v\n", MonitorPortStr, err)
-		} else {
-			MonitorPort = p
-		}
-	}
-	var err error
-	Token, err = library.GenerateToken(16)
-	if err != nil {
-		log.Fatalf("failed to generate token: %v", err)
-	}
-	library.SendMessage(MonitorIP, MonitorPort, "init", Token, Token, EncryptionKey)
-}
+import "chaos-agent/library/breaks"
 
 func main() {
-	// Intentionally ignore the returned list for now; no logging/output per your request.
-	fmt.Println("Starting file swap chaos operation...")
-	files, err := library.PickRandomBinaries()
-	if err != nil {
-		msg := fmt.Sprintf("failed to pick random binaries: %v", err)
-		library.SendMessage(MonitorIP, MonitorPort, "error", msg, Token, EncryptionKey)
-		return
-	}
-	if err == nil {
-		library.SendMessage(MonitorIP, MonitorPort, "chaos_report", fmt.Sprintf("files to be jumbled: %s", files), Token, EncryptionKey)
-	}
-	err = library.CyclicJumble(files)
-	if err != nil {
-		msg := fmt.Sprintf("error in CyclicJumble: %v", err)
-		library.SendMessage(MonitorIP, MonitorPort, "error", msg, Token, EncryptionKey)
-		return
-	}
-	library.SendMessage(MonitorIP, MonitorPort, "operation_complete", "complete", Token, EncryptionKey)
+	breaks.Main("file_swap")
 }
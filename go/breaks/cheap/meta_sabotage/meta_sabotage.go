@@ -0,0 +1,10 @@
+// Description: Damages the metadata of 15–20 randomly chosen system binaries, not their content:
+// execute bit, setuid, ownership, SELinux label or file capabilities (param "sabotage").
+// The implementation lives in the compiled-in break registry (chaos-agent/library/breaks).
+package main
+
+import "chaos-agent/library/breaks"
+
+func main() {
+	breaks.Main("meta_sabotage")
+}
@@ -0,0 +1,251 @@
+// Description: Content-addressed cache for compiled break binaries.
+// A break is rebuilt only when its source, the shared library, go.mod/go.sum,
+// the Go toolchain or the build environment change. Nothing session-specific is compiled in.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	goVersionOnce sync.Once
+	goVersion     string
+	goVersionErr  error
+
+	// buildLocks serialises builds of the same cache key within this process.
+	buildLocks   = make(map[string]*sync.Mutex)
+	buildLocksMu sync.Mutex
+)
+
+// buildCacheDir returns the root of the build cache, honouring CHAOS_BUILD_CACHE.
+func buildCacheDir() (string, error) {
+	if d := os.Getenv("CHAOS_BUILD_CACHE"); d != "" {
+		return d, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "chaos-agent", "builds"), nil
+}
+
+// buildEnv is the explicit environment used for every break build for plat.
+// It is part of the cache key.
+func buildEnv(plat targetPlatform) []string {
+	env := []string{
+		"GOOS=linux",
+		"GOARCH=" + plat.GOARCH,
+		"CGO_ENABLED=0",
+		"HOME=/tmp",
+		// clear potentially dangerous vars
+		"GOFLAGS=",
+		"GOTOOLCHAIN=local",
+	}
+	if plat.GOARM != "" {
+		env = append(env, "GOARM="+plat.GOARM)
+	}
+	return env
+}
+
+// toolchainVersion asks the resolved go tool for its version once per process.
+func toolchainVersion(goBin string) (string, error) {
+	goVersionOnce.Do(func() {
+		// #nosec G204 -- explicit tool path, fixed arguments
+		out, err := exec.Command(goBin, "env", "GOVERSION").Output()
+		if err != nil {
+			goVersionErr = fmt.Errorf("go env GOVERSION: %w", err)
+			return
+		}
+		goVersion = strings.TrimSpace(string(out))
+	})
+	return goVersion, goVersionErr
+}
+
+// buildCacheKey hashes everything that can change the produced binary.
+func buildCacheKey(absSrc, moduleDir, toolchain string, env []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "toolchain=%s\n", toolchain)
+	for _, e := range env {
+		fmt.Fprintf(h, "env=%s\n", e)
+	}
+
+	files := []string{absSrc, filepath.Join(moduleDir, "go.mod"), filepath.Join(moduleDir, "go.sum")}
+	libFiles, err := goSources(filepath.Join(moduleDir, "library"))
+	if err != nil {
+		return "", err
+	}
+	files = append(files, libFiles...)
+
+	for _, f := range files {
+		if err := hashFileInto(h, moduleDir, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// goSources lists non-test .go files under root in a stable order.
+func goSources(root string) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".go") && !strings.HasSuffix(p, "_test.go") {
+			out = append(out, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func hashFileInto(h io.Writer, moduleDir, path string) error {
+	// #nosec G304 -- paths come from our own module tree
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("hash %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	rel, err := filepath.Rel(moduleDir, path)
+	if err != nil {
+		rel = path
+	}
+	fmt.Fprintf(h, "file=%s\n", filepath.ToSlash(rel))
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("hash %s: %w", path, err)
+	}
+	_, err = h.Write([]byte{0})
+	return err
+}
+
+func lockForKey(key string) *sync.Mutex {
+	buildLocksMu.Lock()
+	defer buildLocksMu.Unlock()
+	m, ok := buildLocks[key]
+	if !ok {
+		m = &sync.Mutex{}
+		buildLocks[key] = m
+	}
+	return m
+}
+
+// compileChaosBinary returns the path of a cached break binary for sourcePath built for plat,
+// building it only on a cache miss. The binary carries no session configuration.
+func compileChaosBinary(sourcePath string, plat targetPlatform) (string, error) {
+	return buildCached(sourcePath, "breaks", plat)
+}
+
+// compileAgentBinary returns the cached resident agent binary built for plat.
+func compileAgentBinary(plat targetPlatform) (string, error) {
+	return buildCached(filepath.Join("agent", "agent.go"), "agent", plat)
+}
+
+// compileRollbackBinary returns the cached one-shot rollback tool built for plat.
+func compileRollbackBinary(plat targetPlatform) (string, error) {
+	return buildCached(filepath.Join("rollback", "rollback.go"), "rollback", plat)
+}
+
+// buildCached builds sourcePath (which must live under ./<trustedDir>) for plat through the cache.
+func buildCached(sourcePath, trustedDir string, plat targetPlatform) (string, error) {
+	// Guardrail 1: only build .go files under the trusted directory
+	absSrc, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("abs source: %w", err)
+	}
+	allowedDir, err := filepath.Abs(trustedDir)
+	if err != nil {
+		return "", fmt.Errorf("abs %s: %w", trustedDir, err)
+	}
+	if filepath.Ext(absSrc) != ".go" || !strings.HasPrefix(absSrc, allowedDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("refusing to build untrusted source path: %q", absSrc)
+	}
+	moduleDir := filepath.Dir(allowedDir)
+
+	// Guardrail 2: resolve the go tool explicitly
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return "", fmt.Errorf("go tool not found: %w", err)
+	}
+	toolchain, err := toolchainVersion(goBin)
+	if err != nil {
+		return "", err
+	}
+
+	env := buildEnv(plat)
+	key, err := buildCacheKey(absSrc, moduleDir, toolchain, env)
+	if err != nil {
+		return "", fmt.Errorf("cache key: %w", err)
+	}
+	cacheRoot, err := buildCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cache dir: %w", err)
+	}
+	// Entries are grouped per architecture; the key already covers GOARCH/GOARM.
+	entryDir := filepath.Join(cacheRoot, plat.String(), key[:2], key)
+	outputPath := filepath.Join(entryDir, "break_tool")
+
+	lk := lockForKey(key)
+	lk.Lock()
+	defer lk.Unlock()
+
+	if fi, err := os.Stat(outputPath); err == nil && fi.Mode().IsRegular() {
+		fmt.Printf("♻️ Build cache hit %s (%s)\n", key[:12], plat)
+		return outputPath, nil
+	}
+	if err := os.MkdirAll(entryDir, 0o700); err != nil {
+		return "", fmt.Errorf("create cache entry: %w", err)
+	}
+
+	// Build into a temp name in the entry dir, then rename, so readers never see a partial binary.
+	tmpOut := filepath.Join(entryDir, fmt.Sprintf(".break_tool-%d", time.Now().UnixNano()))
+	defer func() { _ = os.Remove(tmpOut) }()
+
+	// Optional: timeout so builds can’t hang this ephemeral service
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// #nosec G204 -- argv validated (source restricted to ./breaks, ./agent or ./rollback); explicit tool path; no shell used
+	cmd := exec.CommandContext(ctx, goBin, "build", "-trimpath", "-ldflags=-s -w", "-o", tmpOut, absSrc)
+	cmd.Dir = moduleDir
+	// Guardrail 3: explicit env (avoid inherited GOFLAGS/-toolexec/etc.)
+	cmd.Env = append(env, goCacheEnv()...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to compile chaos binary: %w", err)
+	}
+	if err := os.Rename(tmpOut, outputPath); err != nil {
+		return "", fmt.Errorf("publish cache entry: %w", err)
+	}
+	fmt.Printf("🔨 Built %s for %s into cache %s\n", filepath.Base(absSrc), plat, key[:12])
+	return outputPath, nil
+}
+
+// goCacheEnv forwards the caller's Go build and module caches so cache misses stay fast.
+// These do not affect the produced binary and are deliberately not part of the key.
+func goCacheEnv() []string {
+	var out []string
+	for _, k := range []string{"GOCACHE", "GOMODCACHE", "GOPATH"} {
+		if v := os.Getenv(k); v != "" {
+			out = append(out, k+"="+v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+// Description: Monitor-side tracking of delayed breaks.
+// A delayed break reports "armed" when it starts waiting and "detonated" when it fires.
+// Armed-but-not-fired breaks are tracked here so the listener stays open for them and
+// an operator can disarm them (SIGUSR1 disarms every armed break).
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// armedBreak is a delayed break that has armed itself but not yet fired.
+type armedBreak struct {
+	Token      string
+	DetonateAt time.Time
+	ArmedAt    time.Time
+}
+
+var (
+	armedBreaks   = make(map[string]armedBreak)
+	armedBreaksMu sync.Mutex
+)
+
+// pickDetonationDelay returns a random delay in [0, CHAOS_MAX_DETONATION_DELAY].
+// Unset or invalid means every break fires immediately.
+func pickDetonationDelay() time.Duration {
+	v := os.Getenv("CHAOS_MAX_DETONATION_DELAY")
+	if v == "" {
+		return 0
+	}
+	maxDelay, err := time.ParseDuration(v)
+	if err != nil || maxDelay <= 0 {
+		log.Printf("ignoring CHAOS_MAX_DETONATION_DELAY=%q", v)
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(maxDelay/time.Second)+1))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64()) * time.Second
+}
+
+// noteArmed records that token's break is armed to fire at the RFC3339 time in msg.
+func noteArmed(token, msg string) error {
+	at, err := time.Parse(time.RFC3339Nano, msg)
+	if err != nil {
+		return fmt.Errorf("armed message with bad time %q: %w", msg, err)
+	}
+	armedBreaksMu.Lock()
+	armedBreaks[token] = armedBreak{Token: token, DetonateAt: at, ArmedAt: time.Now()}
+	armedBreaksMu.Unlock()
+	return nil
+}
+
+// noteDetonated clears token's armed entry and returns how late it fired versus plan.
+func noteDetonated(token, msg string) (time.Time, time.Duration, error) {
+	firedAt, err := time.Parse(time.RFC3339Nano, msg)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("detonated message with bad time %q: %w", msg, err)
+	}
+	armedBreaksMu.Lock()
+	ab, ok := armedBreaks[token]
+	delete(armedBreaks, token)
+	armedBreaksMu.Unlock()
+	if !ok {
+		return firedAt, 0, nil
+	}
+	return firedAt, firedAt.Sub(ab.DetonateAt), nil
+}
+
+// armedGraceUntil returns the latest planned detonation among armed breaks (zero if none).
+// The listener must not close for inactivity before then.
+func armedGraceUntil() time.Time {
+	armedBreaksMu.Lock()
+	defer armedBreaksMu.Unlock()
+	var latest time.Time
+	for _, ab := range armedBreaks {
+		if ab.DetonateAt.After(latest) {
+			latest = ab.DetonateAt
+		}
+	}
+	return latest
+}
+
+// listArmed returns armed breaks ordered by planned detonation.
+func listArmed() []armedBreak {
+	armedBreaksMu.Lock()
+	out := make([]armedBreak, 0, len(armedBreaks))
+	for _, ab := range armedBreaks {
+		out = append(out, ab)
+	}
+	armedBreaksMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].DetonateAt.Before(out[j].DetonateAt) })
+	return out
+}
+
+// disarmBreak stops the unit of an armed break before it fires.
+// The session token doubles as the session ID, so the unit can be found from it.
+func disarmBreak(ctx context.Context, token string) error {
+	sessionsMu.Lock()
+	s, ok := sessions[token]
+	sessionsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no session for armed token %s", token)
+	}
+	stop := stopSessionUnit
+	if s.Agent != "" {
+		stop = cancelAgentBreak
+	}
+	if err := stop(ctx, s); err != nil {
+		return err
+	}
+	armedBreaksMu.Lock()
+	delete(armedBreaks, token)
+	armedBreaksMu.Unlock()
+	return nil
+}
+
+// disarmAll disarms every armed break, logging failures.
+func disarmAll(ctx context.Context) {
+	for _, ab := range listArmed() {
+		if err := disarmBreak(ctx, ab.Token); err != nil {
+			log.Printf("disarm %s: %v", ab.Token, err)
+			continue
+		}
+		fmt.Printf("🧯 Disarmed %s (was due %s)\n", ab.Token, ab.DetonateAt.Format(time.RFC3339))
+	}
+}
@@ -6,4 +6,9 @@ toolchain go1.24.5
 
 require golang.org/x/crypto v0.46.0
 
-require golang.org/x/sys v0.39.0
+require (
+	github.com/pkg/sftp v1.13.9
+	golang.org/x/sys v0.39.0
+)
+
+require github.com/kr/fs v0.1.0 // indirect
@@ -0,0 +1,341 @@
+// Package agent is the resident testenv daemon. It keeps an authenticated channel to the
+// monitor open, runs signed break instructions from the compiled-in registry and streams
+// their messages back over the same channel, so a cycle needs no compile, upload or SSH.
+package agent
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/breaks"
+	"chaos-agent/library/channel"
+	datatypes "chaos-agent/library/types"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute
+	pingInterval = 30 * time.Second
+	maxOutbox    = 4096 // messages kept for the monitor while it is not connected
+)
+
+// Config is what the daemon needs to reach and trust the monitor.
+type Config struct {
+	AgentID     string
+	MonitorAddr string // host:port of the monitor's agent listener
+	MonitorKey  ed25519.PublicKey
+	Identity    ed25519.PrivateKey
+
+	// Recovery is what to do at start with mutations a previous run left unfinished
+	// (see library.RecoverJournals); empty leaves them alone.
+	Recovery library.RecoveryMode
+
+	// Dial overrides how the monitor is reached (defaults to TCP); useful against a local monitor.
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// Run connects to the monitor and serves instructions until ctx ends, reconnecting with backoff.
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.Dial == nil {
+		d := net.Dialer{Timeout: 15 * time.Second}
+		cfg.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	r := NewRunner()
+	if cfg.Recovery != "" {
+		r.recovery = cfg.Recovery
+		recoverAtStart(r, cfg.Recovery)
+	}
+	// Breaks still running when ctx ends are disarmed or interrupted; let them report and
+	// unwind before the daemon exits.
+	defer r.Wait()
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := Serve(ctx, cfg, r)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("agent: connection to %s ended: %v", cfg.MonitorAddr, err)
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	return nil
+}
+
+// recoverAtStart recovers what a previous run left unfinished without the vault keys, which
+// only the monitor has. Each journal still holding interrupted mutations is reported as
+// "unrecovered" once the monitor connects, so it can answer with a keyed recover instruction.
+func recoverAtStart(r *Runner, mode library.RecoveryMode) {
+	lines, err := library.RecoverJournals(library.JournalDir, mode)
+	for _, l := range lines {
+		log.Printf("agent: recovered %s", l)
+	}
+	if err != nil {
+		log.Printf("agent: journal recovery: %v", err)
+	}
+	pending, err := library.PendingJournals(library.JournalDir)
+	if err != nil {
+		log.Printf("agent: list unrecovered journals: %v", err)
+	}
+	for _, token := range pending {
+		runnerReporter{r: r, token: token}.Report("unrecovered", string(mode))
+	}
+}
+
+// Serve runs one connection: handshake, announce facts, then start instructions as they
+// arrive. It returns as soon as the connection ends; the breaks it started keep running in r
+// and report over the next connection.
+func Serve(ctx context.Context, cfg Config, r *Runner) error {
+	raw, err := cfg.Dial(ctx, cfg.MonitorAddr)
+	if err != nil {
+		return fmt.Errorf("dial monitor: %w", err)
+	}
+	conn, err := channel.Client(raw, cfg.Identity, cfg.MonitorKey)
+	if err != nil {
+		_ = raw.Close()
+		return fmt.Errorf("handshake: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Unblock the read loop when ctx ends.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if err := conn.WriteFrame(channel.Frame{Type: channel.FrameFacts, Facts: hostFacts(cfg.AgentID)}); err != nil {
+		return fmt.Errorf("send facts: %w", err)
+	}
+	r.attach(conn)
+	defer r.detach(conn)
+	go pingLoop(ctx, conn)
+
+	for {
+		var f channel.Frame
+		if err := conn.ReadFrame(&f); err != nil {
+			return err
+		}
+		if f.Type == channel.FrameCancel {
+			// Only the authenticated monitor can send frames, so a cancel needs no signature.
+			r.cancel(f.Token)
+			continue
+		}
+		if f.Type != channel.FrameInstruction || f.Instruction == nil {
+			continue
+		}
+		in := *f.Instruction
+		if err := in.Verify(cfg.MonitorKey, time.Now()); err != nil {
+			log.Printf("agent: rejecting instruction: %v", err)
+			continue
+		}
+		if !r.seen.First(in.ID, in.ExpiresAt) {
+			log.Printf("agent: rejecting replayed instruction %s", in.ID)
+			continue
+		}
+		r.start(ctx, in)
+	}
+}
+
+// Runner owns the breaks an agent runs. It outlives any one connection to the monitor: a
+// break keeps running while the monitor is away, and its messages go over whichever
+// connection is up, or wait in an outbox for the next one.
+type Runner struct {
+	seen     *ReplayGuard
+	recovery library.RecoveryMode // mode for recover instructions
+
+	mu      sync.Mutex
+	conn    *channel.Conn
+	outbox  []channel.Frame
+	cancels map[string]context.CancelFunc
+	running sync.WaitGroup
+}
+
+// NewRunner returns a Runner with no breaks running, for use with Serve.
+func NewRunner() *Runner {
+	return &Runner{seen: NewReplayGuard(), cancels: make(map[string]context.CancelFunc)}
+}
+
+// Wait blocks until every break started by r has finished.
+func (r *Runner) Wait() {
+	r.running.Wait()
+}
+
+// start runs in in the background under ctx, which should be the daemon's, not a connection's.
+func (r *Runner) start(ctx context.Context, in channel.Instruction) {
+	bctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.cancels[in.Token] = cancel
+	r.mu.Unlock()
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		defer func() {
+			r.mu.Lock()
+			delete(r.cancels, in.Token)
+			r.mu.Unlock()
+			cancel()
+		}()
+		rep := runnerReporter{r: r, token: in.Token}
+		var err error
+		switch in.Break {
+		case breaks.RollbackName:
+			err = breaks.Rollback(bctx, in.Config(), rep)
+		case breaks.RecoverName:
+			err = breaks.Recover(bctx, in.Config(), r.recovery, rep)
+		default:
+			err = breaks.Run(bctx, in.Break, in.Config(), rep)
+		}
+		if err != nil {
+			log.Printf("agent: %s (%s) failed: %v", in.Break, in.Token, err)
+		}
+	}()
+}
+
+// cancel stops the break running for token, if any.
+func (r *Runner) cancel(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.cancels[token]; ok {
+		cancel()
+	}
+}
+
+// attach makes conn the connection messages go over, first sending what waited in the outbox.
+func (r *Runner) attach(conn *channel.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.outbox) > 0 {
+		if err := conn.WriteFrame(r.outbox[0]); err != nil {
+			log.Printf("agent: flush outbox: %v", err)
+			return
+		}
+		r.outbox = r.outbox[1:]
+	}
+	r.outbox = nil
+	r.conn = conn
+}
+
+// detach stops using conn; messages wait in the outbox until the next attach.
+func (r *Runner) detach(conn *channel.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == conn {
+		r.conn = nil
+	}
+}
+
+// send writes f over the current connection, or queues it when there is none or it fails.
+func (r *Runner) send(f channel.Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		err := r.conn.WriteFrame(f)
+		if err == nil {
+			return
+		}
+		log.Printf("agent: report over channel: %v", err)
+		r.conn = nil
+	}
+	if len(r.outbox) >= maxOutbox {
+		log.Printf("agent: outbox full, dropping %s for %s", f.Message.Status, f.Message.Token)
+		return
+	}
+	r.outbox = append(r.outbox, f)
+}
+
+// runnerReporter streams a break's messages back to the monitor through its Runner.
+type runnerReporter struct {
+	r     *Runner
+	token string
+}
+
+var _ library.Reporter = runnerReporter{}
+
+func (rr runnerReporter) Report(status, message string) {
+	msg := datatypes.ChaosMessage{Status: status, Message: message, Token: rr.token}
+	rr.r.send(channel.Frame{Type: channel.FrameMessage, Message: &msg})
+}
+
+func pingLoop(ctx context.Context, conn *channel.Conn) {
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := conn.WriteFrame(channel.Frame{Type: channel.FramePing}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func hostFacts(agentID string) *channel.Facts {
+	f := &channel.Facts{AgentID: agentID, Breaks: breaks.Names()}
+	f.Hostname, _ = os.Hostname()
+	var u unix.Utsname
+	if err := unix.Uname(&u); err == nil {
+		f.Machine = unix.ByteSliceToString(u.Machine[:])
+		f.Kernel = unix.ByteSliceToString(u.Release[:])
+	}
+	return f
+}
+
+// ReplayGuard remembers instruction IDs until they expire so each runs at most once,
+// even across reconnects.
+type ReplayGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewReplayGuard returns an empty guard.
+func NewReplayGuard() *ReplayGuard {
+	return &ReplayGuard{seen: make(map[string]time.Time)}
+}
+
+// First reports whether id has not been seen before, recording it until expires.
+func (g *ReplayGuard) First(id string, expires time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for k, exp := range g.seen {
+		if now.After(exp) {
+			delete(g.seen, k)
+		}
+	}
+	if _, dup := g.seen[id]; dup {
+		return false
+	}
+	g.seen[id] = expires
+	return true
+}
+
+// ParseMonitorAddr validates a host:port monitor address.
+func ParseMonitorAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		return "", fmt.Errorf("monitor address %q: %w", addr, err)
+	}
+	if host == "" || port == "" {
+		return "", errors.New("monitor address needs host and port")
+	}
+	return net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"chaos-agent/library/channel"
+	datatypes "chaos-agent/library/types"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// localMonitor is the monitor end of the channel, listening on loopback.
+type localMonitor struct {
+	ln    net.Listener
+	key   ed25519.PrivateKey
+	conns chan *channel.Conn
+}
+
+func startLocalMonitor(t *testing.T, agentKey ed25519.PublicKey) *localMonitor {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &localMonitor{ln: ln, key: key, conns: make(chan *channel.Conn, 4)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			raw, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn, err := channel.Server(raw, key, func(pub ed25519.PublicKey) bool { return pub.Equal(agentKey) })
+			if err != nil {
+				_ = raw.Close()
+				continue
+			}
+			m.conns <- conn
+		}
+	}()
+	return m
+}
+
+// accept waits for the agent to connect and returns the connection after its facts.
+func (m *localMonitor) accept(t *testing.T) (*channel.Conn, channel.Facts) {
+	t.Helper()
+	select {
+	case conn := <-m.conns:
+		t.Cleanup(func() { _ = conn.Close() })
+		var f channel.Frame
+		if err := conn.ReadFrame(&f); err != nil || f.Type != channel.FrameFacts || f.Facts == nil {
+			t.Fatalf("first frame %+v, %v; want facts", f, err)
+		}
+		return conn, *f.Facts
+	case <-time.After(10 * time.Second):
+		t.Fatal("agent did not connect")
+		return nil, channel.Facts{}
+	}
+}
+
+func (m *localMonitor) instruct(t *testing.T, conn *channel.Conn, in channel.Instruction) {
+	t.Helper()
+	in.IssuedAt = time.Now()
+	in.ExpiresAt = in.IssuedAt.Add(2 * time.Minute)
+	if err := in.Sign(m.key); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteFrame(channel.Frame{Type: channel.FrameInstruction, Instruction: &in}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next reads frames until a break message arrives.
+func next(t *testing.T, conn *channel.Conn) datatypes.ChaosMessage {
+	t.Helper()
+	for {
+		var f channel.Frame
+		if err := conn.ReadFrame(&f); err != nil {
+			t.Fatalf("read message: %v", err)
+		}
+		if f.Type == channel.FrameMessage && f.Message != nil {
+			return *f.Message
+		}
+	}
+}
+
+func expect(t *testing.T, conn *channel.Conn, token, status string) {
+	t.Helper()
+	if msg := next(t, conn); msg.Token != token || msg.Status != status {
+		t.Fatalf("got %s/%s %q, want %s/%s", msg.Token, msg.Status, msg.Message, token, status)
+	}
+}
+
+// TestAgentAgainstLocalMonitor runs the daemon against a monitor on loopback. The break is
+// armed with a long delay and cancelled before it fires, so nothing on this machine changes.
+func TestAgentAgainstLocalMonitor(t *testing.T) {
+	agentPub, agentKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := startLocalMonitor(t, agentPub)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, Config{
+			AgentID:     "testenv-1",
+			MonitorAddr: m.ln.Addr().String(),
+			MonitorKey:  m.key.Public().(ed25519.PublicKey),
+			Identity:    agentKey,
+		})
+	}()
+	defer func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("Run did not return after ctx ended")
+		}
+	}()
+
+	conn, facts := m.accept(t)
+	if facts.AgentID != "testenv-1" || !slices.Contains(facts.Breaks, "file_swap") {
+		t.Fatalf("facts %+v", facts)
+	}
+
+	armed := channel.Instruction{ID: "in-1", Break: "file_swap", Token: "armed-session", DelaySeconds: 3600}
+	m.instruct(t, conn, armed)
+	expect(t, conn, "armed-session", "init")
+	expect(t, conn, "armed-session", "armed")
+
+	// A replay and a forged instruction are both dropped: the next message is the fresh one's.
+	m.instruct(t, conn, armed)
+	forged := channel.Instruction{ID: "in-2", Break: "file_swap", Token: "forged", IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Minute), Signature: make([]byte, ed25519.SignatureSize)}
+	if err := conn.WriteFrame(channel.Frame{Type: channel.FrameInstruction, Instruction: &forged}); err != nil {
+		t.Fatal(err)
+	}
+	m.instruct(t, conn, channel.Instruction{ID: "in-3", Break: "no_such_break", Token: "unknown-break"})
+	expect(t, conn, "unknown-break", "error")
+	expect(t, conn, "unknown-break", "operation_complete")
+
+	// The monitor goes away while the break is armed: the agent redials at once instead of
+	// waiting out the hour, and the break reports over the new connection.
+	_ = conn.Close()
+	conn, _ = m.accept(t)
+	if err := conn.WriteFrame(channel.Frame{Type: channel.FrameCancel, Token: "armed-session"}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, conn, "armed-session", "error")
+	expect(t, conn, "armed-session", "operation_complete")
+}
+
+func TestRunnerQueuesMessagesWhileDisconnected(t *testing.T) {
+	r := NewRunner()
+	rep := runnerReporter{r: r, token: "tok"}
+	rep.Report("armed", "later")
+	rep.Report("operation_complete", "complete")
+
+	a, b := net.Pipe()
+	agentPub, agentKey, _ := ed25519.GenerateKey(rand.Reader)
+	monPub, monKey, _ := ed25519.GenerateKey(rand.Reader)
+	server := make(chan *channel.Conn, 1)
+	go func() {
+		conn, err := channel.Server(b, monKey, func(pub ed25519.PublicKey) bool { return pub.Equal(agentPub) })
+		if err != nil {
+			t.Error(err)
+		}
+		server <- conn
+	}()
+	client, err := channel.Client(a, agentKey, monPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon := <-server
+	defer func() { _ = client.Close(); _ = mon.Close() }()
+
+	go r.attach(client)
+	expect(t, mon, "tok", "armed")
+	expect(t, mon, "tok", "operation_complete")
+}
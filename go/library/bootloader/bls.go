@@ -0,0 +1,144 @@
+// Package bootloader parses and edits boot loader configuration (Boot Loader Specification
+// entries, grub.cfg menuentries, the grubenv block) so breaks can sabotage it semantically:
+// the result still parses, but no longer boots the way it did.
+package bootloader
+
+import (
+	"bufio"
+	"bytes"
+	"chaos-agent/library"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BLSLine is one line of a BLS entry. Comments and blank lines have an empty Key and are kept verbatim.
+type BLSLine struct {
+	Key   string
+	Value string
+	Raw   string
+}
+
+// BLSEntry is one /boot/loader/entries/*.conf file.
+type BLSEntry struct {
+	Path  string
+	ID    string // file name without .conf; what saved_entry refers to
+	Lines []BLSLine
+}
+
+// ParseBLSEntry parses the contents of the BLS entry file at path.
+func ParseBLSEntry(path string, data []byte) (*BLSEntry, error) {
+	e := &BLSEntry{Path: path, ID: strings.TrimSuffix(filepath.Base(path), ".conf")}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		raw := sc.Text()
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			e.Lines = append(e.Lines, BLSLine{Raw: raw})
+			continue
+		}
+		key, value := trimmed, ""
+		if i := strings.IndexAny(trimmed, " \t"); i >= 0 {
+			key, value = trimmed[:i], strings.TrimSpace(trimmed[i:])
+		}
+		e.Lines = append(e.Lines, BLSLine{Key: key, Value: value, Raw: raw})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if e.Get("linux") == "" && e.Get("efi") == "" {
+		return nil, fmt.Errorf("parse %s: no linux or efi key", path)
+	}
+	return e, nil
+}
+
+// ReadBLSEntry reads and parses one BLS entry file.
+func ReadBLSEntry(path string) (*BLSEntry, error) {
+	// #nosec G304 -- boot loader entry chosen by the break's own target list
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBLSEntry(path, data)
+}
+
+// ReadBLSDir parses every *.conf in dir, sorted by file name. Files that fail to parse are skipped.
+func ReadBLSDir(dir string) ([]*BLSEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	out := make([]*BLSEntry, 0, len(paths))
+	for _, p := range paths {
+		if e, err := ReadBLSEntry(p); err == nil {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// Get returns the value of the first line with key, or "".
+func (e *BLSEntry) Get(key string) string {
+	for _, l := range e.Lines {
+		if l.Key == key {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of the first line with key, appending the key if it is missing.
+func (e *BLSEntry) Set(key, value string) {
+	for i, l := range e.Lines {
+		if l.Key == key {
+			e.Lines[i] = BLSLine{Key: key, Value: value, Raw: key + " " + value}
+			return
+		}
+	}
+	e.Add(key, value)
+}
+
+// Add appends a key line.
+func (e *BLSEntry) Add(key, value string) {
+	e.Lines = append(e.Lines, BLSLine{Key: key, Value: value, Raw: key + " " + value})
+}
+
+// Delete removes every line with key and reports how many were removed.
+func (e *BLSEntry) Delete(key string) int {
+	kept := e.Lines[:0]
+	n := 0
+	for _, l := range e.Lines {
+		if l.Key == key {
+			n++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	e.Lines = kept
+	return n
+}
+
+// Bytes renders the entry; untouched lines keep their original spacing.
+func (e *BLSEntry) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range e.Lines {
+		b.WriteString(l.Raw)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// WriteFile writes the entry back to its Path, as a mutation under s.
+func (e *BLSEntry) WriteFile(s *library.Session) error {
+	return writePreserving(s, e.Path, e.Bytes())
+}
+
+// writePreserving rewrites path in place, keeping its mode, owner and mtime so the edit
+// does not stand out in a directory listing. It runs as a library.Rewrite under s.
+func writePreserving(s *library.Session, path string, data []byte) error {
+	_, err := s.Perform(&library.Rewrite{Path: path, Data: data})
+	return err
+}
@@ -0,0 +1,195 @@
+package bootloader
+
+import (
+	"bufio"
+	"bytes"
+	"chaos-agent/library"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MenuEntry is a menuentry block of a grub.cfg; Start and End are the line indexes of the
+// "menuentry ... {" line and of its closing "}".
+type MenuEntry struct {
+	Title string
+	Start int
+	End   int
+	Depth int // 0 for top-level entries, 1 inside a submenu, ...
+}
+
+// GrubConfig is a grub.cfg kept as lines, with the menuentries located in it.
+type GrubConfig struct {
+	Path    string
+	Lines   []string
+	Entries []MenuEntry
+}
+
+// ParseGrubConfig parses grub.cfg contents. A config without menuentries (e.g. one that only
+// runs blscfg) is valid and has no Entries.
+func ParseGrubConfig(path string, data []byte) (*GrubConfig, error) {
+	c := &GrubConfig{Path: path}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		c.Lines = append(c.Lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := c.index(); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ReadGrubConfig reads and parses a grub.cfg.
+func ReadGrubConfig(path string) (*GrubConfig, error) {
+	// #nosec G304 -- boot loader file chosen by the break's own target list
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGrubConfig(path, data)
+}
+
+// index locates menuentry blocks by tracking braces outside quotes and comments.
+func (c *GrubConfig) index() error {
+	type block struct {
+		kind  byte // 'e' menuentry, 's' submenu, 'o' anything else (if, function, ...)
+		entry int
+	}
+	c.Entries = c.Entries[:0]
+	var stack []block
+	submenus := 0
+	for i, line := range c.Lines {
+		word := firstWord(line)
+		opens, closes := countBraces(line)
+		for k := range opens {
+			b := block{kind: 'o'}
+			switch {
+			case k == 0 && word == "menuentry":
+				c.Entries = append(c.Entries, MenuEntry{Title: quotedTitle(line), Start: i, Depth: submenus})
+				b = block{kind: 'e', entry: len(c.Entries) - 1}
+			case k == 0 && word == "submenu":
+				b.kind = 's'
+				submenus++
+			}
+			stack = append(stack, b)
+		}
+		for range closes {
+			if len(stack) == 0 {
+				return fmt.Errorf("line %d: unbalanced }", i+1)
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			switch top.kind {
+			case 'e':
+				c.Entries[top.entry].End = i
+			case 's':
+				submenus--
+			}
+		}
+	}
+	if len(stack) != 0 {
+		return errors.New("unterminated block at end of file")
+	}
+	return nil
+}
+
+// Bytes renders the config.
+func (c *GrubConfig) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range c.Lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// WriteFile writes the config back to its Path, as a mutation under s.
+func (c *GrubConfig) WriteFile(s *library.Session) error {
+	return writePreserving(s, c.Path, c.Bytes())
+}
+
+// commandLine returns the index of the first line in entry e whose command is one of cmds.
+func (c *GrubConfig) commandLine(e MenuEntry, cmds ...string) int {
+	for i := e.Start + 1; i < e.End; i++ {
+		w := firstWord(c.Lines[i])
+		for _, cmd := range cmds {
+			if w == cmd {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// replaceLines replaces lines [start, end] with repl and re-indexes the entries.
+func (c *GrubConfig) replaceLines(start, end int, repl []string) error {
+	lines := make([]string, 0, len(c.Lines)-(end-start+1)+len(repl))
+	lines = append(lines, c.Lines[:start]...)
+	lines = append(lines, repl...)
+	lines = append(lines, c.Lines[end+1:]...)
+	c.Lines = lines
+	return c.index()
+}
+
+var (
+	linuxCmds  = []string{"linux", "linux16", "linuxefi"}
+	initrdCmds = []string{"initrd", "initrd16", "initrdefi"}
+)
+
+func firstWord(line string) string {
+	f := strings.Fields(line)
+	if len(f) == 0 {
+		return ""
+	}
+	return f[0]
+}
+
+// countBraces counts block braces on a line, ignoring quoted text, ${var} expansions and comments.
+func countBraces(line string) (opens, closes int) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else if ch == '\\' && quote == '"' {
+				i++
+			}
+		case ch == '\\':
+			i++
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return opens, closes
+		case ch == '$' && i+1 < len(line) && line[i+1] == '{':
+			if j := strings.IndexByte(line[i:], '}'); j > 0 {
+				i += j
+			}
+		case ch == '{':
+			opens++
+		case ch == '}':
+			closes++
+		}
+	}
+	return opens, closes
+}
+
+// quotedTitle returns the first quoted string on a menuentry line.
+func quotedTitle(line string) string {
+	i := strings.IndexAny(line, `'"`)
+	if i < 0 {
+		return ""
+	}
+	q := line[i]
+	j := strings.IndexByte(line[i+1:], q)
+	if j < 0 {
+		return line[i+1:]
+	}
+	return line[i+1 : i+1+j]
+}
@@ -0,0 +1,246 @@
+package bootloader
+
+import (
+	"bytes"
+	"chaos-agent/library"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GRUB's environment block: a header line, "name=value\n" lines, then '#' padding up to the
+// block size (1024 bytes unless the file was created larger). In values, GRUB escapes
+// backslash and newline with a backslash.
+const (
+	GrubenvSize   = 1024
+	grubenvHeader = "# GRUB Environment Block\n"
+)
+
+// GrubenvVar is one variable, in file order.
+type GrubenvVar struct {
+	Name  string
+	Value string
+}
+
+// Grubenv is a parsed environment block.
+type Grubenv struct {
+	Path string
+	Size int // block size to write back; the original file size
+	Vars []GrubenvVar
+}
+
+// ParseGrubenv parses an environment block, rejecting anything GRUB would not load.
+func ParseGrubenv(path string, data []byte) (*Grubenv, error) {
+	if err := ValidateGrubenv(data); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	env := &Grubenv{Path: path, Size: len(data)}
+	body := data[len(grubenvHeader):]
+	for len(body) > 0 && body[0] != '#' {
+		line, rest, err := nextEnvLine(body)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		body = rest
+		name, value, _ := strings.Cut(line, "=")
+		env.Vars = append(env.Vars, GrubenvVar{Name: name, Value: unescapeEnv(value)})
+	}
+	return env, nil
+}
+
+// ReadGrubenv reads and parses a grubenv file.
+func ReadGrubenv(path string) (*Grubenv, error) {
+	// #nosec G304 -- boot loader file chosen by the break's own target list
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGrubenv(path, data)
+}
+
+// ValidateGrubenv checks data the way GRUB's load_env does: header, size, well-formed
+// name=value lines, and nothing but '#' padding after them. Verification uses it to tell
+// a sabotaged-but-valid grubenv from a corrupted one.
+func ValidateGrubenv(data []byte) error {
+	if len(data) < GrubenvSize {
+		return fmt.Errorf("environment block is %d bytes, want at least %d", len(data), GrubenvSize)
+	}
+	if !bytes.HasPrefix(data, []byte(grubenvHeader)) {
+		return errors.New("missing environment block header")
+	}
+	body := data[len(grubenvHeader):]
+	for len(body) > 0 && body[0] != '#' {
+		line, rest, err := nextEnvLine(body)
+		if err != nil {
+			return err
+		}
+		name, _, ok := strings.Cut(line, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("malformed variable line %q", line)
+		}
+		body = rest
+	}
+	if i := bytes.IndexFunc(body, func(r rune) bool { return r != '#' }); i >= 0 {
+		return fmt.Errorf("non-padding byte at offset %d", len(data)-len(body)+i)
+	}
+	return nil
+}
+
+// nextEnvLine splits off one variable line, honouring backslash-escaped newlines.
+func nextEnvLine(body []byte) (string, []byte, error) {
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '\n':
+			return string(body[:i]), body[i+1:], nil
+		}
+	}
+	return "", nil, errors.New("unterminated variable line")
+}
+
+func unescapeEnv(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+func escapeEnv(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", "\\\n")
+	return r.Replace(v)
+}
+
+// Get returns the value of name and whether it is set.
+func (e *Grubenv) Get(name string) (string, bool) {
+	for _, v := range e.Vars {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets name, keeping its position if it already exists.
+func (e *Grubenv) Set(name, value string) {
+	for i := range e.Vars {
+		if e.Vars[i].Name == name {
+			e.Vars[i].Value = value
+			return
+		}
+	}
+	e.Vars = append(e.Vars, GrubenvVar{Name: name, Value: value})
+}
+
+// Delete unsets name and reports whether it was set.
+func (e *Grubenv) Delete(name string) bool {
+	for i := range e.Vars {
+		if e.Vars[i].Name == name {
+			e.Vars = append(e.Vars[:i], e.Vars[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes renders the block padded with '#' to its size; it fails if the variables do not fit.
+func (e *Grubenv) Bytes() ([]byte, error) {
+	size := max(e.Size, GrubenvSize)
+	var b bytes.Buffer
+	b.WriteString(grubenvHeader)
+	for _, v := range e.Vars {
+		if v.Name == "" || strings.ContainsAny(v.Name, "=\n") {
+			return nil, fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		b.WriteString(v.Name + "=" + escapeEnv(v.Value) + "\n")
+	}
+	if b.Len() > size {
+		return nil, fmt.Errorf("variables need %d bytes, block is %d", b.Len(), size)
+	}
+	b.Write(bytes.Repeat([]byte{'#'}, size-b.Len()))
+	return b.Bytes(), nil
+}
+
+// WriteFile writes the block back to its Path, in place so the file keeps its blocks on disk
+// (GRUB's save_env writes to those sectors directly), as a mutation under s.
+func (e *Grubenv) WriteFile(s *library.Session) error {
+	data, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	return writePreserving(s, e.Path, data)
+}
+
+// Grubenv sabotage kinds.
+const (
+	MissingSavedEntry Sabotage = "missing_saved_entry" // saved_entry names a BLS id that does not exist
+	ToggleBootSuccess Sabotage = "toggle_boot_success" // boot_success flipped, triggering or hiding the boot menu
+	BadKernelopts     Sabotage = "bad_kernelopts"      // kernelopts points root= at a device that does not exist
+)
+
+// GrubenvSabotages lists every grubenv Sabotage kind.
+var GrubenvSabotages = []Sabotage{MissingSavedEntry, ToggleBootSuccess, BadKernelopts}
+
+// SabotageGrubenv applies s to env in memory, drawing from rng. blsIDs are the existing BLS
+// entry ids; MissingSavedEntry picks a plausible id outside that set.
+func SabotageGrubenv(env *Grubenv, s Sabotage, blsIDs []string, rng *library.Rand) (Change, error) {
+	ch := Change{Path: env.Path, Entry: "grubenv", Sabotage: s}
+	switch s {
+	case MissingSavedEntry:
+		known := make(map[string]bool, len(blsIDs))
+		for _, id := range blsIDs {
+			known[id] = true
+		}
+		old, _ := env.Get("saved_entry")
+		base := old
+		if base == "" && len(blsIDs) > 0 {
+			base = blsIDs[0]
+		}
+		if base == "" {
+			return ch, fmt.Errorf("%w: no saved_entry and no BLS entries to derive one from", ErrNotApplicable)
+		}
+		missing, err := bumpRelease(base, func(id string) bool { return known[id] })
+		if err != nil {
+			return ch, err
+		}
+		env.Set("saved_entry", missing)
+		ch.Detail = fmt.Sprintf("saved_entry %q -> %q", old, missing)
+
+	case ToggleBootSuccess:
+		old, _ := env.Get("boot_success")
+		next := "1"
+		if old == "1" {
+			next = "0"
+		}
+		env.Set("boot_success", next)
+		ch.Detail = fmt.Sprintf("boot_success %q -> %q", old, next)
+
+	case BadKernelopts:
+		old, _ := env.Get("kernelopts")
+		uuid, err := randomUUID(rng)
+		if err != nil {
+			return ch, err
+		}
+		next := setRootArg(old, "UUID="+uuid)
+		if _, ok := rootArg(old); !ok {
+			next = strings.TrimSpace("root=UUID=" + uuid + " " + old)
+		}
+		env.Set("kernelopts", next)
+		ch.Detail = fmt.Sprintf("kernelopts %q -> %q", old, next)
+
+	default:
+		return ch, fmt.Errorf("unknown grubenv sabotage %q", s)
+	}
+	if _, err := env.Bytes(); err != nil {
+		return ch, err
+	}
+	return ch, nil
+}
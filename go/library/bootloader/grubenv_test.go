@@ -0,0 +1,164 @@
+package bootloader
+
+import (
+	"bytes"
+	"chaos-agent/library"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readTestGrubenv(t *testing.T) *Grubenv {
+	t.Helper()
+	env, err := ReadGrubenv(filepath.Join("testdata", "grubenv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestGrubenvRoundTrip(t *testing.T) {
+	env := readTestGrubenv(t)
+	data, err := env.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := os.ReadFile(env.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, orig) {
+		t.Errorf("testdata grubenv does not round-trip:\n%s", data)
+	}
+
+	env.Set("saved_entry", "other")
+	env.Set("multi", "line one\nline two \\ end")
+	env.Delete("menu_auto_hide")
+	data, err = env.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != GrubenvSize {
+		t.Fatalf("block is %d bytes, want %d", len(data), GrubenvSize)
+	}
+	if err := ValidateGrubenv(data); err != nil {
+		t.Fatal(err)
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if pad := data[end:]; len(pad) == 0 || len(bytes.Trim(pad, "#")) != 0 {
+		t.Errorf("block is not '#'-padded after its variables: %q", pad)
+	}
+	back, err := ParseGrubenv("grubenv", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"saved_entry":  "other",
+		"multi":        "line one\nline two \\ end",
+		"boot_success": "0",
+		"kernelopts":   "root=/dev/mapper/rl-root ro crashkernel=1G-4G:192M,4G-64G:256M,64G-:512M resume=/dev/mapper/rl-swap rd.lvm.lv=rl/root rd.lvm.lv=rl/swap ",
+	} {
+		if got, ok := back.Get(name); !ok || got != want {
+			t.Errorf("%s = %q (set %v), want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := back.Get("menu_auto_hide"); ok {
+		t.Error("deleted menu_auto_hide came back")
+	}
+}
+
+func TestGrubenvBytesRejectsOverflow(t *testing.T) {
+	env := &Grubenv{Path: "grubenv"}
+	env.Set("kernelopts", strings.Repeat("x", GrubenvSize))
+	if _, err := env.Bytes(); err == nil {
+		t.Error("variables larger than the block rendered without error")
+	}
+	env.Set("kernelopts", strings.Repeat("x", GrubenvSize-len(grubenvHeader)-len("kernelopts=\n")))
+	if data, err := env.Bytes(); err != nil || len(data) != GrubenvSize {
+		t.Errorf("exactly full block: %d bytes, %v", len(data), err)
+	}
+	if _, err := (&Grubenv{Vars: []GrubenvVar{{Name: "a=b", Value: "c"}}}).Bytes(); err == nil {
+		t.Error("name with '=' accepted")
+	}
+}
+
+func TestValidateGrubenvRejectsDamage(t *testing.T) {
+	orig, err := os.ReadFile(filepath.Join("testdata", "grubenv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mutate := range map[string]func([]byte) []byte{
+		"short":        func(b []byte) []byte { return b[:GrubenvSize-1] },
+		"header":       func(b []byte) []byte { b[0] = 'X'; return b },
+		"padding":      func(b []byte) []byte { b[len(b)-1] = 0; return b },
+		"unnamed":      func(b []byte) []byte { return append([]byte(grubenvHeader+"=x\n"), b[len(grubenvHeader)+3:]...) },
+		"unterminated": func(b []byte) []byte { return append([]byte(grubenvHeader), bytes.Repeat([]byte("a"), GrubenvSize)...) },
+	} {
+		if err := ValidateGrubenv(mutate(bytes.Clone(orig))); err == nil {
+			t.Errorf("%s: damaged block validated", name)
+		}
+	}
+}
+
+func TestSabotageGrubenv(t *testing.T) {
+	ids := []string{
+		"3f2a9c1e8b7d4e5fa6b1c2d3e4f50617-5.14.0-362.8.1.el9_3.x86_64",
+		"3f2a9c1e8b7d4e5fa6b1c2d3e4f50617-5.14.0-284.11.1.el9_2.x86_64",
+	}
+	for _, tc := range []struct {
+		s     Sabotage
+		check func(t *testing.T, before, after *Grubenv)
+	}{
+		{MissingSavedEntry, func(t *testing.T, before, after *Grubenv) {
+			old, _ := before.Get("saved_entry")
+			got, _ := after.Get("saved_entry")
+			if got == old || got == ids[0] || got == ids[1] || !strings.HasPrefix(got, "3f2a9c1e8b7d4e5fa6b1c2d3e4f50617-5.14.0-") {
+				t.Errorf("saved_entry %q -> %q, want a plausible id that does not exist", old, got)
+			}
+		}},
+		{ToggleBootSuccess, func(t *testing.T, before, after *Grubenv) {
+			if got, _ := after.Get("boot_success"); got != "1" {
+				t.Errorf("boot_success %q, want 1", got)
+			}
+		}},
+		{BadKernelopts, func(t *testing.T, before, after *Grubenv) {
+			old, _ := before.Get("kernelopts")
+			got, _ := after.Get("kernelopts")
+			r, ok := rootArg(got)
+			if !ok || !strings.HasPrefix(r, "UUID=") || got == old {
+				t.Errorf("kernelopts %q -> %q, want root= on a fresh UUID", old, got)
+			}
+			if setRootArg(got, "/dev/mapper/rl-root") != strings.TrimSpace(old) {
+				t.Errorf("kernelopts changed beyond root=: %q", got)
+			}
+		}},
+	} {
+		t.Run(string(tc.s), func(t *testing.T) {
+			before, env := readTestGrubenv(t), readTestGrubenv(t)
+			ch, err := SabotageGrubenv(env, tc.s, ids, library.NewRand("grubenv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.Sabotage != tc.s || ch.Path != env.Path || ch.Detail == "" {
+				t.Errorf("change %+v", ch)
+			}
+			data, err := env.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != GrubenvSize {
+				t.Errorf("sabotaged block is %d bytes", len(data))
+			}
+			after, err := ParseGrubenv(env.Path, data)
+			if err != nil {
+				t.Fatalf("sabotaged block no longer loads: %v", err)
+			}
+			tc.check(t, before, after)
+		})
+	}
+	if _, err := SabotageGrubenv(&Grubenv{}, MissingSavedEntry, nil, library.NewRand("")); !errors.Is(err, ErrNotApplicable) {
+		t.Errorf("no saved_entry and no ids: %v, want ErrNotApplicable", err)
+	}
+}
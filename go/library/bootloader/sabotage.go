@@ -0,0 +1,401 @@
+package bootloader
+
+import (
+	"chaos-agent/library"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sabotage is a semantic edit that keeps a boot entry parseable but stops it from booting.
+type Sabotage string
+
+const (
+	MissingKernel    Sabotage = "missing_kernel"    // linux points at a kernel that does not exist
+	SwapRoot         Sabotage = "swap_root"         // root= swapped with another entry's, or a foreign UUID
+	DropInitrd       Sabotage = "drop_initrd"       // initrd line removed
+	Reorder          Sabotage = "reorder"           // a different entry becomes the default
+	InvalidDirective Sabotage = "invalid_directive" // a directive the boot loader does not know
+)
+
+// Sabotages lists every Sabotage kind.
+var Sabotages = []Sabotage{MissingKernel, SwapRoot, DropInitrd, Reorder, InvalidDirective}
+
+// Change describes one applied sabotage.
+type Change struct {
+	Path     string   `json:"path"`
+	Entry    string   `json:"entry"` // BLS id or menuentry title
+	Sabotage Sabotage `json:"sabotage"`
+	Detail   string   `json:"detail"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s [%s]: %s", c.Sabotage, c.Path, c.Entry, c.Detail)
+}
+
+// ErrNotApplicable is returned when a sabotage cannot be applied to the given config,
+// e.g. reordering a single entry or dropping an initrd that is not there.
+var ErrNotApplicable = errors.New("sabotage not applicable")
+
+// KernelExists is the default check for MissingKernel: kernel paths in boot entries are
+// relative to the boot partition, which is usually mounted at /boot.
+func KernelExists(path string) bool {
+	for _, p := range []string{path, filepath.Join("/boot", path)} {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// bootEntry is what the sabotages need from a BLS entry or a grub.cfg menuentry.
+type bootEntry interface {
+	name() string
+	kernel() string
+	setKernel(string)
+	cmdline() string
+	setCmdline(string)
+	dropInitrd() bool
+}
+
+// SabotageBLS applies s to one randomly chosen entry (two for SwapRoot and Reorder) of entries,
+// in memory, drawing from rng. Write the entries named in the returned changes with
+// WriteBLSChanges.
+func SabotageBLS(entries []*BLSEntry, s Sabotage, exists func(string) bool, rng *library.Rand) ([]Change, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no BLS entries", ErrNotApplicable)
+	}
+	be := make([]bootEntry, len(entries))
+	for i, e := range entries {
+		be[i] = blsEntry{e}
+	}
+	pick, err := randIntn(rng, len(entries))
+	if err != nil {
+		return nil, err
+	}
+	path := func(i int) string { return entries[i].Path }
+
+	switch s {
+	case Reorder:
+		// grub's blscfg sorts entries by version, newest first; swapping versions swaps the order.
+		j, err := otherIndex(rng, len(entries), pick, func(j int) bool {
+			return entries[j].Get("version") != entries[pick].Get("version")
+		})
+		if err != nil {
+			return nil, err
+		}
+		a, b := entries[pick], entries[j]
+		va, vb := a.Get("version"), b.Get("version")
+		a.Set("version", vb)
+		b.Set("version", va)
+		return []Change{
+			{Path: a.Path, Entry: a.ID, Sabotage: s, Detail: fmt.Sprintf("version %s -> %s", va, vb)},
+			{Path: b.Path, Entry: b.ID, Sabotage: s, Detail: fmt.Sprintf("version %s -> %s", vb, va)},
+		}, nil
+	case InvalidDirective:
+		e := entries[pick]
+		e.Add("kernel-verify", "strict")
+		return []Change{{Path: e.Path, Entry: e.ID, Sabotage: s, Detail: "added unknown key kernel-verify"}}, nil
+	}
+	return sabotageEntries(be, pick, s, exists, path, rng)
+}
+
+// WriteBLSChanges writes back every entry a change refers to, as mutations under s.
+func WriteBLSChanges(s *library.Session, entries []*BLSEntry, changes []Change) error {
+	var errs []error
+	for _, e := range entries {
+		for _, c := range changes {
+			if c.Path == e.Path {
+				errs = append(errs, e.WriteFile(s))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SabotageGrubConfig applies s to one menuentry of c chosen from rng, in memory.
+func SabotageGrubConfig(c *GrubConfig, s Sabotage, exists func(string) bool, rng *library.Rand) ([]Change, error) {
+	var idx []int
+	for i, e := range c.Entries {
+		if c.commandLine(e, linuxCmds...) >= 0 {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("%w: no menuentry with a linux command in %s", ErrNotApplicable, c.Path)
+	}
+	be := make([]bootEntry, len(idx))
+	for i, ei := range idx {
+		be[i] = grubEntry{c: c, i: ei}
+	}
+	pick, err := randIntn(rng, len(idx))
+	if err != nil {
+		return nil, err
+	}
+	path := func(int) string { return c.Path }
+
+	switch s {
+	case Reorder:
+		return reorderMenu(c, rng)
+	case InvalidDirective:
+		e := c.Entries[idx[pick]]
+		li := c.commandLine(e, linuxCmds...)
+		indent := c.Lines[li][:len(c.Lines[li])-len(strings.TrimLeft(c.Lines[li], " \t"))]
+		if err := c.replaceLines(li, li, []string{indent + "verify_kernel --strict", c.Lines[li]}); err != nil {
+			return nil, err
+		}
+		return []Change{{Path: c.Path, Entry: e.Title, Sabotage: s, Detail: "inserted unknown command verify_kernel"}}, nil
+	}
+	return sabotageEntries(be, pick, s, exists, path, rng)
+}
+
+// sabotageEntries implements the sabotages that only touch kernel, cmdline and initrd.
+func sabotageEntries(be []bootEntry, pick int, s Sabotage, exists func(string) bool, path func(int) string, rng *library.Rand) ([]Change, error) {
+	if exists == nil {
+		exists = KernelExists
+	}
+	e := be[pick]
+	switch s {
+	case MissingKernel:
+		old := e.kernel()
+		if old == "" {
+			return nil, fmt.Errorf("%w: entry %s has no kernel", ErrNotApplicable, e.name())
+		}
+		missing, err := bumpRelease(old, exists)
+		if err != nil {
+			return nil, err
+		}
+		e.setKernel(missing)
+		return []Change{{Path: path(pick), Entry: e.name(), Sabotage: s, Detail: fmt.Sprintf("kernel %s -> %s", old, missing)}}, nil
+
+	case SwapRoot:
+		old, ok := rootArg(e.cmdline())
+		if !ok {
+			return nil, fmt.Errorf("%w: entry %s has no root= argument", ErrNotApplicable, e.name())
+		}
+		// Prefer another entry's root (a real device on another install); else a random UUID.
+		j, err := otherIndex(rng, len(be), pick, func(j int) bool {
+			r, ok := rootArg(be[j].cmdline())
+			return ok && r != old
+		})
+		if err == nil {
+			other, _ := rootArg(be[j].cmdline())
+			e.setCmdline(setRootArg(e.cmdline(), other))
+			be[j].setCmdline(setRootArg(be[j].cmdline(), old))
+			return []Change{
+				{Path: path(pick), Entry: e.name(), Sabotage: s, Detail: fmt.Sprintf("root %s -> %s", old, other)},
+				{Path: path(j), Entry: be[j].name(), Sabotage: s, Detail: fmt.Sprintf("root %s -> %s", other, old)},
+			}, nil
+		}
+		uuid, err := randomUUID(rng)
+		if err != nil {
+			return nil, err
+		}
+		e.setCmdline(setRootArg(e.cmdline(), "UUID="+uuid))
+		return []Change{{Path: path(pick), Entry: e.name(), Sabotage: s, Detail: fmt.Sprintf("root %s -> UUID=%s", old, uuid)}}, nil
+
+	case DropInitrd:
+		if !e.dropInitrd() {
+			return nil, fmt.Errorf("%w: entry %s has no initrd", ErrNotApplicable, e.name())
+		}
+		return []Change{{Path: path(pick), Entry: e.name(), Sabotage: s, Detail: "initrd removed"}}, nil
+	}
+	return nil, fmt.Errorf("unknown sabotage %q", s)
+}
+
+// reorderMenu swaps the first top-level Linux menuentry (the usual default) with another one.
+func reorderMenu(c *GrubConfig, rng *library.Rand) ([]Change, error) {
+	var top []MenuEntry
+	for _, e := range c.Entries {
+		if e.Depth == 0 && c.commandLine(e, linuxCmds...) >= 0 {
+			top = append(top, e)
+		}
+	}
+	if len(top) < 2 {
+		return nil, fmt.Errorf("%w: fewer than two top-level Linux menuentries", ErrNotApplicable)
+	}
+	j, err := otherIndex(rng, len(top), 0, func(int) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	a, b := top[0], top[j]
+	blockA := append([]string(nil), c.Lines[a.Start:a.End+1]...)
+	blockB := append([]string(nil), c.Lines[b.Start:b.End+1]...)
+	between := append([]string(nil), c.Lines[a.End+1:b.Start]...)
+	repl := append(append(blockB, between...), blockA...)
+	if err := c.replaceLines(a.Start, b.End, repl); err != nil {
+		return nil, err
+	}
+	return []Change{{Path: c.Path, Entry: b.Title, Sabotage: Reorder, Detail: fmt.Sprintf("%q moved ahead of %q", b.Title, a.Title)}}, nil
+}
+
+// ---- entry adapters ----
+
+type blsEntry struct{ e *BLSEntry }
+
+func (b blsEntry) name() string        { return b.e.ID }
+func (b blsEntry) kernel() string      { return b.e.Get("linux") }
+func (b blsEntry) setKernel(k string)  { b.e.Set("linux", k) }
+func (b blsEntry) cmdline() string     { return b.e.Get("options") }
+func (b blsEntry) setCmdline(o string) { b.e.Set("options", o) }
+func (b blsEntry) dropInitrd() bool    { return b.e.Delete("initrd") > 0 }
+
+type grubEntry struct {
+	c *GrubConfig
+	i int
+}
+
+func (g grubEntry) entry() MenuEntry { return g.c.Entries[g.i] }
+func (g grubEntry) name() string     { return g.entry().Title }
+
+// linuxFields splits the entry's linux line into indent, command, kernel and the rest.
+func (g grubEntry) linuxFields() (li int, indent, cmd, kernel, rest string) {
+	li = g.c.commandLine(g.entry(), linuxCmds...)
+	line := g.c.Lines[li]
+	trimmed := strings.TrimLeft(line, " \t")
+	indent = line[:len(line)-len(trimmed)]
+	f := strings.Fields(trimmed)
+	cmd = f[0]
+	if len(f) > 1 {
+		kernel = f[1]
+	}
+	if len(f) > 2 {
+		rest = strings.Join(f[2:], " ")
+	}
+	return li, indent, cmd, kernel, rest
+}
+
+func (g grubEntry) setLinux(kernel, rest string) {
+	li, indent, cmd, _, _ := g.linuxFields()
+	line := indent + cmd + " " + kernel
+	if rest != "" {
+		line += " " + rest
+	}
+	g.c.Lines[li] = line
+}
+
+func (g grubEntry) kernel() string {
+	_, _, _, k, _ := g.linuxFields()
+	return k
+}
+
+func (g grubEntry) setKernel(k string) {
+	_, _, _, _, rest := g.linuxFields()
+	g.setLinux(k, rest)
+}
+
+func (g grubEntry) cmdline() string {
+	_, _, _, _, rest := g.linuxFields()
+	return rest
+}
+
+func (g grubEntry) setCmdline(o string) {
+	_, _, _, k, _ := g.linuxFields()
+	g.setLinux(k, o)
+}
+
+func (g grubEntry) dropInitrd() bool {
+	li := g.c.commandLine(g.entry(), initrdCmds...)
+	if li < 0 {
+		return false
+	}
+	return g.c.replaceLines(li, li, nil) == nil
+}
+
+// ---- helpers ----
+
+func rootArg(cmdline string) (string, bool) {
+	for _, f := range strings.Fields(cmdline) {
+		if v, ok := strings.CutPrefix(f, "root="); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func setRootArg(cmdline, root string) string {
+	f := strings.Fields(cmdline)
+	for i := range f {
+		if strings.HasPrefix(f[i], "root=") {
+			f[i] = "root=" + root
+		}
+	}
+	return strings.Join(f, " ")
+}
+
+// bumpRelease bumps the release number in a kernel file name or BLS id (vmlinuz-5.14.0-362.8.1.el9 ->
+// vmlinuz-5.14.0-363.8.1.el9, vmlinuz-6.8.0-45-generic -> vmlinuz-6.8.0-46-generic) until the
+// result does not exist, so the entry still looks routine.
+func bumpRelease(name string, exists func(string) bool) (string, error) {
+	dir, base := filepath.Split(name)
+	segs := strings.Split(base, "-")
+	seg := -1
+	for i := len(segs) - 1; i > 0; i-- {
+		if segs[i] != "" && segs[i][0] >= '0' && segs[i][0] <= '9' {
+			seg = i
+			break
+		}
+	}
+	if seg < 0 {
+		return "", fmt.Errorf("%w: no version in %s", ErrNotApplicable, name)
+	}
+	digits := strings.IndexFunc(segs[seg], func(r rune) bool { return r < '0' || r > '9' })
+	if digits < 0 {
+		digits = len(segs[seg])
+	}
+	n, err := strconv.Atoi(segs[seg][:digits])
+	if err != nil {
+		return "", fmt.Errorf("version in %s: %w", name, err)
+	}
+	tail := segs[seg][digits:]
+	for range 100 {
+		n++
+		segs[seg] = strconv.Itoa(n) + tail
+		candidate := dir + strings.Join(segs, "-")
+		if !exists(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no unused name found near %s", name)
+}
+
+// otherIndex picks an index in [0,n) other than not for which ok holds, at random from rng.
+func otherIndex(rng *library.Rand, n, not int, ok func(int) bool) (int, error) {
+	var cands []int
+	for j := range n {
+		if j != not && ok(j) {
+			cands = append(cands, j)
+		}
+	}
+	if len(cands) == 0 {
+		return 0, fmt.Errorf("%w: needs a second distinct entry", ErrNotApplicable)
+	}
+	k, err := randIntn(rng, len(cands))
+	if err != nil {
+		return 0, err
+	}
+	return cands[k], nil
+}
+
+// randIntn and randomUUID draw from the session's stream, so a session's seed decides which
+// entry is sabotaged and how.
+func randIntn(rng *library.Rand, n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("empty set")
+	}
+	return rng.IntN(n), nil
+}
+
+func randomUUID(rng *library.Rand) (string, error) {
+	var b [16]byte
+	if _, err := rng.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
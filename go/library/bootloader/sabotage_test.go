@@ -0,0 +1,248 @@
+package bootloader
+
+import (
+	"chaos-agent/library"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testKernels are the kernels the testdata entries boot; MissingKernel must pick none of them.
+var testKernels = map[string]bool{
+	"/vmlinuz-5.14.0-362.8.1.el9_3.x86_64":               true,
+	"/vmlinuz-5.14.0-284.11.1.el9_2.x86_64":              true,
+	"/vmlinuz-0-rescue-3f2a9c1e8b7d4e5fa6b1c2d3e4f50617": true,
+	"/boot/vmlinuz-6.8.0-45-generic":                     true,
+	"/boot/vmlinuz-6.1.0-25-amd64":                       true,
+}
+
+func testKernelExists(path string) bool { return testKernels[path] }
+
+// testSeeds vary which entry each sabotage picks.
+var testSeeds = []string{"boot-a", "boot-b", "boot-c", "boot-d"}
+
+func readTestBLS(t *testing.T) []*BLSEntry {
+	t.Helper()
+	entries, err := ReadBLSDir(filepath.Join("testdata", "entries"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("parsed %d BLS entries from testdata, want 3", len(entries))
+	}
+	return entries
+}
+
+func TestParseBLSTestdata(t *testing.T) {
+	for _, e := range readTestBLS(t) {
+		if e.Get("linux") == "" || e.Get("initrd") == "" || e.Get("options") == "" {
+			t.Errorf("%s: linux %q initrd %q options %q", e.ID, e.Get("linux"), e.Get("initrd"), e.Get("options"))
+		}
+		if got, err := ParseBLSEntry(e.Path, e.Bytes()); err != nil || !slices.Equal(got.Lines, e.Lines) {
+			t.Errorf("%s does not round-trip: %v", e.ID, err)
+		}
+	}
+}
+
+func TestSabotageBLS(t *testing.T) {
+	for _, tc := range []struct {
+		s     Sabotage
+		check func(t *testing.T, before, after *BLSEntry)
+	}{
+		{MissingKernel, func(t *testing.T, before, after *BLSEntry) {
+			if k := after.Get("linux"); k == before.Get("linux") || testKernels[k] {
+				t.Errorf("linux %s -> %s, want a kernel that does not exist", before.Get("linux"), k)
+			}
+		}},
+		{SwapRoot, func(t *testing.T, before, after *BLSEntry) {
+			old, _ := rootArg(before.Get("options"))
+			if r, ok := rootArg(after.Get("options")); !ok || r == old {
+				t.Errorf("root=%s unchanged", old)
+			}
+		}},
+		{DropInitrd, func(t *testing.T, _, after *BLSEntry) {
+			if after.Get("initrd") != "" {
+				t.Errorf("initrd %q still there", after.Get("initrd"))
+			}
+		}},
+		{Reorder, func(t *testing.T, before, after *BLSEntry) {
+			if after.Get("version") == before.Get("version") {
+				t.Errorf("version %s unchanged", before.Get("version"))
+			}
+		}},
+		{InvalidDirective, func(t *testing.T, _, after *BLSEntry) {
+			if after.Get("kernel-verify") == "" {
+				t.Error("no kernel-verify key")
+			}
+		}},
+	} {
+		t.Run(string(tc.s), func(t *testing.T) {
+			for _, seed := range testSeeds {
+				before := readTestBLS(t)
+				entries := readTestBLS(t)
+				changes, err := SabotageBLS(entries, tc.s, testKernelExists, library.NewRand(seed))
+				if err != nil {
+					t.Fatalf("seed %s: %v", seed, err)
+				}
+				if len(changes) == 0 {
+					t.Fatalf("seed %s: no changes", seed)
+				}
+				for i, e := range entries {
+					after, err := ParseBLSEntry(e.Path, e.Bytes())
+					if err != nil {
+						t.Fatalf("seed %s: sabotaged entry no longer parses: %v", seed, err)
+					}
+					changed := slices.ContainsFunc(changes, func(c Change) bool { return c.Path == e.Path })
+					if !changed {
+						if !slices.Equal(after.Lines, before[i].Lines) {
+							t.Errorf("seed %s: %s changed but is not reported", seed, e.ID)
+						}
+						continue
+					}
+					tc.check(t, before[i], after)
+				}
+			}
+		})
+	}
+}
+
+func readTestGrub(t *testing.T) *GrubConfig {
+	t.Helper()
+	c, err := ReadGrubConfig(filepath.Join("testdata", "grub.cfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParseGrubTestdata(t *testing.T) {
+	c := readTestGrub(t)
+	var titles []string
+	for _, e := range c.Entries {
+		titles = append(titles, e.Title)
+	}
+	want := []string{
+		"Ubuntu",
+		"Ubuntu, with Linux 6.8.0-45-generic",
+		"Ubuntu, with Linux 6.8.0-45-generic (recovery mode)",
+		"Debian GNU/Linux 12 (bookworm) (on /dev/sdb2)",
+		"UEFI Firmware Settings",
+	}
+	if !slices.Equal(titles, want) {
+		t.Fatalf("entries %q, want %q", titles, want)
+	}
+	if c.Entries[1].Depth != 1 || c.Entries[0].Depth != 0 {
+		t.Errorf("depths %d, %d; want submenu entries one level down", c.Entries[0].Depth, c.Entries[1].Depth)
+	}
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Bytes()) != string(data) {
+		t.Error("grub.cfg does not round-trip")
+	}
+}
+
+// grubEntryNamed returns the entry titled title, failing the test if there is none.
+func grubEntryNamed(t *testing.T, c *GrubConfig, title string) grubEntry {
+	t.Helper()
+	for i, e := range c.Entries {
+		if e.Title == title {
+			return grubEntry{c: c, i: i}
+		}
+	}
+	t.Fatalf("no menuentry %q", title)
+	return grubEntry{}
+}
+
+// firstLinuxEntry is the title of the first top-level menuentry that boots a kernel.
+func firstLinuxEntry(c *GrubConfig) string {
+	for _, e := range c.Entries {
+		if e.Depth == 0 && c.commandLine(e, linuxCmds...) >= 0 {
+			return e.Title
+		}
+	}
+	return ""
+}
+
+func TestSabotageGrubConfig(t *testing.T) {
+	for _, tc := range []struct {
+		s     Sabotage
+		check func(t *testing.T, before, after *GrubConfig, c Change)
+	}{
+		{MissingKernel, func(t *testing.T, before, after *GrubConfig, c Change) {
+			old, k := grubEntryNamed(t, before, c.Entry).kernel(), grubEntryNamed(t, after, c.Entry).kernel()
+			if k == old || testKernels[k] {
+				t.Errorf("%s: linux %s -> %s, want a kernel that does not exist", c.Entry, old, k)
+			}
+		}},
+		{SwapRoot, func(t *testing.T, before, after *GrubConfig, c Change) {
+			old, _ := rootArg(grubEntryNamed(t, before, c.Entry).cmdline())
+			if r, ok := rootArg(grubEntryNamed(t, after, c.Entry).cmdline()); !ok || r == old {
+				t.Errorf("%s: root=%s unchanged", c.Entry, old)
+			}
+		}},
+		{DropInitrd, func(t *testing.T, _, after *GrubConfig, c Change) {
+			if e := grubEntryNamed(t, after, c.Entry); after.commandLine(e.entry(), initrdCmds...) >= 0 {
+				t.Errorf("%s still has an initrd", c.Entry)
+			}
+		}},
+		{Reorder, func(t *testing.T, before, after *GrubConfig, c Change) {
+			if first := firstLinuxEntry(after); first != c.Entry || first == firstLinuxEntry(before) {
+				t.Errorf("first entry %q, want %q moved ahead of %q", first, c.Entry, firstLinuxEntry(before))
+			}
+		}},
+		{InvalidDirective, func(t *testing.T, _, after *GrubConfig, c Change) {
+			e := grubEntryNamed(t, after, c.Entry).entry()
+			if !slices.ContainsFunc(after.Lines[e.Start:e.End], func(l string) bool {
+				return strings.HasPrefix(strings.TrimSpace(l), "verify_kernel")
+			}) {
+				t.Errorf("%s has no verify_kernel command", c.Entry)
+			}
+		}},
+	} {
+		t.Run(string(tc.s), func(t *testing.T) {
+			for _, seed := range testSeeds {
+				before := readTestGrub(t)
+				c := readTestGrub(t)
+				changes, err := SabotageGrubConfig(c, tc.s, testKernelExists, library.NewRand(seed))
+				if err != nil {
+					t.Fatalf("seed %s: %v", seed, err)
+				}
+				after, err := ParseGrubConfig(c.Path, c.Bytes())
+				if err != nil {
+					t.Fatalf("seed %s: sabotaged grub.cfg no longer parses: %v", seed, err)
+				}
+				if len(after.Entries) != len(before.Entries) {
+					t.Errorf("seed %s: %d menuentries, want %d", seed, len(after.Entries), len(before.Entries))
+				}
+				if len(changes) == 0 {
+					t.Fatalf("seed %s: no changes", seed)
+				}
+				for _, ch := range changes {
+					tc.check(t, before, after, ch)
+				}
+			}
+		})
+	}
+}
+
+func TestSabotageNotApplicable(t *testing.T) {
+	entries := readTestBLS(t)
+	for _, e := range entries {
+		e.Delete("initrd")
+	}
+	if _, err := SabotageBLS(entries, DropInitrd, testKernelExists, library.NewRand("")); !errors.Is(err, ErrNotApplicable) {
+		t.Errorf("dropping absent initrds: %v, want ErrNotApplicable", err)
+	}
+	c, err := ParseGrubConfig("grub.cfg", []byte("insmod blscfg\nblscfg\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SabotageGrubConfig(c, MissingKernel, testKernelExists, library.NewRand("")); !errors.Is(err, ErrNotApplicable) {
+		t.Errorf("blscfg-only grub.cfg: %v, want ErrNotApplicable", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Package breaks is the compiled-in registry of break implementations.
+// Standalone break binaries (./breaks/cheap/*) and the resident agent both run breaks from here,
+// so a break behaves the same whichever way it was delivered.
+package breaks
+
+import (
+	"chaos-agent/library"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Env is what a break receives at run time.
+type Env struct {
+	Token    string
+	Params   map[string]string
+	Reporter library.Reporter
+	Session  *library.Session // the break's own root, vault, journal and random stream
+}
+
+// Report sends a status message for this session to the monitor.
+func (e Env) Report(status, message string) {
+	e.Reporter.Report(status, message)
+}
+
+// Param returns the named parameter, or def when it is unset.
+func (e Env) Param(name, def string) string {
+	if v, ok := e.Params[name]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Func is a break implementation. It reports progress through env and returns an error on failure;
+// lifecycle messages (init, armed/detonated, operation_complete) are sent by Run, not by the break.
+type Func func(ctx context.Context, env Env) error
+
+var registry = map[string]Func{
+	"broken_boot_loader": brokenBootLoader,
+	"command_corrupt":    commandCorrupt,
+	"file_swap":          fileSwap,
+	"meta_sabotage":      metaSabotage,
+}
+
+// Lookup returns the break registered under name.
+func Lookup(name string) (Func, error) {
+	f, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown break %q", name)
+	}
+	return f, nil
+}
+
+// Names lists the registered breaks in order.
+func Names() []string {
+	out := make([]string, 0, len(registry))
+	for n := range registry {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,154 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/bootloader"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// brokenBootLoader corrupts one kernel, initramfs or GRUB/BLS file so the next boot fails.
+// Kernels and initramfs images are corrupted as bytes; the "strategy" param picks the corruption
+// strategy (default: full overwrite). grub.cfg, BLS entries and grubenv are sabotaged semantically
+// so they still parse; "sabotage" picks the kind and mode=bytes treats them as bytes too.
+// Where those files live depends on the distro family (see library.DetectDistro): RHEL keeps
+// initramfs-*.img, grub2/ and BLS entries, Debian initrd.img-* and grub/ without BLS.
+func brokenBootLoader(_ context.Context, env Env) error {
+	distro := library.DetectDistro(env.Session.Root)
+	env.Report("chaos_report", fmt.Sprintf("boot layout: %s", distro))
+	vmlinuzFiles := distro.BootFiles(env.Session.Root)
+
+	env.Report("chaos_report", fmt.Sprintf("found vmlinuz files: %v", vmlinuzFiles))
+	if len(vmlinuzFiles) == 0 {
+		env.Report("chaos_report", "no matching kernel/initramfs/grub files found")
+		return errors.New("no candidate files to corrupt")
+	}
+
+	idx, err := randIndex(env.Session.Rand, len(vmlinuzFiles))
+	if err != nil {
+		return fmt.Errorf("random index failed: %w", err)
+	}
+	file := vmlinuzFiles[idx]
+	if env.Param("mode", "semantic") == "semantic" && isBootConfig(env.Session.Root, distro, file) {
+		return sabotageBootConfig(env, distro, file)
+	}
+	strategy, err := library.StrategyFromParams(env.Params, library.Overwrite{Percent: 100})
+	if err != nil {
+		return err
+	}
+	res, err := library.CorruptFileWith(env.Session, file, strategy)
+	if err != nil {
+		env.Report("chaos_report", fmt.Sprintf("corrupting kernel failed: %v", err))
+		return err
+	}
+	env.Report("chaos_report", fmt.Sprintf("corrupted kernel file %s", file))
+	reportCorruption(env, res, packageOf(env.Session.Root, file))
+	env.Report("variable", fmt.Sprintf("BrokenFiles,%s", file))
+	return nil
+}
+
+func isBootConfig(root library.Root, distro library.DistroProfile, path string) bool {
+	switch filepath.Base(path) {
+	case "grub.cfg", "grubenv":
+		return true
+	}
+	return distro.BLSDir != "" && filepath.Dir(path) == root.Path(distro.BLSDir)
+}
+
+// kernelExists is bootloader.KernelExists for the testenv under root.
+func kernelExists(root library.Root) func(string) bool {
+	return func(path string) bool {
+		for _, p := range []string{path, filepath.Join("/boot", path)} {
+			if _, err := os.Stat(root.Path(p)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// sabotageBootConfig applies one semantic sabotage to grub.cfg or to the BLS entries next to
+// path (or to grubenv), trying kinds in random order until one applies to this config.
+func sabotageBootConfig(env Env, distro library.DistroProfile, path string) error {
+	all := bootloader.Sabotages
+	if filepath.Base(path) == "grubenv" {
+		all = bootloader.GrubenvSabotages
+	}
+	kinds := []bootloader.Sabotage{bootloader.Sabotage(env.Param("sabotage", ""))}
+	if kinds[0] == "" {
+		kinds = append([]bootloader.Sabotage(nil), all...)
+		for i := len(kinds) - 1; i > 0; i-- {
+			j, err := randIndex(env.Session.Rand, i+1)
+			if err != nil {
+				return err
+			}
+			kinds[i], kinds[j] = kinds[j], kinds[i]
+		}
+	}
+
+	var (
+		changes []bootloader.Change
+		write   func(*library.Session) error
+		err     error
+	)
+	for _, kind := range kinds {
+		switch filepath.Base(path) {
+		case "grubenv":
+			genv, rerr := bootloader.ReadGrubenv(path)
+			if rerr != nil {
+				return rerr
+			}
+			var ids []string
+			if distro.BLSDir != "" {
+				entries, _ := bootloader.ReadBLSDir(env.Session.Root.Path(distro.BLSDir))
+				for _, e := range entries {
+					ids = append(ids, e.ID)
+				}
+			}
+			var ch bootloader.Change
+			ch, err = bootloader.SabotageGrubenv(genv, kind, ids, env.Session.Rand)
+			changes = []bootloader.Change{ch}
+			write = genv.WriteFile
+		case "grub.cfg":
+			cfg, rerr := bootloader.ReadGrubConfig(path)
+			if rerr != nil {
+				return rerr
+			}
+			changes, err = bootloader.SabotageGrubConfig(cfg, kind, kernelExists(env.Session.Root), env.Session.Rand)
+			write = cfg.WriteFile
+		default:
+			entries, rerr := bootloader.ReadBLSDir(filepath.Dir(path))
+			if rerr != nil {
+				return rerr
+			}
+			changes, err = bootloader.SabotageBLS(entries, kind, kernelExists(env.Session.Root), env.Session.Rand)
+			write = func(s *library.Session) error { return bootloader.WriteBLSChanges(s, entries, changes) }
+		}
+		if !errors.Is(err, bootloader.ErrNotApplicable) {
+			break
+		}
+	}
+	if err != nil {
+		env.Report("chaos_report", fmt.Sprintf("sabotaging %s failed: %v", path, err))
+		return err
+	}
+	if err := write(env.Session); err != nil {
+		return fmt.Errorf("write sabotaged config: %w", err)
+	}
+	for _, c := range changes {
+		env.Report("chaos_report", fmt.Sprintf("sabotaged boot config: %s", c))
+		env.Report("variable", fmt.Sprintf("BrokenFiles,%s", c.Path))
+	}
+	return nil
+}
+
+// randIndex returns a uniform random int in [0, n) from rng.
+func randIndex(rng *library.Rand, n int) (int, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("empty set")
+	}
+	return rng.IntN(n), nil
+}
@@ -0,0 +1,63 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// commandCorrupt corrupts 15–20 randomly chosen system binaries (or whatever the target_*
+// params select, see selectTargets). By default each ELF binary has
+// one random ELF structure damaged (header, program headers, .dynamic, a DT_NEEDED entry, the
+// interpreter or a slice of .text) so failures differ; non-ELF files are overwritten instead.
+// The "strategy" param picks another corruption strategy for all files.
+func commandCorrupt(_ context.Context, env Env) error {
+	files, owners, err := selectTargets(env)
+	if err != nil {
+		return err
+	}
+	strategy, err := library.StrategyFromParams(env.Params, library.ElfCorruption{Target: library.ElfRandom})
+	if err != nil {
+		return err
+	}
+	env.Report("chaos_report", fmt.Sprintf("files to be corrupted: %s", files))
+	for _, file := range files {
+		res, err := library.CorruptFileWith(env.Session, file, strategy)
+		if errors.Is(err, library.ErrNotELF) {
+			res, err = library.CorruptFileWith(env.Session, file, library.Overwrite{Percent: 100})
+		}
+		if err != nil {
+			env.Report("chaos_report", "broken")
+			continue
+		}
+		reportCorruption(env, res, owners[file])
+	}
+	for _, file := range files {
+		env.Report("variable", fmt.Sprintf("BrokenFiles,%s", file))
+	}
+	return nil
+}
+
+// corruptionReport is a corruption result with the damaged file's owning package.
+type corruptionReport struct {
+	library.CorruptionResult
+	Package *library.PackageInfo `json:"package,omitempty"`
+}
+
+// reportCorruption sends the corruption result as JSON so the verification side can
+// classify the symptom by the strategy and structure that were damaged, and knows which
+// package to reinstall (pkg is the zero value when the file has no known owner).
+func reportCorruption(env Env, res library.CorruptionResult, pkg library.PackageInfo) {
+	r := corruptionReport{CorruptionResult: res}
+	if pkg.Name != "" {
+		r.Package = &pkg
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		env.Report("chaos_report", res.String())
+		return
+	}
+	env.Report("corruption", string(b))
+}
@@ -0,0 +1,42 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// fileSwap exchanges the content of 15–20 randomly chosen system binaries (or whatever the
+// target_* params select, see selectTargets). The "permutation" param picks how (cycle,
+// pairs, same_dir or similar_size; default cycle); every chosen file that takes part ends
+// up with another's content, and the mapping is reported as "jumble_mapping" so
+// verification can tell which binary a path now holds.
+func fileSwap(_ context.Context, env Env) error {
+	fmt.Println("Starting file swap chaos operation...")
+	mode, err := library.ParsePermutationMode(env.Param("permutation", string(library.PermuteCycle)))
+	if err != nil {
+		return err
+	}
+	files, owners, err := selectTargets(env)
+	if err != nil {
+		return err
+	}
+	env.Report("chaos_report", fmt.Sprintf("files to be jumbled: %s", files))
+	m := &library.Jumble{Paths: files, Mode: mode}
+	if _, err := env.Session.Perform(m); err != nil {
+		return fmt.Errorf("error in jumble (%s): %w", mode, err)
+	}
+	mapping := library.JumbleMapping{Mode: mode, Moves: m.Moves(), Packages: make(map[string]library.PackageInfo)}
+	for _, mv := range mapping.Moves {
+		if pkg, ok := owners[mv.Dst]; ok {
+			mapping.Packages[mv.Dst] = pkg
+		}
+	}
+	b, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	env.Report("jumble_mapping", string(b))
+	return nil
+}
@@ -0,0 +1,115 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// metaSabotage damages the metadata of 15–20 randomly chosen system binaries (or whatever
+// the target_* params select, see selectTargets) and leaves their content alone: execute bit
+// dropped, setuid flipped, owner changed to nobody, SELinux label or file capabilities
+// stripped or replaced. The "sabotage" param restricts the kinds (comma-separated, see
+// library.MetaSabotages; default all but chmod) and "value" is passed to each. Every file
+// gets the first kind, in random order, that changes something on it; each change is
+// reported as "meta_change".
+func metaSabotage(_ context.Context, env Env) error {
+	kinds, err := metaSabotageKinds(env.Param("sabotage", ""))
+	if err != nil {
+		return err
+	}
+	files, owners, err := selectTargets(env)
+	if err != nil {
+		return err
+	}
+	env.Report("chaos_report", fmt.Sprintf("files to be sabotaged: %s", files))
+	var changed int
+	for _, file := range files {
+		order := append([]library.MetaSabotage(nil), kinds...)
+		for i := len(order) - 1; i > 0; i-- {
+			j, err := randIndex(env.Session.Rand, i+1)
+			if err != nil {
+				return err
+			}
+			order[i], order[j] = order[j], order[i]
+		}
+		m, err := applyMetaSabotage(env.Session, file, order, env.Param("value", ""))
+		if err != nil {
+			env.Report("chaos_report", fmt.Sprintf("sabotaging metadata of %s failed: %v", file, err))
+			continue
+		}
+		changed++
+		reportMetaChange(env, m, owners[file])
+		env.Report("variable", fmt.Sprintf("BrokenFiles,%s", file))
+	}
+	if changed == 0 {
+		return errors.New("no file's metadata could be sabotaged")
+	}
+	return nil
+}
+
+// metaSabotageKinds parses the "sabotage" param.
+func metaSabotageKinds(param string) ([]library.MetaSabotage, error) {
+	if param == "" {
+		var kinds []library.MetaSabotage
+		for _, k := range library.MetaSabotages {
+			if k != library.MetaChmod { // needs a value to mean anything
+				kinds = append(kinds, k)
+			}
+		}
+		return kinds, nil
+	}
+	var kinds []library.MetaSabotage
+	for _, s := range strings.Split(param, ",") {
+		k := library.MetaSabotage(strings.TrimSpace(s))
+		if !slices.Contains(library.MetaSabotages, k) {
+			return nil, fmt.Errorf("unknown metadata sabotage %q", k)
+		}
+		kinds = append(kinds, k)
+	}
+	return kinds, nil
+}
+
+// applyMetaSabotage performs the first kind that changes file's metadata, under s.
+func applyMetaSabotage(s *library.Session, file string, kinds []library.MetaSabotage, value string) (*library.MetaChange, error) {
+	var errs []error
+	for _, k := range kinds {
+		m := &library.MetaChange{Path: file, Sabotage: k, Value: value}
+		_, err := s.Perform(m)
+		if err == nil {
+			return m, nil
+		}
+		if !errors.Is(err, library.ErrNoMetaChange) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, library.ErrNoMetaChange
+	}
+	return nil, errors.Join(errs...)
+}
+
+// metaChangeReport is a metadata sabotage with the file's owning package.
+type metaChangeReport struct {
+	library.MetaChangeResult
+	Package *library.PackageInfo `json:"package,omitempty"`
+}
+
+// reportMetaChange sends what changed as JSON, so verification can check the metadata, not
+// the content, and knows which package's file attributes to restore.
+func reportMetaChange(env Env, m *library.MetaChange, pkg library.PackageInfo) {
+	r := metaChangeReport{MetaChangeResult: m.Result()}
+	if pkg.Name != "" {
+		r.Package = &pkg
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		env.Report("chaos_report", fmt.Sprintf("%s %s", m.Sabotage, m.Path))
+		return
+	}
+	env.Report("meta_change", string(b))
+}
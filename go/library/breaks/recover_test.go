@@ -0,0 +1,69 @@
+package breaks
+
+import (
+	"bytes"
+	"chaos-agent/library"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// interrupt rewrites the session's journal as if the process died after applying each
+// mutation: the verified and manifest records are dropped.
+func interrupt(t *testing.T, s sandbox) string {
+	t.Helper()
+	path := library.JournalPath(s.root.Path(library.JournalDir), s.cfg.Token)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept [][]byte
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var r library.JournalRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.State != library.StateVerified && r.State != "manifest" {
+			kept = append(kept, line)
+		}
+	}
+	if err := os.WriteFile(path, append(bytes.Join(kept, []byte("\n")), '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecoverNeedsTheVaultKey(t *testing.T) {
+	s := newSandbox(t, library.FamilyRHEL)
+	before := s.snapshot(t)
+	s.run(t, "command_corrupt")
+	path := interrupt(t, s)
+
+	// What the agent does at start: no key, so nothing that needs the vault is reverted,
+	// and the journal stays pending for a keyed recovery.
+	lines, err := library.RecoverJournal(s.root, path, library.RecoverRollback, nil)
+	if !errors.Is(err, library.ErrUnrecovered) {
+		t.Fatalf("keyless recovery: %v, want ErrUnrecovered", err)
+	}
+	if len(lines) == 0 || !strings.Contains(lines[0], "unrecovered") {
+		t.Errorf("keyless recovery lines %q", lines)
+	}
+	pending, err := library.PendingJournals(s.root.Path(library.JournalDir))
+	if err != nil || len(pending) != 1 || pending[0] != s.cfg.Token {
+		t.Fatalf("pending journals %v, %v; want %s", pending, err, s.cfg.Token)
+	}
+
+	// What the monitor's recover instruction does: the key opens the journaled vault.
+	rec := &recorder{}
+	if err := Recover(t.Context(), s.cfg, library.RecoverRollback, rec); err != nil {
+		t.Fatalf("keyed recovery: %v\n%s", err, strings.Join(rec.msgs, "\n"))
+	}
+	if diff := changed(before, s.snapshot(t)); len(diff) > 0 {
+		t.Errorf("after recovery these still differ: %v", diff)
+	}
+	if pending, _ := library.PendingJournals(s.root.Path(library.JournalDir)); len(pending) != 0 {
+		t.Errorf("journals still pending after keyed recovery: %v", pending)
+	}
+}
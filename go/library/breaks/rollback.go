@@ -0,0 +1,103 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RollbackName is the instruction name that rolls a session back instead of running a break.
+const RollbackName = "rollback"
+
+// RecoverName is the instruction name that recovers a session's interrupted mutations with
+// the vault key the agent could not recover them without.
+const RecoverName = "recover"
+
+// RollbackLocal restores every original the session cfg.Token kept in this host's vault
+// (cfg.Params["vault_dir"], default vault.DefaultDir, both under cfg.Root). A vault that
+// rolled back cleanly is deleted. Originals outside cfg.Root are not restored. It waits for
+// the session's break if it is running, so originals are not restored under a live mutation.
+func RollbackLocal(cfg datatypes.BreakConfig) (vault.Report, error) {
+	key, err := vault.ParseKey(cfg.VaultKey)
+	if err != nil {
+		return vault.Report{Token: cfg.Token}, err
+	}
+	root := library.Root(cfg.Root)
+	dir := vault.DefaultDir
+	if d := cfg.Params["vault_dir"]; d != "" {
+		dir = d
+	}
+	store := &vault.DirStore{Root: root.Path(dir)}
+
+	defer lockSession(cfg.Token)()
+	rep, err := vault.Rollback(key, store, cfg.Token, root.Contains)
+	if err != nil {
+		return rep, err
+	}
+	if rep.OK() {
+		if err := store.Remove(cfg.Token); err != nil {
+			return rep, fmt.Errorf("remove vault: %w", err)
+		}
+	}
+	return rep, nil
+}
+
+// Rollback runs RollbackLocal for the resident agent, reporting the outcome as a "rollback"
+// message and each unrestored path as an "error", framed by init and operation_complete like a break.
+func Rollback(_ context.Context, cfg datatypes.BreakConfig, rep library.Reporter) error {
+	rep.Report("init", cfg.Token)
+	defer rep.Report("operation_complete", "complete")
+
+	res, err := RollbackLocal(cfg)
+	if err != nil {
+		rep.Report("error", fmt.Sprintf("rollback %s: %v", cfg.Token, err))
+		return err
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	rep.Report("rollback", string(b))
+	if !res.OK() {
+		paths := make([]string, len(res.Failed))
+		for i, f := range res.Failed {
+			paths[i] = f.Path
+			rep.Report("error", fmt.Sprintf("rollback %s: %s: %s", cfg.Token, f.Path, f.Error))
+		}
+		return fmt.Errorf("rollback %s: could not restore %s", cfg.Token, strings.Join(paths, ", "))
+	}
+	return nil
+}
+
+// Recover finishes the interrupted mutations in session cfg.Token's journal under cfg.Root,
+// opening the session's vault with cfg.VaultKey, in mode (resume when empty). Each outcome
+// is reported as "recovered", and mutations still unrecovered as an "error", framed by init
+// and operation_complete like a break.
+func Recover(_ context.Context, cfg datatypes.BreakConfig, mode library.RecoveryMode, rep library.Reporter) error {
+	rep.Report("init", cfg.Token)
+	defer rep.Report("operation_complete", "complete")
+
+	key, err := vault.ParseKey(cfg.VaultKey)
+	if err != nil {
+		rep.Report("error", fmt.Sprintf("recover %s: %v", cfg.Token, err))
+		return err
+	}
+	if mode == "" {
+		mode = library.RecoverResume
+	}
+	root := library.Root(cfg.Root)
+	unlock := lockSession(cfg.Token)
+	lines, err := library.RecoverJournal(root, library.JournalPath(root.Path(library.JournalDir), cfg.Token), mode, key)
+	unlock()
+	for _, l := range lines {
+		rep.Report("recovered", l)
+	}
+	if err != nil {
+		rep.Report("error", fmt.Sprintf("recover %s: %v", cfg.Token, err))
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/vault"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRollbackLocalUnderRoot(t *testing.T) {
+	s := newSandbox(t, library.FamilyRHEL)
+	before := s.snapshot(t)
+	s.run(t, "command_corrupt")
+	if len(changed(before, s.snapshot(t))) == 0 {
+		t.Fatal("command_corrupt changed nothing in the sandbox")
+	}
+	vaultDir := s.root.Path(filepath.Join(vault.DefaultDir, s.cfg.Token))
+	if _, err := os.Stat(vaultDir); err != nil {
+		t.Fatalf("no vault under the sandbox root: %v", err)
+	}
+
+	rep, err := RollbackLocal(s.cfg)
+	if err != nil || !rep.OK() {
+		t.Fatalf("rollback %+v, %v", rep, err)
+	}
+	if diff := changed(before, s.snapshot(t)); len(diff) > 0 {
+		t.Errorf("after rollback these still differ: %v", diff)
+	}
+	if _, err := os.Stat(vaultDir); !os.IsNotExist(err) {
+		t.Errorf("clean rollback left its vault behind: %v", err)
+	}
+}
+
+// TestRollbackLocalVaultDirIsUnderRoot checks a vault_dir param names a directory inside the
+// sandbox, not the machine's.
+func TestRollbackLocalVaultDirIsUnderRoot(t *testing.T) {
+	s := newSandbox(t, library.FamilyDebian)
+	s.run(t, "command_corrupt")
+	if err := os.MkdirAll(s.root.Path("/srv"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(s.root.Path(vault.DefaultDir), s.root.Path("/srv/vault")); err != nil {
+		t.Fatal(err)
+	}
+	s.cfg.Params["vault_dir"] = "/srv/vault"
+	rep, err := RollbackLocal(s.cfg)
+	if err != nil || !rep.OK() || len(rep.Restored) == 0 {
+		t.Fatalf("rollback from vault_dir %+v, %v", rep, err)
+	}
+}
@@ -0,0 +1,84 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestBreaksStayUnderRoot runs the file breaks with cfg.Root on a fixture whose links point
+// out of it, and checks they change files in the fixture and nothing outside.
+func TestBreaksStayUnderRoot(t *testing.T) {
+	for _, name := range []string{"command_corrupt", "file_swap"} {
+		t.Run(name, func(t *testing.T) {
+			s := newSandbox(t, library.FamilyDebian)
+			outside := t.TempDir()
+			victim := filepath.Join(outside, "victim")
+			if err := os.WriteFile(victim, []byte("#!/bin/sh\n# outside the fixture\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for link, target := range map[string]string{
+				"/usr/bin/escape": victim,
+				"/usr/bin/up":     "../../../../../.." + victim,
+				"/usr/sbin/out":   outside,
+			} {
+				if err := os.Symlink(target, s.root.Path(link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.cfg.Params["target_roots"] = "/usr/bin,/usr/sbin,/usr/sbin/out"
+
+			before := s.snapshot(t)
+			rec := s.run(t, name)
+			if len(changed(before, s.snapshot(t))) == 0 {
+				t.Fatalf("%s changed nothing in the fixture", name)
+			}
+			if got, _ := os.ReadFile(victim); string(got) != "#!/bin/sh\n# outside the fixture\n" {
+				t.Errorf("%s changed %s, outside the fixture", name, victim)
+			}
+			for _, msg := range rec.msgs {
+				if file, ok := strings.CutPrefix(msg, "variable: BrokenFiles,"); ok && !s.root.Contains(file) {
+					t.Errorf("reported broken file %s is outside the fixture", file)
+				}
+			}
+		})
+	}
+}
+
+// TestSessionsRunSideBySide runs breaks of two sessions at once, each on its own fixture,
+// and checks each changed only its own.
+func TestSessionsRunSideBySide(t *testing.T) {
+	sandboxes := []sandbox{newSandbox(t, library.FamilyRHEL), newSandbox(t, library.FamilyDebian)}
+	befores := make([]map[string]string, len(sandboxes))
+	recs := make([]*recorder, len(sandboxes))
+	errs := make([]error, len(sandboxes))
+	var wg sync.WaitGroup
+	for i := range sandboxes {
+		sandboxes[i].cfg.Token = fmt.Sprintf("side-by-side-%d", i)
+		befores[i], recs[i] = sandboxes[i].snapshot(t), &recorder{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = Run(context.Background(), "command_corrupt", sandboxes[i].cfg, recs[i])
+		}()
+	}
+	wg.Wait()
+	for i, s := range sandboxes {
+		if errs[i] != nil {
+			t.Fatalf("session %d: %v\n%s", i, errs[i], strings.Join(recs[i].msgs, "\n"))
+		}
+		if len(changed(befores[i], s.snapshot(t))) == 0 {
+			t.Errorf("session %d changed nothing in its fixture", i)
+		}
+		for _, msg := range recs[i].msgs {
+			if file, ok := strings.CutPrefix(msg, "variable: BrokenFiles,"); ok && !s.root.Contains(file) {
+				t.Errorf("session %d reported broken file %s outside its fixture", i, file)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Each break runs under its own library.Session, so breaks of different sessions run side by
+// side. What runs for one session (its break, rollback and recovery) is serialized, so
+// originals are not restored under a live mutation.
+var (
+	sessionMu    sync.Mutex
+	sessionLocks = make(map[string]*sessionLock)
+)
+
+type sessionLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockSession waits until nothing else runs for token, returning the func that lets the
+// next one run.
+func lockSession(token string) (unlock func()) {
+	sessionMu.Lock()
+	l := sessionLocks[token]
+	if l == nil {
+		l = &sessionLock{}
+		sessionLocks[token] = l
+	}
+	l.users++
+	sessionMu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		sessionMu.Lock()
+		if l.users--; l.users == 0 {
+			delete(sessionLocks, token)
+		}
+		sessionMu.Unlock()
+	}
+}
+
+// openVault returns the vault that keeps originals for this session. Without a key from
+// the monitor one is generated and reported, so the session can still be rolled back.
+func openVault(cfg datatypes.BreakConfig, rep library.Reporter) (*vault.Vault, error) {
+	keyB64 := cfg.VaultKey
+	if keyB64 == "" {
+		var err error
+		if keyB64, err = vault.GenerateKey(); err != nil {
+			return nil, fmt.Errorf("generate vault key: %w", err)
+		}
+		rep.Report("vault_key", keyB64)
+	}
+	key, err := vault.ParseKey(keyB64)
+	if err != nil {
+		return nil, err
+	}
+	var store vault.Store
+	switch cfg.VaultMode {
+	case "", "local":
+		store = &vault.DirStore{Root: library.Root(cfg.Root).Path(vault.DefaultDir)}
+	case "monitor":
+		store = vault.ReporterStore{Report: rep.Report}
+	default:
+		return nil, fmt.Errorf("unknown vault mode %q", cfg.VaultMode)
+	}
+	return vault.New(cfg.Token, key, store)
+}
+
+// reportManifest sends the before/after state of every path the break mutated, so the
+// monitor can later tell a restored file from a still-broken one by hash.
+func reportManifest(rep library.Reporter, token string, entries []library.ManifestEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	b, err := json.Marshal(library.Manifest{Token: token, Entries: entries})
+	if err != nil {
+		rep.Report("error", fmt.Sprintf("manifest: %v", err))
+		return
+	}
+	rep.Report("manifest", string(b))
+}
+
+// Run executes the named break for one session: it announces the session, waits out any
+// detonation delay, runs the break and always finishes with operation_complete so the
+// monitor can close the session promptly.
+func Run(ctx context.Context, name string, cfg datatypes.BreakConfig, rep library.Reporter) error {
+	f, err := Lookup(name)
+	if err != nil {
+		rep.Report("error", err.Error())
+		rep.Report("operation_complete", "complete")
+		return err
+	}
+	rep.Report("init", cfg.Token)
+
+	// Delayed breaks report "armed" now and "detonated" when they actually fire.
+	if err := library.AwaitDetonation(ctx, library.DetonationTime(cfg, time.Now()), rep.Report); err != nil {
+		rep.Report("error", err.Error())
+		rep.Report("operation_complete", "complete")
+		return err
+	}
+
+	v, err := openVault(cfg, rep)
+	if err != nil {
+		rep.Report("error", err.Error())
+		rep.Report("operation_complete", "complete")
+		return err
+	}
+
+	root := library.Root(cfg.Root)
+	j, err := library.OpenJournal(library.JournalPath(root.Path(library.JournalDir), cfg.Token))
+	if err != nil {
+		err = fmt.Errorf("open journal: %w", err)
+		rep.Report("error", err.Error())
+		rep.Report("operation_complete", "complete")
+		return err
+	}
+	defer func() { _ = j.Close() }()
+	if err := j.RecordVault(library.JournalVault{Token: cfg.Token, Dir: root.Path(vault.DefaultDir)}); err != nil {
+		err = fmt.Errorf("journal vault: %w", err)
+		rep.Report("error", err.Error())
+		rep.Report("operation_complete", "complete")
+		return err
+	}
+
+	env := Env{Token: cfg.Token, Params: cfg.Params, Reporter: rep, Session: library.NewSession(root, v, j, cfg.Seed)}
+	unlock := lockSession(cfg.Token)
+	runErr := f(ctx, env)
+	unlock()
+	reportManifest(rep, cfg.Token, j.Manifest())
+	if runErr != nil {
+		rep.Report("error", fmt.Sprintf("%s: %v", name, runErr))
+	}
+	rep.Report("operation_complete", "complete")
+	return runErr
+}
+
+// Main is the body of a standalone break binary: load the sealed config delivered by the
+// monitor, report over TCP and exit non-zero on failure. Stopping the unit (SIGTERM)
+// while the break is armed disarms it.
+func Main(name string) {
+	cfg, err := library.LoadBreakConfig(os.Stdin, os.Args[1:])
+	if err != nil {
+		log.Fatalf("failed to load break config: %v", err)
+	}
+	if cfg.Token == "" {
+		cfg.Token, err = library.GenerateToken(16)
+		if err != nil {
+			log.Fatalf("failed to generate token: %v", err)
+		}
+	}
+	rep := library.TCPReporter{IP: cfg.MonitorIP, Port: cfg.MonitorPort, Token: cfg.Token, EncryptionKey: cfg.EncryptionKey}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	err = Run(ctx, name, cfg, rep)
+	stop()
+	if err != nil {
+		log.Fatalf("❌ %s: %v", name, err)
+	}
+}
@@ -0,0 +1,118 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/fixture"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sandbox is a fixture tree for breaks to run against, with the config that confines them to it.
+type sandbox struct {
+	root library.Root
+	cfg  datatypes.BreakConfig
+}
+
+func newSandbox(t *testing.T, family library.DistroFamily) sandbox {
+	t.Helper()
+	root, err := fixture.Build(t.TempDir(), family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := vault.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sandbox{root: root, cfg: datatypes.BreakConfig{
+		Token: "sandbox-session", Seed: "sandbox", VaultKey: key, VaultMode: "local",
+		Root: string(root), Params: map[string]string{},
+	}}
+}
+
+// recorder collects a break's reports.
+type recorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *recorder) Report(status, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, status+": "+message)
+}
+
+// run runs the named break in the sandbox and fails the test if it errors.
+func (s sandbox) run(t *testing.T, name string) *recorder {
+	t.Helper()
+	rec := &recorder{}
+	if err := Run(context.Background(), name, s.cfg, rec); err != nil {
+		t.Fatalf("%s: %v\n%s", name, err, strings.Join(rec.msgs, "\n"))
+	}
+	return rec
+}
+
+// snapshot maps every file and link in the sandbox, by testenv path, to its mode and
+// content hash or link target. The vault and journal the breaks keep under it are left out.
+func (s sandbox) snapshot(t *testing.T) map[string]string {
+	t.Helper()
+	skip := map[string]bool{vault.DefaultDir: true, library.JournalDir: true}
+	out := make(map[string]string)
+	err := filepath.WalkDir(string(s.root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		logical, _ := s.root.Logical(p)
+		if skip[logical] {
+			return filepath.SkipDir
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			out[logical] = "-> " + target
+		case fi.Mode().IsRegular():
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			out[logical] = fi.Mode().String() + " " + hex.EncodeToString(sum[:])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// changed lists the testenv paths whose entry differs between two snapshots.
+func changed(before, after map[string]string) []string {
+	var out []string
+	for p, v := range before {
+		if after[p] != v {
+			out = append(out, p)
+		}
+	}
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+package breaks
+
+import (
+	"chaos-agent/library"
+	"encoding/json"
+	"fmt"
+)
+
+// selectTargets picks the files a break mutates under the policy its params describe
+// (see library.TargetPolicyFromParams), starting from the default binary selection, and
+// reports why as "target_selection". It also returns the owning package of each selected
+// file the package database knows, for the break to report with its mutation. Targets are
+// always looked for under the session's root, whatever root the params' policy names, and
+// drawn from its random stream.
+func selectTargets(env Env) ([]string, map[string]library.PackageInfo, error) {
+	policy, err := library.TargetPolicyFromParams(env.Params, library.DefaultTargetPolicy())
+	if err != nil {
+		return nil, nil, err
+	}
+	policy.Root = env.Session.Root
+	sel, err := policy.Select(env.Session.Rand)
+	if b, jerr := json.Marshal(sel.Summary()); jerr == nil {
+		env.Report("target_selection", string(b))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("select targets: %w", err)
+	}
+	owners := make(map[string]library.PackageInfo)
+	for _, d := range sel.Decisions {
+		if d.Selected && d.Package != nil {
+			owners[d.Resolved] = *d.Package
+		}
+	}
+	return sel.Selected, owners, nil
+}
+
+// packageOf is the owner of path in the system package database; the zero value if unknown,
+// and always under a fixture root, which the system database says nothing about.
+func packageOf(root library.Root, path string) library.PackageInfo {
+	db := library.SystemPackageDB()
+	if db == nil || !root.IsHost() {
+		return library.PackageInfo{}
+	}
+	owners, err := db.Owners([]string{path})
+	if err != nil {
+		return library.PackageInfo{}
+	}
+	return owners[path]
+}
@@ -0,0 +1,267 @@
+// Package channel is the authenticated, encrypted link between the monitor and resident agents.
+//
+// Both ends hold long-term Ed25519 identity keys: the agent pins the monitor's public key and
+// the monitor keeps a list of authorized agent keys. The handshake exchanges signed ephemeral
+// X25519 keys, derives one ChaCha20-Poly1305 key per direction, and every frame after that is
+// sealed with a per-direction sequence number as nonce, so frames cannot be replayed or reordered.
+package channel
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	protocolVersion  = "chaos-channel/1"
+	maxFrame         = 4 * 1024 * 1024
+	handshakeTimeout = 15 * time.Second
+)
+
+// clientHello opens the handshake; Sig covers the client's ephemeral key and nonce.
+type clientHello struct {
+	Version   string `json:"version"`
+	Identity  []byte `json:"identity"`
+	Ephemeral []byte `json:"ephemeral"`
+	Nonce     []byte `json:"nonce"`
+	Sig       []byte `json:"sig"`
+}
+
+// serverHello answers it; Sig binds both ephemeral keys, the nonce and the client identity.
+type serverHello struct {
+	Ephemeral []byte `json:"ephemeral"`
+	Sig       []byte `json:"sig"`
+}
+
+// Conn is an established channel. Reads and writes are each safe for concurrent use.
+type Conn struct {
+	raw net.Conn
+
+	// Peer is the remote end's Ed25519 identity.
+	Peer ed25519.PublicKey
+
+	wmu     sync.Mutex
+	send    cipher.AEAD
+	sendSeq uint64
+
+	rmu     sync.Mutex
+	recv    cipher.AEAD
+	recvSeq uint64
+}
+
+// Client performs the agent side of the handshake over raw.
+// monitorKey is the pinned monitor identity; any other key is rejected.
+func Client(raw net.Conn, identity ed25519.PrivateKey, monitorKey ed25519.PublicKey) (*Conn, error) {
+	_ = raw.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer func() { _ = raw.SetDeadline(time.Time{}) }()
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	pub := identity.Public().(ed25519.PublicKey)
+	hello := clientHello{
+		Version:   protocolVersion,
+		Identity:  pub,
+		Ephemeral: eph.PublicKey().Bytes(),
+		Nonce:     nonce,
+	}
+	hello.Sig = ed25519.Sign(identity, clientTranscript(hello))
+	if err := writeJSONFrame(raw, hello); err != nil {
+		return nil, fmt.Errorf("send hello: %w", err)
+	}
+
+	var reply serverHello
+	if err := readJSONFrame(raw, &reply); err != nil {
+		return nil, fmt.Errorf("read server hello: %w", err)
+	}
+	if !ed25519.Verify(monitorKey, serverTranscript(hello, reply.Ephemeral), reply.Sig) {
+		return nil, errors.New("monitor signature does not match pinned key")
+	}
+	return finish(raw, eph, reply.Ephemeral, hello, monitorKey, true)
+}
+
+// Server performs the monitor side of the handshake over raw.
+// authorize decides whether the presented agent identity may connect.
+func Server(raw net.Conn, identity ed25519.PrivateKey, authorize func(ed25519.PublicKey) bool) (*Conn, error) {
+	_ = raw.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer func() { _ = raw.SetDeadline(time.Time{}) }()
+
+	var hello clientHello
+	if err := readJSONFrame(raw, &hello); err != nil {
+		return nil, fmt.Errorf("read client hello: %w", err)
+	}
+	if hello.Version != protocolVersion {
+		return nil, fmt.Errorf("unsupported protocol %q", hello.Version)
+	}
+	if len(hello.Identity) != ed25519.PublicKeySize || len(hello.Nonce) != 32 {
+		return nil, errors.New("malformed client hello")
+	}
+	peer := ed25519.PublicKey(hello.Identity)
+	if !ed25519.Verify(peer, clientTranscript(hello), hello.Sig) {
+		return nil, errors.New("agent signature invalid")
+	}
+	if !authorize(peer) {
+		return nil, errors.New("agent identity not authorized")
+	}
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	reply := serverHello{Ephemeral: eph.PublicKey().Bytes()}
+	reply.Sig = ed25519.Sign(identity, serverTranscript(hello, reply.Ephemeral))
+	if err := writeJSONFrame(raw, reply); err != nil {
+		return nil, fmt.Errorf("send server hello: %w", err)
+	}
+	return finish(raw, eph, hello.Ephemeral, hello, peer, false)
+}
+
+func clientTranscript(h clientHello) []byte {
+	return bytes.Join([][]byte{[]byte(protocolVersion + " client"), h.Identity, h.Ephemeral, h.Nonce}, nil)
+}
+
+func serverTranscript(h clientHello, serverEph []byte) []byte {
+	return bytes.Join([][]byte{[]byte(protocolVersion + " server"), h.Identity, h.Ephemeral, serverEph, h.Nonce}, nil)
+}
+
+// finish derives the directional keys and returns the established Conn.
+func finish(raw net.Conn, eph *ecdh.PrivateKey, peerEph []byte, hello clientHello, peer ed25519.PublicKey, isClient bool) (*Conn, error) {
+	peerPub, err := ecdh.X25519().NewPublicKey(peerEph)
+	if err != nil {
+		return nil, fmt.Errorf("peer ephemeral key: %w", err)
+	}
+	shared, err := eph.ECDH(peerPub)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := hkdf.Key(sha256.New, shared, hello.Nonce, protocolVersion+" keys", 2*chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	c2s, err := chacha20poly1305.New(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := chacha20poly1305.New(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{raw: raw, Peer: peer}
+	if isClient {
+		c.send, c.recv = c2s, s2c
+	} else {
+		c.send, c.recv = s2c, c2s
+	}
+	return c, nil
+}
+
+// WriteFrame seals and sends one JSON-encoded value.
+func (c *Conn) WriteFrame(v any) error {
+	pt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	ct := c.send.Seal(nil, seqNonce(c.sendSeq), pt, nil)
+	c.sendSeq++
+	return writeRawFrame(c.raw, ct)
+}
+
+// ReadFrame receives, opens and JSON-decodes one frame into v.
+func (c *Conn) ReadFrame(v any) error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	ct, err := readRawFrame(c.raw)
+	if err != nil {
+		return err
+	}
+	pt, err := c.recv.Open(nil, seqNonce(c.recvSeq), ct, nil)
+	if err != nil {
+		return errors.New("frame failed authentication (tampered, replayed or reordered)")
+	}
+	c.recvSeq++
+	return json.Unmarshal(pt, v)
+}
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error {
+	return c.raw.Close()
+}
+
+// RemoteAddr returns the peer's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raw.RemoteAddr()
+}
+
+func seqNonce(seq uint64) []byte {
+	n := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(n[4:], seq)
+	return n
+}
+
+// Frames use the same 4-byte big-endian length prefix as the break report protocol.
+func writeRawFrame(w io.Writer, b []byte) error {
+	if len(b) > maxFrame {
+		return fmt.Errorf("frame too large (%d bytes)", len(b))
+	}
+	var lenBuf [4]byte
+	// #nosec G115 -- bounded by maxFrame above
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(b)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readRawFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n == 0 || n > maxFrame {
+		return nil, fmt.Errorf("bad frame length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeJSONFrame(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeRawFrame(w, b)
+}
+
+func readJSONFrame(r io.Reader, v any) error {
+	b, err := readRawFrame(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
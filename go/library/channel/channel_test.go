@@ -0,0 +1,205 @@
+package channel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func publicKey(key ed25519.PrivateKey) ed25519.PublicKey {
+	return key.Public().(ed25519.PublicKey)
+}
+
+// dial runs both ends of the handshake over net.Pipe, with the agent pinning pinned. Frames
+// from the agent pass through tamper once the handshake is done, which returns what to pass
+// on in place of each; nil passes everything as is.
+func dial(t *testing.T, agent, monitor ed25519.PrivateKey, pinned ed25519.PublicKey,
+	authorize func(ed25519.PublicKey) bool, tamper func([]byte) [][]byte) (client, server *Conn, clientErr, serverErr error) {
+	t.Helper()
+	agentRaw, agentWire := net.Pipe()
+	monitorWire, monitorRaw := net.Pipe()
+	t.Cleanup(func() {
+		for _, c := range []net.Conn{agentRaw, agentWire, monitorWire, monitorRaw} {
+			_ = c.Close()
+		}
+	})
+	go func() {
+		defer func() { _ = agentWire.Close() }()
+		_, _ = io.Copy(agentWire, monitorWire)
+	}()
+	go func() {
+		defer func() { _ = monitorWire.Close() }()
+		for n := 0; ; n++ {
+			frame, err := readRawFrame(agentWire)
+			if err != nil {
+				return
+			}
+			out := [][]byte{frame}
+			if n > 0 && tamper != nil {
+				out = tamper(frame)
+			}
+			for _, f := range out {
+				if err := writeRawFrame(monitorWire, f); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if server, serverErr = Server(monitorRaw, monitor, authorize); serverErr != nil {
+			_ = monitorRaw.Close()
+		}
+	}()
+	if client, clientErr = Client(agentRaw, agent, pinned); clientErr != nil {
+		_ = agentRaw.Close()
+	}
+	<-done
+	return client, server, clientErr, serverErr
+}
+
+func allow(ed25519.PublicKey) bool { return true }
+
+func TestHandshakeRejectsWrongMonitorKey(t *testing.T) {
+	agent, monitor := newKey(t), newKey(t)
+	_, _, err, _ := dial(t, agent, monitor, publicKey(newKey(t)), allow, nil)
+	if err == nil || !strings.Contains(err.Error(), "pinned key") {
+		t.Errorf("agent pinning another key connected: %v", err)
+	}
+}
+
+func TestHandshakeRejectsUnauthorizedAgent(t *testing.T) {
+	agent, monitor := newKey(t), newKey(t)
+	authorized := publicKey(newKey(t))
+	_, _, clientErr, serverErr := dial(t, agent, monitor, publicKey(monitor),
+		func(k ed25519.PublicKey) bool { return k.Equal(authorized) }, nil)
+	if serverErr == nil || !strings.Contains(serverErr.Error(), "not authorized") {
+		t.Errorf("monitor accepted an unauthorized agent: %v", serverErr)
+	}
+	if clientErr == nil {
+		t.Error("unauthorized agent completed its handshake")
+	}
+}
+
+func TestReadFrameRejectsTamperedReplayedAndReordered(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func() func([]byte) [][]byte
+		ok     int // frames read back in order before the failing one
+	}{
+		{"as sent", func() func([]byte) [][]byte { return nil }, 2},
+		{"tampered", func() func([]byte) [][]byte {
+			return func(f []byte) [][]byte {
+				f[len(f)/2] ^= 1
+				return [][]byte{f}
+			}
+		}, 0},
+		{"replayed", func() func([]byte) [][]byte {
+			return func(f []byte) [][]byte { return [][]byte{f, f} }
+		}, 1},
+		{"reordered", func() func([]byte) [][]byte {
+			var held []byte
+			return func(f []byte) [][]byte {
+				if held == nil {
+					held = f
+					return nil
+				}
+				return [][]byte{f, held}
+			}
+		}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			agent, monitor := newKey(t), newKey(t)
+			client, server, clientErr, serverErr := dial(t, agent, monitor, publicKey(monitor), allow, tc.tamper())
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("handshake: agent %v, monitor %v", clientErr, serverErr)
+			}
+			if !server.Peer.Equal(publicKey(agent)) || !client.Peer.Equal(publicKey(monitor)) {
+				t.Fatal("peers do not name each other's identity")
+			}
+			go func() {
+				for _, token := range []string{"first", "second"} {
+					if err := client.WriteFrame(Frame{Type: FrameCancel, Token: token}); err != nil {
+						return
+					}
+				}
+			}()
+			for i, want := range []string{"first", "second"}[:tc.ok] {
+				var f Frame
+				if err := server.ReadFrame(&f); err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if f.Token != want {
+					t.Fatalf("frame %d is %q, want %q", i, f.Token, want)
+				}
+			}
+			if tc.ok == 2 {
+				return
+			}
+			var f Frame
+			err := server.ReadFrame(&f)
+			if err == nil || !strings.Contains(err.Error(), "failed authentication") {
+				t.Errorf("read %+v, err %v; want an authentication failure", f, err)
+			}
+		})
+	}
+}
+
+func TestInstructionVerify(t *testing.T) {
+	monitor := newKey(t)
+	issued := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	signed := func(t *testing.T) Instruction {
+		t.Helper()
+		in := Instruction{
+			ID: "i-1", Break: "breaks/cheap/file_swap", Token: "tok", Seed: "seed",
+			Params:   map[string]string{"mode": "pairs"},
+			IssuedAt: issued, ExpiresAt: issued.Add(10 * time.Minute),
+		}
+		if err := in.Sign(monitor); err != nil {
+			t.Fatal(err)
+		}
+		return in
+	}
+	if err := signed(t).Verify(publicKey(monitor), issued.Add(time.Minute)); err != nil {
+		t.Fatalf("valid instruction rejected: %v", err)
+	}
+	for _, tc := range []struct {
+		name   string
+		modify func(*Instruction)
+		key    ed25519.PublicKey
+		at     time.Time
+		want   string
+	}{
+		{"expired", func(*Instruction) {}, publicKey(monitor), issued.Add(11 * time.Minute), "validity window"},
+		{"not yet issued", func(*Instruction) {}, publicKey(monitor), issued.Add(-2 * time.Minute), "validity window"},
+		{"break changed", func(in *Instruction) { in.Break = "breaks/cheap/broken_boot_loader" }, publicKey(monitor), issued, "signature invalid"},
+		{"params changed", func(in *Instruction) { in.Params["mode"] = "cycle" }, publicKey(monitor), issued, "signature invalid"},
+		{"expiry extended", func(in *Instruction) { in.ExpiresAt = in.ExpiresAt.Add(time.Hour) }, publicKey(monitor), issued.Add(time.Hour), "signature invalid"},
+		{"unsigned", func(in *Instruction) { in.Signature = nil }, publicKey(monitor), issued, "not signed"},
+		{"other monitor", func(*Instruction) {}, publicKey(newKey(t)), issued, "signature invalid"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in := signed(t)
+			tc.modify(&in)
+			err := in.Verify(tc.key, tc.at)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Verify: %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package channel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncodePublicKey renders an Ed25519 public key as base64.
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key.
+func ParsePublicKey(b64 string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ReadPublicKey reads a base64 Ed25519 public key from path.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	// #nosec G304 -- key path chosen by the operator
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(string(b))
+}
+
+// EncodePrivateKey renders an Ed25519 private key as its base64 seed.
+func EncodePrivateKey(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Seed())
+}
+
+// ReadPrivateKey reads a base64 Ed25519 seed from path.
+func ReadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	// #nosec G304 -- key path chosen by the operator
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode private key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("private key seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// LoadOrCreatePrivateKey reads the key at path, generating and saving a new one (0600) if absent.
+func LoadOrCreatePrivateKey(path string) (ed25519.PrivateKey, error) {
+	key, err := ReadPrivateKey(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+	_, key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(EncodePrivateKey(key)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,108 @@
+package channel
+
+import (
+	datatypes "chaos-agent/library/types"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Frame types carried over an established Conn.
+const (
+	FrameFacts       = "facts"       // agent -> monitor, once after connecting
+	FrameInstruction = "instruction" // monitor -> agent
+	FrameMessage     = "message"     // agent -> monitor, a break's ChaosMessage
+	FrameCancel      = "cancel"      // monitor -> agent, stop the break running for Token
+	FramePing        = "ping"        // either way, keeps NAT/firewall state alive
+)
+
+// Frame is the envelope for everything sent after the handshake.
+type Frame struct {
+	Type        string                  `json:"type"`
+	Facts       *Facts                  `json:"facts,omitempty"`
+	Instruction *Instruction            `json:"instruction,omitempty"`
+	Message     *datatypes.ChaosMessage `json:"message,omitempty"`
+	Token       string                  `json:"token,omitempty"` // FrameCancel
+}
+
+// Facts describe the agent's host; the monitor uses Machine to pick a build architecture.
+type Facts struct {
+	AgentID  string   `json:"agent_id"`
+	Hostname string   `json:"hostname"`
+	Machine  string   `json:"machine"` // uname -m
+	Kernel   string   `json:"kernel"`  // uname -r
+	Breaks   []string `json:"breaks"`  // compiled-in registry
+}
+
+// Instruction tells an agent to run one break. It is signed by the monitor's identity key
+// independently of the channel, so a captured instruction cannot be altered, and it expires.
+type Instruction struct {
+	ID           string            `json:"id"`
+	Break        string            `json:"break"`
+	Token        string            `json:"token"`
+	Seed         string            `json:"seed"`
+	Params       map[string]string `json:"params,omitempty"`
+	DetonateAt   time.Time         `json:"detonate_at,omitempty"`
+	DelaySeconds int64             `json:"delay_seconds,omitempty"`
+	VaultKey     string            `json:"vault_key,omitempty"`
+	VaultMode    string            `json:"vault_mode,omitempty"`
+	IssuedAt     time.Time         `json:"issued_at"`
+	ExpiresAt    time.Time         `json:"expires_at"`
+	Signature    []byte            `json:"signature,omitempty"`
+}
+
+func (in Instruction) signedBytes() ([]byte, error) {
+	in.Signature = nil
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(protocolVersion+" instruction\n"), b...), nil
+}
+
+// Sign signs in with the monitor's identity key.
+func (in *Instruction) Sign(key ed25519.PrivateKey) error {
+	msg, err := in.signedBytes()
+	if err != nil {
+		return err
+	}
+	in.Signature = ed25519.Sign(key, msg)
+	return nil
+}
+
+// Verify checks the signature against the pinned monitor key and that in is currently valid.
+func (in Instruction) Verify(monitorKey ed25519.PublicKey, now time.Time) error {
+	if len(in.Signature) == 0 {
+		return errors.New("instruction is not signed")
+	}
+	msg, err := in.signedBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(monitorKey, msg, in.Signature) {
+		return errors.New("instruction signature invalid")
+	}
+	if in.ID == "" || in.Break == "" || in.Token == "" {
+		return errors.New("instruction missing id, break or token")
+	}
+	if now.Before(in.IssuedAt.Add(-time.Minute)) || now.After(in.ExpiresAt) {
+		return fmt.Errorf("instruction %s outside its validity window", in.ID)
+	}
+	return nil
+}
+
+// Config converts in into the BreakConfig the break runner expects.
+// There is no monitor address or report key: results go back over the channel.
+func (in Instruction) Config() datatypes.BreakConfig {
+	return datatypes.BreakConfig{
+		Seed:         in.Seed,
+		Token:        in.Token,
+		Params:       in.Params,
+		DetonateAt:   in.DetonateAt,
+		DelaySeconds: in.DelaySeconds,
+		VaultKey:     in.VaultKey,
+		VaultMode:    in.VaultMode,
+	}
+}
@@ -2,18 +2,16 @@
 package library
 
 import (
-	crand "crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"math"
-	"math/big"
 	"os"
-	"sort"
 	"time"
 )
 
-// CorruptFile overwrites ~percent% of a file's bytes in-place using cryptographically random data.
+// CorruptFile overwrites ~percent% of a file's bytes in place with random data, each new byte
+// different from the one it replaces.
+// It is CorruptFileWith(s, path, Overwrite{Percent: percent}).
 // Contract:
 //   - percent < 0  -> error
 //   - percent == 0 -> no-op
@@ -21,15 +19,93 @@ import (
 //   - percent >= 100    -> full overwrite (exactly size bytes), without changing file size
 //
 // Implementation notes:
-//   - No full-file loads; O(k) memory where k is the number of bytes to corrupt.
-//   - Uses CSPRNG for both index selection and bytes.
-//   - Coalesces adjacent positions to reduce syscalls.
+//   - No full-file loads; the file is walked in 64 KiB blocks, each getting its
+//     hypergeometric share of k, so memory stays flat whatever the size and percent.
+//   - Positions and bytes both come from s.Rand, so a session seed replays the exact same
+//     corruption.
+//   - Changed ranges are exact up to 65536 runs, then one span per touched block.
 //   - Restores mtime (atime best-effort via mtime for portability).
-func CorruptFile(path string, percent int) (retErr error) {
-	p, err := validatePercent(percent)
-	if err != nil || p == 0 {
-		return err // 0 => no-op
+//   - path must be inside s.Root.
+func CorruptFile(s *Session, path string, percent int) error {
+	_, err := CorruptFileWith(s, path, Overwrite{Percent: percent})
+	return err
+}
+
+// CorruptFileWith applies strategy to the regular file at path in place, restores its mtime,
+// and reports exactly which byte ranges changed. It runs as a Corruption mutation under s
+// (see Session.Perform).
+func CorruptFileWith(s *Session, path string, strategy CorruptionStrategy) (CorruptionResult, error) {
+	m := &Corruption{Path: path, Strategy: strategy}
+	_, err := s.Perform(m)
+	return m.Result, err
+}
+
+const corruptionKind = "corrupt"
+
+// Corruption is the Mutation behind CorruptFileWith. Revert restores the file from the session's vault.
+type Corruption struct {
+	Path     string
+	Strategy CorruptionStrategy
+	Result   CorruptionResult // filled in by Apply
+
+	sha256  string // content at Plan time
+	planned bool
+	applied bool
+}
+
+var _ Mutation = (*Corruption)(nil)
+
+type corruptionSpec struct {
+	Path     string          `json:"path"`
+	Strategy string          `json:"strategy"`
+	Params   json.RawMessage `json:"params"`
+	SHA256   string          `json:"sha256"`
+}
+
+// Plan records the file's current hash and the strategy with its parameters.
+func (m *Corruption) Plan(*Session) (MutationPlan, error) {
+	fi, err := os.Lstat(m.Path)
+	if err != nil {
+		return MutationPlan{}, fmt.Errorf("stat %q: %w", m.Path, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return MutationPlan{}, fmt.Errorf("corrupt: %q is not a regular file", m.Path)
+	}
+	if m.sha256, err = fileSHA256(m.Path); err != nil {
+		return MutationPlan{}, err
+	}
+	params, err := json.Marshal(m.Strategy)
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	spec, err := json.Marshal(corruptionSpec{Path: m.Path, Strategy: m.Strategy.Name(), Params: params, SHA256: m.sha256})
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	m.planned = true
+	return MutationPlan{Kind: corruptionKind, Paths: []string{m.Path}, Spec: spec}, nil
+}
+
+func decodeCorruption(raw json.RawMessage) (Mutation, error) {
+	var spec corruptionSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, err
 	}
+	strategy, err := decodeStrategy(spec.Strategy, spec.Params)
+	if err != nil {
+		return nil, err
+	}
+	return &Corruption{Path: spec.Path, Strategy: strategy, sha256: spec.SHA256, planned: true}, nil
+}
+
+// Apply runs the strategy on the file, drawing from the session's stream.
+func (m *Corruption) Apply(s *Session) (retErr error) {
+	if !m.planned {
+		return errors.New("corrupt: Apply before Plan")
+	}
+	path, strategy := m.Path, m.Strategy
+	res := CorruptionResult{Path: path, Strategy: strategy.Name()}
+	defer func() { m.Result = res }()
 
 	f, info, err := openRegular(path)
 	if err != nil {
@@ -45,23 +121,54 @@ func CorruptFile(path string, percent int) (retErr error) {
 			}
 		}
 	}()
-	size := info.Size()
-	if size == 0 {
+	res.OrigSize = info.Size()
+	res.NewSize = info.Size()
+
+	var changed []ByteRange
+	if sc, ok := strategy.(StructureCorruptor); ok {
+		res.Structure, changed, err = sc.ApplyStructure(f, info.Size(), s.Rand)
+	} else {
+		changed, err = strategy.Apply(f, info.Size(), s.Rand)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", strategy.Name(), path, err)
+	}
+	res.Changed = changed
+	m.applied = true
+	if len(changed) == 0 {
 		return nil
 	}
-	origMod := info.ModTime()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	if st, err := f.Stat(); err == nil {
+		res.NewSize = st.Size()
+	}
+	return finalize(path, f, info.ModTime())
+}
 
-	k := computeK(size, p)
-	if k >= size {
-		if err := doFullOverwrite(f, size); err != nil {
-			return err
-		}
-	} else {
-		if err := doPartialOverwrite(f, size, k); err != nil {
-			return err
-		}
+// Verify checks that the file's content changed. A strategy that reported no changed
+// bytes (a zero percent, a pattern already in place) passes.
+func (m *Corruption) Verify() error {
+	if m.applied && len(m.Result.Changed) == 0 {
+		return nil
 	}
-	return finalize(path, f, origMod)
+	sum, err := fileSHA256(m.Path)
+	if err != nil {
+		return err
+	}
+	if sum == m.sha256 {
+		return fmt.Errorf("%q is unchanged", m.Path)
+	}
+	return nil
+}
+
+// Revert restores the file from the session's vault, unless its content never changed.
+func (m *Corruption) Revert(s *Session) error {
+	if sum, err := fileSHA256(m.Path); err == nil && sum == m.sha256 {
+		return nil
+	}
+	return s.revert(m.Path)
 }
 
 // ---- helpers (small, focused) ----
@@ -105,60 +212,38 @@ func computeK(size int64, percent int) int64 {
 	return k
 }
 
-func doFullOverwrite(f *os.File, size int64) error {
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("seek start: %w", err)
-	}
-	if _, err := io.CopyN(f, crand.Reader, size); err != nil {
-		return fmt.Errorf("full overwrite: %w", err)
+// doFullOverwrite replaces every byte with a random one that differs from it.
+func doFullOverwrite(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	changed, err := writePattern(f, ByteRange{Offset: 0, Length: size}, nil, rng)
+	if err != nil {
+		return changed, fmt.Errorf("full overwrite: %w", err)
 	}
 	if err := f.Sync(); err != nil {
-		return fmt.Errorf("fsync: %w", err)
-	}
-	// Defensive: ensure size unchanged
-	if err := f.Truncate(size); err != nil {
-		return fmt.Errorf("truncate: %w", err)
+		return changed, fmt.Errorf("fsync: %w", err)
 	}
-	return nil
+	return changed, nil
 }
 
-func doPartialOverwrite(f *os.File, total, k int64) error {
-	// Guard: k must fit in 'int' for buffer allocation on this platform.
-	if k > int64(math.MaxInt) {
-		return fmt.Errorf("selection size too large for this platform (k=%d)", k)
-	}
-
-	positions, err := sampleKUniqueCrypto64(total, k)
-	if err != nil {
-		return fmt.Errorf("sample positions: %w", err)
-	}
-
-	// Sort to coalesce adjacent positions into runs (reduces syscalls).
-	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
-
-	randBytes := make([]byte, int(k))
-	if _, err := crand.Read(randBytes); err != nil {
-		return fmt.Errorf("read random bytes: %w", err)
-	}
-
-	runStart := 0
-	for i := 1; i <= len(positions); i++ {
-		// Continue run while consecutive
-		if i < len(positions) && positions[i] == positions[i-1]+1 {
-			continue
+// doPartialOverwrite overwrites exactly k uniformly chosen bytes with random data, one block
+// at a time. Each new byte differs from the one it replaces, so all k really change.
+func doPartialOverwrite(f *os.File, total, k int64, rng *Rand) ([]ByteRange, error) {
+	rnd := make([]byte, sampleBlockSize)
+	changed, err := streamSampled(f, total, k, rng, func(span []byte, sel []int) error {
+		if err := randomNonZero(rng, rnd[:len(sel)]); err != nil {
+			return err
 		}
-		// Close and write current run [runStart, i)
-		offset := positions[runStart]
-		if _, err := f.WriteAt(randBytes[runStart:i], offset); err != nil {
-			return fmt.Errorf("writeAt offset=%d len=%d: %w", offset, i-runStart, err)
+		for i, off := range sel {
+			span[off] ^= rnd[i]
 		}
-		runStart = i
+		return nil
+	})
+	if err != nil {
+		return changed, err
 	}
-
 	if err := f.Sync(); err != nil {
-		return fmt.Errorf("fsync: %w", err)
+		return changed, fmt.Errorf("fsync: %w", err)
 	}
-	return nil
+	return changed, nil
 }
 
 func finalize(path string, f *os.File, mtime time.Time) error {
@@ -173,50 +258,12 @@ func finalize(path string, f *os.File, mtime time.Time) error {
 	return nil
 }
 
-// ---- crypto-safe sampling utilities ----
-
-// sampleKUniqueCrypto64 selects k unique integers from [0, total) using Floyd's algorithm,
-// with cryptographically secure randomness. Runs in O(k) time/space.
-func sampleKUniqueCrypto64(total, k int64) ([]int64, error) {
-	if k <= 0 {
-		return []int64{}, nil
-	}
-	if k > total {
-		return nil, fmt.Errorf("k(%d) > total(%d)", k, total)
-	}
-	selected := make(map[int64]struct{}, min64(k, 1<<16)) // pre-cap; grows as needed
-	for t := total - k; t < total; t++ {
-		m, err := cryptoIntn64(t + 1) // uniform in [0..t]
-		if err != nil {
-			return nil, err
-		}
-		if _, exists := selected[m]; exists {
-			selected[t] = struct{}{}
-		} else {
-			selected[m] = struct{}{}
-		}
-	}
-	out := make([]int64, 0, len(selected))
-	for v := range selected {
-		out = append(out, v)
-	}
-	return out, nil
-}
+// ---- sampling utilities ----
 
-func cryptoIntn64(n int64) (int64, error) {
+// randInt64n returns a random int64 in [0, n) from rng.
+func randInt64n(rng *Rand, n int64) (int64, error) {
 	if n <= 0 {
-		return 0, fmt.Errorf("cryptoIntn64: n must be > 0, got %d", n)
-	}
-	x, err := crand.Int(crand.Reader, big.NewInt(n)) // 0..n-1
-	if err != nil {
-		return 0, err
-	}
-	return x.Int64(), nil
-}
-
-func min64(a, b int64) int64 {
-	if a < b {
-		return a
+		return 0, fmt.Errorf("randInt64n: n must be > 0, got %d", n)
 	}
-	return b
+	return rng.Int64N(n), nil
 }
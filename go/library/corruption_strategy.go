@@ -0,0 +1,480 @@
+package library
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a span of a file, [Offset, Offset+Length).
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func (r ByteRange) String() string {
+	return fmt.Sprintf("[%d,%d)", r.Offset, r.Offset+r.Length)
+}
+
+// CorruptionResult records what a strategy did to one file.
+// Changed ranges refer to the file after corruption, except for bytes removed by
+// truncation, which are reported as the range past NewSize that no longer exists.
+type CorruptionResult struct {
+	Path      string      `json:"path"`
+	Strategy  string      `json:"strategy"`
+	Structure string      `json:"structure,omitempty"` // what a structure-aware strategy damaged
+	OrigSize  int64       `json:"orig_size"`
+	NewSize   int64       `json:"new_size"`
+	Changed   []ByteRange `json:"changed"`
+}
+
+func (r CorruptionResult) String() string {
+	parts := make([]string, len(r.Changed))
+	for i, c := range r.Changed {
+		parts[i] = c.String()
+	}
+	name := r.Strategy
+	if r.Structure != "" {
+		name += "(" + r.Structure + ")"
+	}
+	return fmt.Sprintf("%s %s size %d->%d changed %s", name, r.Path, r.OrigSize, r.NewSize, strings.Join(parts, ","))
+}
+
+// CorruptionStrategy damages an open regular file of the given size in place, drawing any
+// random choice from rng, and returns exactly the byte ranges it changed. It must not load
+// the whole file.
+type CorruptionStrategy interface {
+	Name() string
+	Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error)
+}
+
+// StructureCorruptor is implemented by strategies that damage a named structure of the file
+// (see ElfCorruption); CorruptFileWith records the structure in the result.
+type StructureCorruptor interface {
+	CorruptionStrategy
+	ApplyStructure(f *os.File, size int64, rng *Rand) (structure string, changed []ByteRange, err error)
+}
+
+// Overwrite replaces ~Percent% of the file's bytes at random positions with random data
+// (the historical CorruptFile behaviour; 100 overwrites everything).
+type Overwrite struct {
+	Percent int
+}
+
+func (Overwrite) Name() string { return "overwrite" }
+
+func (s Overwrite) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	p, err := validatePercent(s.Percent)
+	if err != nil || p == 0 || size == 0 {
+		return nil, err
+	}
+	k := computeK(size, p)
+	if k >= size {
+		return doFullOverwrite(f, size, rng)
+	}
+	return doPartialOverwrite(f, size, k, rng)
+}
+
+// Truncate cuts the last Percent% of the file (at least one byte).
+type Truncate struct {
+	Percent int
+}
+
+func (Truncate) Name() string { return "truncate" }
+
+func (s Truncate) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	p, err := validatePercent(s.Percent)
+	if err != nil || p == 0 || size == 0 {
+		return nil, err
+	}
+	cut := computeK(size, p)
+	if err := f.Truncate(size - cut); err != nil {
+		return nil, fmt.Errorf("truncate: %w", err)
+	}
+	return []ByteRange{{Offset: size - cut, Length: cut}}, nil
+}
+
+// FlipBits inverts one random bit in each of Count distinct random bytes.
+type FlipBits struct {
+	Count int64
+}
+
+func (FlipBits) Name() string { return "flip_bits" }
+
+func (s FlipBits) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	if s.Count < 0 {
+		return nil, fmt.Errorf("bit count must be >= 0, got %d", s.Count)
+	}
+	n := min(s.Count, size)
+	if n == 0 {
+		return nil, nil
+	}
+	rnd := make([]byte, sampleBlockSize)
+	return streamSampled(f, size, n, rng, func(span []byte, sel []int) error {
+		if _, err := rng.Read(rnd[:len(sel)]); err != nil {
+			return fmt.Errorf("read random bytes: %w", err)
+		}
+		for i, off := range sel {
+			span[off] ^= 1 << (rnd[i] & 7)
+		}
+		return nil
+	})
+}
+
+// ZeroTail overwrites the last Bytes bytes (or, when Bytes is 0, the last Percent%) with zeros.
+type ZeroTail struct {
+	Bytes   int64
+	Percent int
+}
+
+func (ZeroTail) Name() string { return "zero_tail" }
+
+func (s ZeroTail) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	n := s.Bytes
+	if n == 0 {
+		p, err := validatePercent(s.Percent)
+		if err != nil || p == 0 || size == 0 {
+			return nil, err
+		}
+		n = computeK(size, p)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("byte count must be >= 0, got %d", n)
+	}
+	n = min(n, size)
+	if n == 0 {
+		return nil, nil
+	}
+	return writePattern(f, ByteRange{Offset: size - n, Length: n}, []byte{0}, rng)
+}
+
+// RandomSpan overwrites one contiguous span of Length bytes at a random offset with random
+// data, every byte different from the one it replaces.
+type RandomSpan struct {
+	Length int64
+}
+
+func (RandomSpan) Name() string { return "random_span" }
+
+func (s RandomSpan) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	if s.Length < 0 {
+		return nil, fmt.Errorf("span length must be >= 0, got %d", s.Length)
+	}
+	n := min(s.Length, size)
+	if n == 0 {
+		return nil, nil
+	}
+	off, err := randInt64n(rng, size-n+1)
+	if err != nil {
+		return nil, err
+	}
+	return writePattern(f, ByteRange{Offset: off, Length: n}, nil, rng)
+}
+
+// OverwriteSegment overwrites a known segment with Pattern repeated, or with random data
+// when Pattern is empty. The segment is clipped to the file.
+type OverwriteSegment struct {
+	Offset  int64
+	Length  int64
+	Pattern []byte
+}
+
+func (OverwriteSegment) Name() string { return "overwrite_segment" }
+
+func (s OverwriteSegment) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	if s.Offset < 0 || s.Length < 0 {
+		return nil, fmt.Errorf("segment offset/length must be >= 0, got %d/%d", s.Offset, s.Length)
+	}
+	if s.Offset >= size {
+		return nil, nil
+	}
+	r := ByteRange{Offset: s.Offset, Length: min(s.Length, size-s.Offset)}
+	if r.Length == 0 {
+		return nil, nil
+	}
+	return writePattern(f, r, s.Pattern, rng)
+}
+
+// InjectInvalidLine inserts Line (a default garbage line when empty) at the start of a
+// randomly chosen line, shifting the rest of the file. Meant for text configuration files.
+type InjectInvalidLine struct {
+	Line string
+}
+
+// defaultInvalidLine is not valid syntax in any of the config formats we target.
+const defaultInvalidLine = "}{ ::chaos:: \x00 ]]"
+
+func (InjectInvalidLine) Name() string { return "inject_invalid_line" }
+
+func (s InjectInvalidLine) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	line := s.Line
+	if line == "" {
+		line = defaultInvalidLine
+	}
+	line = strings.TrimRight(line, "\n") + "\n"
+
+	at, err := randomLineStart(f, size, rng)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertAt(f, size, at, []byte(line)); err != nil {
+		return nil, err
+	}
+	// Everything from the insertion point on has moved.
+	return []ByteRange{{Offset: at, Length: size + int64(len(line)) - at}}, nil
+}
+
+// strategies builds an empty strategy by name, for decoding journaled mutations.
+var strategies = map[string]func() CorruptionStrategy{
+	Overwrite{}.Name():         func() CorruptionStrategy { return &Overwrite{} },
+	Truncate{}.Name():          func() CorruptionStrategy { return &Truncate{} },
+	FlipBits{}.Name():          func() CorruptionStrategy { return &FlipBits{} },
+	ZeroTail{}.Name():          func() CorruptionStrategy { return &ZeroTail{} },
+	RandomSpan{}.Name():        func() CorruptionStrategy { return &RandomSpan{} },
+	OverwriteSegment{}.Name():  func() CorruptionStrategy { return &OverwriteSegment{} },
+	InjectInvalidLine{}.Name(): func() CorruptionStrategy { return &InjectInvalidLine{} },
+	ElfCorruption{}.Name():     func() CorruptionStrategy { return &ElfCorruption{} },
+}
+
+// decodeStrategy rebuilds a strategy from its name and JSON-encoded fields.
+func decodeStrategy(name string, params []byte) (CorruptionStrategy, error) {
+	newStrategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown corruption strategy %q", name)
+	}
+	s := newStrategy()
+	if err := json.Unmarshal(params, s); err != nil {
+		return nil, fmt.Errorf("decode %s strategy: %w", name, err)
+	}
+	return s, nil
+}
+
+// StrategyFromParams builds a strategy from break parameters:
+//
+//	strategy = overwrite | truncate | flip_bits | zero_tail | random_span | overwrite_segment | inject_invalid_line | elf
+//	percent, bits, bytes, length, offset, pattern (hex), line, elf_target
+//
+// def is used when no strategy is named.
+func StrategyFromParams(params map[string]string, def CorruptionStrategy) (CorruptionStrategy, error) {
+	name := params["strategy"]
+	if name == "" {
+		return def, nil
+	}
+	intParam := func(key string, dflt int64) (int64, error) {
+		v, ok := params[key]
+		if !ok || v == "" {
+			return dflt, nil
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("param %s: %w", key, err)
+		}
+		return n, nil
+	}
+	var (
+		s   CorruptionStrategy
+		err error
+		n   int64
+	)
+	switch name {
+	case "overwrite":
+		n, err = intParam("percent", 100)
+		s = Overwrite{Percent: int(n)}
+	case "truncate":
+		n, err = intParam("percent", 50)
+		s = Truncate{Percent: int(n)}
+	case "flip_bits":
+		n, err = intParam("bits", 64)
+		s = FlipBits{Count: n}
+	case "zero_tail":
+		var pct int64
+		if n, err = intParam("bytes", 0); err == nil {
+			pct, err = intParam("percent", 25)
+		}
+		s = ZeroTail{Bytes: n, Percent: int(pct)}
+	case "random_span":
+		n, err = intParam("length", 4096)
+		s = RandomSpan{Length: n}
+	case "overwrite_segment":
+		seg := OverwriteSegment{}
+		if seg.Offset, err = intParam("offset", 0); err == nil {
+			seg.Length, err = intParam("length", 4096)
+		}
+		if p := params["pattern"]; err == nil && p != "" {
+			seg.Pattern, err = parseHexPattern(p)
+		}
+		s = seg
+	case "inject_invalid_line":
+		s = InjectInvalidLine{Line: params["line"]}
+	case "elf":
+		n, err = intParam("length", 64)
+		target := ElfTarget(params["elf_target"])
+		if target != "" && target != ElfRandom && !slices.Contains(ElfTargets, target) {
+			return nil, fmt.Errorf("strategy elf: unknown target %q", target)
+		}
+		s = ElfCorruption{Target: target, TextLength: n}
+	default:
+		return nil, fmt.Errorf("unknown corruption strategy %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("strategy %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// ---- strategy helpers ----
+
+func parseHexPattern(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(s), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", s, err)
+	}
+	return b, nil
+}
+
+// appendRange appends r, merging it into the last range when they touch.
+func appendRange(rs []ByteRange, r ByteRange) []ByteRange {
+	if n := len(rs); n > 0 && rs[n-1].Offset+rs[n-1].Length == r.Offset {
+		rs[n-1].Length += r.Length
+		return rs
+	}
+	return append(rs, r)
+}
+
+// writePattern fills r with pattern repeated, or when pattern is empty with random bytes
+// from rng that each differ from the byte they replace, in bounded chunks. It returns the ranges
+// whose bytes actually changed: all of r for random data, only the bytes that did not
+// already match for a pattern.
+func writePattern(f *os.File, r ByteRange, pattern []byte, rng *Rand) ([]ByteRange, error) {
+	const chunk = 64 * 1024
+	old := make([]byte, min(r.Length, chunk))
+	buf := make([]byte, len(old))
+	var changed []ByteRange
+	for done := int64(0); done < r.Length; {
+		n := min(int64(len(buf)), r.Length-done)
+		o, b := old[:n], buf[:n]
+		if _, err := f.ReadAt(o, r.Offset+done); err != nil {
+			return changed, fmt.Errorf("readAt offset=%d len=%d: %w", r.Offset+done, n, err)
+		}
+		if len(pattern) == 0 {
+			if err := randomNonZero(rng, b); err != nil {
+				return changed, err
+			}
+			for i := range b {
+				b[i] ^= o[i]
+			}
+		} else {
+			for i := range b {
+				b[i] = pattern[(done+int64(i))%int64(len(pattern))]
+			}
+		}
+		if _, err := f.WriteAt(b, r.Offset+done); err != nil {
+			return changed, fmt.Errorf("writeAt offset=%d len=%d: %w", r.Offset+done, n, err)
+		}
+		changed = appendDiff(changed, r.Offset+done, o, b)
+		done += n
+	}
+	return changed, nil
+}
+
+// randomNonZero fills b with random bytes in 1..255 from rng: XORed onto a byte, one always
+// changes it.
+func randomNonZero(rng *Rand, b []byte) error {
+	if _, err := rng.Read(b); err != nil {
+		return fmt.Errorf("read random bytes: %w", err)
+	}
+	for i := range b {
+		for b[i] == 0 {
+			b[i] = byte(rng.Uint32())
+		}
+	}
+	return nil
+}
+
+// appendDiff records the runs where old and new differ (relative to base), or while over
+// maxExactRanges, one span from the first to the last differing byte.
+func appendDiff(rs []ByteRange, base int64, old, new []byte) []ByteRange {
+	exact := len(rs) < maxExactRanges
+	first, last := -1, -1
+	for i := range old {
+		if old[i] == new[i] {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if exact {
+			rs = appendRange(rs, ByteRange{Offset: base + int64(i), Length: 1})
+		}
+	}
+	if !exact && first >= 0 {
+		rs = appendRange(rs, ByteRange{Offset: base + int64(first), Length: int64(last - first + 1)})
+	}
+	return rs
+}
+
+// randomLineStart picks the start offset of a uniformly random line in one streaming pass
+// (reservoir sampling), so large files are never held in memory.
+func randomLineStart(f *os.File, size int64, rng *Rand) (int64, error) {
+	br := bufio.NewReader(io.NewSectionReader(f, 0, size))
+	var (
+		chosen int64
+		seen   int64 = 1 // line starting at offset 0
+		pos    int64
+	)
+	for {
+		b, err := br.ReadSlice('\n')
+		pos += int64(len(b))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("scan lines: %w", err)
+		}
+		if pos >= size {
+			break
+		}
+		// a new line starts at pos
+		seen++
+		j, err := randInt64n(rng, seen)
+		if err != nil {
+			return 0, err
+		}
+		if j == 0 {
+			chosen = pos
+		}
+	}
+	return chosen, nil
+}
+
+// insertAt inserts data at offset at, shifting [at, size) forward in bounded chunks, back to front.
+func insertAt(f *os.File, size, at int64, data []byte) error {
+	const chunk = 64 * 1024
+	shift := int64(len(data))
+	buf := make([]byte, chunk)
+	for end := size; end > at; {
+		start := max(at, end-chunk)
+		b := buf[:end-start]
+		if _, err := f.ReadAt(b, start); err != nil && err != io.EOF {
+			return fmt.Errorf("readAt offset=%d: %w", start, err)
+		}
+		if _, err := f.WriteAt(b, start+shift); err != nil {
+			return fmt.Errorf("writeAt offset=%d: %w", start+shift, err)
+		}
+		end = start
+	}
+	if _, err := f.WriteAt(data, at); err != nil {
+		return fmt.Errorf("writeAt offset=%d: %w", at, err)
+	}
+	return nil
+}
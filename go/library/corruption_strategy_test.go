@@ -0,0 +1,138 @@
+package library
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testContent is repetitive, zero-heavy data: random bytes and patterns often coincide with it.
+func testContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		if i%3 != 0 {
+			b[i] = byte(i % 5)
+		}
+	}
+	return b
+}
+
+// diffRanges is the merged runs where a and b differ, over their common length.
+func diffRanges(a, b []byte) []ByteRange {
+	var rs []ByteRange
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			rs = appendRange(rs, ByteRange{Offset: int64(i), Length: 1})
+		}
+	}
+	return rs
+}
+
+// applyTo runs s on a temp file holding data, drawing from rng, and returns the reported
+// ranges and the new content.
+func applyTo(t *testing.T, s CorruptionStrategy, data []byte, rng *Rand) ([]ByteRange, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := s.Apply(f, int64(len(data)), rng)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", s.Name(), err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changed, after
+}
+
+// TestStrategiesReportExactlyTheChangedBytes checks the reported ranges are the bytes that
+// differ, no more: a random byte equal to the one it replaced must not be reported.
+func TestStrategiesReportExactlyTheChangedBytes(t *testing.T) {
+	rng := NewRand("exact-ranges")
+	data := testContent(2 * sampleBlockSize)
+	tail := slices.Clone(data)
+	clear(tail[len(tail)-100:]) // zero_tail finds part of its tail already zero
+
+	for _, tc := range []struct {
+		name string
+		s    CorruptionStrategy
+		data []byte
+		n    int64 // bytes expected to change; -1 to skip the count
+	}{
+		{"overwrite_1", Overwrite{Percent: 1}, data, int64(len(data)) / 100},
+		{"overwrite_50", Overwrite{Percent: 50}, data, int64(len(data)) / 2},
+		{"overwrite_full", Overwrite{Percent: 100}, data, int64(len(data))},
+		{"flip_bits", FlipBits{Count: 500}, data, 500},
+		{"random_span", RandomSpan{Length: 70000}, data, 70000},
+		{"zero_tail", ZeroTail{Bytes: 300}, tail, -1},
+		{"segment_pattern", OverwriteSegment{Offset: 10, Length: 5000, Pattern: []byte{0, 1}}, data, -1},
+		{"segment_random", OverwriteSegment{Offset: 10, Length: 5000}, data, 5000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			changed, after := applyTo(t, tc.s, tc.data, rng)
+			want := diffRanges(tc.data, after)
+			if !slices.Equal(changed, want) {
+				t.Fatalf("reported %d ranges, %d actually differ", len(changed), len(want))
+			}
+			var n int64
+			for _, r := range changed {
+				n += r.Length
+			}
+			if tc.n >= 0 && n != tc.n {
+				t.Errorf("%d bytes changed, want %d", n, tc.n)
+			}
+			if tc.n < 0 && (n == 0 || n == 300 || n == 5000) {
+				t.Errorf("%d bytes changed; a pattern already in place must not count", n)
+			}
+		})
+	}
+}
+
+func TestGarbleStringChangesEveryByte(t *testing.T) {
+	name := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	path := filepath.Join(t.TempDir(), "dynstr")
+	if err := os.WriteFile(path, name, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := garbleString(f, ByteRange{Offset: 0, Length: int64(len(name))}, NewRand("garble")); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(path)
+	if bytes.ContainsRune(after, 'a') {
+		t.Errorf("garbled %q still has an original byte in place", after)
+	}
+}
+
+// TestOneByteOverwriteVerifies is the case that used to pass Verify on faith: one random byte.
+func TestOneByteOverwriteVerifies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "small")
+	if err := os.WriteFile(path, []byte{0}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(HostRoot, nil, nil, "")
+	for range 64 {
+		res, err := CorruptFileWith(s, path, Overwrite{Percent: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Changed) != 1 || res.Changed[0] != (ByteRange{Offset: 0, Length: 1}) {
+			t.Fatalf("changed %v, want [0,1)", res.Changed)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package library provides delayed and scheduled detonation for break binaries.
+package library
+
+import (
+	datatypes "chaos-agent/library/types"
+	"context"
+	"fmt"
+	"time"
+)
+
+// DetonationTime returns when a break configured by cfg should fire, relative to now.
+// An explicit DetonateAt wins over DelaySeconds; the zero time means "immediately".
+func DetonationTime(cfg datatypes.BreakConfig, now time.Time) time.Time {
+	if !cfg.DetonateAt.IsZero() {
+		return cfg.DetonateAt
+	}
+	if cfg.DelaySeconds > 0 {
+		return now.Add(time.Duration(cfg.DelaySeconds) * time.Second)
+	}
+	return time.Time{}
+}
+
+// AwaitDetonation blocks until at. If at is in the future it first reports "armed" with the
+// planned time, then sleeps; once it fires it reports "detonated" with the real timestamp.
+// Immediate breaks (zero or past at) return at once without reporting.
+// report is typically a closure over SendMessage.
+func AwaitDetonation(ctx context.Context, at time.Time, report func(status, message string)) error {
+	if at.IsZero() || !time.Now().Before(at) {
+		return nil
+	}
+	report("armed", at.UTC().Format(time.RFC3339Nano))
+
+	t := time.NewTimer(time.Until(at))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("disarmed before detonation: %w", ctx.Err())
+	case <-t.C:
+	}
+	report("detonated", time.Now().UTC().Format(time.RFC3339Nano))
+	return nil
+}
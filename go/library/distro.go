@@ -0,0 +1,252 @@
+package library
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DistroFamily is the family of Linux distribution a testenv belongs to, as far as its
+// filesystem layout goes.
+type DistroFamily string
+
+const (
+	FamilyRHEL    DistroFamily = "rhel"   // RHEL, CentOS, Fedora, Rocky, Alma, ...
+	FamilyDebian  DistroFamily = "debian" // Debian, Ubuntu, Mint, ...
+	FamilyUnknown DistroFamily = ""
+)
+
+// OSRelease is the part of os-release(5) distro detection uses.
+type OSRelease struct {
+	ID         string   `json:"id"`
+	IDLike     []string `json:"id_like,omitempty"`
+	VersionID  string   `json:"version_id,omitempty"`
+	PrettyName string   `json:"pretty_name,omitempty"`
+}
+
+// ReadOSRelease reads /etc/os-release under root, falling back to /usr/lib/os-release as
+// os-release(5) says to.
+func ReadOSRelease(root Root) (OSRelease, error) {
+	var firstErr error
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		// #nosec G304 -- fixed system path under the testenv root
+		f, err := os.Open(root.Path(p))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		defer func() { _ = f.Close() }()
+		return ParseOSRelease(f)
+	}
+	return OSRelease{}, firstErr
+}
+
+// ParseOSRelease parses os-release KEY=value lines; values may be shell-quoted.
+func ParseOSRelease(r io.Reader) (OSRelease, error) {
+	var o OSRelease
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if uq, err := strconv.Unquote(value); err == nil {
+			value = uq
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		switch key {
+		case "ID":
+			o.ID = strings.ToLower(value)
+		case "ID_LIKE":
+			o.IDLike = strings.Fields(strings.ToLower(value))
+		case "VERSION_ID":
+			o.VersionID = value
+		case "PRETTY_NAME":
+			o.PrettyName = value
+		}
+	}
+	return o, sc.Err()
+}
+
+// familyIDs maps os-release IDs to their family; ID_LIKE is consulted when ID is not listed.
+var familyIDs = map[string]DistroFamily{
+	"rhel": FamilyRHEL, "centos": FamilyRHEL, "fedora": FamilyRHEL, "rocky": FamilyRHEL,
+	"almalinux": FamilyRHEL, "ol": FamilyRHEL, "amzn": FamilyRHEL,
+	"debian": FamilyDebian, "ubuntu": FamilyDebian, "linuxmint": FamilyDebian, "raspbian": FamilyDebian,
+}
+
+// Family is the distro family o belongs to, FamilyUnknown if neither ID nor ID_LIKE says.
+func (o OSRelease) Family() DistroFamily {
+	for _, id := range append([]string{o.ID}, o.IDLike...) {
+		if f, ok := familyIDs[id]; ok {
+			return f
+		}
+	}
+	return FamilyUnknown
+}
+
+// DistroProfile is where a distro family keeps what the selectors look for and what the
+// built-in deny list protects. All paths are testenv paths; globs use filepath.Match syntax.
+type DistroProfile struct {
+	Family  DistroFamily `json:"family"`
+	Release OSRelease    `json:"release"`
+
+	Kernels     []string `json:"kernels"`
+	Initrds     []string `json:"initrds"`
+	GrubConfigs []string `json:"grub_configs"`
+	Grubenvs    []string `json:"grubenvs"`
+	BLSDir      string   `json:"bls_dir,omitempty"` // "" where the family does not use BLS
+
+	DenyExact    []string `json:"deny_exact"`
+	DenyPrefixes []string `json:"deny_prefixes"`
+	DenyPatterns []string `json:"deny_patterns"` // versioned loader/libc real files
+}
+
+// commonDeny is protected on every family: auth config.
+var commonDeny = DistroProfile{
+	DenyExact:    []string{"/etc/passwd", "/etc/sudoers"},
+	DenyPrefixes: []string{"/etc/pam.d/"}, // PAM stack & sudo includes
+}
+
+var distroProfiles = map[DistroFamily]DistroProfile{
+	FamilyRHEL: {
+		Family:      FamilyRHEL,
+		Kernels:     []string{"/boot/vmlinuz-*"},
+		Initrds:     []string{"/boot/initramfs-*.img"},
+		GrubConfigs: []string{"/boot/grub2/grub.cfg"},
+		Grubenvs:    []string{"/boot/grub2/grubenv"},
+		BLSDir:      "/boot/loader/entries",
+		DenyExact: []string{
+			"/lib64/ld-linux-x86-64.so.2", "/usr/lib64/ld-linux-x86-64.so.2",
+			"/lib/ld-linux.so.2", "/usr/lib/ld-linux.so.2", // 32-bit interpreter if present
+			"/lib/ld-linux-aarch64.so.1", "/usr/lib/ld-linux-aarch64.so.1",
+			"/lib64/libc.so.6", "/usr/lib64/libc.so.6",
+		},
+		DenyPatterns: []string{
+			"/lib64/ld-*.so", "/usr/lib64/ld-*.so", // e.g. /lib64/ld-2.34.so
+			"/lib64/libc-*.so", "/usr/lib64/libc-*.so",
+		},
+	},
+	FamilyDebian: {
+		Family:      FamilyDebian,
+		Kernels:     []string{"/boot/vmlinuz-*"},
+		Initrds:     []string{"/boot/initrd.img-*"},
+		GrubConfigs: []string{"/boot/grub/grub.cfg"},
+		Grubenvs:    []string{"/boot/grub/grubenv"},
+		DenyExact: []string{
+			"/lib64/ld-linux-x86-64.so.2", "/usr/lib64/ld-linux-x86-64.so.2",
+			"/lib/ld-linux-aarch64.so.1", "/usr/lib/ld-linux-aarch64.so.1",
+		},
+		DenyPatterns: []string{
+			"/lib/*-linux-gnu*/ld-linux*.so.*", "/usr/lib/*-linux-gnu*/ld-linux*.so.*",
+			"/lib/*-linux-gnu*/ld-*.so", "/usr/lib/*-linux-gnu*/ld-*.so",
+			"/lib/*-linux-gnu*/libc.so.6", "/usr/lib/*-linux-gnu*/libc.so.6",
+			"/lib/*-linux-gnu*/libc-*.so", "/usr/lib/*-linux-gnu*/libc-*.so",
+		},
+	},
+}
+
+// ProfileFor is the profile of family; FamilyUnknown gets every family's paths, so
+// selectors still find the files and the deny list protects whichever layout it is.
+func ProfileFor(family DistroFamily) DistroProfile {
+	if p, ok := distroProfiles[family]; ok {
+		p.DenyExact = slices.Concat(p.DenyExact, commonDeny.DenyExact)
+		p.DenyPrefixes = slices.Concat(p.DenyPrefixes, commonDeny.DenyPrefixes)
+		return p
+	}
+	u := DistroProfile{DenyExact: slices.Clone(commonDeny.DenyExact), DenyPrefixes: slices.Clone(commonDeny.DenyPrefixes)}
+	for _, f := range []DistroFamily{FamilyRHEL, FamilyDebian} {
+		p := distroProfiles[f]
+		u.Kernels = appendNew(u.Kernels, p.Kernels...)
+		u.Initrds = appendNew(u.Initrds, p.Initrds...)
+		u.GrubConfigs = appendNew(u.GrubConfigs, p.GrubConfigs...)
+		u.Grubenvs = appendNew(u.Grubenvs, p.Grubenvs...)
+		u.DenyExact = appendNew(u.DenyExact, p.DenyExact...)
+		u.DenyPatterns = appendNew(u.DenyPatterns, p.DenyPatterns...)
+		if u.BLSDir == "" {
+			u.BLSDir = p.BLSDir
+		}
+	}
+	return u
+}
+
+// DetectDistro reads os-release under root and returns its family's profile; without a
+// readable os-release the profile is FamilyUnknown's.
+func DetectDistro(root Root) DistroProfile {
+	o, err := ReadOSRelease(root)
+	if err != nil {
+		return ProfileFor(FamilyUnknown)
+	}
+	p := ProfileFor(o.Family())
+	p.Release = o
+	return p
+}
+
+// BootFiles lists the existing boot files under root the profile knows of: kernels,
+// initrds, grub configs, BLS entries and grubenvs, in that order.
+func (p DistroProfile) BootFiles(root Root) []string {
+	patterns := slices.Concat(p.Kernels, p.Initrds, p.GrubConfigs)
+	if p.BLSDir != "" {
+		patterns = append(patterns, filepath.Join(p.BLSDir, "*.conf"))
+	}
+	patterns = append(patterns, p.Grubenvs...)
+	var out []string
+	for _, pat := range patterns {
+		if matches, _ := root.Glob(pat); len(matches) > 0 {
+			out = append(out, matches...)
+		}
+	}
+	return out
+}
+
+// IsDenied reports whether the testenv path is on the profile's built-in deny list.
+func (p DistroProfile) IsDenied(path string) bool {
+	path = filepath.Clean(path)
+	if slices.Contains(p.DenyExact, path) {
+		return true
+	}
+	for _, pre := range p.DenyPrefixes {
+		if strings.HasPrefix(path, pre) {
+			return true
+		}
+	}
+	for _, g := range p.DenyPatterns {
+		if ok, _ := filepath.Match(g, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// String names the profile for reports, e.g. "debian (Ubuntu 24.04 LTS)".
+func (p DistroProfile) String() string {
+	name := string(p.Family)
+	if name == "" {
+		name = "unknown"
+	}
+	if p.Release.PrettyName != "" {
+		return fmt.Sprintf("%s (%s)", name, p.Release.PrettyName)
+	}
+	return name
+}
+
+func appendNew(dst []string, src ...string) []string {
+	for _, s := range src {
+		if !slices.Contains(dst, s) {
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,123 @@
+package library_test
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/fixture"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDetectDistroFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		family library.DistroFamily
+		id     string
+		boot   []string
+	}{
+		{library.FamilyRHEL, "rhel", []string{
+			"/boot/vmlinuz-5.14.0-362.8.1.el9_3.x86_64",
+			"/boot/vmlinuz-5.14.0-427.13.1.el9_4.x86_64",
+			"/boot/initramfs-5.14.0-362.8.1.el9_3.x86_64.img",
+			"/boot/initramfs-5.14.0-427.13.1.el9_4.x86_64.img",
+			"/boot/grub2/grub.cfg",
+			"/boot/loader/entries/8c3a1b2f6e0d4c5a9b7e1f2a3c4d5e6f-5.14.0-362.8.1.el9_3.x86_64.conf",
+			"/boot/loader/entries/8c3a1b2f6e0d4c5a9b7e1f2a3c4d5e6f-5.14.0-427.13.1.el9_4.x86_64.conf",
+			"/boot/grub2/grubenv",
+		}},
+		{library.FamilyDebian, "ubuntu", []string{
+			"/boot/vmlinuz-6.8.0-28-generic",
+			"/boot/vmlinuz-6.8.0-31-generic",
+			"/boot/initrd.img-6.8.0-28-generic",
+			"/boot/initrd.img-6.8.0-31-generic",
+			"/boot/grub/grub.cfg",
+			"/boot/grub/grubenv",
+		}},
+	} {
+		t.Run(string(tc.family), func(t *testing.T) {
+			root, err := fixture.Build(t.TempDir(), tc.family)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := library.DetectDistro(root)
+			if p.Family != tc.family || p.Release.ID != tc.id {
+				t.Errorf("detected %s, id %q; want %s, id %q", p, p.Release.ID, tc.family, tc.id)
+			}
+			var boot []string
+			for _, f := range p.BootFiles(root) {
+				logical, _ := root.Logical(f)
+				boot = append(boot, logical)
+			}
+			if !slices.Equal(boot, tc.boot) {
+				t.Errorf("boot files\n%s\nwant\n%s", strings.Join(boot, "\n"), strings.Join(tc.boot, "\n"))
+			}
+
+			// Without os-release the family is unknown, and the profile still finds the boot files.
+			if err := os.Remove(root.Path("/etc/os-release")); err != nil {
+				t.Fatal(err)
+			}
+			u := library.DetectDistro(root)
+			if u.Family != library.FamilyUnknown || u.String() != "unknown" {
+				t.Errorf("without os-release detected %s", u)
+			}
+			if n := len(u.BootFiles(root)); n != len(tc.boot) {
+				t.Errorf("unknown profile found %d boot files, want %d", n, len(tc.boot))
+			}
+		})
+	}
+}
+
+func TestOSReleaseFamily(t *testing.T) {
+	for _, tc := range []struct {
+		osRelease string
+		want      library.DistroFamily
+	}{
+		{"ID=rocky\nID_LIKE=\"rhel centos fedora\"\n", library.FamilyRHEL},
+		{"ID='almalinux'\n", library.FamilyRHEL},
+		{"ID=pop\nID_LIKE=\"ubuntu debian\"\n", library.FamilyDebian},
+		{"# comment\nID=Debian\n", library.FamilyDebian},
+		{"ID=arch\n", library.FamilyUnknown},
+		{"", library.FamilyUnknown},
+	} {
+		o, err := library.ParseOSRelease(strings.NewReader(tc.osRelease))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := o.Family(); got != tc.want {
+			t.Errorf("%q: family %q, want %q", tc.osRelease, got, tc.want)
+		}
+	}
+}
+
+func TestDistroIsDenied(t *testing.T) {
+	rhel := library.ProfileFor(library.FamilyRHEL)
+	debian := library.ProfileFor(library.FamilyDebian)
+	unknown := library.ProfileFor(library.FamilyUnknown)
+	for _, tc := range []struct {
+		path                  string
+		rhel, debian, unknown bool
+	}{
+		{"/usr/lib/x86_64-linux-gnu/libc.so.6", false, true, true},
+		{"/lib64/ld-2.34.so", true, false, true},
+		{"/lib64/ld-linux-x86-64.so.2", true, true, true},
+		{"/usr/lib64/libc.so.6", true, false, true},
+		{"/lib/x86_64-linux-gnu/libc-2.31.so", false, true, true},
+		{"/usr/lib/aarch64-linux-gnu/ld-linux-aarch64.so.1", false, true, true},
+		{"/lib64/../lib64/ld-2.34.so", true, false, true},
+		{"/etc/passwd", true, true, true},
+		{"/etc/pam.d/sudo", true, true, true},
+		{"/etc/pam.d", false, false, false},
+		{"/usr/lib64/libcrypt.so.2", false, false, false},
+		{"/usr/bin/ls", false, false, false},
+	} {
+		for name, got := range map[string][2]bool{
+			"rhel":    {rhel.IsDenied(tc.path), tc.rhel},
+			"debian":  {debian.IsDenied(tc.path), tc.debian},
+			"unknown": {unknown.IsDenied(tc.path), tc.unknown},
+		} {
+			if got[0] != got[1] {
+				t.Errorf("%s: IsDenied(%s) = %v, want %v", name, tc.path, got[0], got[1])
+			}
+		}
+	}
+}
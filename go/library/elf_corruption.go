@@ -0,0 +1,269 @@
+package library
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ElfTarget names the ELF structure an ElfCorruption damages. Each one fails differently:
+//
+//	header  - ELF magic broken: "cannot execute binary file: Exec format error"
+//	phdr    - program header table scrambled: exec fails or the process dies while mapping
+//	dynamic - .dynamic scrambled: the dynamic loader crashes or rejects the object
+//	needed  - one DT_NEEDED name garbled: "error while loading shared libraries: ... cannot open shared object file"
+//	interp  - PT_INTERP path garbled: "No such file or directory" for a file that exists
+//	text    - a slice of .text scrambled: SIGILL/SIGSEGV once that code runs
+type ElfTarget string
+
+const (
+	ElfHeader  ElfTarget = "header"
+	ElfPhdr    ElfTarget = "phdr"
+	ElfDynamic ElfTarget = "dynamic"
+	ElfNeeded  ElfTarget = "needed"
+	ElfInterp  ElfTarget = "interp"
+	ElfText    ElfTarget = "text"
+	ElfRandom  ElfTarget = "random" // any target the file actually has
+)
+
+// ElfTargets lists the concrete targets.
+var ElfTargets = []ElfTarget{ElfHeader, ElfPhdr, ElfDynamic, ElfNeeded, ElfInterp, ElfText}
+
+// ErrNotELF is returned when an ElfCorruption is applied to a file that is not ELF.
+var ErrNotELF = errors.New("not an ELF file")
+
+// ElfCorruption damages one ELF structure of an executable or shared object.
+type ElfCorruption struct {
+	Target     ElfTarget
+	TextLength int64 // bytes of .text to scramble (default 64)
+}
+
+func (ElfCorruption) Name() string { return "elf" }
+
+// Apply implements CorruptionStrategy; use ApplyStructure to learn what was damaged.
+func (s ElfCorruption) Apply(f *os.File, size int64, rng *Rand) ([]ByteRange, error) {
+	_, changed, err := s.ApplyStructure(f, size, rng)
+	return changed, err
+}
+
+// ApplyStructure damages the target and reports which structure it hit, e.g. "interp /lib64/ld-linux-x86-64.so.2".
+func (s ElfCorruption) ApplyStructure(f *os.File, size int64, rng *Rand) (string, []ByteRange, error) {
+	ef, err := elf.NewFile(io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrNotELF, err)
+	}
+	defer func() { _ = ef.Close() }()
+
+	target := s.Target
+	if target == "" || target == ElfRandom {
+		avail := availableElfTargets(ef)
+		i, err := randInt64n(rng, int64(len(avail)))
+		if err != nil {
+			return "", nil, err
+		}
+		target = avail[i]
+	}
+
+	switch target {
+	case ElfHeader:
+		// e_ident[EI_MAG1..EI_MAG3] ("ELF"); EI_CLASS and the rest stay intact.
+		changed, err := writePattern(f, ByteRange{Offset: 1, Length: 3}, []byte("CHS"), rng)
+		return "header e_ident magic", changed, err
+
+	case ElfPhdr:
+		r, err := phdrTable(f, ef)
+		if err != nil {
+			return "", nil, err
+		}
+		changed, err := writePattern(f, r, nil, rng)
+		return fmt.Sprintf("phdr table (%d entries)", len(ef.Progs)), changed, err
+
+	case ElfDynamic:
+		r, ok := dynamicRange(ef)
+		if !ok {
+			return "", nil, errors.New("no .dynamic section (static binary?)")
+		}
+		changed, err := writePattern(f, r, nil, rng)
+		return "dynamic", changed, err
+
+	case ElfNeeded:
+		return corruptNeeded(f, ef, rng)
+
+	case ElfInterp:
+		for _, p := range ef.Progs {
+			if p.Type != elf.PT_INTERP || p.Filesz < 2 {
+				continue
+			}
+			// Keep the terminating NUL so the path stays a string, just not the right one.
+			r := ByteRange{Offset: int64(p.Off), Length: int64(p.Filesz) - 1} // #nosec G115 -- bounded by file size
+			old, err := readString(f, r.Offset, r.Length)
+			if err != nil {
+				return "", nil, err
+			}
+			if err := garbleString(f, r, rng); err != nil {
+				return "", nil, err
+			}
+			return "interp " + old, []ByteRange{r}, nil
+		}
+		return "", nil, errors.New("no PT_INTERP (static binary?)")
+
+	case ElfText:
+		sec := ef.Section(".text")
+		if sec == nil || sec.Type == elf.SHT_NOBITS || sec.Size == 0 {
+			return "", nil, errors.New("no .text section")
+		}
+		n := s.TextLength
+		if n <= 0 {
+			n = 64
+		}
+		n = min(n, int64(sec.Size)) // #nosec G115 -- section sizes are bounded by the file
+		off, err := randInt64n(rng, int64(sec.Size)-n+1)
+		if err != nil {
+			return "", nil, err
+		}
+		changed, err := writePattern(f, ByteRange{Offset: int64(sec.Offset) + off, Length: n}, nil, rng)
+		return fmt.Sprintf("text +%#x", off), changed, err
+	}
+	return "", nil, fmt.Errorf("unknown ELF target %q", target)
+}
+
+// availableElfTargets lists the targets the file has; the header always exists.
+func availableElfTargets(ef *elf.File) []ElfTarget {
+	out := []ElfTarget{ElfHeader}
+	if len(ef.Progs) > 0 {
+		out = append(out, ElfPhdr)
+	}
+	if _, ok := dynamicRange(ef); ok {
+		out = append(out, ElfDynamic)
+	}
+	if libs, _ := ef.ImportedLibraries(); len(libs) > 0 {
+		out = append(out, ElfNeeded)
+	}
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_INTERP {
+			out = append(out, ElfInterp)
+			break
+		}
+	}
+	if sec := ef.Section(".text"); sec != nil && sec.Type != elf.SHT_NOBITS && sec.Size > 0 {
+		out = append(out, ElfText)
+	}
+	return out
+}
+
+// phdrTable locates the program header table from e_phoff/e_phentsize/e_phnum.
+func phdrTable(f *os.File, ef *elf.File) (ByteRange, error) {
+	var (
+		hdr                        [64]byte
+		phoffAt, entAt, numAt, wid int
+	)
+	if ef.Class == elf.ELFCLASS64 {
+		phoffAt, entAt, numAt, wid = 0x20, 0x36, 0x38, 8
+	} else {
+		phoffAt, entAt, numAt, wid = 0x1c, 0x2a, 0x2c, 4
+	}
+	if _, err := f.ReadAt(hdr[:], 0); err != nil && !errors.Is(err, io.EOF) {
+		return ByteRange{}, fmt.Errorf("read ELF header: %w", err)
+	}
+	var phoff uint64
+	if wid == 8 {
+		phoff = ef.ByteOrder.Uint64(hdr[phoffAt:])
+	} else {
+		phoff = uint64(ef.ByteOrder.Uint32(hdr[phoffAt:]))
+	}
+	ent := ef.ByteOrder.Uint16(hdr[entAt:])
+	num := ef.ByteOrder.Uint16(hdr[numAt:])
+	if phoff == 0 || num == 0 {
+		return ByteRange{}, errors.New("no program header table")
+	}
+	// #nosec G115 -- e_phoff of a file we just parsed
+	return ByteRange{Offset: int64(phoff), Length: int64(ent) * int64(num)}, nil
+}
+
+func dynamicRange(ef *elf.File) (ByteRange, bool) {
+	if sec := ef.Section(".dynamic"); sec != nil && sec.Type != elf.SHT_NOBITS && sec.Size > 0 {
+		// #nosec G115 -- section offsets are bounded by the file
+		return ByteRange{Offset: int64(sec.Offset), Length: int64(sec.Size)}, true
+	}
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_DYNAMIC && p.Filesz > 0 {
+			// #nosec G115 -- segment offsets are bounded by the file
+			return ByteRange{Offset: int64(p.Off), Length: int64(p.Filesz)}, true
+		}
+	}
+	return ByteRange{}, false
+}
+
+// corruptNeeded garbles the name of one random DT_NEEDED entry in .dynstr.
+func corruptNeeded(f *os.File, ef *elf.File, rng *Rand) (string, []ByteRange, error) {
+	offs, err := ef.DynValue(elf.DT_NEEDED)
+	if err != nil || len(offs) == 0 {
+		return "", nil, errors.New("no DT_NEEDED entries")
+	}
+	dynstr := ef.Section(".dynstr")
+	if dynstr == nil {
+		return "", nil, errors.New("no .dynstr section")
+	}
+	i, err := randInt64n(rng, int64(len(offs)))
+	if err != nil {
+		return "", nil, err
+	}
+	if offs[i] >= dynstr.Size {
+		return "", nil, fmt.Errorf("DT_NEEDED offset %#x outside .dynstr", offs[i])
+	}
+	start := int64(dynstr.Offset + offs[i])                       // #nosec G115 -- bounded by .dynstr
+	name, err := readString(f, start, int64(dynstr.Size-offs[i])) // #nosec G115 -- bounded by .dynstr
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "" {
+		return "", nil, errors.New("empty DT_NEEDED name")
+	}
+	r := ByteRange{Offset: start, Length: int64(len(name))}
+	if err := garbleString(f, r, rng); err != nil {
+		return "", nil, err
+	}
+	return "needed " + name, []ByteRange{r}, nil
+}
+
+// readString reads a NUL-terminated string of at most limit bytes at off.
+func readString(f *os.File, off, limit int64) (string, error) {
+	buf := make([]byte, min(limit, 4096))
+	n, err := f.ReadAt(buf, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("readAt offset=%d: %w", off, err)
+	}
+	buf = buf[:n]
+	for i, b := range buf {
+		if b == 0 {
+			return string(buf[:i]), nil
+		}
+	}
+	return string(buf), nil
+}
+
+// garbleString replaces every byte in r with a random lowercase letter other than the one
+// there, keeping the length, so the name still parses but no longer resolves.
+func garbleString(f *os.File, r ByteRange, rng *Rand) error {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, r.Length)
+	if _, err := f.ReadAt(b, r.Offset); err != nil {
+		return fmt.Errorf("readAt offset=%d: %w", r.Offset, err)
+	}
+	for i := range b {
+		was := b[i]
+		for b[i] == was {
+			j, err := randInt64n(rng, int64(len(letters)))
+			if err != nil {
+				return err
+			}
+			b[i] = letters[j]
+		}
+	}
+	if _, err := f.WriteAt(b, r.Offset); err != nil {
+		return fmt.Errorf("writeAt offset=%d: %w", r.Offset, err)
+	}
+	return nil
+}
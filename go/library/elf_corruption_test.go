@@ -0,0 +1,187 @@
+package library
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hostELF is the content of the machine's /bin/true, which tests corrupt copies of; the
+// test is skipped where it is not a dynamically linked ELF.
+func hostELF(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("/bin/true")
+	if err != nil {
+		t.Skipf("no /bin/true: %v", err)
+	}
+	ef, err := elf.Open("/bin/true")
+	if err != nil {
+		t.Skipf("/bin/true is not ELF: %v", err)
+	}
+	defer func() { _ = ef.Close() }()
+	if libs, _ := ef.ImportedLibraries(); len(libs) == 0 {
+		t.Skip("/bin/true is statically linked")
+	}
+	return data
+}
+
+// elfStructures is the file range each target damages in the ELF at path, and the names
+// a "needed" structure may report.
+func elfStructures(t *testing.T, path string) (map[ElfTarget]ByteRange, []string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phdr, err := phdrTable(f, ef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic, ok := dynamicRange(ef)
+	if !ok {
+		t.Fatal("no dynamic range")
+	}
+	section := func(name string) ByteRange {
+		sec := ef.Section(name)
+		if sec == nil {
+			t.Fatalf("no %s section", name)
+		}
+		return ByteRange{Offset: int64(sec.Offset), Length: int64(sec.Size)}
+	}
+	out := map[ElfTarget]ByteRange{
+		ElfHeader:  {Offset: 0, Length: 4}, // the e_ident magic
+		ElfPhdr:    phdr,
+		ElfDynamic: dynamic,
+		ElfNeeded:  section(".dynstr"),
+		ElfText:    section(".text"),
+	}
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_INTERP {
+			out[ElfInterp] = ByteRange{Offset: int64(p.Off), Length: int64(p.Filesz)}
+		}
+	}
+	libs, err := ef.ImportedLibraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, libs
+}
+
+func TestElfCorruptionTargets(t *testing.T) {
+	data := hostELF(t)
+	rng := NewRand("elf-targets")
+	for _, target := range append(slices.Clone(ElfTargets), ElfRandom, ElfRandom, ElfRandom, ElfRandom) {
+		t.Run(string(target), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "true")
+			if err := os.WriteFile(path, data, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			ranges, libs := elfStructures(t, path)
+			interp, err := readInterp(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			structure, changed, err := ElfCorruption{Target: target}.ApplyStructure(f, int64(len(data)), rng)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hit := structureTarget(structure, interp, libs)
+			if hit == "" || (target != ElfRandom && hit != target) {
+				t.Fatalf("structure %q does not name a %s structure of the file", structure, target)
+			}
+			if len(changed) == 0 {
+				t.Fatalf("%s changed nothing", structure)
+			}
+			r := ranges[hit]
+			for _, c := range changed {
+				if c.Offset < r.Offset || c.Offset+c.Length > r.Offset+r.Length {
+					t.Errorf("%s changed %+v, outside %s at %+v", structure, c, hit, r)
+				}
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := diffRanges(data, after); !slices.Equal(got, changed) {
+				t.Errorf("%s reported %+v, but %+v differ", structure, changed, got)
+			}
+		})
+	}
+}
+
+// structureTarget is the target whose structure ApplyStructure reported, or "".
+func structureTarget(structure, interp string, libs []string) ElfTarget {
+	switch name, isNeeded := strings.CutPrefix(structure, "needed "); {
+	case structure == "header e_ident magic":
+		return ElfHeader
+	case strings.HasPrefix(structure, "phdr table ("):
+		return ElfPhdr
+	case structure == "dynamic":
+		return ElfDynamic
+	case isNeeded && slices.Contains(libs, name):
+		return ElfNeeded
+	case structure == "interp "+interp:
+		return ElfInterp
+	case strings.HasPrefix(structure, "text +0x"):
+		return ElfText
+	}
+	return ""
+}
+
+// readInterp is the PT_INTERP path of the ELF at path.
+func readInterp(path string) (string, error) {
+	ef, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = ef.Close() }()
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_INTERP {
+			b := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(b, 0); err != nil {
+				return "", err
+			}
+			return strings.TrimRight(string(b), "\x00"), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no PT_INTERP", path)
+}
+
+func TestElfCorruptionRejectsNonELF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script")
+	script := []byte("#!/bin/sh\necho not an ELF\n")
+	if err := os.WriteFile(path, script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	for _, target := range append(slices.Clone(ElfTargets), ElfRandom) {
+		if _, _, err := (ElfCorruption{Target: target}).ApplyStructure(f, int64(len(script)), NewRand("")); !errors.Is(err, ErrNotELF) {
+			t.Errorf("%s on a script: %v, want ErrNotELF", target, err)
+		}
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(script) {
+		t.Error("script changed")
+	}
+}
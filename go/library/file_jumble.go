@@ -4,322 +4,502 @@
 package library
 
 import (
-	"bytes"
 	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
 	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math/big"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"syscall"
-	"time"
 
 	"golang.org/x/sys/unix"
 )
 
 // CyclicJumble takes absolute file paths, filters to real regular files via validatePaths,
-// shuffles them using crypto/rand, then performs a cycle so that paths[i]’s content
-// becomes paths[(i+1)%n], while preserving each destination’s original metadata.
-func CyclicJumble(paths []string) error {
-	paths = validatePaths(paths)
-	if len(paths) < 2 {
-		return errors.New("need at least two real regular files after validation")
-	}
-
-	// Snapshot destination metadata (what we’ll restore after writing).
-	destMeta := make(map[string]datatypes.FileMeta, len(paths))
-	for _, p := range paths {
-		m, err := captureMeta(p)
-		if err != nil {
-			return fmt.Errorf("capture meta %s: %w", p, err)
-		}
-		destMeta[p] = m
-	}
+// shuffles them with s.Rand (so a session seed replays the same order), then moves content
+// around one cycle (PermuteCycle), keeping each destination’s original metadata. It runs as
+// a Jumble mutation under s (see Session.Perform), so every path must be inside s.Root; use
+// a Jumble with another Mode for pairs or groups.
+func CyclicJumble(s *Session, paths []string) error {
+	_, err := s.Perform(&Jumble{Paths: paths})
+	return err
+}
 
-	// Stage all sources to temp copies so we can overwrite freely.
-	staged, cleanup, err := stageAll(paths)
-	if err != nil {
-		return err
-	}
-	defer cleanup()
+const jumbleKind = "jumble"
 
-	// Secure shuffle using crypto/rand (Fisher–Yates).
-	if err := shuffleCrypto(paths); err != nil {
-		return fmt.Errorf("shuffle: %w", err)
-	}
+// Jumble is the Mutation behind CyclicJumble. Plan fixes which file receives whose content
+// under Mode (PermuteCycle when empty); Revert moves content back by hash, so it also undoes
+// a jumble that was interrupted part way.
+type Jumble struct {
+	Paths []string
+	Mode  PermutationMode
 
-	// Apply the cycle: write staged content of paths[i] into paths[(i+1)%n]
-	// while restoring the destination’s original metadata.
-	n := len(paths)
-	for i := 0; i < n; i++ {
-		src := paths[i]
-		dst := paths[(i+1)%n]
-		if err := writeOverWithMeta(staged[src], dst, destMeta[dst]); err != nil {
-			return fmt.Errorf("apply to %s: %w", dst, err)
-		}
-	}
-	return nil
+	order  []string          // paths that take part, fixed by Plan
+	moves  []FileMove        // fixed by Plan
+	sha256 map[string]string // content of each path at Plan time
+	tx     string            // where moves are saved while in flight, next to the journal
 }
 
-// validatePaths returns a new slice containing only absolute, existing, regular files.
-// Symlinks, dirs, missing paths, devices, FIFOs, etc. are discarded.
-func validatePaths(paths []string) []string {
-	out := make([]string, 0, len(paths))
-	seen := make(map[string]struct{}, len(paths))
+var _ Mutation = (*Jumble)(nil)
 
-	for _, p := range paths {
-		if p == "" || !filepath.IsAbs(p) {
-			continue
-		}
-		if _, ok := seen[p]; ok {
-			continue
-		}
-		// Lstat to ensure we don’t follow symlinks.
+type jumbleSpec struct {
+	Order  []string          `json:"order"`
+	Mode   PermutationMode   `json:"mode,omitempty"`
+	Moves  []FileMove        `json:"moves,omitempty"` // absent: one cycle through Order
+	SHA256 map[string]string `json:"sha256"`
+	Tx     string            `json:"tx,omitempty"`
+}
+
+// Plan validates and shuffles the paths, permutes them under Mode and records the content
+// hash of each path that takes part. The shuffle draws from the session's stream.
+func (m *Jumble) Plan(s *Session) (MutationPlan, error) {
+	valid := validatePaths(m.Paths)
+	if len(valid) < 2 {
+		return MutationPlan{}, errors.New("need at least two real regular files after validation")
+	}
+	s.Rand.Shuffle(len(valid), func(i, j int) { valid[i], valid[j] = valid[j], valid[i] })
+	sizes := make(map[string]int64, len(valid))
+	for _, p := range valid {
 		fi, err := os.Lstat(p)
 		if err != nil {
-			continue
+			return MutationPlan{}, err
 		}
-		if fi.Mode()&os.ModeSymlink != 0 {
-			continue
-		}
-		if !fi.Mode().IsRegular() {
-			continue
+		sizes[p] = fi.Size()
+	}
+	moves, err := permute(m.Mode, valid, sizes)
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	order := make([]string, len(moves))
+	for i, mv := range moves {
+		order[i] = mv.Dst
+	}
+	sums := make(map[string]string, len(order))
+	for _, p := range order {
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return MutationPlan{}, fmt.Errorf("hash %s: %w", p, err)
 		}
-		out = append(out, p)
-		seen[p] = struct{}{}
+		sums[p] = sum
 	}
-	return out
+	var id [8]byte
+	if _, err := cryptorand.Read(id[:]); err != nil {
+		return MutationPlan{}, err
+	}
+	tx := s.Journal.sidecar("jumble-" + hex.EncodeToString(id[:]) + ".json")
+	spec, err := json.Marshal(jumbleSpec{Order: order, Mode: m.Mode, Moves: moves, SHA256: sums, Tx: tx})
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	m.order, m.moves, m.sha256, m.tx = order, moves, sums, tx
+	return MutationPlan{Kind: jumbleKind, Paths: order, Spec: spec}, nil
 }
 
-/* -------------------- internals (simple + Linux-friendly) -------------------- */
-func captureMeta(path string) (datatypes.FileMeta, error) {
-	var m datatypes.FileMeta
-	fi, err := os.Lstat(path)
-	if err != nil {
-		return m, err
+func decodeJumble(raw json.RawMessage) (Mutation, error) {
+	var spec jumbleSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, err
 	}
-	if !fi.Mode().IsRegular() {
-		return m, fmt.Errorf("not a regular file: %s", path)
+	if len(spec.Order) < 2 {
+		return nil, errors.New("jumble spec needs at least two paths")
 	}
-	m.Mode = fi.Mode()
-
-	st, ok := fi.Sys().(*syscall.Stat_t)
-	if !ok {
-		return m, errors.New("unexpected stat type")
+	moves := spec.Moves
+	if len(moves) == 0 {
+		moves = cycleMoves(spec.Order)
 	}
-	m.UID = int(st.Uid)
-	m.GID = int(st.Gid)
-
-	// atime/mtime from Stat_t (Linux)
-	m.Atime = time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
-	m.Mtime = time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec))
+	return &Jumble{Paths: spec.Order, Mode: spec.Mode, order: spec.Order, moves: moves, sha256: spec.SHA256, tx: spec.Tx}, nil
+}
 
-	// xattrs: best-effort
-	if xa, err := readXattrs(path); err == nil {
-		m.XAttr = xa
-	} else {
-		m.XAttr = map[string][]byte{}
-	}
-	return m, nil
+// Moves returns the planned moves: which file receives whose content.
+func (m *Jumble) Moves() []FileMove {
+	return slices.Clone(m.moves)
 }
 
-// stageAll creates temp copies of each source file’s content.
-// Returns a map[srcPath]tmpCopy and a cleanup func.
-func stageAll(paths []string) (map[string]string, func(), error) {
-	out := make(map[string]string, len(paths))
-	cleanup := func() {
-		for _, t := range out {
-			_ = os.Remove(t)
-		}
+// Apply performs the planned moves, all or nothing. A move left in flight by a crash is
+// rolled back first, so a resumed Apply starts from the originals.
+func (m *Jumble) Apply(*Session) error {
+	if len(m.order) == 0 {
+		return errors.New("jumble: Apply before Plan")
 	}
-	for _, src := range paths {
-		tmp, err := stageCopy(src)
-		if err != nil {
-			cleanup()
-			return nil, func() {}, fmt.Errorf("stage copy %s: %w", src, err)
-		}
-		out[src] = tmp
+	if err := recoverJumbleTx(m.tx); err != nil {
+		return err
+	}
+	moves := make(map[string]string, len(m.moves))
+	for _, mv := range m.moves {
+		moves[mv.Dst] = mv.Src
 	}
-	return out, cleanup, nil
+	return moveContent(moves, m.tx)
 }
 
-// stageCopy copies src into a temp file in the same directory (same filesystem).
-func stageCopy(src string) (tmpPath string, err error) {
-	dir := filepath.Dir(src)
-	fTmp, err := os.CreateTemp(dir, ".jumble-src-*")
-	if err != nil {
-		return "", err
-	}
-	tmpPath = fTmp.Name()
-	//nolint:gosec // G304: srcPath is validated by validatePaths
-	fSrc, err := os.Open(src)
-	if err != nil {
-		_ = os.Remove(tmpPath)
-		return "", err
+// moveContent gives each destination its source's current content (dst -> src), keeping
+// every destination's metadata. Sources may overlap destinations: everything is staged next
+// to its destination before anything is replaced, then renamed into place.
+//
+// Content is moved rather than copied where it can be: a source that this call also
+// replaces, that nothing else links to and that is used once is hard-linked next to its
+// destination, given the destination's metadata and renamed over it, so no data is written.
+// Sources on another filesystem than their destination, and those kept or used twice, are
+// copied instead.
+//
+// The move is a transaction (see jumbleTx): if any step fails, every destination gets its
+// original content and metadata back. With txPath set, the transaction is saved there
+// before the first destination changes, so recoverJumbleTx can undo it after a crash.
+func moveContent(moves map[string]string, txPath string) (err error) {
+	tx := &jumbleTx{path: txPath}
+	destMeta := make(map[string]datatypes.FileMeta, len(moves))
+	uses := make(map[string]int, len(moves))
+	for dst, src := range moves {
+		m, err := vault.CaptureMeta(dst)
+		if err != nil {
+			return fmt.Errorf("capture meta %s: %w", dst, err)
+		}
+		destMeta[dst] = m
+		uses[src]++
 	}
 	defer func() {
-		if closeErr := fTmp.Close(); closeErr != nil {
-			err = errors.Join(err, fmt.Errorf("closing temp %q: %w", tmpPath, closeErr))
+		if err != nil {
+			err = errors.Join(err, tx.rollback())
 		}
 	}()
 
-	if _, err := io.Copy(fTmp, fSrc); err != nil {
-		_ = os.Remove(tmpPath)
-		return "", err
+	for dst, src := range moves {
+		_, replaced := moves[src]
+		tmp, err := stageFor(src, dst, replaced && uses[src] == 1)
+		if err != nil {
+			return fmt.Errorf("stage %s for %s: %w", src, dst, err)
+		}
+		tx.Steps = append(tx.Steps, jumbleStep{Dst: dst, Tmp: tmp, Meta: destMeta[dst]})
 	}
-	if err := fTmp.Sync(); err != nil {
-		_ = os.Remove(tmpPath)
-		return "", err
+	// Backups go after staging, which needs the sources' link counts as they were.
+	for i, s := range tx.Steps {
+		bak, err := linkTemp(s.Dst, filepath.Dir(s.Dst), ".jumble-bak-")
+		if err != nil {
+			return fmt.Errorf("back up %s: %w", s.Dst, err)
+		}
+		tx.Steps[i].Bak = bak
+	}
+	if err := tx.save(); err != nil {
+		return fmt.Errorf("save jumble transaction: %w", err)
 	}
-	return tmpPath, nil
-}
 
-// small helper to keep writeOverWithMeta simple
-func copyPathToFile(srcPath string, dst *os.File) (err error) {
-	//nolint:gosec // G304: srcPath is validated by validatePaths
-	src, err := os.Open(srcPath)
-	if err != nil {
-		return err
+	for _, s := range tx.Steps {
+		if err := applyMeta(s.Tmp, s.Meta); err != nil {
+			return fmt.Errorf("apply meta to %s: %w", s.Dst, err)
+		}
 	}
-	// defer src.Close()
-	defer func() {
-		if closeErr := src.Close(); closeErr != nil {
-			err = errors.Join(err, fmt.Errorf("closing temp %q: %w", srcPath, closeErr))
+	for _, s := range tx.Steps {
+		if err := replaceWith(s.Tmp, s.Dst); err != nil {
+			return fmt.Errorf("apply to %s: %w", s.Dst, err)
 		}
-	}()
-	_, err = io.Copy(dst, src)
-	return err
+		if err := vault.ApplyPostMeta(s.Dst, s.Meta); err != nil {
+			return fmt.Errorf("renamed %s but failed to restore times: %w", s.Dst, err)
+		}
+	}
+	return tx.commit()
+}
+
+// replaceWith renames a staged file over its destination. It is a variable so tests can
+// fail a move part way, which nothing on disk reliably does for root.
+var replaceWith = os.Rename
+
+// jumbleTx is a moveContent in progress. Each step keeps a hard link to its destination's
+// original inode, so undoing the move is a rename per destination plus its metadata.
+type jumbleTx struct {
+	Steps []jumbleStep `json:"steps"`
+
+	path string // where the transaction is saved; "" keeps it in memory only
+}
+
+type jumbleStep struct {
+	Dst  string             `json:"dst"`
+	Tmp  string             `json:"tmp"` // replacement staged next to Dst
+	Bak  string             `json:"bak"` // link to Dst's original inode
+	Meta datatypes.FileMeta `json:"meta"`
 }
 
-// writeOverWithMeta writes stagedSrc content into dest, applying meta before/after.
-func writeOverWithMeta(stagedSrc, dest string, meta datatypes.FileMeta) error {
-	dir := filepath.Dir(dest)
-	tmp, err := os.CreateTemp(dir, ".jumble-dst-*")
+// save writes the transaction to its path, atomically.
+func (tx *jumbleTx) save() error {
+	if tx.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(tx)
 	if err != nil {
 		return err
 	}
-	tmpPath := tmp.Name()
-	// Always close and attempt to remove the temp; after a successful rename
-	// the remove is a no-op (ENOENT), which we ignore. This avoids branching.
-	defer func() {
-		_ = tmp.Close()
-		_ = os.Remove(tmpPath)
-	}()
-
-	// Copy content and flush
-	if err := copyPathToFile(stagedSrc, tmp); err != nil {
+	tmp := tx.path + ".tmp"
+	// #nosec G304 -- sidecar of the agent's journal
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
 		return err
 	}
-	if err := tmp.Sync(); err != nil {
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
 		return err
 	}
-
-	// Pre-rename metadata, then atomic replace
-	if err := applyPreMeta(tmpPath, meta); err != nil {
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
 		return err
 	}
-	if err := os.Rename(tmpPath, dest); err != nil {
+	if err := f.Close(); err != nil {
 		return err
 	}
+	return os.Rename(tmp, tx.path)
+}
 
-	// Post-rename times
-	if err := applyPostMeta(dest, meta); err != nil {
-		return fmt.Errorf("renamed but failed to restore times: %w", err)
+// commit ends a transaction whose destinations were all replaced. Dropping the saved
+// transaction is the commit point; backups left by a crash after it are only litter.
+func (tx *jumbleTx) commit() error {
+	if tx.path != "" {
+		if err := os.Remove(tx.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
 	}
-	return nil
+	for _, s := range tx.Steps {
+		_ = os.Remove(s.Bak)
+	}
+	return syncDirs(tx.Steps)
 }
 
-func applyPreMeta(path string, meta datatypes.FileMeta) error {
-	// Ownership (ignore EPERM/EACCES so non-root still works best-effort)
-	if err := os.Chown(path, meta.UID, meta.GID); err != nil {
-		if !errors.Is(err, unix.EPERM) && !errors.Is(err, unix.EACCES) {
+// rollback puts every destination's original inode back, with its metadata, and removes
+// whatever was staged. It can be repeated: a destination whose backup is gone was restored.
+func (tx *jumbleTx) rollback() error {
+	var errs []error
+	for _, s := range tx.Steps {
+		if s.Bak != "" {
+			if err := os.Rename(s.Bak, s.Dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("restore %s: %w", s.Dst, err))
+				continue
+			}
+			_ = os.Remove(s.Bak) // still there if Dst was never replaced
+			if err := applyMeta(s.Dst, s.Meta); err != nil {
+				errs = append(errs, fmt.Errorf("restore meta of %s: %w", s.Dst, err))
+			} else if err := vault.ApplyPostMeta(s.Dst, s.Meta); err != nil {
+				errs = append(errs, fmt.Errorf("restore times of %s: %w", s.Dst, err))
+			}
+		}
+		_ = os.Remove(s.Tmp)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("jumble rollback: %w", errors.Join(errs...))
+	}
+	if tx.path != "" {
+		if err := os.Remove(tx.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
 			return err
 		}
 	}
-	// Mode (preserve suid/sgid/sticky if present)
-	const keep = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
-	if err := os.Chmod(path, meta.Mode&keep); err != nil {
+	return syncDirs(tx.Steps)
+}
+
+// recoverJumbleTx rolls back the transaction saved at path by a process that died during
+// moveContent. No saved transaction means none was in progress.
+func recoverJumbleTx(path string) error {
+	if path == "" {
+		return nil
+	}
+	// #nosec G304 -- sidecar of the agent's journal
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	// xattrs: best-effort; ignore common non-fatal errors
-	for k, v := range meta.XAttr {
-		if err := unix.Setxattr(path, k, v, 0); err != nil && !isIgnorableXErr(err) {
+	tx := &jumbleTx{path: path}
+	if err := json.Unmarshal(b, tx); err != nil {
+		return fmt.Errorf("decode jumble transaction %s: %w", path, err)
+	}
+	return tx.rollback()
+}
+
+// Verify checks that every destination now holds its source's original content.
+func (m *Jumble) Verify() error {
+	for _, mv := range m.moves {
+		sum, err := fileSHA256(mv.Dst)
+		if err != nil {
 			return err
 		}
+		if sum != m.sha256[mv.Src] {
+			return fmt.Errorf("%s does not hold the content of %s", mv.Dst, mv.Src)
+		}
 	}
 	return nil
 }
 
-func applyPostMeta(path string, meta datatypes.FileMeta) error {
-	return os.Chtimes(path, meta.Atime, meta.Mtime)
+// Revert gives every path its original content back: a move left in flight is rolled back,
+// then content is taken from whichever path now holds it, or else from the session's vault.
+func (m *Jumble) Revert(s *Session) error {
+	if err := recoverJumbleTx(m.tx); err != nil {
+		return err
+	}
+	current := make(map[string]string, len(m.order))
+	holder := make(map[string]string, len(m.order)) // hash -> a path holding it
+	for _, p := range m.order {
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		current[p] = sum
+		holder[sum] = p
+	}
+	moves := make(map[string]string)
+	var lost []string
+	for _, p := range m.order {
+		want := m.sha256[p]
+		if current[p] == want {
+			continue
+		}
+		if src, ok := holder[want]; ok {
+			moves[p] = src
+		} else {
+			lost = append(lost, p)
+		}
+	}
+	if len(moves) > 0 {
+		if err := moveContent(moves, m.tx); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, p := range lost {
+		if err := s.revert(p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-/* ------------------------------- helpers ----------------------------------- */
+// validatePaths returns a new slice containing only absolute, existing, regular files.
+// Symlinks, dirs, missing paths, devices, FIFOs, etc. are discarded.
+func validatePaths(paths []string) []string {
+	out := make([]string, 0, len(paths))
+	seen := make(map[string]struct{}, len(paths))
 
-func shuffleCrypto(a []string) error {
-	// Fisher–Yates with crypto/rand
-	n := len(a)
-	for i := n - 1; i > 0; i-- {
-		jBig, err := cryptorand.Int(cryptorand.Reader, big.NewInt(int64(i+1)))
+	for _, p := range paths {
+		if p == "" || !filepath.IsAbs(p) {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		// Lstat to ensure we don’t follow symlinks.
+		fi, err := os.Lstat(p)
 		if err != nil {
-			return err
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			continue
 		}
-		j := int(jBig.Int64())
-		a[i], a[j] = a[j], a[i]
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		out = append(out, p)
+		seen[p] = struct{}{}
 	}
-	return nil
+	return out
 }
 
-func readXattrs(path string) (map[string][]byte, error) {
-	out := make(map[string][]byte)
-	n, err := unix.Listxattr(path, nil)
-	if err != nil || n <= 0 {
-		return out, err
+/* -------------------- internals (simple + Linux-friendly) -------------------- */
+
+// stageFor puts src's content in a temp file next to dst: a hard link when movable says the
+// inode may change names and it has no other links, a copy otherwise or across filesystems.
+func stageFor(src, dst string, movable bool) (string, error) {
+	dir := filepath.Dir(dst)
+	if movable && soleLink(src) {
+		tmp, err := linkTemp(src, dir, ".jumble-lnk-")
+		if !errors.Is(err, unix.EXDEV) {
+			return tmp, err
+		}
 	}
-	buf := make([]byte, n)
-	n, err = unix.Listxattr(path, buf)
-	if err != nil || n <= 0 {
-		return out, err
+	return copyTemp(src, dir)
+}
+
+// applyMeta gives path meta's ownership, mode and exactly its xattrs.
+func applyMeta(path string, meta datatypes.FileMeta) error {
+	if err := vault.ApplyPreMeta(path, meta); err != nil {
+		return err
 	}
-	for _, name := range splitZ(buf[:n]) {
-		sz, gerr := unix.Getxattr(path, name, nil)
-		if gerr != nil || sz <= 0 {
-			continue
+	return vault.DropExtraXattrs(path, meta.XAttr)
+}
+
+// soleLink reports whether path is the only name of its inode, so moving it changes no other file.
+func soleLink(path string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Nlink == 1
+}
+
+// linkTemp hard-links src under a fresh temp name in dir starting with prefix.
+func linkTemp(src, dir, prefix string) (string, error) {
+	for range 8 {
+		var b [8]byte
+		if _, err := cryptorand.Read(b[:]); err != nil {
+			return "", err
 		}
-		val := make([]byte, sz)
-		got, gerr := unix.Getxattr(path, name, val)
-		if gerr == nil && got >= 0 {
-			out[name] = append([]byte(nil), val[:got]...)
+		tmp := filepath.Join(dir, prefix+hex.EncodeToString(b[:]))
+		err := os.Link(src, tmp)
+		if err == nil || !errors.Is(err, fs.ErrExist) {
+			return tmp, err
 		}
 	}
-	return out, nil
+	return "", fmt.Errorf("no free temp name in %s", dir)
 }
 
-func isIgnorableXErr(err error) bool {
-	if err == nil {
-		return true
+// copyTemp copies src into a temp file in dir.
+func copyTemp(src, dir string) (tmpPath string, err error) {
+	fTmp, err := os.CreateTemp(dir, ".jumble-dst-*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath = fTmp.Name()
+	defer func() {
+		if closeErr := fTmp.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("closing temp %q: %w", tmpPath, closeErr))
+		}
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	//nolint:gosec // G304: src is validated by validatePaths
+	fSrc, err := os.Open(src)
+	if err != nil {
+		return tmpPath, err
+	}
+	defer func() { _ = fSrc.Close() }()
+	if _, err := io.Copy(fTmp, fSrc); err != nil {
+		return tmpPath, err
 	}
-	// On Linux ENOTSUP == EOPNOTSUPP; checking ENOTSUP covers both.
-	return errors.Is(err, unix.ENOTSUP) ||
-		errors.Is(err, unix.EPERM) ||
-		errors.Is(err, unix.EACCES) ||
-		errors.Is(err, unix.EINVAL) ||
-		errors.Is(err, unix.ENODATA)
+	return tmpPath, fTmp.Sync()
 }
 
-func splitZ(b []byte) []string {
-	var out []string
-	for len(b) > 0 {
-		i := bytes.IndexByte(b, 0)
-		if i < 0 {
-			break
+// syncDirs fsyncs the destinations' directories so the renames are durable.
+func syncDirs(steps []jumbleStep) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, s := range steps {
+		dir := filepath.Dir(s.Dst)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		// #nosec G304 -- parent directory of a validated target
+		d, err := os.Open(dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
 		}
-		if i > 0 {
-			out = append(out, string(b[:i]))
+		if err := d.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("fsync %s: %w", dir, err))
 		}
-		b = b[i+1:]
+		_ = d.Close()
 	}
-	return out
+	return errors.Join(errs...)
 }
@@ -0,0 +1,301 @@
+package library
+
+import (
+	"bytes"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jumbleFile is what a jumble must give back to a path: its inode, content and metadata.
+type jumbleFile struct {
+	fi   os.FileInfo
+	data []byte
+	meta datatypes.FileMeta
+}
+
+// jumbleTree makes n files in a temp dir, each with its own content, mode, xattr, mtime
+// and, as root, owner, so a destination given another's metadata shows.
+func jumbleTree(t *testing.T, n int) (dir string, paths []string) {
+	t.Helper()
+	dir = t.TempDir()
+	modes := []os.FileMode{0o640, 0o750, 0o604, 0o711, 0o600}
+	for i := range n {
+		p := filepath.Join(dir, fmt.Sprintf("f%d", i))
+		if err := os.WriteFile(p, bytes.Repeat([]byte{byte('a' + i)}, 100*(i+1)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, modes[i%len(modes)]); err != nil {
+			t.Fatal(err)
+		}
+		setXattr(t, p, "user.tag", fmt.Sprintf("f%d", i))
+		if os.Geteuid() == 0 {
+			if err := os.Chown(p, 1000+i, 2000+i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mtime := time.Date(2024, 3, 1+i, 12, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return dir, paths
+}
+
+func snapshotFiles(t *testing.T, paths ...string) map[string]jumbleFile {
+	t.Helper()
+	out := make(map[string]jumbleFile, len(paths))
+	for _, p := range paths {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := vault.CaptureMeta(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[p] = jumbleFile{fi: fi, data: data, meta: meta}
+	}
+	return out
+}
+
+// checkOriginals checks every path is back on its original inode, with its content and metadata.
+func checkOriginals(t *testing.T, want map[string]jumbleFile) {
+	t.Helper()
+	now := snapshotFiles(t, slices.Collect(maps.Keys(want))...)
+	for p, w := range want {
+		got := now[p]
+		if !os.SameFile(got.fi, w.fi) {
+			t.Errorf("%s is not on its original inode", p)
+		}
+		if !bytes.Equal(got.data, w.data) {
+			t.Errorf("%s content differs from the original", p)
+		}
+		checkMeta(t, p, got.meta, w.meta)
+	}
+}
+
+func checkMeta(t *testing.T, path string, got, want datatypes.FileMeta) {
+	t.Helper()
+	if !metaEqual(got, want) || !got.Mtime.Equal(want.Mtime) {
+		t.Errorf("%s metadata %+v mtime %v, want %+v mtime %v", path, summarizeMeta(got), got.Mtime, summarizeMeta(want), want.Mtime)
+	}
+}
+
+// checkNoLitter checks nothing staged or backed up is left in dir.
+func checkNoLitter(t *testing.T, dir string) {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		if strings.HasPrefix(e.Name(), ".jumble-") {
+			t.Errorf("%s left in %s", e.Name(), dir)
+		}
+	}
+}
+
+// cycle is the dst -> src moves of paths[i]'s content going to paths[i+1].
+func cycle(paths []string) map[string]string {
+	moves := make(map[string]string, len(paths))
+	for _, mv := range cycleMoves(paths) {
+		moves[mv.Dst] = mv.Src
+	}
+	return moves
+}
+
+// failRename makes the nth rename of a jumble fail, or call fn instead, for the rest of the test.
+func failRename(t *testing.T, nth int, fn func() error) *int {
+	t.Helper()
+	calls := 0
+	t.Cleanup(func() { replaceWith = os.Rename })
+	replaceWith = func(tmp, dst string) error {
+		calls++
+		if calls == nth {
+			return fn()
+		}
+		return os.Rename(tmp, dst)
+	}
+	return &calls
+}
+
+func TestMoveContentRollsBackPartWay(t *testing.T) {
+	dir, paths := jumbleTree(t, 4)
+	want := snapshotFiles(t, paths...)
+	txPath := filepath.Join(t.TempDir(), "jumble-tx.json")
+	injected := errors.New("injected rename failure")
+	calls := failRename(t, 3, func() error { return injected })
+
+	err := moveContent(cycle(paths), txPath)
+	if !errors.Is(err, injected) {
+		t.Fatalf("moveContent: %v, want the injected failure", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("%d renames, want two done before the third failed", *calls)
+	}
+	checkOriginals(t, want)
+	checkNoLitter(t, dir)
+	if _, err := os.Lstat(txPath); !os.IsNotExist(err) {
+		t.Errorf("transaction left behind: %v", err)
+	}
+}
+
+func TestMoveContentRollsBackMissingSource(t *testing.T) {
+	dir, paths := jumbleTree(t, 3)
+	want := snapshotFiles(t, paths...)
+	moves := cycle(paths)
+	moves[paths[0]] = filepath.Join(dir, "missing")
+
+	if err := moveContent(moves, ""); err == nil {
+		t.Fatal("moved content from a missing source")
+	}
+	checkOriginals(t, want)
+	checkNoLitter(t, dir)
+}
+
+// TestRecoverJumbleTxAfterCrash stops a jumble dead after two of its renames, with the
+// transaction saved and neither committed nor rolled back, as a killed agent leaves it.
+func TestRecoverJumbleTxAfterCrash(t *testing.T) {
+	dir, paths := jumbleTree(t, 4)
+	want := snapshotFiles(t, paths...)
+	txPath := filepath.Join(t.TempDir(), "jumble-tx.json")
+	failRename(t, 3, func() error { panic("killed") })
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("jumble was not stopped")
+			}
+		}()
+		_ = moveContent(cycle(paths), txPath)
+	}()
+	if _, err := os.Stat(txPath); err != nil {
+		t.Fatalf("no saved transaction: %v", err)
+	}
+	changed := 0
+	for p, w := range want {
+		if data, _ := os.ReadFile(p); !bytes.Equal(data, w.data) {
+			changed++
+		}
+	}
+	if changed != 2 {
+		t.Fatalf("%d destinations changed before the crash, want 2", changed)
+	}
+
+	saved, err := os.ReadFile(txPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if i > 0 {
+			// A recovery killed before it dropped the transaction runs again on the next start.
+			if err := os.WriteFile(txPath, saved, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := recoverJumbleTx(txPath); err != nil {
+			t.Fatal(err)
+		}
+		checkOriginals(t, want)
+		checkNoLitter(t, dir)
+		if _, err := os.Lstat(txPath); !os.IsNotExist(err) {
+			t.Errorf("transaction left behind: %v", err)
+		}
+	}
+	if err := recoverJumbleTx(txPath); err != nil {
+		t.Errorf("recover with no transaction saved: %v", err)
+	}
+}
+
+// TestMoveContentLinksOrCopies checks which sources are hard-linked into place and which
+// are copied, and that every destination keeps its own metadata either way, even when it
+// now shares its inode with what was a source.
+func TestMoveContentLinksOrCopies(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		moves func(p []string) map[string]string // dst -> src over f0..f3
+		other bool                               // f1 gets another hard link first
+		kept  []int                              // files that are sources only and must not change
+		// linked[dst] is true where dst must end on its source's original inode.
+		linked map[int]bool
+	}{
+		{
+			name:   "cycle of sole links",
+			moves:  func(p []string) map[string]string { return cycle(p[:3]) },
+			linked: map[int]bool{0: true, 1: true, 2: true},
+		},
+		{
+			name:   "source with another link",
+			moves:  func(p []string) map[string]string { return cycle(p[:3]) },
+			other:  true,
+			linked: map[int]bool{0: true, 1: true, 2: false}, // f2 takes f1's content
+		},
+		{
+			name: "source used twice",
+			moves: func(p []string) map[string]string {
+				return map[string]string{p[0]: p[2], p[1]: p[2], p[2]: p[0]}
+			},
+			linked: map[int]bool{0: false, 1: false, 2: true},
+		},
+		{
+			name:   "source not replaced",
+			moves:  func(p []string) map[string]string { return map[string]string{p[0]: p[3]} },
+			kept:   []int{3},
+			linked: map[int]bool{0: false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, paths := jumbleTree(t, 4)
+			other := filepath.Join(t.TempDir(), "f1-other")
+			if tc.other {
+				if err := os.Link(paths[1], other); err != nil {
+					t.Fatal(err)
+				}
+				if soleLink(paths[1]) {
+					t.Fatal("soleLink true for a file with two links")
+				}
+			}
+			before := snapshotFiles(t, paths...)
+			moves := tc.moves(paths)
+
+			if err := moveContent(moves, ""); err != nil {
+				t.Fatal(err)
+			}
+			after := snapshotFiles(t, paths...)
+			for i, isLinked := range tc.linked {
+				dst := paths[i]
+				src := before[moves[dst]]
+				got := after[dst]
+				if !bytes.Equal(got.data, src.data) {
+					t.Errorf("%s does not hold %s's content", dst, moves[dst])
+				}
+				if same := os.SameFile(got.fi, src.fi); same != isLinked {
+					t.Errorf("%s on %s's inode: %v, want %v", dst, moves[dst], same, isLinked)
+				}
+				checkMeta(t, dst, got.meta, before[dst].meta)
+			}
+			for _, i := range tc.kept {
+				checkOriginals(t, map[string]jumbleFile{paths[i]: before[paths[i]]})
+			}
+			if tc.other {
+				// The other name still sees f1's original inode, content and metadata.
+				checkOriginals(t, map[string]jumbleFile{other: before[paths[1]]})
+			}
+			checkNoLitter(t, dir)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Package fixture builds small fake testenv trees, one per distro family, laid out the way
+// that family lays out a real system (merged /usr, boot files, loader and libc, auth config).
+// Selection, the breaks and the mutations run against one through a library.Root, in tests
+// and in sandbox mode, without touching the machine.
+package fixture
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/bootloader"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// file is one entry of a tree: a symlink when Link is set, else a regular file.
+type file struct {
+	Data string
+	Mode os.FileMode
+	Link string
+	Size int // random bytes appended to Data, so binaries and kernels differ in size
+}
+
+// Binaries are the programs every fixture has in /usr/bin, as small shell scripts.
+var Binaries = []string{
+	"bash", "cat", "cp", "cut", "date", "env", "find", "grep", "gzip", "head", "id",
+	"ls", "mv", "sed", "sort", "ssh", "tail", "tar", "tr", "wc",
+}
+
+const (
+	rhelKernel   = "5.14.0-427.13.1.el9_4.x86_64"
+	rhelOld      = "5.14.0-362.8.1.el9_3.x86_64"
+	debianKernel = "6.8.0-31-generic"
+	debianOld    = "6.8.0-28-generic"
+	machineID    = "8c3a1b2f6e0d4c5a9b7e1f2a3c4d5e6f"
+	rootUUID     = "4f1c2d3e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+)
+
+// Build writes the fixture tree of family under dir, which must not exist yet or be empty,
+// and returns it as a Root. FamilyUnknown is not a layout and is refused.
+func Build(dir string, family library.DistroFamily) (library.Root, error) {
+	var tree map[string]file
+	switch family {
+	case library.FamilyRHEL:
+		tree = rhelTree()
+	case library.FamilyDebian:
+		tree = debianTree()
+	default:
+		return "", fmt.Errorf("no fixture for distro family %q", family)
+	}
+	for name, f := range commonTree() {
+		if _, ok := tree[name]; !ok {
+			tree[name] = f
+		}
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return "", fmt.Errorf("fixture dir %s is not empty", dir)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	// In a fixed order, so a failed build always stops at the same entry.
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := write(filepath.Join(dir, name), tree[name]); err != nil {
+			return "", fmt.Errorf("fixture %s: %w", name, err)
+		}
+	}
+	return library.Root(dir), nil
+}
+
+func write(path string, f file) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if f.Link != "" {
+		return os.Symlink(f.Link, path)
+	}
+	data := []byte(f.Data)
+	if f.Size > 0 {
+		pad := make([]byte, f.Size)
+		if _, err := rand.Read(pad); err != nil {
+			return err
+		}
+		data = append(data, pad...)
+	}
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0o644
+	}
+	return os.WriteFile(path, data, mode)
+}
+
+// commonTree is what both families share: merged /usr, the binaries and auth config.
+func commonTree() map[string]file {
+	t := map[string]file{
+		"bin":  {Link: "usr/bin"},
+		"sbin": {Link: "usr/sbin"},
+		"lib":  {Link: "usr/lib"},
+
+		"usr/bin/sh":     {Link: "bash"},
+		"usr/sbin/sshd":  {Data: "#!/bin/sh\n# sshd\n", Mode: 0o755, Size: 4096},
+		"etc/passwd":     {Data: "root:x:0:0:root:/root:/bin/bash\n"},
+		"etc/sudoers":    {Data: "root ALL=(ALL:ALL) ALL\n", Mode: 0o440},
+		"etc/pam.d/sshd": {Data: "auth include password-auth\n"},
+		"etc/hostname":   {Data: "testenv\n"},
+	}
+	for i, b := range Binaries {
+		t["usr/bin/"+b] = file{Data: "#!/bin/sh\n# " + b + "\n", Mode: 0o755, Size: 2048 * (i + 1)}
+	}
+	return t
+}
+
+func rhelTree() map[string]file {
+	t := map[string]file{
+		"etc/os-release": {Data: osRelease("Red Hat Enterprise Linux", "rhel", "fedora", "9.4", "Red Hat Enterprise Linux 9.4 (Plow)")},
+		"etc/ld.so.conf": {Data: "include ld.so.conf.d/*.conf\n"},
+
+		"lib64":                          {Link: "usr/lib64"},
+		"usr/lib64/ld-linux-x86-64.so.2": {Data: "\x7fELF", Mode: 0o755, Size: 8192},
+		"usr/lib64/libc.so.6":            {Data: "\x7fELF", Mode: 0o755, Size: 16384},
+		"usr/bin/ld.so":                  {Link: "/lib64/ld-linux-x86-64.so.2"},
+		"usr/bin/ldd":                    {Data: "#!/bin/bash\n# ldd\n", Mode: 0o755, Size: 1024},
+
+		"boot/grub2/grub.cfg": {Data: "set default=\"${saved_entry}\"\nload_env -f ${config_directory}/grubenv\nblscfg\n"},
+		"boot/grub2/grubenv":  {Data: grubenv("saved_entry", machineID+"-"+rhelKernel, "boot_success", "0", "kernelopts", "root=UUID="+rootUUID+" ro crashkernel=auto")},
+	}
+	for _, k := range []string{rhelKernel, rhelOld} {
+		t["boot/vmlinuz-"+k] = file{Data: "MZ", Mode: 0o755, Size: 64 * 1024}
+		t["boot/initramfs-"+k+".img"] = file{Data: "\x1f\x8b", Mode: 0o600, Size: 96 * 1024}
+		t["boot/loader/entries/"+machineID+"-"+k+".conf"] = file{Data: fmt.Sprintf(
+			"title Red Hat Enterprise Linux (%[1]s) 9.4 (Plow)\nversion %[1]s\nlinux /vmlinuz-%[1]s\ninitrd /initramfs-%[1]s.img\noptions root=UUID=%[2]s ro crashkernel=auto\ngrub_users $grub_users\ngrub_arg --unrestricted\ngrub_class rhel\n",
+			k, rootUUID)}
+	}
+	return t
+}
+
+func debianTree() map[string]file {
+	t := map[string]file{
+		"etc/os-release":                         {Data: osRelease("Ubuntu", "ubuntu", "debian", "24.04", "Ubuntu 24.04 LTS")},
+		"etc/ld.so.conf":                         {Data: "include /etc/ld.so.conf.d/*.conf\n"},
+		"etc/ld.so.conf.d/x86_64-linux-gnu.conf": {Data: "/usr/local/lib/x86_64-linux-gnu\n/lib/x86_64-linux-gnu\n/usr/lib/x86_64-linux-gnu\n"},
+
+		"lib64":                          {Link: "usr/lib64"},
+		"usr/lib64/ld-linux-x86-64.so.2": {Link: "../lib/x86_64-linux-gnu/ld-linux-x86-64.so.2"},
+		"usr/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2": {Data: "\x7fELF", Mode: 0o755, Size: 8192},
+		"usr/lib/x86_64-linux-gnu/libc.so.6":            {Data: "\x7fELF", Mode: 0o755, Size: 16384},
+		"usr/bin/ld.so":                                 {Link: "/lib64/ld-linux-x86-64.so.2"},
+		"usr/bin/ldd":                                   {Data: "#!/bin/bash\n# ldd\n", Mode: 0o755, Size: 1024},
+
+		"boot/vmlinuz":      {Link: "vmlinuz-" + debianKernel},
+		"boot/initrd.img":   {Link: "initrd.img-" + debianKernel},
+		"boot/grub/grubenv": {Data: grubenv("saved_entry", "gnulinux-advanced-"+rootUUID+">gnulinux-"+debianKernel+"-advanced-"+rootUUID)},
+	}
+	var cfg strings.Builder
+	cfg.WriteString("set default=\"0\"\nif [ -s $prefix/grubenv ]; then\n  load_env\nfi\n")
+	for i, k := range []string{debianKernel, debianOld} {
+		t["boot/vmlinuz-"+k] = file{Data: "MZ", Mode: 0o600, Size: 64*1024 + i*4096}
+		t["boot/initrd.img-"+k] = file{Data: "\x1f\x8b", Mode: 0o644, Size: 96 * 1024}
+		fmt.Fprintf(&cfg, "menuentry 'Ubuntu, with Linux %[1]s' --class ubuntu $menuentry_id_option 'gnulinux-%[1]s-advanced-%[2]s' {\n\tlinux\t/boot/vmlinuz-%[1]s root=UUID=%[2]s ro quiet splash\n\tinitrd\t/boot/initrd.img-%[1]s\n}\n", k, rootUUID)
+	}
+	t["boot/grub/grub.cfg"] = file{Data: cfg.String(), Mode: 0o444}
+	return t
+}
+
+func osRelease(name, id, idLike, version, pretty string) string {
+	return fmt.Sprintf("NAME=%q\nID=%s\nID_LIKE=%q\nVERSION_ID=%q\nPRETTY_NAME=%q\n", name, id, idLike, version, pretty)
+}
+
+// grubenv renders name, value pairs as a GRUB environment block.
+func grubenv(kv ...string) string {
+	env := &bootloader.Grubenv{Size: bootloader.GrubenvSize}
+	for i := 0; i+1 < len(kv); i += 2 {
+		env.Vars = append(env.Vars, bootloader.GrubenvVar{Name: kv[i], Value: kv[i+1]})
+	}
+	b, err := env.Bytes()
+	if err != nil {
+		panic(err) // the fixtures' own variables always fit
+	}
+	return string(b)
+}
@@ -0,0 +1,392 @@
+package library
+
+import (
+	"bufio"
+	"chaos-agent/library/vault"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalDir is where the testenv keeps one mutation journal per session.
+const JournalDir = "/var/lib/chaos-journal"
+
+// JournalState is a step a mutation has reached.
+type JournalState string
+
+// Mutation steps, in the order Perform records them. Failed is followed by Reverted when
+// the revert succeeds.
+const (
+	StatePlanned  JournalState = "planned"
+	StateApplied  JournalState = "applied"
+	StateVerified JournalState = "verified"
+	StateFailed   JournalState = "failed"
+	StateReverted JournalState = "reverted"
+
+	// stateManifest records the before/after manifest of a finished mutation; it does not
+	// change the mutation's state.
+	stateManifest JournalState = "manifest"
+	// stateVault records where the session's vault is; it belongs to no mutation.
+	stateVault JournalState = "vault"
+)
+
+// JournalRecord is one line of a journal. Records for the same mutation share Seq; only
+// the planned record carries the plan.
+type JournalRecord struct {
+	Seq   int           `json:"seq"`
+	State JournalState  `json:"state"`
+	Time  time.Time     `json:"time"`
+	Plan  *MutationPlan `json:"plan,omitempty"`
+	Error string        `json:"error,omitempty"`
+
+	Manifest []ManifestEntry `json:"manifest,omitempty"` // on a "manifest" record
+	Vault    *JournalVault   `json:"vault,omitempty"`    // on a "vault" record
+}
+
+// JournalVault is where a session keeps the originals its mutations revert from.
+type JournalVault struct {
+	Token string `json:"token"`
+	Dir   string `json:"dir"` // root of the vault.DirStore
+}
+
+// Journal is an append-only, fsynced record of a session's mutations. A nil *Journal
+// records nothing, so mutations run the same with or without one.
+type Journal struct {
+	path string
+
+	mu       sync.Mutex
+	f        *os.File
+	next     int
+	manifest []ManifestEntry
+}
+
+// JournalPath is the journal file for session token under dir.
+func JournalPath(dir, token string) string {
+	return filepath.Join(dir, token+".jsonl")
+}
+
+// OpenJournal opens (or creates) the journal at path for appending.
+func OpenJournal(path string) (*Journal, error) {
+	recs, err := ReadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- journal under the agent's state directory
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, f: f}
+	for _, r := range recs {
+		j.next = max(j.next, r.Seq+1)
+	}
+	return j, nil
+}
+
+// Path is the journal's file.
+func (j *Journal) Path() string { return j.path }
+
+// sidecar is the path of a file named name kept next to the journal, for a mutation's own
+// crash-recovery state; "" without a journal.
+func (j *Journal) sidecar(name string) string {
+	if j == nil {
+		return ""
+	}
+	return strings.TrimSuffix(j.path, ".jsonl") + "." + name
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+func (j *Journal) planned(p MutationPlan) (int, error) {
+	if j == nil {
+		return 0, nil
+	}
+	j.mu.Lock()
+	seq := j.next
+	j.next++
+	j.mu.Unlock()
+	return seq, j.append(JournalRecord{Seq: seq, State: StatePlanned, Plan: &p})
+}
+
+func (j *Journal) record(seq int, state JournalState, cause error) error {
+	if j == nil {
+		return nil
+	}
+	r := JournalRecord{Seq: seq, State: state}
+	if cause != nil {
+		r.Error = cause.Error()
+	}
+	return j.append(r)
+}
+
+// recordManifest journals the before/after state of a finished mutation's paths.
+func (j *Journal) recordManifest(seq int, plan MutationPlan, state JournalState, before []FileState) error {
+	if j == nil {
+		return nil
+	}
+	entries := make([]ManifestEntry, len(plan.Paths))
+	for i, p := range plan.Paths {
+		entries[i] = ManifestEntry{Seq: seq, Kind: plan.Kind, State: state, Path: p, Before: before[i], After: CaptureFileState(p)}
+	}
+	j.mu.Lock()
+	j.manifest = append(j.manifest, entries...)
+	j.mu.Unlock()
+	return j.append(JournalRecord{Seq: seq, State: stateManifest, Manifest: entries})
+}
+
+// RecordVault notes where the session's vault is, so recovery after a restart can open it.
+func (j *Journal) RecordVault(v JournalVault) error {
+	if j == nil {
+		return nil
+	}
+	return j.append(JournalRecord{Seq: -1, State: stateVault, Vault: &v})
+}
+
+// Manifest returns the manifest entries recorded through this journal since it was opened.
+func (j *Journal) Manifest() []ManifestEntry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.manifest)
+}
+
+func (j *Journal) append(r JournalRecord) error {
+	r.Time = time.Now().UTC()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// ReadJournal reads every record at path; a missing journal has none. A torn last line
+// (the process died mid-write) is ignored.
+func ReadJournal(path string) ([]JournalRecord, error) {
+	// #nosec G304 -- journal under the agent's state directory
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var out []JournalRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var r JournalRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, sc.Err()
+}
+
+// Unfinished is a journaled mutation that neither verified nor reverted.
+type Unfinished struct {
+	Seq   int
+	State JournalState // last state reached: planned, applied or failed
+	Plan  MutationPlan
+}
+
+// UnfinishedMutations returns the mutations in recs that were interrupted, in journal order.
+func UnfinishedMutations(recs []JournalRecord) []Unfinished {
+	plans := make(map[int]MutationPlan)
+	last := make(map[int]JournalState)
+	for _, r := range recs {
+		if r.Plan != nil {
+			plans[r.Seq] = *r.Plan
+		}
+		if r.State != stateManifest && r.State != stateVault {
+			last[r.Seq] = r.State
+		}
+	}
+	var out []Unfinished
+	for seq, st := range last {
+		p, ok := plans[seq]
+		if !ok || st == StateVerified || st == StateReverted {
+			continue
+		}
+		out = append(out, Unfinished{Seq: seq, State: st, Plan: p})
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Seq < out[b].Seq })
+	return out
+}
+
+// RecoveryMode is what RecoverJournal does with interrupted mutations.
+type RecoveryMode string
+
+const (
+	// RecoverResume finishes them: apply what was only planned, then verify.
+	RecoverResume RecoveryMode = "resume"
+	// RecoverRollback reverts them, newest first.
+	RecoverRollback RecoveryMode = "rollback"
+)
+
+// ErrUnrecovered is returned by RecoverJournal when interrupted mutations could not be
+// reverted for want of the session's vault key. They stay unfinished in the journal, so a
+// later recovery with the key (see breaks.Recover) can finish them.
+var ErrUnrecovered = errors.New("interrupted mutations left unrecovered")
+
+// journalVault is the vault recorded in recs, or the default one for the journal at path.
+func journalVault(recs []JournalRecord, path string) JournalVault {
+	for _, r := range slices.Backward(recs) {
+		if r.State == stateVault && r.Vault != nil {
+			return *r.Vault
+		}
+	}
+	return JournalVault{Token: strings.TrimSuffix(filepath.Base(path), ".jsonl"), Dir: vault.DefaultDir}
+}
+
+// RecoverJournal resumes or rolls back the interrupted mutations in the journal at path,
+// journaling each outcome. It returns one line per mutation describing what happened.
+// A mutation that already failed is always reverted, whatever the mode. Reverts restore
+// from the session's vault, opened with key; without a key, mutations that need it are
+// reported as unrecovered and RecoverJournal returns ErrUnrecovered. The mutations run under
+// root, the testenv the journal belongs to.
+func RecoverJournal(root Root, path string, mode RecoveryMode, key []byte) ([]string, error) {
+	if mode != RecoverResume && mode != RecoverRollback {
+		return nil, fmt.Errorf("unknown recovery mode %q", mode)
+	}
+	recs, err := ReadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	todo := UnfinishedMutations(recs)
+	if len(todo) == 0 {
+		return nil, nil
+	}
+	var v *vault.Vault
+	if key != nil {
+		jv := journalVault(recs, path)
+		if v, err = vault.New(jv.Token, key, &vault.DirStore{Root: jv.Dir}); err != nil {
+			return nil, fmt.Errorf("open vault: %w", err)
+		}
+	}
+	j, err := OpenJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = j.Close() }()
+	// A resumed mutation draws afresh: the stream it first drew from is gone with the process.
+	s := NewSession(root, v, j, "")
+
+	if mode == RecoverRollback {
+		sort.Slice(todo, func(a, b int) bool { return todo[a].Seq > todo[b].Seq })
+	}
+	var out []string
+	unrecovered := 0
+	for _, u := range todo {
+		desc := fmt.Sprintf("%s #%d %s", u.Plan.Kind, u.Seq, strings.Join(u.Plan.Paths, ","))
+		m, err := DecodeMutation(u.Plan)
+		if err != nil {
+			out = append(out, fmt.Sprintf("%s: %v", desc, err))
+			continue
+		}
+		if mode == RecoverRollback || u.State == StateFailed {
+			if err := m.Revert(s); errors.Is(err, vault.ErrNoVault) {
+				unrecovered++
+				out = append(out, desc+": unrecovered: no vault key for the session")
+				continue
+			} else if err != nil {
+				_ = j.record(u.Seq, StateFailed, err)
+				out = append(out, fmt.Sprintf("%s: revert failed: %v", desc, err))
+				continue
+			}
+			_ = j.record(u.Seq, StateReverted, nil)
+			out = append(out, desc+": reverted")
+			continue
+		}
+		if u.State == StatePlanned {
+			if err := m.Apply(s); err != nil {
+				err = errors.Join(err, revertFailed(s, u.Seq, m, err))
+				if errors.Is(err, vault.ErrNoVault) {
+					unrecovered++
+				}
+				out = append(out, fmt.Sprintf("%s: resume: %v", desc, err))
+				continue
+			}
+			_ = j.record(u.Seq, StateApplied, nil)
+		}
+		if err := m.Verify(); err != nil {
+			err = errors.Join(err, revertFailed(s, u.Seq, m, err))
+			if errors.Is(err, vault.ErrNoVault) {
+				unrecovered++
+			}
+			out = append(out, fmt.Sprintf("%s: verify: %v", desc, err))
+			continue
+		}
+		_ = j.record(u.Seq, StateVerified, nil)
+		out = append(out, desc+": resumed")
+	}
+	if unrecovered > 0 {
+		return out, fmt.Errorf("%w: %d of %d", ErrUnrecovered, unrecovered, len(todo))
+	}
+	return out, nil
+}
+
+// PendingJournals lists the session tokens whose journals in dir still hold interrupted mutations.
+func PendingJournals(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, p := range paths {
+		recs, err := ReadJournal(p)
+		if err != nil {
+			return out, err
+		}
+		if len(UnfinishedMutations(recs)) > 0 {
+			out = append(out, strings.TrimSuffix(filepath.Base(p), ".jsonl"))
+		}
+	}
+	return out, nil
+}
+
+// RecoverJournals runs RecoverJournal on every journal in the host's dir, with no vault key:
+// mutations that need one are left for a keyed recovery, and the error wraps ErrUnrecovered.
+func RecoverJournals(dir string, mode RecoveryMode) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	var errs []error
+	for _, p := range paths {
+		lines, err := RecoverJournal(HostRoot, p, mode, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+		for _, l := range lines {
+			out = append(out, strings.TrimSuffix(filepath.Base(p), ".jsonl")+": "+l)
+		}
+	}
+	return out, errors.Join(errs...)
+}
@@ -0,0 +1,253 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultLifelines are the programs the lab reaches and runs the testenv through. A break
+// that damages one of them, or any library one of them loads, cuts the monitor off.
+var DefaultLifelines = []string{
+	"sshd", "sftp-server", "scp", "ssh",
+	"systemd", "systemctl", "journalctl",
+	"bash", "sh", "sudo",
+}
+
+// lifelineDirs are searched, in order, for lifelines given by name.
+var lifelineDirs = []string{
+	"/usr/sbin", "/usr/bin", "/sbin", "/bin",
+	"/usr/lib/systemd", "/lib/systemd",
+	"/usr/libexec/openssh", "/usr/lib/openssh", "/usr/lib/ssh",
+}
+
+// defaultLibDirs are the loader's trusted directories, searched after ld.so.conf.
+var defaultLibDirs = []string{
+	"/lib64", "/usr/lib64", "/lib", "/usr/lib",
+	"/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu",
+	"/lib/aarch64-linux-gnu", "/usr/lib/aarch64-linux-gnu",
+}
+
+// ProtectedSet is the files no break may touch, each with why.
+type ProtectedSet map[string]string
+
+// Has reports whether path (after resolving symlinks) is protected, and why.
+func (s ProtectedSet) Has(path string) (string, bool) {
+	if why, ok := s[path]; ok {
+		return why, true
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		why, ok := s[real]
+		return why, ok
+	}
+	return "", false
+}
+
+// LifelineClosure protects each lifeline (a path, or a name looked up in the usual binary
+// directories) and everything it needs to start: its ELF interpreter and its DT_NEEDED
+// libraries, transitively, found the way the loader would (RPATH/RUNPATH, ld.so.conf, the
+// default directories). Both a file's path and its symlink target are protected. Lifelines
+// that are missing or cannot be parsed are reported as warnings, not errors: a testenv
+// without sftp-server simply has nothing to protect there.
+func LifelineClosure(lifelines []string) (ProtectedSet, []string) {
+	return LifelineClosureIn(HostRoot, lifelines)
+}
+
+// LifelineClosureIn is LifelineClosure for the testenv under root; the set holds real paths.
+func LifelineClosureIn(root Root, lifelines []string) (ProtectedSet, []string) {
+	set := make(ProtectedSet)
+	var warnings []string
+	searchDirs := append(ldSoConfDirs(root, "/etc/ld.so.conf", 0), defaultLibDirs...)
+
+	type item struct{ path, why string }
+	var queue []item
+	for _, l := range lifelines {
+		p := findLifeline(root, l)
+		if p == "" {
+			warnings = append(warnings, "lifeline "+l+" not found")
+			continue
+		}
+		queue = append(queue, item{p, "lifeline " + l})
+	}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		real, err := root.EvalSymlinks(it.path)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", it.path, err))
+			continue
+		}
+		if _, done := set[real]; done {
+			continue
+		}
+		set[it.path] = it.why
+		set[real] = it.why
+
+		deps, err := elfDeps(root, real, searchDirs)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", real, err))
+			continue
+		}
+		for _, d := range deps {
+			queue = append(queue, item{d, "needed by " + it.path})
+		}
+	}
+	return set, warnings
+}
+
+var (
+	protectedMu   sync.Mutex
+	protectedSets = make(map[Root]ProtectedSet)
+)
+
+// DefaultProtected is LifelineClosure(DefaultLifelines), computed once per process.
+func DefaultProtected() ProtectedSet {
+	return ProtectedIn(HostRoot)
+}
+
+// ProtectedIn is LifelineClosureIn(root, DefaultLifelines), computed once per root.
+func ProtectedIn(root Root) ProtectedSet {
+	if root.IsHost() {
+		root = HostRoot
+	}
+	protectedMu.Lock()
+	defer protectedMu.Unlock()
+	set, ok := protectedSets[root]
+	if !ok {
+		set, _ = LifelineClosureIn(root, DefaultLifelines)
+		protectedSets[root] = set
+	}
+	return set
+}
+
+// findLifeline resolves a lifeline name to an existing real path under root, or "".
+func findLifeline(root Root, l string) string {
+	if filepath.IsAbs(l) {
+		if _, err := os.Stat(root.Path(l)); err == nil {
+			return root.Path(l)
+		}
+		return ""
+	}
+	for _, dir := range lifelineDirs {
+		p := root.Path(filepath.Join(dir, l))
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p
+		}
+	}
+	return ""
+}
+
+// elfDeps returns the interpreter and DT_NEEDED libraries of the ELF file at path, each
+// resolved under root to the first matching file for the same class and machine. searchDirs
+// are testenv paths. A non-ELF file (a script) has none; an unresolvable library is
+// skipped, as the loader would fail anyway.
+func elfDeps(root Root, path string, searchDirs []string) ([]string, error) {
+	ef, err := elf.Open(path)
+	if err != nil {
+		return nil, nil // not ELF
+	}
+	defer func() { _ = ef.Close() }()
+
+	var out []string
+	for _, p := range ef.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		b := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(b, 0); err != nil {
+			return nil, fmt.Errorf("read interpreter: %w", err)
+		}
+		out = append(out, root.Path(string(bytes.TrimRight(b, "\x00"))))
+	}
+
+	needed, err := ef.ImportedLibraries()
+	if err != nil {
+		return out, nil // static binary: no dynamic section
+	}
+	origin, _ := root.Logical(filepath.Dir(path))
+	var dirs []string
+	runpath, _ := ef.DynString(elf.DT_RUNPATH)
+	if len(runpath) == 0 {
+		rpath, _ := ef.DynString(elf.DT_RPATH)
+		runpath = rpath
+	}
+	for _, rp := range runpath {
+		for _, d := range strings.Split(rp, ":") {
+			d = strings.ReplaceAll(strings.ReplaceAll(d, "${ORIGIN}", origin), "$ORIGIN", origin)
+			if d != "" {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	dirs = append(dirs, searchDirs...)
+	for _, lib := range needed {
+		if strings.Contains(lib, "/") {
+			out = append(out, root.Path(lib))
+			continue
+		}
+		if p := findLibrary(root, lib, dirs, ef.Class, ef.Machine); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// findLibrary returns the first dirs/name under root that is an ELF file of the given class
+// and machine.
+func findLibrary(root Root, name string, dirs []string, class elf.Class, machine elf.Machine) string {
+	for _, dir := range dirs {
+		p := root.Path(filepath.Join(dir, name))
+		ef, err := elf.Open(p)
+		if err != nil {
+			continue
+		}
+		ok := ef.Class == class && ef.Machine == machine
+		_ = ef.Close()
+		if ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// ldSoConfDirs reads the library directories listed in the ld.so.conf at the testenv path
+// under root, following includes.
+func ldSoConfDirs(root Root, path string, depth int) []string {
+	if depth > 8 {
+		return nil
+	}
+	// #nosec G304 -- the system loader configuration
+	f, err := os.Open(root.Path(path))
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+	var dirs []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "include"):
+			glob := strings.TrimSpace(strings.TrimPrefix(line, "include"))
+			if !filepath.IsAbs(glob) {
+				glob = filepath.Join(filepath.Dir(path), glob)
+			}
+			matches, _ := root.Glob(glob)
+			for _, m := range matches {
+				if logical, ok := root.Logical(m); ok {
+					dirs = append(dirs, ldSoConfDirs(root, logical, depth+1)...)
+				}
+			}
+		case filepath.IsAbs(line):
+			dirs = append(dirs, line)
+		}
+	}
+	return dirs
+}
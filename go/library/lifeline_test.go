@@ -0,0 +1,121 @@
+package library_test
+
+import (
+	"bytes"
+	"chaos-agent/library"
+	"debug/elf"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hostShell is the machine's /bin/sh and its ELF interpreter, resolved; the test is skipped
+// where /bin/sh is not a dynamically linked ELF.
+func hostShell(t *testing.T) (sh, interp string) {
+	t.Helper()
+	sh, err := filepath.EvalSymlinks("/bin/sh")
+	if err != nil {
+		t.Skipf("no /bin/sh: %v", err)
+	}
+	ef, err := elf.Open(sh)
+	if err != nil {
+		t.Skipf("%s is not ELF: %v", sh, err)
+	}
+	defer func() { _ = ef.Close() }()
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_INTERP {
+			b, err := io.ReadAll(p.Open())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if interp, err = filepath.EvalSymlinks(string(bytes.TrimRight(b, "\x00"))); err != nil {
+				t.Fatal(err)
+			}
+			return sh, interp
+		}
+	}
+	t.Skipf("%s is statically linked", sh)
+	return "", ""
+}
+
+// hasLibc reports whether set protects a C library (libc.so.6, or libc-2.x.so on older glibcs).
+func hasLibc(set library.ProtectedSet) bool {
+	for p := range set {
+		if b := filepath.Base(p); strings.HasPrefix(b, "libc.so") || strings.HasPrefix(b, "libc-") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLifelineClosureOfShell(t *testing.T) {
+	sh, interp := hostShell(t)
+	// Only reads the machine's files.
+	set, warnings := library.LifelineClosureIn(library.HostRoot, []string{"/bin/sh", "no-such-lifeline"})
+	if _, ok := set[sh]; !ok {
+		t.Errorf("%s not protected", sh)
+	}
+	if why, ok := set.Has("/bin/sh"); !ok || why != "lifeline /bin/sh" {
+		t.Errorf("/bin/sh: %q, %v", why, ok)
+	}
+	if why, ok := set[interp]; !ok || !strings.HasPrefix(why, "needed by ") {
+		t.Errorf("interpreter %s: %q, %v", interp, why, ok)
+	}
+	if !hasLibc(set) {
+		t.Errorf("no libc among %d protected files", len(set))
+	}
+	if len(warnings) != 1 || warnings[0] != "lifeline no-such-lifeline not found" {
+		t.Errorf("warnings %q, want only the missing lifeline", warnings)
+	}
+}
+
+// TestLifelineClosureUnderRoot copies the shell's closure, files and links, into a temp tree
+// and checks the closure computed there names the copies, not the machine's files.
+func TestLifelineClosureUnderRoot(t *testing.T) {
+	hostShell(t)
+	hostSet, _ := library.LifelineClosure([]string{"/bin/sh"})
+	root := library.Root(t.TempDir())
+	for p := range hostSet {
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(root.Path(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if real != p {
+			// Absolute, so it resolves inside the root the way the closure follows links.
+			if err := os.Symlink(real, root.Path(p)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		data, err := os.ReadFile(real) // #nosec G304 -- file the host's shell loads
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(root.Path(p), data, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, warnings := library.LifelineClosureIn(root, []string{"sh", "sshd"})
+	for p := range set {
+		if !root.Contains(p) {
+			t.Errorf("%s is outside the root", p)
+		}
+	}
+	for p := range hostSet {
+		if _, ok := set[root.Path(p)]; !ok {
+			t.Errorf("%s not protected under the root", p)
+		}
+	}
+	if !hasLibc(set) {
+		t.Error("no libc protected under the root")
+	}
+	if len(warnings) != 1 || warnings[0] != "lifeline sshd not found" {
+		t.Errorf("warnings %q, want only the missing sshd", warnings)
+	}
+}
@@ -0,0 +1,80 @@
+package library
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// FileState is what a manifest records about a path at one point in time.
+type FileState struct {
+	Exists bool        `json:"exists"`
+	Size   int64       `json:"size,omitempty"`
+	Mode   os.FileMode `json:"mode,omitempty"`
+	UID    int         `json:"uid"`
+	GID    int         `json:"gid"`
+	SHA256 string      `json:"sha256,omitempty"` // regular files only
+	Link   string      `json:"link,omitempty"`   // a symlink's target, recorded instead of a hash
+	Error  string      `json:"error,omitempty"`  // the state could not be read
+}
+
+// SameContent reports whether s and o hold the same content: the same hash for files, the
+// same target for symlinks.
+func (s FileState) SameContent(o FileState) bool {
+	if s.Mode&os.ModeSymlink != 0 || o.Mode&os.ModeSymlink != 0 {
+		return s.Mode&os.ModeSymlink == o.Mode&os.ModeSymlink && s.Link == o.Link
+	}
+	return s.SHA256 == o.SHA256
+}
+
+// ManifestEntry records one path a mutation touched, before Apply and once the mutation
+// finished (verified, or failed and reverted).
+type ManifestEntry struct {
+	Seq    int          `json:"seq"`
+	Kind   string       `json:"kind"`
+	State  JournalState `json:"state"`
+	Path   string       `json:"path"`
+	Before FileState    `json:"before"`
+	After  FileState    `json:"after"`
+}
+
+// Manifest is every path a session mutated, reported to the monitor as a "manifest" message.
+type Manifest struct {
+	Token   string          `json:"token"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// CaptureFileState hashes and stats path without following a final symlink.
+func CaptureFileState(path string) FileState {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FileState{}
+	}
+	if err != nil {
+		return FileState{Error: err.Error()}
+	}
+	st := FileState{Exists: true, Size: fi.Size(), Mode: fi.Mode()}
+	if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+		st.UID, st.GID = int(sys.Uid), int(sys.Gid)
+	}
+	switch {
+	case fi.Mode().IsRegular():
+		if st.SHA256, err = fileSHA256(path); err != nil {
+			st.Error = err.Error()
+		}
+	case fi.Mode()&os.ModeSymlink != 0:
+		if st.Link, err = os.Readlink(path); err != nil {
+			st.Error = err.Error()
+		}
+	}
+	return st
+}
+
+func captureStates(paths []string) []FileState {
+	out := make([]FileState, len(paths))
+	for i, p := range paths {
+		out[i] = CaptureFileState(p)
+	}
+	return out
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/nacl/box"
@@ -55,7 +56,7 @@ func SendRawMessage(ip string, port int, message string, encryptionKey string) e
 		return fmt.Errorf("failed to encrypt message: %v", err)
 	}
 
-	addr := fmt.Sprintf("%s:%d", ip, port)
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to connect to %s: %v", addr, err)
@@ -0,0 +1,338 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const metaKind = "meta"
+
+// MetaSabotage is one way to damage a file's metadata while leaving its content alone.
+type MetaSabotage string
+
+const (
+	MetaDropExec       MetaSabotage = "drop_exec"            // clear every execute bit
+	MetaToggleSetuid   MetaSabotage = "toggle_setuid"        // set setuid if clear, clear it if set
+	MetaChmod          MetaSabotage = "chmod"                // permission bits to Value (octal)
+	MetaChownNobody    MetaSabotage = "chown_nobody"         // owner and group to nobody, or to Value "uid:gid"
+	MetaStripSELinux   MetaSabotage = "strip_selinux"        // remove security.selinux
+	MetaRelabelSELinux MetaSabotage = "relabel_selinux"      // security.selinux to Value, or the same label typed unlabeled_t
+	MetaStripCaps      MetaSabotage = "strip_capabilities"   // remove security.capability
+	MetaReplaceCaps    MetaSabotage = "replace_capabilities" // security.capability to Value (hex), or an empty capability set
+)
+
+// MetaSabotages lists every MetaSabotage kind.
+var MetaSabotages = []MetaSabotage{
+	MetaDropExec, MetaToggleSetuid, MetaChmod, MetaChownNobody,
+	MetaStripSELinux, MetaRelabelSELinux, MetaStripCaps, MetaReplaceCaps,
+}
+
+const (
+	xattrSELinux    = "security.selinux"
+	xattrCapability = "security.capability"
+	nobodyID        = 65534 // nobody/nogroup when the testenv's passwd and group do not say
+)
+
+// ErrNoMetaChange is returned by MetaChange.Plan when the sabotage would leave the file's
+// metadata as it is (no execute bit to drop, no SELinux label to strip, ...).
+var ErrNoMetaChange = errors.New("sabotage would not change the file's metadata")
+
+// MetaChange is the Mutation that sabotages a regular file's metadata (mode, ownership or
+// xattrs) instead of its content. Plan records the metadata before and after; Revert puts
+// the recorded original back, so it needs nothing from the vault, and the vault keeps only
+// the metadata for a session rollback (see NeedsContentBackup).
+type MetaChange struct {
+	Path     string
+	Sabotage MetaSabotage
+	Value    string // for the sabotages that take one; "" picks their default
+
+	before  datatypes.FileMeta // at Plan time
+	after   datatypes.FileMeta // what Apply sets
+	planned bool
+}
+
+var (
+	_ Mutation      = (*MetaChange)(nil)
+	_ ContentBackup = (*MetaChange)(nil)
+)
+
+type metaSpec struct {
+	Path     string             `json:"path"`
+	Sabotage MetaSabotage       `json:"sabotage"`
+	Value    string             `json:"value,omitempty"`
+	Before   datatypes.FileMeta `json:"before"`
+	After    datatypes.FileMeta `json:"after"`
+}
+
+// MetaChangeResult is what a break reports about a metadata sabotage.
+type MetaChangeResult struct {
+	Path     string       `json:"path"`
+	Sabotage MetaSabotage `json:"sabotage"`
+	Before   MetaSummary  `json:"before"`
+	After    MetaSummary  `json:"after"`
+}
+
+// MetaSummary is the metadata a MetaSabotage can change, in the form ls -l and getfattr print.
+type MetaSummary struct {
+	Mode   string            `json:"mode"` // octal, with setuid/setgid/sticky
+	UID    int               `json:"uid"`
+	GID    int               `json:"gid"`
+	XAttrs map[string]string `json:"xattrs,omitempty"` // security.* only, hex unless printable
+}
+
+// Result summarises the planned change.
+func (m *MetaChange) Result() MetaChangeResult {
+	return MetaChangeResult{Path: m.Path, Sabotage: m.Sabotage, Before: summarizeMeta(m.before), After: summarizeMeta(m.after)}
+}
+
+// Plan captures the file's metadata and works out what the sabotage turns it into.
+func (m *MetaChange) Plan(s *Session) (MutationPlan, error) {
+	before, err := vault.CaptureMeta(m.Path)
+	if err != nil {
+		return MutationPlan{}, fmt.Errorf("meta %q: %w", m.Path, err)
+	}
+	after, err := sabotageMeta(s.Root, before, m.Sabotage, m.Value)
+	if err != nil {
+		return MutationPlan{}, fmt.Errorf("%s %q: %w", m.Sabotage, m.Path, err)
+	}
+	if metaEqual(before, after) {
+		return MutationPlan{}, fmt.Errorf("%s %q: %w", m.Sabotage, m.Path, ErrNoMetaChange)
+	}
+	spec, err := json.Marshal(metaSpec{Path: m.Path, Sabotage: m.Sabotage, Value: m.Value, Before: before, After: after})
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	m.before, m.after, m.planned = before, after, true
+	return MutationPlan{Kind: metaKind, Paths: []string{m.Path}, Spec: spec}, nil
+}
+
+func decodeMeta(raw json.RawMessage) (Mutation, error) {
+	var spec metaSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, err
+	}
+	return &MetaChange{Path: spec.Path, Sabotage: spec.Sabotage, Value: spec.Value, before: spec.Before, after: spec.After, planned: true}, nil
+}
+
+// NeedsContentBackup is false: the content is never touched, so Perform vaults only the
+// metadata.
+func (m *MetaChange) NeedsContentBackup() bool { return false }
+
+// Apply sets the planned metadata; the file's times are kept.
+func (m *MetaChange) Apply(*Session) error {
+	if !m.planned {
+		return errors.New("meta: Apply before Plan")
+	}
+	return setMeta(m.Path, m.after)
+}
+
+// Verify checks the file carries exactly the planned metadata: a chown the testenv refused,
+// or an xattr its filesystem does not support, fails here rather than passing silently.
+func (m *MetaChange) Verify() error {
+	now, err := vault.CaptureMeta(m.Path)
+	if err != nil {
+		return err
+	}
+	if !metaEqual(now, m.after) {
+		return fmt.Errorf("%q has metadata %+v, want %+v", m.Path, summarizeMeta(now), summarizeMeta(m.after))
+	}
+	return nil
+}
+
+// Revert puts the metadata recorded at Plan time back.
+func (m *MetaChange) Revert(*Session) error {
+	return setMeta(m.Path, m.before)
+}
+
+// setMeta makes path's ownership, mode and xattrs those of meta, then restores its times.
+// Ownership goes first: chown clears setuid and security.capability.
+func setMeta(path string, meta datatypes.FileMeta) error {
+	if err := vault.ApplyPreMeta(path, meta); err != nil {
+		return err
+	}
+	if now, err := vault.CaptureMeta(path); err == nil {
+		for k := range now.XAttr {
+			if _, keep := meta.XAttr[k]; keep {
+				continue
+			}
+			if err := unix.Removexattr(path, k); err != nil {
+				return fmt.Errorf("remove xattr %s from %s: %w", k, path, err)
+			}
+		}
+	}
+	return vault.ApplyPostMeta(path, meta)
+}
+
+// sabotageMeta returns before as s (with value) would leave it on a file in the testenv under root.
+func sabotageMeta(root Root, before datatypes.FileMeta, s MetaSabotage, value string) (datatypes.FileMeta, error) {
+	after := before
+	after.XAttr = maps.Clone(before.XAttr)
+	if after.XAttr == nil {
+		after.XAttr = map[string][]byte{}
+	}
+	switch s {
+	case MetaDropExec:
+		after.Mode &^= 0o111
+	case MetaToggleSetuid:
+		after.Mode ^= os.ModeSetuid
+	case MetaChmod:
+		perm, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || perm > 0o7777 {
+			return after, fmt.Errorf("chmod wants an octal mode, got %q", value)
+		}
+		after.Mode = after.Mode&^(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) | unixToFileMode(uint32(perm))
+	case MetaChownNobody:
+		uid, gid, err := nobodyOwner(root, value)
+		if err != nil {
+			return after, err
+		}
+		after.UID, after.GID = uid, gid
+	case MetaStripSELinux:
+		delete(after.XAttr, xattrSELinux)
+	case MetaRelabelSELinux:
+		after.XAttr[xattrSELinux] = relabel(before.XAttr[xattrSELinux], value)
+	case MetaStripCaps:
+		delete(after.XAttr, xattrCapability)
+	case MetaReplaceCaps:
+		caps := emptyCaps()
+		if value != "" {
+			var err error
+			if caps, err = hex.DecodeString(value); err != nil {
+				return after, fmt.Errorf("capability value: %w", err)
+			}
+		}
+		after.XAttr[xattrCapability] = caps
+	default:
+		return after, fmt.Errorf("unknown metadata sabotage %q", s)
+	}
+	return after, nil
+}
+
+// relabel is the SELinux label to write: value if given, else label with its type replaced
+// by unlabeled_t (a plausible label the policy will not let anything use).
+func relabel(label []byte, value string) []byte {
+	if value != "" {
+		return append([]byte(value), 0)
+	}
+	parts := strings.Split(strings.TrimRight(string(label), "\x00"), ":")
+	if len(parts) < 4 {
+		return []byte("system_u:object_r:unlabeled_t:s0\x00")
+	}
+	parts[2] = "unlabeled_t"
+	return append([]byte(strings.Join(parts, ":")), 0)
+}
+
+// emptyCaps is a revision 2 security.capability granting nothing, with the effective flag
+// set: the binary still looks capability-aware but starts without the privileges it expects.
+func emptyCaps() []byte {
+	const vfsCapRevision2, vfsCapFlagsEffective = 0x02000000, 0x000001
+	b := make([]byte, 20)
+	binary.LittleEndian.PutUint32(b, vfsCapRevision2|vfsCapFlagsEffective)
+	return b
+}
+
+// nobodyOwner parses "uid:gid", or looks nobody and nogroup/nobody up in root's
+// passwd and group files.
+func nobodyOwner(root Root, value string) (int, int, error) {
+	if value != "" {
+		u, g, ok := strings.Cut(value, ":")
+		uid, uerr := strconv.Atoi(u)
+		gid, gerr := strconv.Atoi(g)
+		if !ok || uerr != nil || gerr != nil {
+			return 0, 0, fmt.Errorf("owner wants uid:gid, got %q", value)
+		}
+		return uid, gid, nil
+	}
+	uid := lookupID(root.Path("/etc/passwd"), 2, "nobody")
+	gid := lookupID(root.Path("/etc/group"), 2, "nogroup", "nobody")
+	return uid, gid, nil
+}
+
+// lookupID returns field idx of the first line of a passwd-style file whose name is one of
+// names, in the order given; nobodyID if there is none.
+func lookupID(path string, idx int, names ...string) int {
+	// #nosec G304 -- the testenv's account database
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nobodyID
+	}
+	for _, name := range names {
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for sc.Scan() {
+			f := strings.Split(sc.Text(), ":")
+			if len(f) > idx && f[0] == name {
+				if id, err := strconv.Atoi(f[idx]); err == nil {
+					return id
+				}
+			}
+		}
+	}
+	return nobodyID
+}
+
+func metaEqual(a, b datatypes.FileMeta) bool {
+	return a.Mode == b.Mode && a.UID == b.UID && a.GID == b.GID &&
+		maps.EqualFunc(a.XAttr, b.XAttr, bytes.Equal)
+}
+
+func summarizeMeta(m datatypes.FileMeta) MetaSummary {
+	s := MetaSummary{Mode: fmt.Sprintf("%04o", UnixMode(m.Mode)), UID: m.UID, GID: m.GID}
+	for k, v := range m.XAttr {
+		if !strings.HasPrefix(k, "security.") {
+			continue
+		}
+		if s.XAttrs == nil {
+			s.XAttrs = make(map[string]string)
+		}
+		if t := strings.TrimRight(string(v), "\x00"); k == xattrSELinux && strconv.CanBackquote(t) {
+			s.XAttrs[k] = t
+		} else {
+			s.XAttrs[k] = hex.EncodeToString(v)
+		}
+	}
+	return s
+}
+
+// UnixMode is m's permission bits with setuid/setgid/sticky in their chmod places, as
+// chmod takes them and stat(1) prints them with %a.
+func UnixMode(m os.FileMode) uint32 {
+	u := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		u |= 0o4000
+	}
+	if m&os.ModeSetgid != 0 {
+		u |= 0o2000
+	}
+	if m&os.ModeSticky != 0 {
+		u |= 0o1000
+	}
+	return u
+}
+
+// unixToFileMode is the inverse of UnixMode.
+func unixToFileMode(u uint32) os.FileMode {
+	m := os.FileMode(u & 0o777)
+	if u&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if u&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if u&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
@@ -0,0 +1,294 @@
+package library
+
+import (
+	"bytes"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	binLabel = "system_u:object_r:bin_t:s0\x00"
+	// netRawCaps is a revision 2 security.capability granting cap_net_raw, effective.
+	netRawCaps = "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+)
+
+// metaRoot is a testenv root whose passwd and group name nobody 99 and nogroup 98, with a
+// tool binary in it.
+func metaRoot(t *testing.T) (root Root, tool string) {
+	t.Helper()
+	root = Root(t.TempDir())
+	files := map[string]string{
+		"/etc/passwd":   "root:x:0:0:root:/root:/bin/sh\nnobody:x:99:99:Nobody:/:/sbin/nologin\n",
+		"/etc/group":    "root:x:0:\nnobody:x:99:\nnogroup:x:98:\n",
+		"/usr/bin/tool": "#!/bin/sh\necho tool\n",
+	}
+	for p, data := range files {
+		if err := os.MkdirAll(filepath.Dir(root.Path(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(root.Path(p), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, root.Path("/usr/bin/tool")
+}
+
+// metaVault is a local vault for the test.
+func metaVault(t *testing.T) (v *vault.Vault, key []byte, store *vault.DirStore) {
+	t.Helper()
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	store = &vault.DirStore{Root: t.TempDir()}
+	v, err := vault.New("meta-test", key, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, key, store
+}
+
+// setXattr sets a security.* xattr, skipping the test where the filesystem or the
+// process's privileges do not allow it.
+func setXattr(t *testing.T, path, name, value string) {
+	t.Helper()
+	if err := unix.Setxattr(path, name, []byte(value), 0); err != nil {
+		t.Skipf("set %s: %v", name, err)
+	}
+}
+
+// TestMetaChangeSabotages performs each sabotage on a file in a temp root through Perform,
+// and checks the file carries the planned metadata with its content and times untouched,
+// that the vault kept the metadata but no content, and that both the journaled Revert and a
+// vault rollback put the original metadata back.
+func TestMetaChangeSabotages(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown and security.* xattrs need root")
+	}
+	for _, tc := range []struct {
+		sabotage MetaSabotage
+		mode     os.FileMode
+		xattr    map[string]string // set before the sabotage
+		value    string
+		want     func(m *datatypes.FileMeta)
+	}{
+		{MetaDropExec, 0o755, nil, "", func(m *datatypes.FileMeta) { m.Mode = 0o644 }},
+		{MetaToggleSetuid, 0o755, nil, "", func(m *datatypes.FileMeta) { m.Mode = 0o755 | os.ModeSetuid }},
+		{MetaChmod, 0o755, nil, "2711", func(m *datatypes.FileMeta) { m.Mode = 0o711 | os.ModeSetgid }},
+		{MetaChownNobody, 0o755, nil, "", func(m *datatypes.FileMeta) { m.UID, m.GID = 99, 98 }},
+		{MetaStripSELinux, 0o755, map[string]string{xattrSELinux: binLabel}, "", func(m *datatypes.FileMeta) {
+			delete(m.XAttr, xattrSELinux)
+		}},
+		{MetaRelabelSELinux, 0o755, map[string]string{xattrSELinux: binLabel}, "", func(m *datatypes.FileMeta) {
+			m.XAttr[xattrSELinux] = []byte("system_u:object_r:unlabeled_t:s0\x00")
+		}},
+		{MetaStripCaps, 0o755, map[string]string{xattrCapability: netRawCaps}, "", func(m *datatypes.FileMeta) {
+			delete(m.XAttr, xattrCapability)
+		}},
+		{MetaReplaceCaps, 0o755, map[string]string{xattrCapability: netRawCaps}, "", func(m *datatypes.FileMeta) {
+			m.XAttr[xattrCapability] = emptyCaps()
+		}},
+	} {
+		t.Run(string(tc.sabotage), func(t *testing.T) {
+			root, tool := metaRoot(t)
+			v, key, store := metaVault(t)
+			s := NewSession(root, v, nil, "")
+			if err := os.Chmod(tool, tc.mode); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tc.xattr {
+				setXattr(t, tool, k, v)
+			}
+			mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			if err := os.Chtimes(tool, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(tool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, err := vault.CaptureMeta(tool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := before
+			want.XAttr = maps.Clone(before.XAttr)
+			if want.XAttr == nil {
+				want.XAttr = map[string][]byte{}
+			}
+			tc.want(&want)
+
+			plan, err := s.Perform(&MetaChange{Path: tool, Sabotage: tc.sabotage, Value: tc.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			now, err := vault.CaptureMeta(tool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !metaEqual(now, want) {
+				t.Errorf("sabotaged to %+v, want %+v", summarizeMeta(now), summarizeMeta(want))
+			}
+			if got, _ := os.ReadFile(tool); !bytes.Equal(got, content) {
+				t.Error("content changed")
+			}
+			if !now.Mtime.Equal(mtime) {
+				t.Errorf("mtime %v, want %v kept", now.Mtime, mtime)
+			}
+			entries, err := store.Entries("meta-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || !entries[0].MetaOnly || entries[0].Size != 0 {
+				t.Fatalf("vault entries %+v, want one metadata-only entry", entries)
+			}
+
+			m, err := DecodeMutation(plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Revert(s); err != nil {
+				t.Fatal(err)
+			}
+			if now, _ := vault.CaptureMeta(tool); !metaEqual(now, before) || !now.Mtime.Equal(mtime) {
+				t.Errorf("reverted to %+v, want %+v", summarizeMeta(now), summarizeMeta(before))
+			}
+
+			if err := m.Apply(s); err != nil {
+				t.Fatal(err)
+			}
+			rep, err := vault.Rollback(key, store, "meta-test", nil)
+			if err != nil || !rep.OK() {
+				t.Fatalf("rollback %+v, %v", rep, err)
+			}
+			if now, _ := vault.CaptureMeta(tool); !metaEqual(now, before) || !now.Mtime.Equal(mtime) {
+				t.Errorf("rolled back to %+v, want %+v", summarizeMeta(now), summarizeMeta(before))
+			}
+			if got, _ := os.ReadFile(tool); !bytes.Equal(got, content) {
+				t.Error("rollback changed the content")
+			}
+		})
+	}
+}
+
+func TestMetaChangeNoChange(t *testing.T) {
+	root, tool := metaRoot(t)
+	v, _, store := metaVault(t)
+	s := NewSession(root, v, nil, "")
+	for _, kind := range []MetaSabotage{MetaDropExec, MetaStripSELinux, MetaStripCaps} {
+		if _, err := s.Perform(&MetaChange{Path: tool, Sabotage: kind}); !errors.Is(err, ErrNoMetaChange) {
+			t.Errorf("%s on a plain 0644 file: %v, want ErrNoMetaChange", kind, err)
+		}
+	}
+	if entries, _ := store.Entries("meta-test"); len(entries) != 0 {
+		t.Errorf("vault kept %d entries for sabotages that changed nothing", len(entries))
+	}
+}
+
+func TestSabotageMeta(t *testing.T) {
+	root, _ := metaRoot(t)
+	before := datatypes.FileMeta{Mode: 0o755, UID: 0, GID: 0, XAttr: map[string][]byte{
+		xattrSELinux:    []byte(binLabel),
+		xattrCapability: []byte(netRawCaps),
+		"user.note":     []byte("kept"),
+	}}
+	with := func(f func(m *datatypes.FileMeta)) datatypes.FileMeta {
+		m := before
+		m.XAttr = maps.Clone(before.XAttr)
+		f(&m)
+		return m
+	}
+	for _, tc := range []struct {
+		sabotage MetaSabotage
+		value    string
+		want     datatypes.FileMeta
+		err      bool
+	}{
+		{MetaDropExec, "", with(func(m *datatypes.FileMeta) { m.Mode = 0o644 }), false},
+		{MetaToggleSetuid, "", with(func(m *datatypes.FileMeta) { m.Mode |= os.ModeSetuid }), false},
+		{MetaChmod, "4750", with(func(m *datatypes.FileMeta) { m.Mode = 0o750 | os.ModeSetuid }), false},
+		{MetaChmod, "1777", with(func(m *datatypes.FileMeta) { m.Mode = 0o777 | os.ModeSticky }), false},
+		{MetaChmod, "", before, true},
+		{MetaChmod, "0o644", before, true},
+		{MetaChmod, "17777", before, true},
+		{MetaChownNobody, "", with(func(m *datatypes.FileMeta) { m.UID, m.GID = 99, 98 }), false},
+		{MetaChownNobody, "1000:100", with(func(m *datatypes.FileMeta) { m.UID, m.GID = 1000, 100 }), false},
+		{MetaChownNobody, "1000", before, true},
+		{MetaChownNobody, "nobody:nogroup", before, true},
+		{MetaStripSELinux, "", with(func(m *datatypes.FileMeta) { delete(m.XAttr, xattrSELinux) }), false},
+		{MetaRelabelSELinux, "", with(func(m *datatypes.FileMeta) {
+			m.XAttr[xattrSELinux] = []byte("system_u:object_r:unlabeled_t:s0\x00")
+		}), false},
+		{MetaRelabelSELinux, "system_u:object_r:shadow_t:s0", with(func(m *datatypes.FileMeta) {
+			m.XAttr[xattrSELinux] = []byte("system_u:object_r:shadow_t:s0\x00")
+		}), false},
+		{MetaStripCaps, "", with(func(m *datatypes.FileMeta) { delete(m.XAttr, xattrCapability) }), false},
+		{MetaReplaceCaps, "", with(func(m *datatypes.FileMeta) { m.XAttr[xattrCapability] = emptyCaps() }), false},
+		{MetaReplaceCaps, "0100000200100000", with(func(m *datatypes.FileMeta) {
+			m.XAttr[xattrCapability] = []byte{0x01, 0, 0, 0x02, 0, 0x10, 0, 0}
+		}), false},
+		{MetaReplaceCaps, "not hex", before, true},
+		{"bogus", "", before, true},
+	} {
+		got, err := sabotageMeta(root, before, tc.sabotage, tc.value)
+		switch {
+		case tc.err && err == nil:
+			t.Errorf("%s %q: got %+v, want an error", tc.sabotage, tc.value, summarizeMeta(got))
+		case !tc.err && err != nil:
+			t.Errorf("%s %q: %v", tc.sabotage, tc.value, err)
+		case !tc.err && !metaEqual(got, tc.want):
+			t.Errorf("%s %q: got %+v, want %+v", tc.sabotage, tc.value, summarizeMeta(got), summarizeMeta(tc.want))
+		}
+	}
+	if len(before.XAttr) != 3 || string(before.XAttr[xattrSELinux]) != binLabel || string(before.XAttr[xattrCapability]) != netRawCaps {
+		t.Errorf("sabotageMeta changed the metadata it was given: %+v", summarizeMeta(before))
+	}
+
+	// Without passwd or group entries for nobody, the owner falls back to 65534.
+	if err := os.Remove(root.Path("/etc/passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sabotageMeta(root, before, MetaChownNobody, ""); err != nil || got.UID != nobodyID || got.GID != 98 {
+		t.Errorf("chown_nobody without passwd: %d:%d, %v; want %d:98", got.UID, got.GID, err, nobodyID)
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	for _, tc := range []struct {
+		label, value, want string
+	}{
+		{binLabel, "", "system_u:object_r:unlabeled_t:s0\x00"},
+		{"system_u:object_r:sshd_exec_t:s0", "", "system_u:object_r:unlabeled_t:s0\x00"},
+		{"system_u:object_r:bin_t:s0:c0.c1023\x00", "", "system_u:object_r:unlabeled_t:s0:c0.c1023\x00"},
+		{"", "", "system_u:object_r:unlabeled_t:s0\x00"},
+		{"bin_t", "", "system_u:object_r:unlabeled_t:s0\x00"},
+		{binLabel, "unconfined_u:object_r:user_home_t:s0", "unconfined_u:object_r:user_home_t:s0\x00"},
+	} {
+		if got := string(relabel([]byte(tc.label), tc.value)); got != tc.want {
+			t.Errorf("relabel(%q, %q) = %q, want %q", tc.label, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestEmptyCaps(t *testing.T) {
+	caps := emptyCaps()
+	if len(caps) != 20 {
+		t.Fatalf("%d bytes, want the 20 of a revision 2 capability", len(caps))
+	}
+	if magic := binary.LittleEndian.Uint32(caps); magic != 0x02000001 {
+		t.Errorf("magic_etc %#08x, want revision 2 with the effective flag", magic)
+	}
+	if !bytes.Equal(caps[4:], make([]byte, 16)) {
+		t.Errorf("capability sets % x, want none granted", caps[4:])
+	}
+}
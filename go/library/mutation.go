@@ -0,0 +1,166 @@
+package library
+
+import (
+	"chaos-agent/library/vault"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Mutation is one change a break makes to the filesystem, split so it can be journaled,
+// checked and undone. Plan fixes exactly what will change without touching anything (it
+// must be called first); Apply makes the change; Verify confirms it took effect; Revert
+// puts the planned paths back as they were at Plan time. Plan, Apply and Revert draw
+// randomness, sidecar files and originals from the session they run under.
+type Mutation interface {
+	Plan(s *Session) (MutationPlan, error)
+	Apply(s *Session) error
+	Verify() error
+	Revert(s *Session) error
+}
+
+// Session is what one break's mutations run under: the root they are confined to, the vault
+// that keeps originals, the journal that records them and the stream every random choice
+// comes from. Each break has its own, so breaks in one process do not share any of them.
+type Session struct {
+	Root    Root
+	Vault   *vault.Vault // nil keeps no originals
+	Journal *Journal     // nil records nothing
+	Rand    *Rand
+}
+
+// NewSession is a session under root that keeps originals in v, records to j and draws from
+// a stream keyed from seed (see NewRand).
+func NewSession(root Root, v *vault.Vault, j *Journal, seed string) *Session {
+	return &Session{Root: root, Vault: v, Journal: j, Rand: NewRand(seed)}
+}
+
+// revert restores path's original from the session's vault.
+func (s *Session) revert(path string) error {
+	if s.Vault == nil {
+		return vault.ErrNoVault
+	}
+	return s.Vault.Revert(path)
+}
+
+// ContentBackup is implemented by mutations that can say whether the vault needs the
+// planned paths' content. One that only changes metadata (see MetaChange) answers false,
+// and Perform keeps just the metadata, sparing a full copy of every target.
+type ContentBackup interface {
+	NeedsContentBackup() bool
+}
+
+// MutationPlan describes a planned mutation. Spec holds everything DecodeMutation needs
+// to rebuild it, including the pre-mutation hashes Verify and Revert check against.
+type MutationPlan struct {
+	Kind  string          `json:"kind"`
+	Paths []string        `json:"paths"`
+	Spec  json.RawMessage `json:"spec"`
+}
+
+// mutationKinds rebuilds a planned mutation from its journaled spec.
+var mutationKinds = map[string]func(spec json.RawMessage) (Mutation, error){
+	corruptionKind: decodeCorruption,
+	jumbleKind:     decodeJumble,
+	metaKind:       decodeMeta,
+	rewriteKind:    decodeRewrite,
+}
+
+// DecodeMutation rebuilds a planned mutation, e.g. from a journal after a restart.
+func DecodeMutation(p MutationPlan) (Mutation, error) {
+	decode, ok := mutationKinds[p.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown mutation kind %q", p.Kind)
+	}
+	return decode(p.Spec)
+}
+
+// ErrLifeline is returned by Perform for a plan that touches a protected file (see ProtectedIn).
+var ErrLifeline = errors.New("refusing to mutate a lifeline file")
+
+// Perform runs m through its steps: plan, preserve the planned paths in the session's vault
+// (their metadata alone, if m needs no content backup), journal, apply and verify. A
+// mutation that fails to apply or verify is reverted. The planned paths' state before Apply
+// and at the end goes into the journal's manifest. Plans that reach outside the session's
+// root or touch the lab's lifelines in it (see ProtectedIn) are refused before anything
+// changes.
+func (s *Session) Perform(m Mutation) (MutationPlan, error) {
+	plan, err := m.Plan(s)
+	if err != nil {
+		return plan, err
+	}
+	protected := ProtectedIn(s.Root)
+	for _, p := range plan.Paths {
+		if !s.Root.Contains(p) {
+			return plan, fmt.Errorf("%w %s: %s", ErrOutsideRoot, s.Root, p)
+		}
+		if why, ok := protected.Has(p); ok {
+			return plan, fmt.Errorf("%w: %s (%s)", ErrLifeline, p, why)
+		}
+	}
+	if s.Vault != nil {
+		preserve := s.Vault.Preserve
+		if b, ok := m.(ContentBackup); ok && !b.NeedsContentBackup() {
+			preserve = s.Vault.PreserveMeta
+		}
+		for _, p := range plan.Paths {
+			if err := preserve(p); err != nil {
+				return plan, err
+			}
+		}
+	}
+	j := s.Journal
+	seq, err := j.planned(plan)
+	if err != nil {
+		return plan, fmt.Errorf("journal: %w", err)
+	}
+	before := captureStates(plan.Paths)
+	fail := func(cause error) error {
+		state, rerr := StateReverted, revertFailed(s, seq, m, cause)
+		if rerr != nil {
+			state = StateFailed
+		}
+		return errors.Join(cause, rerr, j.recordManifest(seq, plan, state, before))
+	}
+	if err := m.Apply(s); err != nil {
+		return plan, fail(err)
+	}
+	if err := j.record(seq, StateApplied, nil); err != nil {
+		return plan, fmt.Errorf("journal: %w", err)
+	}
+	if err := m.Verify(); err != nil {
+		return plan, fail(fmt.Errorf("verify %s: %w", plan.Kind, err))
+	}
+	if err := j.record(seq, StateVerified, nil); err != nil {
+		return plan, fmt.Errorf("journal: %w", err)
+	}
+	return plan, j.recordManifest(seq, plan, StateVerified, before)
+}
+
+// revertFailed journals a failed mutation and reverts it.
+func revertFailed(s *Session, seq int, m Mutation, cause error) error {
+	_ = s.Journal.record(seq, StateFailed, cause)
+	if err := m.Revert(s); err != nil {
+		return fmt.Errorf("revert: %w", err)
+	}
+	return s.Journal.record(seq, StateReverted, nil)
+}
+
+// fileSHA256 hashes path's content without loading it whole.
+func fileSHA256(path string) (string, error) {
+	// #nosec G304 -- mutation target chosen by the break
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,121 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// PermutationMode is how a Jumble decides which file receives whose content. Every mode
+// yields a derangement: each file it includes ends up with another file's content.
+type PermutationMode string
+
+const (
+	// PermuteCycle moves content around one cycle through every file.
+	PermuteCycle PermutationMode = "cycle"
+	// PermutePairs swaps disjoint pairs (the last three form a cycle when the count is odd).
+	PermutePairs PermutationMode = "pairs"
+	// PermuteSameDir swaps pairs only within a directory; files alone in theirs are left out.
+	PermuteSameDir PermutationMode = "same_dir"
+	// PermuteSimilarSize swaps pairs only between files within maxSizeRatio of each other's
+	// size, so a swapped binary is not obviously too small or too big for its name.
+	PermuteSimilarSize PermutationMode = "similar_size"
+)
+
+// maxSizeRatio bounds how much larger than the smallest file of a PermuteSimilarSize group
+// another member may be.
+const maxSizeRatio = 2
+
+// ParsePermutationMode checks s names a mode; "" is PermuteCycle.
+func ParsePermutationMode(s string) (PermutationMode, error) {
+	switch m := PermutationMode(s); m {
+	case "":
+		return PermuteCycle, nil
+	case PermuteCycle, PermutePairs, PermuteSameDir, PermuteSimilarSize:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown permutation mode %q (want cycle, pairs, same_dir or similar_size)", s)
+	}
+}
+
+// FileMove is one step of a jumble: Dst ends up holding Src's original content.
+type FileMove struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// JumbleMapping is what a jumble reports: which file now holds whose content, and the
+// package owning each path, where known.
+type JumbleMapping struct {
+	Mode     PermutationMode        `json:"mode"`
+	Moves    []FileMove             `json:"moves"`
+	Packages map[string]PackageInfo `json:"packages,omitempty"`
+}
+
+// permute turns paths, already shuffled, into the moves of a derangement under mode. Each
+// included path appears once as Src and once as Dst, never as both in one move.
+func permute(mode PermutationMode, paths []string, sizes map[string]int64) ([]FileMove, error) {
+	var moves []FileMove
+	switch mode {
+	case "", PermuteCycle:
+		moves = cycleMoves(paths)
+	case PermutePairs:
+		moves = pairMoves(paths)
+	case PermuteSameDir:
+		groups := make(map[string][]string)
+		for _, p := range paths {
+			groups[filepath.Dir(p)] = append(groups[filepath.Dir(p)], p)
+		}
+		dirs := make([]string, 0, len(groups))
+		for d := range groups {
+			dirs = append(dirs, d)
+		}
+		sort.Strings(dirs)
+		for _, d := range dirs {
+			moves = append(moves, pairMoves(groups[d])...)
+		}
+	case PermuteSimilarSize:
+		bySize := append([]string(nil), paths...)
+		sort.SliceStable(bySize, func(a, b int) bool { return sizes[bySize[a]] < sizes[bySize[b]] })
+		for start := 0; start < len(bySize); {
+			end := start + 1
+			for end < len(bySize) && sizes[bySize[end]] <= maxSizeRatio*sizes[bySize[start]] {
+				end++
+			}
+			moves = append(moves, pairMoves(bySize[start:end])...)
+			start = end
+		}
+	default:
+		return nil, fmt.Errorf("unknown permutation mode %q", mode)
+	}
+	if len(moves) < 2 {
+		return nil, errors.New("no two files can exchange content under " + string(mode))
+	}
+	return moves, nil
+}
+
+// cycleMoves moves group[i]'s content to group[(i+1)%n]; nothing for fewer than two.
+func cycleMoves(group []string) []FileMove {
+	if len(group) < 2 {
+		return nil
+	}
+	moves := make([]FileMove, len(group))
+	for i, src := range group {
+		moves[i] = FileMove{Src: src, Dst: group[(i+1)%len(group)]}
+	}
+	return moves
+}
+
+// pairMoves swaps group[0]<->group[1], group[2]<->group[3] and so on; an odd group's last
+// three form a cycle, so every member still moves.
+func pairMoves(group []string) []FileMove {
+	var moves []FileMove
+	for i := 0; i+1 < len(group); i += 2 {
+		if len(group)-i == 3 {
+			return append(moves, cycleMoves(group[i:])...)
+		}
+		moves = append(moves, FileMove{Src: group[i], Dst: group[i+1]}, FileMove{Src: group[i+1], Dst: group[i]})
+	}
+	return moves
+}
@@ -0,0 +1,126 @@
+package library
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// checkDerangement checks moves send every path in want to another exactly once and give
+// each exactly one source, and that no path outside want takes part.
+func checkDerangement(t *testing.T, moves []FileMove, want []string) {
+	t.Helper()
+	srcs, dsts := make(map[string]int), make(map[string]int)
+	for _, mv := range moves {
+		if mv.Src == mv.Dst {
+			t.Errorf("%s moves onto itself", mv.Src)
+		}
+		srcs[mv.Src]++
+		dsts[mv.Dst]++
+	}
+	for _, p := range want {
+		if srcs[p] != 1 || dsts[p] != 1 {
+			t.Errorf("%s is a source %d times and a destination %d times, want once each", p, srcs[p], dsts[p])
+		}
+	}
+	if got := slices.Sorted(maps.Keys(srcs)); !slices.Equal(got, slices.Sorted(slices.Values(want))) {
+		t.Errorf("moves include %v, want %v", got, want)
+	}
+}
+
+// sameGroup reports whether every move stays within one of groups.
+func sameGroup(moves []FileMove, groups ...[]string) bool {
+	for _, mv := range moves {
+		ok := false
+		for _, g := range groups {
+			ok = ok || slices.Contains(g, mv.Src) && slices.Contains(g, mv.Dst)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPermute(t *testing.T) {
+	sizes := map[string]int64{
+		"/s/empty1": 0, "/s/empty2": 0,
+		"/s/small": 100, "/s/mid": 150, "/s/big": 190,
+		"/s/huge": 1000,
+	}
+	for _, tc := range []struct {
+		name   string
+		mode   PermutationMode
+		paths  []string
+		want   []string   // paths that take part
+		groups [][]string // moves stay within one of these, if set
+	}{
+		{"cycle", PermuteCycle, []string{"/a", "/b", "/c", "/d"}, []string{"/a", "/b", "/c", "/d"}, nil},
+		{"default is cycle", "", []string{"/a", "/b"}, []string{"/a", "/b"}, nil},
+		{"pairs even", PermutePairs, []string{"/a", "/b", "/c", "/d"}, []string{"/a", "/b", "/c", "/d"},
+			[][]string{{"/a", "/b"}, {"/c", "/d"}}},
+		{"pairs odd", PermutePairs, []string{"/a", "/b", "/c", "/d", "/e"}, []string{"/a", "/b", "/c", "/d", "/e"},
+			[][]string{{"/a", "/b"}, {"/c", "/d", "/e"}}},
+		{"pairs of three", PermutePairs, []string{"/a", "/b", "/c"}, []string{"/a", "/b", "/c"}, nil},
+		{
+			"same_dir drops a file alone in its dir", PermuteSameDir,
+			[]string{"/x/1", "/y/only", "/x/2", "/z/1", "/z/2", "/z/3"},
+			[]string{"/x/1", "/x/2", "/z/1", "/z/2", "/z/3"},
+			[][]string{{"/x/1", "/x/2"}, {"/z/1", "/z/2", "/z/3"}},
+		},
+		{
+			"similar_size groups by ratio", PermuteSimilarSize,
+			[]string{"/s/huge", "/s/mid", "/s/empty1", "/s/small", "/s/big", "/s/empty2"},
+			[]string{"/s/empty1", "/s/empty2", "/s/small", "/s/mid", "/s/big"},
+			[][]string{{"/s/empty1", "/s/empty2"}, {"/s/small", "/s/mid", "/s/big"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			moves, err := permute(tc.mode, tc.paths, sizes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDerangement(t, moves, tc.want)
+			if tc.groups != nil && !sameGroup(moves, tc.groups...) {
+				t.Errorf("moves %v cross the groups %v", moves, tc.groups)
+			}
+		})
+	}
+}
+
+func TestPairMovesOddTail(t *testing.T) {
+	got := pairMoves([]string{"/a", "/b", "/c", "/d", "/e"})
+	want := []FileMove{
+		{Src: "/a", Dst: "/b"}, {Src: "/b", Dst: "/a"},
+		{Src: "/c", Dst: "/d"}, {Src: "/d", Dst: "/e"}, {Src: "/e", Dst: "/c"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("pairMoves = %v, want %v", got, want)
+	}
+	if got := pairMoves([]string{"/a"}); len(got) != 0 {
+		t.Errorf("pairMoves of one = %v", got)
+	}
+}
+
+func TestPermuteTooFew(t *testing.T) {
+	sizes := map[string]int64{"/a": 1, "/b": 10, "/c": 100, "/z": 0}
+	for _, tc := range []struct {
+		mode  PermutationMode
+		paths []string
+	}{
+		{PermuteCycle, []string{"/a"}},
+		{PermutePairs, []string{"/a"}},
+		{PermuteSameDir, []string{"/x/a", "/y/b", "/z/c"}},
+		{PermuteSimilarSize, []string{"/a", "/b", "/c"}},
+		{PermuteSimilarSize, []string{"/z", "/a"}}, // empty is only similar to empty
+	} {
+		_, err := permute(tc.mode, tc.paths, sizes)
+		if err == nil || !strings.Contains(err.Error(), "no two files") {
+			t.Errorf("%s over %v: %v, want the fewer-than-two-moves error", tc.mode, tc.paths, err)
+		}
+	}
+	if _, err := permute("shuffle", []string{"/a", "/b"}, sizes); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
@@ -0,0 +1,244 @@
+package library
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PackageInfo is the package that owns a file, as the package database records it.
+type PackageInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Release string `json:"release,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Vendor  string `json:"vendor,omitempty"`
+	Config  bool   `json:"config"` // the file is flagged %config in the package
+}
+
+// NVRA is the package's name-version-release.arch, as rpm -q prints it.
+func (p PackageInfo) NVRA() string {
+	s := p.Name + "-" + p.Version
+	if p.Release != "" {
+		s += "-" + p.Release
+	}
+	if p.Arch != "" {
+		s += "." + p.Arch
+	}
+	return s
+}
+
+// PackageDB answers which package owns a file. Paths no package owns are absent from the
+// result; that is not an error.
+type PackageDB interface {
+	Owners(paths []string) (map[string]PackageInfo, error)
+}
+
+// SystemPackageDB is the testenv's own package database, or nil when it has none we read.
+func SystemPackageDB() PackageDB {
+	if HaveRPM() {
+		return RPMDB{}
+	}
+	return nil
+}
+
+// rpmFileConfig is RPMFILE_CONFIG in an rpm FILEFLAGS value.
+const rpmFileConfig = 1
+
+// rpmOwnerFormat prints one line per package owning a queried file: the owner alone, not
+// the package's file list.
+const rpmOwnerFormat = `%{NAME}\t%{VERSION}\t%{RELEASE}\t%{ARCH}\t%{VENDOR}\n`
+
+// rpmNotOwned ends the line rpm -qf prints for a file no package owns.
+const rpmNotOwned = " is not owned by any package"
+
+// RPMDB queries the system rpm database: one rpm -qf for the owners of all paths, then one
+// rpm -qcf for the config files of the packages that own some.
+type RPMDB struct {
+	Timeout time.Duration // 0 means a minute
+}
+
+// HaveRPM reports whether rpm is installed, so RPMDB can work.
+func HaveRPM() bool {
+	_, err := exec.LookPath("rpm")
+	return err == nil
+}
+
+// Owners implements PackageDB.
+func (db RPMDB) Owners(paths []string) (map[string]PackageInfo, error) {
+	if len(paths) == 0 {
+		return map[string]PackageInfo{}, nil
+	}
+	timeout := db.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	owners, exact, err := rpmOwners(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+	if !exact {
+		// A file two packages own prints two lines, so the answers no longer line up with
+		// the paths; ask again one path at a time.
+		owners = make(map[string]PackageInfo, len(paths))
+		for _, p := range paths {
+			one, _, err := rpmOwners(ctx, []string{p})
+			if err != nil {
+				return nil, err
+			}
+			maps.Copy(owners, one)
+		}
+	}
+	var owned []string
+	for _, p := range paths {
+		if _, ok := owners[p]; ok {
+			owned = append(owned, p)
+		}
+	}
+	if len(owned) == 0 {
+		return owners, nil
+	}
+	out, err := runRPM(ctx, append([]string{"-qcf", "--"}, owned...))
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]bool)
+	for line := range strings.Lines(string(out)) {
+		config[strings.TrimSpace(line)] = true
+	}
+	for _, p := range owned {
+		if config[p] {
+			info := owners[p]
+			info.Config = true
+			owners[p] = info
+		}
+	}
+	return owners, nil
+}
+
+// rpmOwners runs rpm -qf on paths and matches its answers to them in order: each path gets
+// an owner line or a not-owned line. exact is false when the lines do not add up that way;
+// the first owner line of each path is kept either way.
+func rpmOwners(ctx context.Context, paths []string) (owners map[string]PackageInfo, exact bool, err error) {
+	out, err := runRPM(ctx, append([]string{"-qf", "--queryformat", rpmOwnerFormat, "--"}, paths...))
+	if err != nil {
+		return nil, false, err
+	}
+	owners = make(map[string]PackageInfo, len(paths))
+	i := 0
+	exact = true
+	for line := range strings.Lines(string(out)) {
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasSuffix(line, rpmNotOwned):
+			if i >= len(paths) || !strings.Contains(line, paths[i]) {
+				exact = false
+			}
+		case strings.Count(line, "\t") == 4:
+			if i < len(paths) {
+				if _, seen := owners[paths[i]]; !seen {
+					owners[paths[i]] = packageFields(strings.Split(line, "\t"))
+				}
+			}
+		default:
+			continue
+		}
+		i++
+	}
+	return owners, exact && i == len(paths), nil
+}
+
+// runRPM runs rpm with args and returns its standard output. rpm exits non-zero when some
+// path is unowned or missing, which is not an error here.
+func runRPM(ctx context.Context, args []string) ([]byte, error) {
+	// #nosec G204 -- fixed binary; paths are arguments, not shell
+	out, err := exec.CommandContext(ctx, "rpm", args...).Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("rpm %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// FilePackageDB is a stand-in package database read from a file, for testenvs without rpm
+// and for tests. Each line is "<path>\t<fileflags>\t<name>\t<version>\t<release>\t<arch>\t<vendor>";
+// '#' lines are comments. On an rpm machine, rpm -qa --queryformat
+// '[%{FILENAMES}\t%{FILEFLAGS}\t%{NAME}\t%{VERSION}\t%{RELEASE}\t%{ARCH}\t%{VENDOR}\n]' dumps one.
+type FilePackageDB struct {
+	Path string
+}
+
+// Owners implements PackageDB.
+func (db FilePackageDB) Owners(paths []string) (map[string]PackageInfo, error) {
+	// #nosec G304 -- stand-in database named by the operator
+	f, err := os.Open(db.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	all, err := parsePkgdb(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", db.Path, err)
+	}
+	return pickOwners(all, paths), nil
+}
+
+// parsePkgdb reads pkgdb lines into path -> owner.
+func parsePkgdb(r io.Reader) (map[string]PackageInfo, error) {
+	out := make(map[string]PackageInfo)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) < 4 {
+			return nil, fmt.Errorf("line %d: want at least path, flags, name and version", n)
+		}
+		flags, err := strconv.ParseUint(f[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: flags: %w", n, err)
+		}
+		p := packageFields(f[2:])
+		p.Config = flags&rpmFileConfig != 0
+		out[f[0]] = p
+	}
+	return out, sc.Err()
+}
+
+// packageFields reads name, version and the optional release, arch and vendor.
+func packageFields(f []string) PackageInfo {
+	p := PackageInfo{Name: f[0], Version: f[1]}
+	if len(f) > 2 {
+		p.Release = f[2]
+	}
+	if len(f) > 3 {
+		p.Arch = f[3]
+	}
+	if len(f) > 4 && f[4] != "(none)" {
+		p.Vendor = f[4]
+	}
+	return p
+}
+
+func pickOwners(all map[string]PackageInfo, paths []string) map[string]PackageInfo {
+	out := make(map[string]PackageInfo, len(paths))
+	for _, p := range paths {
+		if info, ok := all[p]; ok {
+			out[p] = info
+		}
+	}
+	return out
+}
@@ -0,0 +1,255 @@
+package library
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testPkgdb = "testdata/pkgdb"
+
+const testKernel = "/usr/lib/modules/5.14.0-362.8.1.el9_3.x86_64"
+
+func TestFilePackageDBOwners(t *testing.T) {
+	db := FilePackageDB{Path: testPkgdb}
+	owners, err := db.Owners([]string{"/usr/bin/ls", "/etc/sysconfig/chronyd", "/usr/bin/thirdparty", "/usr/bin/vendorless", "/usr/bin/unowned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 4 {
+		t.Errorf("%d owners, want 4 (the unowned path absent): %v", len(owners), owners)
+	}
+	if ls := owners["/usr/bin/ls"]; ls.NVRA() != "coreutils-8.32-34.el9.x86_64" || ls.Config || ls.Vendor != "Rocky Enterprise Software Foundation" {
+		t.Errorf("/usr/bin/ls: %+v", ls)
+	}
+	if c := owners["/etc/sysconfig/chronyd"]; c.Name != "chrony" || !c.Config {
+		t.Errorf("/etc/sysconfig/chronyd: %+v, want a chrony %%config file", c)
+	}
+	if v := owners["/usr/bin/thirdparty"].Vendor; v != "" {
+		t.Errorf("vendor (none) read as %q", v)
+	}
+	if v := owners["/usr/bin/vendorless"]; v.NVRA() != "local-build-0.1" {
+		t.Errorf("four-field line read as %+v", v)
+	}
+
+	for name, content := range map[string]string{
+		"short line": "/usr/bin/ls\t0\tcoreutils\n",
+		"bad flags":  "/usr/bin/ls\tconfig\tcoreutils\t8.32\n",
+	} {
+		path := filepath.Join(t.TempDir(), "pkgdb")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (FilePackageDB{Path: path}).Owners([]string{"/usr/bin/ls"}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, err := (FilePackageDB{Path: filepath.Join(t.TempDir(), "none")}).Owners(nil); err == nil {
+		t.Error("missing database accepted")
+	}
+}
+
+func TestPackageExcluded(t *testing.T) {
+	kernel := &PackageInfo{Name: "kernel-core", Vendor: "Rocky Enterprise Software Foundation"}
+	config := &PackageInfo{Name: "chrony", Vendor: "Rocky Enterprise Software Foundation", Config: true}
+	thirdParty := &PackageInfo{Name: "acme-tools"}
+	for _, tc := range []struct {
+		name string
+		p    TargetPolicy
+		info *PackageInfo
+		want string // substring of the reason; "" for not excluded
+	}{
+		{"no criteria", TargetPolicy{}, kernel, ""},
+		{"unowned allowed", TargetPolicy{ExcludePackages: []string{"kernel-core"}}, nil, ""},
+		{"unowned needs a package", TargetPolicy{Packages: []string{"*"}}, nil, "not owned"},
+		{"unowned needs a vendor", TargetPolicy{Vendors: []string{"Acme"}}, nil, "not owned"},
+		{"excluded", TargetPolicy{ExcludePackages: []string{"kernel-core"}}, kernel, "kernel-core excluded"},
+		{"excluded by glob", TargetPolicy{ExcludePackages: []string{"kernel*"}}, kernel, "excluded"},
+		{"other package kept", TargetPolicy{ExcludePackages: []string{"kernel-core"}}, config, ""},
+		{"not in packages", TargetPolicy{Packages: []string{"coreutils", "chrony"}}, kernel, "not in coreutils, chrony"},
+		{"in packages", TargetPolicy{Packages: []string{"chrony"}}, config, ""},
+		{"wrong vendor", TargetPolicy{Vendors: []string{"Rocky Enterprise Software Foundation"}}, thirdParty, `vendor ""`},
+		{"right vendor", TargetPolicy{Vendors: []string{"Rocky Enterprise Software Foundation"}}, kernel, ""},
+		{"config", TargetPolicy{ExcludeConfig: true}, config, "config file of package chrony"},
+		{"not config", TargetPolicy{ExcludeConfig: true}, kernel, ""},
+	} {
+		got := tc.p.packageExcluded(tc.info)
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// pkgdbTree is a testenv whose files the testdata pkgdb partly owns.
+func pkgdbTree(t *testing.T) Root {
+	t.Helper()
+	root := Root(t.TempDir())
+	for _, p := range []string{
+		"/usr/bin/ls", "/usr/bin/cat", "/usr/bin/unowned",
+		"/etc/sysconfig/chronyd", "/etc/sysconfig/cpupower",
+		testKernel + "/vmlinuz", testKernel + "/System.map",
+	} {
+		if err := os.MkdirAll(filepath.Dir(root.Path(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(root.Path(p), []byte("contents of "+p+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestSelectPackageCriteria(t *testing.T) {
+	root := pkgdbTree(t)
+	rng := NewRand("pkgdb")
+	for _, tc := range []struct {
+		name     string
+		set      func(p *TargetPolicy)
+		selected []string
+		excluded []string // by RulePackage
+	}{
+		{"exclude kernel-core", func(p *TargetPolicy) { p.ExcludePackages = []string{"kernel-core"} },
+			[]string{"/usr/bin/cat", "/usr/bin/ls", "/usr/bin/unowned", "/etc/sysconfig/chronyd", "/etc/sysconfig/cpupower"},
+			[]string{testKernel + "/System.map", testKernel + "/vmlinuz"}},
+		{"exclude config", func(p *TargetPolicy) { p.ExcludeConfig = true },
+			[]string{"/usr/bin/cat", "/usr/bin/ls", "/usr/bin/unowned", testKernel + "/System.map", testKernel + "/vmlinuz"},
+			[]string{"/etc/sysconfig/chronyd", "/etc/sysconfig/cpupower"}},
+		{"both", func(p *TargetPolicy) { p.ExcludePackages, p.ExcludeConfig = []string{"kernel-core"}, true },
+			[]string{"/usr/bin/cat", "/usr/bin/ls", "/usr/bin/unowned"},
+			[]string{"/etc/sysconfig/chronyd", "/etc/sysconfig/cpupower", testKernel + "/System.map", testKernel + "/vmlinuz"}},
+		{"only coreutils", func(p *TargetPolicy) { p.Packages = []string{"coreutils"} },
+			[]string{"/usr/bin/cat", "/usr/bin/ls"},
+			[]string{"/usr/bin/unowned", "/etc/sysconfig/chronyd", "/etc/sysconfig/cpupower", testKernel + "/System.map", testKernel + "/vmlinuz"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := TargetPolicy{
+				Root: root, Roots: []string{"/usr/bin", "/etc/sysconfig", testKernel},
+				MinCount: 20, MaxCount: 20, PackageDB: testPkgdb,
+			}
+			tc.set(&p)
+			sel, err := p.Select(rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var selected, excluded []string
+			for _, d := range sel.Decisions {
+				logical, _ := root.Logical(d.Resolved)
+				switch {
+				case d.Selected:
+					selected = append(selected, logical)
+				case d.Rule == RulePackage:
+					excluded = append(excluded, logical)
+				}
+				if logical == "/usr/bin/ls" && (d.Package == nil || d.Package.Name != "coreutils") {
+					t.Errorf("/usr/bin/ls owner %+v", d.Package)
+				}
+			}
+			if !slices.Equal(selected, tc.selected) {
+				t.Errorf("selected %v, want %v", selected, tc.selected)
+			}
+			if !slices.Equal(excluded, tc.excluded) {
+				t.Errorf("excluded by package %v, want %v", excluded, tc.excluded)
+			}
+		})
+	}
+}
+
+// TestSelectPackageCriteriaNeedADatabase checks a fixture tree without a stand-in database
+// is an error: the machine's rpm database says nothing about it.
+func TestSelectPackageCriteriaNeedADatabase(t *testing.T) {
+	p := TargetPolicy{Root: pkgdbTree(t), Roots: []string{"/usr/bin"}, MinCount: 1, MaxCount: 1, ExcludeConfig: true}
+	if sel, err := p.Select(NewRand("")); err == nil {
+		t.Errorf("selected %v without a package database", sel.Selected)
+	}
+}
+
+// fakeRPM answers rpm -qf and rpm -qcf for a few paths the way rpm does, and logs each call.
+const fakeRPM = `#!/bin/sh
+printf '%s\n' "$*" >> "$RPM_LOG"
+mode=$1
+while [ "$1" != "--" ]; do shift; done
+shift
+status=0
+for p in "$@"; do
+	case "$mode:$p" in
+	-qf:/usr/bin/ls) printf 'coreutils\t8.32\t34.el9\tx86_64\tRocky\n' ;;
+	-qf:/etc/chrony.conf) printf 'chrony\t4.3\t1.el9\tx86_64\tRocky\n' ;;
+	-qf:/usr/lib/shared) printf 'pkg-a\t1\t1\tnoarch\t(none)\npkg-b\t2\t1\tnoarch\t(none)\n' ;;
+	-qf:/missing) echo "error: file $p: No such file or directory" >&2; status=1 ;;
+	-qf:*) echo "file $p is not owned by any package"; status=1 ;;
+	-qcf:/etc/chrony.conf) printf '/etc/chrony.conf\n/etc/chrony.keys\n' ;;
+	-qcf:*) echo "(contains no files)" ;;
+	esac
+done
+exit $status
+`
+
+func TestRPMDBOwners(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rpm"), []byte(fakeRPM), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	log := filepath.Join(dir, "calls")
+	t.Setenv("RPM_LOG", log)
+
+	for _, tc := range []struct {
+		name   string
+		paths  []string
+		owners map[string]string // path -> NVRA, with "+config" for %config files
+		calls  []string
+	}{
+		{"batched", []string{"/usr/bin/ls", "/usr/bin/unowned", "/etc/chrony.conf"},
+			map[string]string{"/usr/bin/ls": "coreutils-8.32-34.el9.x86_64", "/etc/chrony.conf": "chrony-4.3-1.el9.x86_64+config"},
+			[]string{
+				"-qf --queryformat " + rpmOwnerFormat + " -- /usr/bin/ls /usr/bin/unowned /etc/chrony.conf",
+				"-qcf -- /usr/bin/ls /etc/chrony.conf",
+			}},
+		{"two owners", []string{"/usr/lib/shared", "/usr/bin/ls"},
+			map[string]string{"/usr/lib/shared": "pkg-a-1-1.noarch", "/usr/bin/ls": "coreutils-8.32-34.el9.x86_64"},
+			[]string{
+				"-qf --queryformat " + rpmOwnerFormat + " -- /usr/lib/shared /usr/bin/ls",
+				"-qf --queryformat " + rpmOwnerFormat + " -- /usr/lib/shared",
+				"-qf --queryformat " + rpmOwnerFormat + " -- /usr/bin/ls",
+				"-qcf -- /usr/lib/shared /usr/bin/ls",
+			}},
+		{"missing file", []string{"/missing", "/etc/chrony.conf"},
+			map[string]string{"/etc/chrony.conf": "chrony-4.3-1.el9.x86_64+config"},
+			[]string{
+				"-qf --queryformat " + rpmOwnerFormat + " -- /missing /etc/chrony.conf",
+				"-qf --queryformat " + rpmOwnerFormat + " -- /missing",
+				"-qf --queryformat " + rpmOwnerFormat + " -- /etc/chrony.conf",
+				"-qcf -- /etc/chrony.conf",
+			}},
+		{"none owned", []string{"/usr/bin/unowned"}, map[string]string{},
+			[]string{"-qf --queryformat " + rpmOwnerFormat + " -- /usr/bin/unowned"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Remove(log)
+			owners, err := RPMDB{}.Owners(tc.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for p, info := range owners {
+				got[p] = info.NVRA()
+				if info.Config {
+					got[p] += "+config"
+				}
+			}
+			if !maps.Equal(got, tc.owners) {
+				t.Errorf("owners %v, want %v", got, tc.owners)
+			}
+			data, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); !slices.Equal(calls, tc.calls) {
+				t.Errorf("rpm calls\n%s\nwant\n%s", strings.Join(calls, "\n"), strings.Join(tc.calls, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// maxCapture bounds how much stdout/stderr a single Run keeps.
+const maxCapture = 1 << 20
+
+// keepAliveInterval is how often a warm connection is probed.
+const keepAliveInterval = 30 * time.Second
+
+// Result is the outcome of one remote command.
+type Result struct {
+	Host       string        `json:"host"`
+	Command    string        `json:"command"`
+	ExitStatus int           `json:"exit_status"` // -1 if the command did not report one (signal, dropped link)
+	Signal     string        `json:"signal,omitempty"`
+	Stdout     []byte        `json:"stdout"`
+	Stderr     []byte        `json:"stderr"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Client is a single warm SSH connection to one host.
+type Client struct {
+	cfg  HostConfig
+	conn *ssh.Client
+
+	sftpMu sync.Mutex
+	sftp   *sftp.Client
+
+	done      chan struct{} // closed once the connection is gone, however it went
+	doneOnce  sync.Once
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Dial connects to cfg, verifying the host key against cfg.KnownHostsFile.
+// Unknown or mismatched host keys are rejected; there is no trust-on-first-use.
+func Dial(ctx context.Context, cfg HostConfig) (*Client, error) {
+	hostKeyCB, err := knownhosts.New(cfg.KnownHostsFile...)
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts %v: %w", cfg.KnownHostsFile, err)
+	}
+	auth, err := authMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return dialWith(ctx, cfg, &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCB,
+		Timeout:         cfg.Timeout,
+	})
+}
+
+func dialWith(ctx context.Context, cfg HostConfig, clientCfg *ssh.ClientConfig) (*Client, error) {
+	d := net.Dialer{Timeout: cfg.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", cfg.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", cfg.Addr(), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = nc.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(nc, cfg.Addr(), clientCfg)
+	if err != nil {
+		_ = nc.Close()
+		return nil, fmt.Errorf("ssh handshake with %s: %w", cfg.Addr(), err)
+	}
+	_ = nc.SetDeadline(time.Time{})
+
+	cl := &Client{cfg: cfg, conn: ssh.NewClient(c, chans, reqs), done: make(chan struct{})}
+	go cl.watch()
+	go cl.keepAlive()
+	return cl, nil
+}
+
+// authMethods offers the running ssh-agent (if any) followed by the configured identity files.
+func authMethods(cfg HostConfig) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if ac, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(ac).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, p := range cfg.IdentityFiles {
+		// #nosec G304 -- identity files named by the operator's ssh config
+		pem, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		s, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			log.Printf("remote: skipping identity %s: %v", p, err)
+			continue
+		}
+		signers = append(signers, s)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no usable SSH credentials for %s", cfg.Alias)
+	}
+	return methods, nil
+}
+
+// watch marks the client dead as soon as the connection ends: closed by Close, dropped by
+// the network or by the server.
+func (c *Client) watch() {
+	_ = c.conn.Wait()
+	c.doneOnce.Do(func() { close(c.done) })
+}
+
+// keepAlive pings the server until the connection dies or Close is called.
+func (c *Client) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, _, err := c.conn.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				_ = c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Alive reports whether the connection is still usable.
+func (c *Client) Alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Close tears down the SFTP subsystem and the SSH connection.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.doneOnce.Do(func() { close(c.done) })
+		c.sftpMu.Lock()
+		if c.sftp != nil {
+			_ = c.sftp.Close()
+			c.sftp = nil
+		}
+		c.sftpMu.Unlock()
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+// Run executes cmd on the host, feeding stdin if non-nil, and captures exit status and output.
+// Cancelling ctx signals the remote process and closes the session.
+// A non-zero exit status is reported in Result, not as an error; errors mean the
+// command could not be run or its outcome is unknown.
+func (c *Client) Run(ctx context.Context, cmd string, stdin io.Reader) (Result, error) {
+	res := Result{Host: c.cfg.Alias, Command: cmd, ExitStatus: -1, Started: time.Now()}
+
+	sess, err := c.conn.NewSession()
+	if err != nil {
+		return res, fmt.Errorf("new session: %w", err)
+	}
+	defer func() { _ = sess.Close() }()
+
+	var stdout, stderr cappedBuffer
+	stdout.max, stderr.max = maxCapture, maxCapture
+	sess.Stdout, sess.Stderr = &stdout, &stderr
+	if stdin != nil {
+		sess.Stdin = stdin
+	}
+
+	if err := sess.Start(cmd); err != nil {
+		return res, fmt.Errorf("start %q: %w", cmd, err)
+	}
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- sess.Wait() }()
+
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGTERM)
+		_ = sess.Close()
+		err = <-waitErr
+		if err == nil {
+			err = ctx.Err()
+		} else {
+			err = errors.Join(ctx.Err(), err)
+		}
+	}
+
+	res.Duration = time.Since(res.Started)
+	res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		res.ExitStatus = 0
+	case errors.As(err, &exitErr):
+		res.ExitStatus = exitErr.ExitStatus()
+		res.Signal = exitErr.Signal()
+		if ctx.Err() == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return res, fmt.Errorf("run %q: %w", cmd, err)
+	}
+	return res, nil
+}
+
+// Upload copies localPath to remotePath over SFTP with the given mode.
+// The remote file must not already exist, so a session never overwrites another's artifact.
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string, mode os.FileMode) (err error) {
+	sc, err := c.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	// #nosec G304 -- local artifact produced by the monitor
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", localPath, err)
+	}
+	defer func() { _ = src.Close() }()
+
+	dst, err := sc.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("sftp create %s: %w", remotePath, err)
+	}
+	defer func() {
+		if cerr := dst.Close(); cerr != nil {
+			err = errors.Join(err, fmt.Errorf("sftp close %s: %w", remotePath, cerr))
+		}
+		if err != nil {
+			_ = sc.Remove(remotePath)
+		}
+	}()
+	if err := dst.Chmod(mode); err != nil {
+		return fmt.Errorf("sftp chmod %s: %w", remotePath, err)
+	}
+
+	copied := make(chan error, 1)
+	go func() {
+		_, cerr := io.Copy(dst, src)
+		copied <- cerr
+	}()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return fmt.Errorf("sftp write %s: %w", remotePath, err)
+		}
+		return nil
+	case <-ctx.Done():
+		_ = dst.Close()
+		<-copied
+		return ctx.Err()
+	}
+}
+
+// WriteSecret creates remotePath readable by its owner only and writes data to it. The data
+// goes in on the session's stdin, so it never shows in a command line or unit property.
+// Like Upload, it refuses to replace an existing file.
+func (c *Client) WriteSecret(ctx context.Context, remotePath string, data []byte) (Result, error) {
+	res, err := c.Run(ctx, "umask 077 && set -C && cat > "+ShellQuote(remotePath), bytes.NewReader(data))
+	if err != nil {
+		return res, err
+	}
+	if res.ExitStatus != 0 {
+		return res, fmt.Errorf("write %s exited %d: %s", remotePath, res.ExitStatus, bytes.TrimSpace(res.Stderr))
+	}
+	return res, nil
+}
+
+// Remove deletes remotePath over SFTP; a missing file is not an error.
+func (c *Client) Remove(remotePath string) error {
+	sc, err := c.sftpClient()
+	if err != nil {
+		return err
+	}
+	if err := sc.Remove(remotePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Exists reports whether remotePath is present.
+func (c *Client) Exists(remotePath string) (bool, error) {
+	sc, err := c.sftpClient()
+	if err != nil {
+		return false, err
+	}
+	if _, err := sc.Lstat(remotePath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Client) sftpClient() (*sftp.Client, error) {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+	if c.sftp != nil {
+		return c.sftp, nil
+	}
+	sc, err := sftp.NewClient(c.conn)
+	if err != nil {
+		return nil, fmt.Errorf("start sftp: %w", err)
+	}
+	c.sftp = sc
+	return sc, nil
+}
+
+// cappedBuffer keeps the first max bytes written and silently drops the rest.
+type cappedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func joinHostPort(host, port string) string {
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,334 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an in-process SSH server that runs exec requests with sh and serves SFTP.
+type testServer struct {
+	ln      net.Listener
+	hostKey ssh.Signer
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startTestServer(t *testing.T, clientKey ssh.PublicKey) *testServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{ln: ln, hostKey: hostKey}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.drop()
+	})
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, nc)
+			s.mu.Unlock()
+			go s.serve(nc, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(nc net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			_ = nch.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(ch, chReqs)
+	}
+}
+
+func serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer func() { _ = ch.Close() }()
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var p struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &p); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			cmd := exec.Command("sh", "-c", p.Command)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+			status := uint32(0)
+			var exitErr *exec.ExitError
+			if err := cmd.Run(); errors.As(err, &exitErr) {
+				status = uint32(exitErr.ExitCode())
+			} else if err != nil {
+				status = 255
+			}
+			_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		case "subsystem":
+			_ = req.Reply(true, nil)
+			srv, err := sftp.NewServer(ch)
+			if err != nil {
+				return
+			}
+			_ = srv.Serve()
+			return
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// drop closes every connection the server has accepted, as a dying host or network would.
+func (s *testServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.conns = nil
+}
+
+// testIdentity writes a fresh client key to a temp dir and returns a HostConfig offering it,
+// with the ssh-agent out of the way.
+func testIdentity(t *testing.T) (HostConfig, ssh.PublicKey) {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(identity, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return HostConfig{Alias: "testenv", User: "chaos", IdentityFiles: []string{identity}, Timeout: 5 * time.Second}, signer.PublicKey()
+}
+
+// pointAt points cfg at s, with a known_hosts that vouches for hostKey.
+func pointAt(t *testing.T, cfg HostConfig, s *testServer, hostKey ssh.PublicKey) HostConfig {
+	t.Helper()
+	cfg.HostName, cfg.Port, _ = net.SplitHostPort(s.ln.Addr().String())
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.ln.Addr().String())}, hostKey) + "\n"
+	if err := os.WriteFile(knownHosts, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.KnownHostsFile = []string{knownHosts}
+	return cfg
+}
+
+// newTestClient starts a server and dials it.
+func newTestClient(t *testing.T) (*Client, *testServer, HostConfig) {
+	t.Helper()
+	cfg, clientKey := testIdentity(t)
+	s := startTestServer(t, clientKey)
+	cfg = pointAt(t, cfg, s, s.hostKey.PublicKey())
+	c, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c, s, cfg
+}
+
+func TestClientRun(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	ctx := context.Background()
+
+	res, err := c.Run(ctx, "cat; echo done >&2", bytes.NewReader([]byte("hello\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitStatus != 0 || string(res.Stdout) != "hello\n" || string(res.Stderr) != "done\n" {
+		t.Errorf("got status %d stdout %q stderr %q", res.ExitStatus, res.Stdout, res.Stderr)
+	}
+
+	res, err = c.Run(ctx, "exit 3", nil)
+	if err != nil {
+		t.Fatalf("non-zero exit is not an error: %v", err)
+	}
+	if res.ExitStatus != 3 {
+		t.Errorf("exit status %d, want 3", res.ExitStatus)
+	}
+}
+
+func TestClientUploadExistsRemove(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	local := filepath.Join(dir, "break_tool")
+	if err := os.WriteFile(local, []byte("#!/bin/sh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	remotePath := filepath.Join(dir, "uploaded")
+
+	if err := c.Upload(ctx, local, remotePath, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(remotePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o700 {
+		t.Errorf("uploaded mode %v, want 0700", fi.Mode().Perm())
+	}
+	if err := c.Upload(ctx, local, remotePath, 0o700); err == nil {
+		t.Error("upload over an existing file succeeded")
+	}
+	if ok, err := c.Exists(remotePath); err != nil || !ok {
+		t.Errorf("Exists = %v, %v after upload", ok, err)
+	}
+	if err := c.Remove(remotePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove(remotePath); err != nil {
+		t.Errorf("removing a missing file: %v", err)
+	}
+	if ok, err := c.Exists(remotePath); err != nil || ok {
+		t.Errorf("Exists = %v, %v after remove", ok, err)
+	}
+}
+
+func TestDialRejectsUnknownHostKey(t *testing.T) {
+	cfg, clientKey := testIdentity(t)
+	s := startTestServer(t, clientKey)
+	other, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = pointAt(t, cfg, s, other)
+	if c, err := Dial(context.Background(), cfg); err == nil {
+		_ = c.Close()
+		t.Fatal("dial succeeded against a host key known_hosts does not list")
+	}
+}
+
+func TestDroppedConnectionIsNotAlive(t *testing.T) {
+	c, s, cfg := newTestClient(t)
+	if !c.Alive() {
+		t.Fatal("fresh client not alive")
+	}
+	p := &Pool{clients: map[string]*Client{cfg.Alias: c}, resolve: func(string) (HostConfig, error) { return cfg, nil }}
+	t.Cleanup(p.Close)
+
+	s.drop()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Alive() {
+		if time.Now().After(deadline) {
+			t.Fatal("client still alive 5s after its connection was dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fresh, err := p.Get(context.Background(), cfg.Alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == c || !fresh.Alive() {
+		t.Fatal("pool handed out the dead client")
+	}
+	if _, err := fresh.Run(context.Background(), "true", nil); err != nil {
+		t.Errorf("run on redialled client: %v", err)
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	_ = c.Close()
+	_ = c.Close()
+	if c.Alive() {
+		t.Error("closed client reports alive")
+	}
+}
+
+func TestClientWriteSecret(t *testing.T) {
+	c, _, _ := newTestClient(t)
+	ctx := context.Background()
+	remotePath := filepath.Join(t.TempDir(), "session.key")
+	secret := []byte("c2VjcmV0IGtleQ==\n")
+
+	res, err := c.WriteSecret(ctx, remotePath, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains([]byte(res.Command), bytes.TrimSpace(secret)) {
+		t.Errorf("secret in the command line %q", res.Command)
+	}
+	got, err := os.ReadFile(remotePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("wrote %q, want %q", got, secret)
+	}
+	fi, err := os.Stat(remotePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("secret mode %v, want 0600", fi.Mode().Perm())
+	}
+	if _, err := c.WriteSecret(ctx, remotePath, []byte("other\n")); err == nil {
+		t.Error("write over an existing file succeeded")
+	}
+	if got, _ := os.ReadFile(remotePath); !bytes.Equal(got, secret) {
+		t.Errorf("existing file changed to %q", got)
+	}
+}
@@ -0,0 +1,164 @@
+// Package remote is the monitor's native SSH transport to testenv hosts.
+// It keeps one warm, host-key-verified connection per host, uploads over SFTP and
+// runs commands with their exit status and output captured.
+package remote
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HostConfig describes how to reach one testenv host.
+type HostConfig struct {
+	Alias          string   // name used by callers, e.g. "testenv"
+	HostName       string   // address to dial
+	Port           string   // defaults to "22"
+	User           string   // defaults to $USER
+	IdentityFiles  []string // private keys to offer
+	KnownHostsFile []string // files used to verify the host key
+	Timeout        time.Duration
+}
+
+// Addr returns the dialable host:port.
+func (h HostConfig) Addr() string {
+	port := h.Port
+	if port == "" {
+		port = "22"
+	}
+	return joinHostPort(h.HostName, port)
+}
+
+// LoadHostConfig resolves alias against ~/.ssh/config, the same file the old scp/ssh exec path used.
+// Only the directives the lab needs are honoured: HostName, Port, User, IdentityFile and
+// UserKnownHostsFile. A missing config file is not an error; alias is then dialled directly.
+func LoadHostConfig(alias string) (HostConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return HostConfig{}, err
+	}
+	return loadHostConfigFrom(filepath.Join(home, ".ssh", "config"), home, alias)
+}
+
+func loadHostConfigFrom(cfgPath, home, alias string) (HostConfig, error) {
+	hc := HostConfig{Alias: alias, HostName: alias, Timeout: 15 * time.Second}
+
+	// #nosec G304 -- the operator's own ssh config
+	f, err := os.Open(cfgPath)
+	if err != nil && !os.IsNotExist(err) {
+		return hc, fmt.Errorf("open %s: %w", cfgPath, err)
+	}
+	if err == nil {
+		defer func() { _ = f.Close() }()
+		if err := applySSHConfig(bufio.NewScanner(f), home, &hc); err != nil {
+			return hc, fmt.Errorf("parse %s: %w", cfgPath, err)
+		}
+	}
+
+	if hc.User == "" {
+		hc.User = os.Getenv("USER")
+	}
+	if len(hc.IdentityFiles) == 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			p := filepath.Join(home, ".ssh", name)
+			if _, err := os.Stat(p); err == nil {
+				hc.IdentityFiles = append(hc.IdentityFiles, p)
+			}
+		}
+	}
+	if len(hc.KnownHostsFile) == 0 {
+		hc.KnownHostsFile = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+	return hc, nil
+}
+
+// applySSHConfig applies the first value of each directive from matching Host blocks, as ssh does.
+func applySSHConfig(sc *bufio.Scanner, home string, hc *HostConfig) error {
+	matching := true // directives before the first Host line apply to all hosts
+	set := map[string]bool{}
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := splitDirective(line)
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(key)
+
+		if key == "host" {
+			matching = hostMatches(hc.Alias, strings.Fields(val))
+			continue
+		}
+		if !matching {
+			continue
+		}
+		switch key {
+		case "identityfile":
+			hc.IdentityFiles = append(hc.IdentityFiles, expandHome(val, home))
+			continue
+		case "userknownhostsfile":
+			if !set[key] {
+				for _, p := range strings.Fields(val) {
+					hc.KnownHostsFile = append(hc.KnownHostsFile, expandHome(p, home))
+				}
+			}
+		}
+		if set[key] {
+			continue
+		}
+		set[key] = true
+		switch key {
+		case "hostname":
+			hc.HostName = val
+		case "port":
+			hc.Port = val
+		case "user":
+			hc.User = val
+		}
+	}
+	return sc.Err()
+}
+
+func splitDirective(line string) (key, val string, ok bool) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return "", "", false
+	}
+	key = line[:i]
+	val = strings.TrimSpace(strings.TrimLeft(line[i:], " \t="))
+	val = strings.Trim(val, `"`)
+	return key, val, val != ""
+}
+
+// hostMatches implements ssh_config Host pattern matching, including "!" negation.
+func hostMatches(alias string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		neg := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if ok, _ := path.Match(p, alias); ok {
+			if neg {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+func expandHome(p, home string) string {
+	if p == "~" {
+		return home
+	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		return filepath.Join(home, rest)
+	}
+	return p
+}
@@ -0,0 +1,52 @@
+package remote
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool keeps one warm Client per host alias and redials when a connection has died.
+type Pool struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	resolve func(alias string) (HostConfig, error)
+}
+
+// NewPool returns a Pool that resolves aliases through ~/.ssh/config.
+func NewPool() *Pool {
+	return &Pool{clients: make(map[string]*Client), resolve: LoadHostConfig}
+}
+
+// Get returns the warm client for alias, dialling if there is none or it has died.
+func (p *Pool) Get(ctx context.Context, alias string) (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[alias]; ok {
+		if c.Alive() {
+			return c, nil
+		}
+		_ = c.Close()
+		delete(p.clients, alias)
+	}
+	cfg, err := p.resolve(alias)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[alias] = c
+	return c, nil
+}
+
+// Close closes every pooled connection.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for alias, c := range p.clients {
+		_ = c.Close()
+		delete(p.clients, alias)
+	}
+}
@@ -0,0 +1,197 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnitSpec describes a transient systemd service that runs a break detached from the SSH session.
+type UnitSpec struct {
+	Name        string        // unit name without the .service suffix
+	Description string        // shown by systemctl status
+	Command     []string      // absolute binary path followed by its arguments
+	RuntimeMax  time.Duration // hard runtime limit; the unit is killed and marked failed past it
+	CPUQuota    string        // e.g. "50%"
+	MemoryMax   string        // e.g. "256M"
+	Cleanup     []string      // paths removed by ExecStopPost once the unit stops
+}
+
+// UnitStatus is the subset of `systemctl show` the monitor needs to follow a unit.
+type UnitStatus struct {
+	Name           string `json:"name"`
+	LoadState      string `json:"load_state"`
+	ActiveState    string `json:"active_state"`
+	SubState       string `json:"sub_state"`
+	Result         string `json:"result"`           // success, exit-code, signal, timeout, oom-kill, ...
+	ExecMainCode   int    `json:"exec_main_code"`   // 1 = exited, 2 = killed, 3 = dumped
+	ExecMainStatus int    `json:"exec_main_status"` // exit status, or signal number when killed
+}
+
+// Done reports whether the unit's main process has finished (successfully or not).
+func (s UnitStatus) Done() bool {
+	switch s.ActiveState {
+	case "failed", "inactive":
+		return true
+	case "active":
+		return s.SubState == "exited"
+	}
+	return false
+}
+
+// Succeeded reports whether the unit finished with a zero exit status.
+func (s UnitStatus) Succeeded() bool {
+	return s.Done() && s.Result == "success" && s.ExecMainStatus == 0
+}
+
+func unitFile(name string) string {
+	return name + ".service"
+}
+
+// systemdRunArgv renders spec as a systemd-run invocation (without sudo).
+// RemainAfterExit keeps a successful unit loaded so its result can still be read.
+func systemdRunArgv(spec UnitSpec) []string {
+	argv := []string{
+		"systemd-run",
+		"--unit=" + unitFile(spec.Name),
+		"--quiet",
+		"-p", "RemainAfterExit=yes",
+		"-p", "StandardOutput=journal",
+		"-p", "StandardError=journal",
+		"-p", "KillMode=mixed",
+		"-p", "TimeoutStopSec=15s",
+	}
+	if spec.Description != "" {
+		argv = append(argv, "--description="+spec.Description)
+	}
+	if spec.RuntimeMax > 0 {
+		argv = append(argv, "-p", fmt.Sprintf("RuntimeMaxSec=%d", int(spec.RuntimeMax.Seconds())))
+	}
+	if spec.CPUQuota != "" {
+		argv = append(argv, "-p", "CPUQuota="+spec.CPUQuota)
+	}
+	if spec.MemoryMax != "" {
+		argv = append(argv, "-p", "MemoryMax="+spec.MemoryMax)
+	}
+	if len(spec.Cleanup) > 0 {
+		argv = append(argv, "-p", "ExecStopPost=/bin/rm -f -- "+strings.Join(spec.Cleanup, " "))
+	}
+	argv = append(argv, "--")
+	return append(argv, spec.Command...)
+}
+
+// StartUnit launches spec as a transient service. The process keeps running if the SSH link drops.
+func (c *Client) StartUnit(ctx context.Context, spec UnitSpec) (Result, error) {
+	if spec.Name == "" || len(spec.Command) == 0 {
+		return Result{}, fmt.Errorf("unit spec needs a name and a command")
+	}
+	res, err := c.Run(ctx, ShellJoin(systemdRunArgv(spec)), nil)
+	if err != nil {
+		return res, err
+	}
+	if res.ExitStatus != 0 {
+		return res, fmt.Errorf("systemd-run %s exited %d: %s", spec.Name, res.ExitStatus, bytes.TrimSpace(res.Stderr))
+	}
+	return res, nil
+}
+
+// UnitStatus reads the unit's current state.
+func (c *Client) UnitStatus(ctx context.Context, name string) (UnitStatus, error) {
+	cmd := ShellJoin([]string{"systemctl", "show", unitFile(name), "--no-pager",
+		"-p", "LoadState,ActiveState,SubState,Result,ExecMainCode,ExecMainStatus"})
+	res, err := c.Run(ctx, cmd, nil)
+	if err != nil {
+		return UnitStatus{Name: name}, err
+	}
+	if res.ExitStatus != 0 {
+		return UnitStatus{Name: name}, fmt.Errorf("systemctl show %s exited %d", name, res.ExitStatus)
+	}
+	return parseUnitShow(name, res.Stdout), nil
+}
+
+func parseUnitShow(name string, out []byte) UnitStatus {
+	st := UnitStatus{Name: name}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "LoadState":
+			st.LoadState = v
+		case "ActiveState":
+			st.ActiveState = v
+		case "SubState":
+			st.SubState = v
+		case "Result":
+			st.Result = v
+		case "ExecMainCode":
+			st.ExecMainCode, _ = strconv.Atoi(v)
+		case "ExecMainStatus":
+			st.ExecMainStatus, _ = strconv.Atoi(v)
+		}
+	}
+	return st
+}
+
+// WaitUnit polls the unit every interval until its main process has finished or ctx ends.
+func (c *Client) WaitUnit(ctx context.Context, name string, interval time.Duration) (UnitStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		st, err := c.UnitStatus(ctx, name)
+		if err == nil && st.Done() {
+			return st, nil
+		}
+		select {
+		case <-ctx.Done():
+			return st, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// UnitLogs returns the unit's journal output.
+func (c *Client) UnitLogs(ctx context.Context, name string) ([]byte, error) {
+	res, err := c.Run(ctx, ShellJoin([]string{"journalctl", "-u", unitFile(name), "-o", "short-iso", "--no-pager"}), nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Stdout, nil
+}
+
+// StopUnit stops the unit (running ExecStopPost cleanup) and clears any failed state so it is unloaded.
+func (c *Client) StopUnit(ctx context.Context, name string) error {
+	cmd := ShellJoin([]string{"systemctl", "stop", unitFile(name)}) + "; " +
+		ShellJoin([]string{"systemctl", "reset-failed", unitFile(name)}) + " 2>/dev/null; true"
+	res, err := c.Run(ctx, cmd, nil)
+	if err != nil {
+		return err
+	}
+	if res.ExitStatus != 0 {
+		return fmt.Errorf("stop %s exited %d", name, res.ExitStatus)
+	}
+	return nil
+}
+
+// ShellJoin quotes argv for a POSIX shell.
+func ShellJoin(argv []string) string {
+	q := make([]string, len(argv))
+	for i, a := range argv {
+		q[i] = ShellQuote(a)
+	}
+	return strings.Join(q, " ")
+}
+
+// ShellQuote single-quotes s for a POSIX shell, leaving plainly safe words bare.
+func ShellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,%@+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
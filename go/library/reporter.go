@@ -0,0 +1,27 @@
+// Package library provides the reporting abstraction breaks use to talk to the monitor.
+package library
+
+// Reporter delivers status messages for one session to the monitor.
+// Standalone break binaries use TCPReporter; the resident agent streams over its channel.
+type Reporter interface {
+	Report(status, message string)
+}
+
+// TCPReporter sends every message over a fresh encrypted TCP connection (see SendMessage).
+type TCPReporter struct {
+	IP            string
+	Port          int
+	Token         string
+	EncryptionKey string
+}
+
+// Report implements Reporter.
+func (r TCPReporter) Report(status, message string) {
+	SendMessage(r.IP, r.Port, status, message, r.Token, r.EncryptionKey)
+}
+
+// ReporterFunc adapts a function to Reporter.
+type ReporterFunc func(status, message string)
+
+// Report implements Reporter.
+func (f ReporterFunc) Report(status, message string) { f(status, message) }
@@ -0,0 +1,115 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const rewriteKind = "rewrite"
+
+// Rewrite replaces a regular file's content in place, keeping its inode, mode, owner and
+// mtime so the edit does not stand out in a directory listing. It is the Mutation behind
+// semantic edits such as boot loader sabotage. Revert restores the file from the session's vault.
+type Rewrite struct {
+	Path string
+	Data []byte
+
+	sha256 string // content at Plan time
+}
+
+var _ Mutation = (*Rewrite)(nil)
+
+type rewriteSpec struct {
+	Path   string `json:"path"`
+	Data   []byte `json:"data"`
+	SHA256 string `json:"sha256"`
+}
+
+// Plan records the file's current hash and the new content.
+func (m *Rewrite) Plan(*Session) (MutationPlan, error) {
+	fi, err := os.Lstat(m.Path)
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return MutationPlan{}, fmt.Errorf("rewrite: %q is not a regular file", m.Path)
+	}
+	if m.sha256, err = fileSHA256(m.Path); err != nil {
+		return MutationPlan{}, err
+	}
+	spec, err := json.Marshal(rewriteSpec{Path: m.Path, Data: m.Data, SHA256: m.sha256})
+	if err != nil {
+		return MutationPlan{}, err
+	}
+	return MutationPlan{Kind: rewriteKind, Paths: []string{m.Path}, Spec: spec}, nil
+}
+
+func decodeRewrite(raw json.RawMessage) (Mutation, error) {
+	var spec rewriteSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, err
+	}
+	return &Rewrite{Path: spec.Path, Data: spec.Data, sha256: spec.SHA256}, nil
+}
+
+// Apply overwrites the file, then trims it: a same-size rewrite keeps the file's blocks where they are.
+func (m *Rewrite) Apply(*Session) error {
+	if m.sha256 == "" {
+		return errors.New("rewrite: Apply before Plan")
+	}
+	path := m.Path
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// #nosec G304 -- path is a mutation target chosen by the break
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	if _, err := f.Write(m.Data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Truncate(int64(len(m.Data))); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("truncate %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("fsync %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", path, err)
+	}
+	if err := os.Chtimes(path, time.Time{}, fi.ModTime()); err != nil {
+		return fmt.Errorf("chtimes %s: %w", path, err)
+	}
+	return nil
+}
+
+// Verify checks that the file holds exactly the new content.
+func (m *Rewrite) Verify() error {
+	sum, err := fileSHA256(m.Path)
+	if err != nil {
+		return err
+	}
+	want := sha256.Sum256(m.Data)
+	if sum != hex.EncodeToString(want[:]) {
+		return fmt.Errorf("%q does not hold the rewritten content", m.Path)
+	}
+	return nil
+}
+
+// Revert restores the file from the session's vault, unless its content never changed.
+func (m *Rewrite) Revert(s *Session) error {
+	if sum, err := fileSHA256(m.Path); err == nil && sum == m.sha256 {
+		return nil
+	}
+	return s.revert(m.Path)
+}
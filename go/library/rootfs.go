@@ -0,0 +1,114 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Root is the directory the library treats as the testenv's "/". Paths the library works
+// with are always real paths on this machine; a Root maps the testenv paths breaks think in
+// (/usr/bin, /boot, /etc/ld.so.conf) to them, and the built-in protections are checked on
+// the testenv path. "" and "/" are the machine itself; any other directory is a fixture
+// tree, so selection, breaks and mutations can run without touching the real system.
+type Root string
+
+// HostRoot is the machine's own filesystem.
+const HostRoot Root = "/"
+
+// ErrOutsideRoot is returned by Perform for a plan that touches a path outside the session's root.
+var ErrOutsideRoot = errors.New("path outside the filesystem root")
+
+// IsHost reports whether r is the machine's own filesystem.
+func (r Root) IsHost() bool {
+	return r == "" || r == HostRoot
+}
+
+// Path is where the testenv path p lives under r.
+func (r Root) Path(p string) string {
+	if r.IsHost() {
+		return p
+	}
+	return filepath.Join(string(r), filepath.Clean("/"+p))
+}
+
+// Logical is the testenv path for the real path p, and whether p is inside r at all.
+func (r Root) Logical(p string) (string, bool) {
+	if r.IsHost() {
+		return filepath.Clean(p), filepath.IsAbs(p)
+	}
+	rel, err := filepath.Rel(filepath.Clean(string(r)), filepath.Clean(p))
+	if err != nil || !filepath.IsAbs(p) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return filepath.Join("/", rel), true
+}
+
+// Contains reports whether the real path p is inside r once the machine resolves the
+// symlinks in its directories: a fixture's /bin -> /usr/bin leads to the machine's /usr/bin.
+func (r Root) Contains(p string) bool {
+	if r.IsHost() {
+		return filepath.IsAbs(p)
+	}
+	if real, err := filepath.EvalSymlinks(filepath.Dir(p)); err == nil {
+		p = filepath.Join(real, filepath.Base(p))
+	}
+	if real, err := filepath.EvalSymlinks(string(r)); err == nil {
+		r = Root(real)
+	}
+	_, ok := r.Logical(p)
+	return ok
+}
+
+// EvalSymlinks is filepath.EvalSymlinks as seen from inside r: absolute link targets and
+// ".." are taken against r, as under chroot, so a fixture's links never lead out of it.
+func (r Root) EvalSymlinks(p string) (string, error) {
+	if r.IsHost() {
+		return filepath.EvalSymlinks(p)
+	}
+	logical, ok := r.Logical(p)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoot, p)
+	}
+	resolved := "/"
+	todo := strings.Split(logical, "/")
+	for links := 0; len(todo) > 0; {
+		c := todo[0]
+		todo = todo[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, c)
+		fi, err := os.Lstat(r.Path(next))
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many links resolving %s", p)
+		}
+		target, err := os.Readlink(r.Path(next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		todo = append(strings.Split(target, "/"), todo...)
+	}
+	return r.Path(resolved), nil
+}
+
+// Glob is filepath.Glob on a testenv pattern; matches are real paths under r.
+func (r Root) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(r.Path(pattern))
+}
@@ -0,0 +1,163 @@
+package library_test
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/fixture"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// escapingFixture builds an RHEL fixture with links that would lead out of it if followed
+// on the machine: an absolute link to a file outside, an absolute link to a directory
+// outside, and a relative link with more ".." than the tree is deep. It returns the root and
+// the outside directory, which holds the file victim.
+func escapingFixture(t *testing.T) (library.Root, string) {
+	t.Helper()
+	root, err := fixture.Build(t.TempDir(), library.FamilyRHEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	for _, name := range []string{"victim", "bystander"} {
+		if err := os.WriteFile(filepath.Join(outside, name), []byte("outside the fixture\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"/usr/bin/abs":    "/etc/hostname",
+		"/usr/bin/up":     "../../../../../../etc/hostname",
+		"/usr/bin/escape": filepath.Join(outside, "victim"),
+		"/opt/outdir":     outside,
+	}
+	for p, target := range links {
+		if err := os.MkdirAll(filepath.Dir(root.Path(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, root.Path(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func TestRootEvalSymlinks(t *testing.T) {
+	root, outside := escapingFixture(t)
+	for _, tc := range []struct {
+		path    string // real path
+		want    string // testenv path it resolves to; "" for an error
+		outside bool   // the error is ErrOutsideRoot
+	}{
+		{root.Path("/usr/bin/cat"), "/usr/bin/cat", false},
+		{root.Path("/bin/sh"), "/usr/bin/bash", false},
+		{root.Path("/usr/bin/ld.so"), "/usr/lib64/ld-linux-x86-64.so.2", false},
+		{root.Path("/usr/bin/abs"), "/etc/hostname", false},
+		{root.Path("/usr/bin/up"), "/etc/hostname", false},
+		{root.Path("/bin/../etc/hostname"), "/etc/hostname", false},
+		{root.Path("/usr/bin/escape"), "", false},
+		{root.Path("/opt/outdir/victim"), "", false},
+		{string(root) + "/usr/bin/../../../" + filepath.Base(outside) + "/victim", "", true},
+		{filepath.Join(outside, "victim"), "", true},
+	} {
+		got, err := root.EvalSymlinks(tc.path)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s resolved to %s, want an error", tc.path, got)
+		case tc.want == "":
+			if tc.outside != errors.Is(err, library.ErrOutsideRoot) {
+				t.Errorf("%s: %v", tc.path, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tc.path, err)
+		case got != root.Path(tc.want):
+			t.Errorf("%s resolved to %s, want %s", tc.path, got, root.Path(tc.want))
+		}
+	}
+}
+
+// outsideState is the content of every file in dir, to show a test left it alone.
+func outsideState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	out := make(map[string]string)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[e.Name()] = string(data)
+	}
+	return out
+}
+
+// TestMutationsStayUnderRoot runs selection, corruption and a jumble against a fixture
+// whose links point out of it, and checks only files in the fixture change.
+func TestMutationsStayUnderRoot(t *testing.T) {
+	root, outside := escapingFixture(t)
+	before := outsideState(t, outside)
+	s := library.NewSession(root, nil, nil, "stay-under-root")
+
+	picked, err := library.PickRandomBinaries(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(picked) < 2 {
+		t.Fatalf("picked %v", picked)
+	}
+	for _, p := range picked {
+		if !root.Contains(p) {
+			t.Errorf("picked %s, outside the fixture", p)
+		}
+	}
+
+	policy := library.DefaultTargetPolicy()
+	policy.Root, policy.Roots = root, []string{"/opt/outdir"}
+	if sel, err := policy.Select(s.Rand); err == nil || len(sel.Warnings) == 0 {
+		t.Errorf("selected %v from a root linked out of the fixture (warnings %q)", sel.Selected, sel.Warnings)
+	}
+
+	victim := filepath.Join(outside, "victim")
+	for _, p := range []string{victim, root.Path("/opt/outdir/victim")} {
+		if err := library.CorruptFile(s, p, 50); !errors.Is(err, library.ErrOutsideRoot) {
+			t.Errorf("corrupt %s: %v, want ErrOutsideRoot", p, err)
+		}
+	}
+	if err := library.CorruptFile(s, root.Path("/usr/bin/escape"), 50); err == nil {
+		t.Error("corrupted through a symlink")
+	}
+	jumbles := [][]string{
+		{victim, filepath.Join(outside, "bystander")},
+		{picked[0], root.Path("/opt/outdir/victim")},
+	}
+	for _, paths := range jumbles {
+		if err := library.CyclicJumble(s, paths); !errors.Is(err, library.ErrOutsideRoot) {
+			t.Errorf("jumble %v: %v, want ErrOutsideRoot", paths, err)
+		}
+	}
+
+	target, err := os.ReadFile(picked[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := library.CorruptFile(s, picked[0], 50); err != nil {
+		t.Fatalf("corrupt %s: %v", picked[0], err)
+	}
+	if got, _ := os.ReadFile(picked[0]); string(got) == string(target) {
+		t.Errorf("%s unchanged by corruption", picked[0])
+	}
+	if err := library.CyclicJumble(s, slices.Concat(picked, []string{root.Path("/usr/bin/escape")})); err != nil {
+		t.Fatalf("jumble %v: %v", picked, err)
+	}
+
+	after := outsideState(t, outside)
+	for name, data := range before {
+		if after[name] != data {
+			t.Errorf("%s outside the fixture changed", name)
+		}
+	}
+}
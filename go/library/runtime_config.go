@@ -0,0 +1,156 @@
+// Package library provides run-time configuration delivery for break binaries.
+package library
+
+import (
+	"bufio"
+	datatypes "chaos-agent/library/types"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// maxSealedConfig caps how much we are willing to read for a sealed config blob.
+const maxSealedConfig = 1 << 20
+
+// SealBreakConfig encrypts cfg under a fresh random 32-byte key using NaCl secretbox.
+// It returns the key and the sealed blob, both base64-encoded.
+// The key travels separately from the blob (key file or stdin vs. sealed file) so neither alone reveals the config.
+func SealBreakConfig(cfg datatypes.BreakConfig) (keyB64, blobB64 string, err error) {
+	pt, err := json.Marshal(cfg)
+	if err != nil {
+		return "", "", fmt.Errorf("marshal config: %w", err)
+	}
+
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", "", fmt.Errorf("generate config key: %w", err)
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", "", fmt.Errorf("generate nonce: %w", err)
+	}
+
+	sealed := secretbox.Seal(nonce[:], pt, &nonce, &key)
+	return base64.StdEncoding.EncodeToString(key[:]), base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenBreakConfig reverses SealBreakConfig.
+func OpenBreakConfig(keyB64, blobB64 string) (datatypes.BreakConfig, error) {
+	var cfg datatypes.BreakConfig
+
+	rawKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(keyB64))
+	if err != nil {
+		return cfg, fmt.Errorf("base64 decode config key: %w", err)
+	}
+	if len(rawKey) != 32 {
+		return cfg, fmt.Errorf("config key must be 32 bytes, got %d", len(rawKey))
+	}
+	var key [32]byte
+	copy(key[:], rawKey)
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(blobB64))
+	if err != nil {
+		return cfg, fmt.Errorf("base64 decode config blob: %w", err)
+	}
+	if len(sealed) < 24+secretbox.Overhead {
+		return cfg, errors.New("config blob too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+
+	pt, ok := secretbox.Open(nil, sealed[24:], &nonce, &key)
+	if !ok {
+		return cfg, errors.New("config blob failed authentication")
+	}
+	if err := json.Unmarshal(pt, &cfg); err != nil {
+		return cfg, fmt.Errorf("unmarshal config: %w", err)
+	}
+	return cfg, nil
+}
+
+// ConfigEnvelope renders the stdin payload for a break: the key line, optionally followed by the blob line.
+// Pass an empty blob when the blob is shipped as a sealed file instead.
+func ConfigEnvelope(keyB64, blobB64 string) []byte {
+	if blobB64 == "" {
+		return []byte(keyB64 + "\n")
+	}
+	return []byte(keyB64 + "\n" + blobB64 + "\n")
+}
+
+// LoadBreakConfig reads the config key from a key file named by "--key <path>", or else from
+// stdin, and the sealed blob from either the next stdin line or a sealed file named by
+// "--config <path>" / "--config=<path>" in args. Key and sealed files are removed once
+// read; they are single-use. "--root <dir>" runs the break in sandbox mode against the
+// fixture tree at dir, whatever the config says.
+func LoadBreakConfig(stdin io.Reader, args []string) (datatypes.BreakConfig, error) {
+	sealedPath := argValue(args, "--config")
+
+	r := bufio.NewReader(io.LimitReader(stdin, maxSealedConfig))
+	var keyLine string
+	if keyPath := argValue(args, "--key"); keyPath != "" {
+		// #nosec G304 -- path is handed to us by the monitor that launched this binary.
+		b, err := os.ReadFile(keyPath)
+		if err != nil {
+			return datatypes.BreakConfig{}, fmt.Errorf("read config key %q: %w", keyPath, err)
+		}
+		_ = os.Remove(keyPath)
+		keyLine = string(b)
+	} else {
+		var err error
+		keyLine, err = r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return datatypes.BreakConfig{}, fmt.Errorf("read config key: %w", err)
+		}
+	}
+	if strings.TrimSpace(keyLine) == "" {
+		return datatypes.BreakConfig{}, errors.New("no config key on stdin or in the key file")
+	}
+
+	var blob string
+	if sealedPath != "" {
+		// #nosec G304 -- path is handed to us by the monitor that launched this binary.
+		b, err := os.ReadFile(sealedPath)
+		if err != nil {
+			return datatypes.BreakConfig{}, fmt.Errorf("read sealed config %q: %w", sealedPath, err)
+		}
+		_ = os.Remove(sealedPath)
+		blob = string(b)
+	} else {
+		var err error
+		blob, err = r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return datatypes.BreakConfig{}, fmt.Errorf("read config blob: %w", err)
+		}
+	}
+	cfg, err := OpenBreakConfig(keyLine, blob)
+	if err != nil {
+		return cfg, err
+	}
+	if root := argValue(args, "--root"); root != "" {
+		if cfg.Root, err = filepath.Abs(root); err != nil {
+			return cfg, fmt.Errorf("sandbox root: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// argValue returns the value of "<name> <value>" or "<name>=<value>" in args, or "".
+func argValue(args []string, name string) string {
+	for i, a := range args {
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			return v
+		}
+		if a == name && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package library
+
+import (
+	datatypes "chaos-agent/library/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadBreakConfigFromFiles(t *testing.T) {
+	want := datatypes.BreakConfig{MonitorIP: "192.0.2.1", MonitorPort: 4444, Token: "tok", Seed: "seed"}
+	keyB64, blobB64, err := SealBreakConfig(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyPath, sealedPath := filepath.Join(dir, "cfg.key"), filepath.Join(dir, "cfg.sealed")
+	write := func() {
+		if err := os.WriteFile(keyPath, ConfigEnvelope(keyB64, ""), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(sealedPath, []byte(blobB64), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	cfg, err := LoadBreakConfig(strings.NewReader(""), []string{"--config", sealedPath, "--key=" + keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MonitorIP != want.MonitorIP || cfg.MonitorPort != want.MonitorPort || cfg.Token != want.Token || cfg.Seed != want.Seed {
+		t.Errorf("loaded %+v, want %+v", cfg, want)
+	}
+	for _, p := range []string{keyPath, sealedPath} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed once read: %v", p, err)
+		}
+	}
+
+	// The key on stdin still works, and a missing key file is an error, not a wait on stdin.
+	write()
+	if _, err := LoadBreakConfig(strings.NewReader(keyB64+"\n"), []string{"--config", sealedPath}); err != nil {
+		t.Errorf("key on stdin: %v", err)
+	}
+	if _, err := LoadBreakConfig(strings.NewReader(keyB64+"\n"), []string{"--key", filepath.Join(dir, "missing")}); err == nil {
+		t.Error("loaded a config without its key file")
+	}
+}
@@ -0,0 +1,174 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"os"
+)
+
+// sampleBlockSize is how much of a file streaming sampling holds in memory at once.
+const sampleBlockSize = 64 * 1024
+
+// maxExactRanges bounds how many changed ranges a streaming mutation reports byte-exactly.
+// Past it, each further block's changes are reported as one span from its first to its last
+// changed byte, so the report stays small even when k is in the hundreds of millions.
+const maxExactRanges = 1 << 16
+
+// newSampleRNG returns a ChaCha8 stream keyed from the session's: reproducible from the
+// session seed, and fast enough to draw a position for every corrupted byte of a large file
+// without taking the session stream's lock each time.
+func newSampleRNG(session *Rand) (*rand.Rand, error) {
+	var seed [32]byte
+	if _, err := session.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	return rand.New(rand.NewChaCha8(seed)), nil
+}
+
+// streamSampled picks exactly k distinct byte positions in [0, size), uniformly among all
+// k-subsets, and lets mutate change them block by block. Each block's share of k is drawn
+// from the hypergeometric distribution of the remaining positions (the last block takes
+// what is left, so the total is exactly k); positions within a block are chosen with Floyd's
+// algorithm. mutate gets the span of the block from its first to its last selected byte and
+// the selected offsets within that span, ascending; the span is then written back.
+// Memory is O(sampleBlockSize) whatever size and k.
+func streamSampled(f *os.File, size, k int64, session *Rand, mutate func(span []byte, sel []int) error) ([]ByteRange, error) {
+	if k < 0 || k > size {
+		return nil, fmt.Errorf("k(%d) outside [0,%d]", k, size)
+	}
+	rng, err := newSampleRNG(session)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, sampleBlockSize)
+	set := make([]uint64, sampleBlockSize/64)
+	sel := make([]int, 0, sampleBlockSize)
+
+	var changed []ByteRange
+	remN, remK := size, k
+	for off := int64(0); off < size && remK > 0; {
+		n := min(int64(sampleBlockSize), size-off)
+		x := hypergeometric(rng, remN, remK, n)
+		remN -= n
+		remK -= x
+		if x > 0 {
+			sel = pickInBlock(rng, int(n), int(x), set, sel[:0])
+			lo, hi := sel[0], sel[len(sel)-1]+1
+			span := buf[:hi-lo]
+			if _, err := f.ReadAt(span, off+int64(lo)); err != nil {
+				return changed, fmt.Errorf("readAt offset=%d len=%d: %w", off+int64(lo), len(span), err)
+			}
+			for i := range sel {
+				sel[i] -= lo
+			}
+			if err := mutate(span, sel); err != nil {
+				return changed, err
+			}
+			if _, err := f.WriteAt(span, off+int64(lo)); err != nil {
+				return changed, fmt.Errorf("writeAt offset=%d len=%d: %w", off+int64(lo), len(span), err)
+			}
+			changed = appendSelected(changed, off+int64(lo), sel)
+		}
+		off += n
+	}
+	return changed, nil
+}
+
+// appendSelected records the runs of selected offsets (relative to base), or while over
+// maxExactRanges, the whole span.
+func appendSelected(rs []ByteRange, base int64, sel []int) []ByteRange {
+	if len(rs) >= maxExactRanges {
+		return appendRange(rs, ByteRange{Offset: base + int64(sel[0]), Length: int64(sel[len(sel)-1] - sel[0] + 1)})
+	}
+	start := 0
+	for i := 1; i <= len(sel); i++ {
+		if i < len(sel) && sel[i] == sel[i-1]+1 {
+			continue
+		}
+		rs = appendRange(rs, ByteRange{Offset: base + int64(sel[start]), Length: int64(i - start)})
+		start = i
+	}
+	return rs
+}
+
+// pickInBlock returns x distinct offsets in [0, n), ascending, chosen uniformly. set is
+// scratch space of at least n bits. When x is more than half of n the complement is
+// sampled instead, so the work is O(min(x, n-x)) draws plus one pass over the bitset.
+func pickInBlock(rng *rand.Rand, n, x int, set []uint64, out []int) []int {
+	words := (n + 63) / 64
+	clear(set[:words])
+	want, invert := x, false
+	if 2*x > n {
+		want, invert = n-x, true
+	}
+	for j := n - want; j < n; j++ {
+		t := rng.IntN(j + 1)
+		if set[t/64]&(1<<(t%64)) != 0 {
+			t = j
+		}
+		set[t/64] |= 1 << (t % 64)
+	}
+	for w := 0; w < words; w++ {
+		word := set[w]
+		if invert {
+			word = ^word
+			if rem := n - w*64; rem < 64 {
+				word &= 1<<rem - 1
+			}
+		}
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			out = append(out, w*64+b)
+			word &= word - 1
+		}
+	}
+	return out
+}
+
+// hypergeometric draws how many of n positions taken from a population of total, of which
+// k are selected, are selected: inversion by searching outward from the mode, O(stddev).
+func hypergeometric(rng *rand.Rand, total, k, n int64) int64 {
+	lo, hi := max(0, n-(total-k)), min(n, k)
+	if lo == hi {
+		return lo
+	}
+	mode := min(max((n+1)*(k+1)/(total+2), lo), hi)
+	pMode := math.Exp(lchoose(k, mode) + lchoose(total-k, n-mode) - lchoose(total, n))
+
+	u := rng.Float64()
+	acc := pMode
+	if u < acc {
+		return mode
+	}
+	up, pUp := mode, pMode
+	down, pDown := mode, pMode
+	for up < hi || down > lo {
+		if up < hi {
+			// P(x+1)/P(x) = (k-x)(n-x) / ((x+1)(total-k-n+x+1))
+			pUp *= float64(k-up) * float64(n-up) / (float64(up+1) * float64(total-k-n+up+1))
+			up++
+			if acc += pUp; u < acc {
+				return up
+			}
+		}
+		if down > lo {
+			// P(x-1)/P(x) = x(total-k-n+x) / ((k-x+1)(n-x+1))
+			pDown *= float64(down) * float64(total-k-n+down) / (float64(k-down+1) * float64(n-down+1))
+			down--
+			if acc += pDown; u < acc {
+				return down
+			}
+		}
+	}
+	return mode // u landed in the rounding slack above the summed probabilities
+}
+
+// lchoose is log(n choose r).
+func lchoose(n, r int64) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(r + 1))
+	c, _ := math.Lgamma(float64(n - r + 1))
+	return a - b - c
+}
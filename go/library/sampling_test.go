@@ -0,0 +1,207 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testRNG() *rand.Rand {
+	return rand.New(rand.NewChaCha8([32]byte{'s', 'a', 'm', 'p', 'l', 'e'}))
+}
+
+// sampledFile runs streamSampled over a zero file of size bytes, flipping each selected byte,
+// drawing from rng, and returns the reported ranges and the number of bytes that were flipped.
+func sampledFile(t testing.TB, size, k int64, rng *Rand) ([]ByteRange, int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sampled")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := streamSampled(f, size, k, rng, func(span []byte, sel []int) error {
+		for _, i := range sel {
+			span[i] ^= 0xff
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flipped int64
+	for _, b := range data {
+		if b == 0xff {
+			flipped++
+		}
+	}
+	return changed, flipped
+}
+
+func TestStreamSampledPicksExactlyK(t *testing.T) {
+	rng := NewRand("exact-k")
+	for _, tc := range []struct{ size, k int64 }{
+		{1, 1},
+		{100, 0},
+		{100, 37},
+		{100, 100},
+		{sampleBlockSize, 1},
+		{3*sampleBlockSize + 123, 1},
+		{3*sampleBlockSize + 123, 5000},
+		{3*sampleBlockSize + 123, 3*sampleBlockSize + 100},
+		{3*sampleBlockSize + 123, 3*sampleBlockSize + 123},
+	} {
+		changed, flipped := sampledFile(t, tc.size, tc.k, rng)
+		var reported int64
+		for _, r := range changed {
+			reported += r.Length
+		}
+		if flipped != tc.k || reported != tc.k {
+			t.Errorf("size %d k %d: flipped %d, reported %d", tc.size, tc.k, flipped, reported)
+		}
+	}
+	if _, err := streamSampled(nil, 10, 11, rng, nil); err == nil {
+		t.Error("k larger than the file accepted")
+	}
+}
+
+// chiSquare is Pearson's statistic for observed counts against expected ones.
+func chiSquare(observed []int, expected []float64) float64 {
+	var x2 float64
+	for i, o := range observed {
+		d := float64(o) - expected[i]
+		x2 += d * d / expected[i]
+	}
+	return x2
+}
+
+// TestPickInBlockIsUniform checks every offset is equally likely, on both the direct path
+// and the complement one (x more than half of n).
+func TestPickInBlockIsUniform(t *testing.T) {
+	const n, trials = 100, 20000
+	rng := testRNG()
+	set := make([]uint64, 2)
+	for _, x := range []int{10, 90} {
+		hits := make([]int, n)
+		for range trials {
+			sel := pickInBlock(rng, n, x, set, nil)
+			if len(sel) != x {
+				t.Fatalf("x=%d: picked %d", x, len(sel))
+			}
+			for i, s := range sel {
+				if i > 0 && s <= sel[i-1] {
+					t.Fatalf("x=%d: offsets not ascending and distinct: %v", x, sel)
+				}
+				hits[s]++
+			}
+		}
+		expected := make([]float64, n)
+		for i := range expected {
+			expected[i] = float64(trials*x) / n
+		}
+		// 99 degrees of freedom: mean 99, sd 14. The stream is seeded, so this never flakes.
+		if x2 := chiSquare(hits, expected); x2 > 170 {
+			t.Errorf("x=%d: chi-square %.1f over %d offsets; picks are not uniform", x, x2, n)
+		}
+	}
+}
+
+// TestHypergeometricMatchesDistribution compares draws with the exact probabilities, and
+// the mean with n*k/total where the support is too wide to bin.
+func TestHypergeometricMatchesDistribution(t *testing.T) {
+	rng := testRNG()
+	const total, k, n, trials = 50, 20, 10, 50000
+	counts := make([]int, n+1)
+	for range trials {
+		counts[hypergeometric(rng, total, k, n)]++
+	}
+	// Bins expecting fewer than 5 draws are merged into their neighbours.
+	var obs []int
+	var exp []float64
+	var o, e float64
+	for x := int64(0); x <= n; x++ {
+		p := math.Exp(lchoose(k, x) + lchoose(total-k, n-x) - lchoose(total, n))
+		o += float64(counts[x])
+		e += p * trials
+		if e >= 5 || x == n {
+			obs, exp = append(obs, int(o)), append(exp, e)
+			o, e = 0, 0
+		}
+	}
+	if x2 := chiSquare(obs, exp); x2 > 3*float64(len(obs))+10 {
+		t.Errorf("chi-square %.1f over %d bins; draws %v", x2, len(obs), counts)
+	}
+
+	// A 64 KiB block of a 1 GB file with a million bytes to corrupt.
+	const bigTotal, bigK, bigN, bigTrials = 1 << 30, 1 << 20, sampleBlockSize, 20000
+	var sum float64
+	for range bigTrials {
+		sum += float64(hypergeometric(rng, bigTotal, bigK, bigN))
+	}
+	mean := float64(bigN) * bigK / bigTotal
+	if got := sum / bigTrials; math.Abs(got-mean) > 0.5 {
+		t.Errorf("mean share %.2f, want %.2f", got, mean)
+	}
+}
+
+// TestStreamSampledSpreadsAcrossBlocks checks no block is favoured: each block's mean share
+// of k is k * blockSize / size, whichever block it is.
+func TestStreamSampledSpreadsAcrossBlocks(t *testing.T) {
+	rng := NewRand("blocks")
+	const blocks, k, trials = 4, 1000, 300
+	size := int64(blocks * sampleBlockSize)
+	perBlock := make([]float64, blocks)
+	for range trials {
+		changed, _ := sampledFile(t, size, k, rng)
+		for _, r := range changed {
+			for off := r.Offset; off < r.Offset+r.Length; off++ {
+				perBlock[off/sampleBlockSize]++
+			}
+		}
+	}
+	// Each block's share has sd ~12.5; over 300 trials its mean has sd ~0.75.
+	for b, n := range perBlock {
+		if mean := n / trials; math.Abs(mean-k/blocks) > 4 {
+			t.Errorf("block %d got %.1f of %d on average, want %d", b, mean, k, k/blocks)
+		}
+	}
+}
+
+// BenchmarkCorruptFile overwrites 1%, 50% and 99% of a large sparse file; allocations should
+// not grow with the file or with k.
+func BenchmarkCorruptFile(b *testing.B) {
+	const size = 64 << 20
+	path := filepath.Join(b.TempDir(), "large")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	s := NewSession(HostRoot, nil, nil, "")
+	for _, percent := range []int{1, 50, 99} {
+		b.Run(fmt.Sprintf("%d%%", percent), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+			for b.Loop() {
+				if err := CorruptFile(s, path, percent); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
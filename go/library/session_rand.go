@@ -0,0 +1,55 @@
+package library
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	mathrand "math/rand/v2"
+	"sync"
+)
+
+// Rand is a session's random stream: every random choice a break makes (which files, which
+// bytes, which kind of damage) is drawn from one ChaCha8 stream keyed from the session seed
+// the monitor seals into the break config, so running a session again with the same seed
+// against the same testenv makes the same choices. Keys, nonces and ids never come from it.
+// It is safe for concurrent use.
+type Rand struct {
+	*mathrand.Rand
+	src *lockedChaCha8
+}
+
+// NewRand keys a session stream from seed. An empty seed keys it from crypto/rand, so
+// nothing is reproducible.
+func NewRand(seed string) *Rand {
+	var key [32]byte
+	if seed == "" {
+		_, _ = rand.Read(key[:]) // never fails on Linux
+	} else {
+		key = sha256.Sum256([]byte(seed))
+	}
+	src := &lockedChaCha8{c: mathrand.NewChaCha8(key)}
+	return &Rand{Rand: mathrand.New(src), src: src}
+}
+
+// Read fills p with random bytes from the stream.
+func (r *Rand) Read(p []byte) (int, error) {
+	return r.src.Read(p)
+}
+
+// lockedChaCha8 is a mathrand.Source and io.Reader over one ChaCha8 stream, safe for
+// concurrent use.
+type lockedChaCha8 struct {
+	mu sync.Mutex
+	c  *mathrand.ChaCha8
+}
+
+func (s *lockedChaCha8) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.Uint64()
+}
+
+func (s *lockedChaCha8) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.Read(p)
+}
@@ -0,0 +1,34 @@
+package library_test
+
+import (
+	"chaos-agent/library"
+	"chaos-agent/library/fixture"
+	"slices"
+	"testing"
+)
+
+func TestSessionSeedReplaysSelection(t *testing.T) {
+	root, err := fixture.Build(t.TempDir(), library.FamilyRHEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pick := func(seed string) []string {
+		t.Helper()
+		sel, err := library.PickRandomBinaries(library.NewSession(root, nil, nil, seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sel
+	}
+	first := pick("session-a")
+	if again := pick("session-a"); !slices.Equal(first, again) {
+		t.Errorf("same seed picked %v, then %v", first, again)
+	}
+	differ := false
+	for _, seed := range []string{"session-b", "session-c", "session-d"} {
+		differ = differ || !slices.Equal(first, pick(seed))
+	}
+	if !differ {
+		t.Errorf("three other seeds all picked %v", first)
+	}
+}
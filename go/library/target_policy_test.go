@@ -0,0 +1,196 @@
+package library_test
+
+import (
+	"chaos-agent/library"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// policyTree builds a testenv under a temp dir with one candidate in /usr/bin per rule,
+// and tool, which passes every rule.
+func policyTree(t *testing.T) library.Root {
+	t.Helper()
+	root := library.Root(t.TempDir())
+	files := map[string]string{
+		"/usr/bin/tool":          "#!/bin/sh\necho tool\n",
+		"/usr/bin/other-tool":    "#!/bin/sh\necho other\n",
+		"/usr/bin/keep":          "#!/bin/sh\necho lifeline\n",
+		"/usr/bin/secret":        "#!/bin/sh\necho secret\n",
+		"/usr/bin/tool.bak":      "#!/bin/sh\necho backup\n",
+		"/usr/bin/unmatched":     "#!/bin/sh\necho unmatched\n",
+		"/usr/bin/tool-notes":    "plain text, not a script\n",
+		"/usr/bin/tool-huge":     "#!/bin/sh\n" + strings.Repeat("# padding\n", 1000),
+		"/opt/private/tool-priv": "#!/bin/sh\necho private\n",
+		"/etc/passwd":            "root:x:0:0:root:/root:/bin/bash\n",
+	}
+	for p, content := range files {
+		if err := os.MkdirAll(filepath.Dir(root.Path(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(root.Path(p), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"/usr/bin/tool-again":    "tool",
+		"/usr/bin/tool-dangling": "/usr/bin/nowhere",
+		"/usr/bin/tool-passwd":   "/etc/passwd",
+		"/usr/bin/tool-private":  "../../opt/private/tool-priv",
+	}
+	for p, target := range links {
+		if err := os.Symlink(target, root.Path(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(root.Path("/usr/bin/tool-dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestTargetPolicyFromParams(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{"roots": ["/opt"], "vendors": ["Acme, Inc."]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		params  map[string]string
+		check   func(p library.TargetPolicy) bool
+		wantErr bool
+	}{
+		{"defaults", map[string]string{}, func(p library.TargetPolicy) bool {
+			return slices.Equal(p.Roots, library.DefaultTargetPolicy().Roots) && p.MinCount == 15 && p.MaxCount == 20
+		}, false},
+		{"deny kinds", map[string]string{"target_deny": "/usr/bin/secret, /opt/private/,*.bak"}, func(p library.TargetPolicy) bool {
+			return slices.Equal(p.DenyExact, []string{"/usr/bin/secret"}) &&
+				slices.Equal(p.DenyPrefixes, []string{"/opt/private/"}) &&
+				slices.Equal(p.DenyPatterns, []string{"*.bak"})
+		}, false},
+		{"lists", map[string]string{"target_roots": "/usr/bin,/opt", "target_patterns": "tool*", "target_kinds": "script,config"}, func(p library.TargetPolicy) bool {
+			return slices.Equal(p.Roots, []string{"/usr/bin", "/opt"}) && slices.Equal(p.Patterns, []string{"tool*"}) &&
+				slices.Equal(p.Kinds, []library.FileKind{library.KindScript, library.KindConfig})
+		}, false},
+		{"sizes and count", map[string]string{"target_min_size": "10", "target_max_size": "100", "target_count": "2-5"}, func(p library.TargetPolicy) bool {
+			return p.MinSize == 10 && p.MaxSize == 100 && p.MinCount == 2 && p.MaxCount == 5
+		}, false},
+		{"single count", map[string]string{"target_count": "3"}, func(p library.TargetPolicy) bool {
+			return p.MinCount == 3 && p.MaxCount == 3
+		}, false},
+		{"file then inline then params", map[string]string{
+			"target_policy_file": policyFile,
+			"target_policy":      `{"min_count": 1, "max_count": 2}`,
+			"target_lifelines":   "/usr/bin/keep",
+		}, func(p library.TargetPolicy) bool {
+			return slices.Equal(p.Roots, []string{"/opt"}) && slices.Equal(p.Vendors, []string{"Acme, Inc."}) &&
+				p.MaxCount == 2 && slices.Equal(p.Lifelines, []string{"/usr/bin/keep"})
+		}, false},
+		{"package params", map[string]string{"target_packages": "coreutils", "target_exclude_packages": "kernel*", "target_exclude_config": "true", "target_package_db": "/tmp/db"}, func(p library.TargetPolicy) bool {
+			return slices.Equal(p.Packages, []string{"coreutils"}) && slices.Equal(p.ExcludePackages, []string{"kernel*"}) &&
+				p.ExcludeConfig && p.PackageDB == "/tmp/db"
+		}, false},
+		{"bad count", map[string]string{"target_count": "many"}, nil, true},
+		{"inverted count", map[string]string{"target_count": "5-2"}, nil, true},
+		{"bad size", map[string]string{"target_max_size": "big"}, nil, true},
+		{"inverted sizes", map[string]string{"target_min_size": "100", "target_max_size": "10"}, nil, true},
+		{"unknown kind", map[string]string{"target_kinds": "socket"}, nil, true},
+		{"relative root", map[string]string{"target_roots": "usr/bin"}, nil, true},
+		{"bad pattern", map[string]string{"target_patterns": "[tool"}, nil, true},
+		{"bad inline json", map[string]string{"target_policy": "{"}, nil, true},
+		{"missing policy file", map[string]string{"target_policy_file": filepath.Join(t.TempDir(), "none.json")}, nil, true},
+		{"bad exclude_config", map[string]string{"target_exclude_config": "maybe"}, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := library.TargetPolicyFromParams(tc.params, library.DefaultTargetPolicy())
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("accepted %v as %+v", tc.params, p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(p) {
+				t.Errorf("%v gave %+v", tc.params, p)
+			}
+		})
+	}
+}
+
+func TestTargetPolicySelectRules(t *testing.T) {
+	root := policyTree(t)
+	p, err := library.TargetPolicyFromParams(map[string]string{
+		"target_roots":     "/usr/bin,/missing",
+		"target_patterns":  "tool*,keep,secret",
+		"target_kinds":     "script",
+		"target_max_size":  "1000",
+		"target_count":     "1-20",
+		"target_deny":      "/usr/bin/secret,/opt/private/,*.bak",
+		"target_lifelines": "/usr/bin/keep",
+	}, library.DefaultTargetPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Root = root
+	sel, err := p.Select(library.NewRand("target-policy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"tool":          "",
+		"other-tool":    library.RulePattern,
+		"tool-again":    library.RuleDuplicate,
+		"tool-dir":      library.RuleNotFile,
+		"tool-dangling": library.RuleUnresolvable,
+		"tool-passwd":   library.RuleProtected,
+		"keep":          library.RuleLifeline,
+		"secret":        library.RuleDenied,
+		"tool-private":  library.RuleDenied,
+		"tool.bak":      library.RuleDenied,
+		"unmatched":     library.RulePattern,
+		"tool-huge":     library.RuleSize,
+		"tool-notes":    library.RuleKind,
+	}
+	got := make(map[string]string)
+	for _, d := range sel.Decisions {
+		got[filepath.Base(d.Path)] = d.Rule
+	}
+	for name, rule := range want {
+		if r, ok := got[name]; !ok || r != rule {
+			t.Errorf("%s: rule %q, want %q", name, r, rule)
+		}
+	}
+	// tool and tool-again are the same file; whichever the scan met first is the eligible one.
+	if !slices.Equal(sel.Selected, []string{root.Path("/usr/bin/tool")}) {
+		t.Errorf("selected %v, want only /usr/bin/tool", sel.Selected)
+	}
+
+	for _, w := range []string{"tool-dangling", "/missing"} {
+		if !slices.ContainsFunc(sel.Warnings, func(s string) bool { return strings.Contains(s, w) }) {
+			t.Errorf("no warning about %s in %q", w, sel.Warnings)
+		}
+	}
+	if n := sel.Excluded()[library.RuleDenied]; n != 3 {
+		t.Errorf("%d candidates denied, want secret, tool-private and tool.bak", n)
+	}
+}
+
+// TestTargetPolicySelectNothingEligible checks a policy whose roots are all unreadable is an
+// error carrying the warnings, not a panic or an empty success.
+func TestTargetPolicySelectNothingEligible(t *testing.T) {
+	p := library.DefaultTargetPolicy()
+	p.Root = library.Root(t.TempDir())
+	p.Roots = []string{"/missing"}
+	sel, err := p.Select(library.NewRand(""))
+	if err == nil {
+		t.Fatalf("selected %v from a missing root", sel.Selected)
+	}
+	if len(sel.Warnings) == 0 {
+		t.Error("unreadable root gave no warning")
+	}
+}
@@ -13,6 +13,30 @@ type ChaosMessage struct {
 	Token   string `json:"token"`
 }
 
+// BreakConfig is the per-session configuration a break binary receives at run time.
+// It is sealed by the monitor and delivered as a sealed file and a single-use key file (or on stdin), never compiled in.
+type BreakConfig struct {
+	MonitorIP     string            `json:"monitor_ip"`
+	MonitorPort   int               `json:"monitor_port"`
+	EncryptionKey string            `json:"encryption_key"` // base64 NaCl box public key of the monitor
+	Seed          string            `json:"seed"`           // per-session; keys the break's random choices
+	Token         string            `json:"token"`          // session token assigned by the monitor
+	Params        map[string]string `json:"params,omitempty"`
+
+	// Detonation timing: an absolute time wins over a delay; neither means fire immediately.
+	DetonateAt   time.Time `json:"detonate_at,omitempty"`
+	DelaySeconds int64     `json:"delay_seconds,omitempty"`
+
+	// Vault for originals of mutated files: a base64 key the monitor keeps, and where sealed
+	// originals go ("local" on the testenv, "monitor" streamed back as reports).
+	VaultKey  string `json:"vault_key,omitempty"`
+	VaultMode string `json:"vault_mode,omitempty"`
+
+	// Root, when set, is a fixture tree the break treats as "/" (sandbox mode): targets are
+	// found, and the vault and journal kept, under it, and nothing outside it is mutated.
+	Root string `json:"root,omitempty"`
+}
+
 // FileMeta holds metadata about a file necessary for preserving its state.
 type FileMeta struct {
 	Mode  os.FileMode
@@ -0,0 +1,144 @@
+package vault
+
+import (
+	"bytes"
+	datatypes "chaos-agent/library/types"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// CaptureMeta snapshots path's mode, ownership, times and xattrs (xattrs best-effort).
+func CaptureMeta(path string) (datatypes.FileMeta, error) {
+	var m datatypes.FileMeta
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return m, err
+	}
+	if !fi.Mode().IsRegular() {
+		return m, fmt.Errorf("not a regular file: %s", path)
+	}
+	m.Mode = fi.Mode()
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return m, errors.New("unexpected stat type")
+	}
+	m.UID = int(st.Uid)
+	m.GID = int(st.Gid)
+
+	// atime/mtime from Stat_t (Linux)
+	m.Atime = time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+	m.Mtime = time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec))
+
+	// xattrs: best-effort
+	if xa, err := readXattrs(path); err == nil {
+		m.XAttr = xa
+	} else {
+		m.XAttr = map[string][]byte{}
+	}
+	return m, nil
+}
+
+// ApplyPreMeta applies ownership, mode and xattrs; call it before the file is renamed into place.
+func ApplyPreMeta(path string, meta datatypes.FileMeta) error {
+	// Ownership (ignore EPERM/EACCES so non-root still works best-effort)
+	if err := os.Chown(path, meta.UID, meta.GID); err != nil {
+		if !errors.Is(err, unix.EPERM) && !errors.Is(err, unix.EACCES) {
+			return err
+		}
+	}
+	// Mode (preserve suid/sgid/sticky if present)
+	const keep = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	if err := os.Chmod(path, meta.Mode&keep); err != nil {
+		return err
+	}
+	// xattrs: best-effort; ignore common non-fatal errors
+	for k, v := range meta.XAttr {
+		if err := unix.Setxattr(path, k, v, 0); err != nil && !isIgnorableXErr(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyPostMeta restores atime/mtime; call it last, since any write changes them.
+func ApplyPostMeta(path string, meta datatypes.FileMeta) error {
+	return os.Chtimes(path, meta.Atime, meta.Mtime)
+}
+
+// DropExtraXattrs removes path's xattrs that are not in keep, e.g. ones an inode brought
+// with it when it was moved into another file's place.
+func DropExtraXattrs(path string, keep map[string][]byte) error {
+	now, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	for k := range now {
+		if _, ok := keep[k]; ok {
+			continue
+		}
+		if err := unix.Removexattr(path, k); err != nil && !isIgnorableXErr(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+/* ------------------------------- helpers ----------------------------------- */
+
+func readXattrs(path string) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	n, err := unix.Listxattr(path, nil)
+	if err != nil || n <= 0 {
+		return out, err
+	}
+	buf := make([]byte, n)
+	n, err = unix.Listxattr(path, buf)
+	if err != nil || n <= 0 {
+		return out, err
+	}
+	for _, name := range splitZ(buf[:n]) {
+		sz, gerr := unix.Getxattr(path, name, nil)
+		if gerr != nil || sz <= 0 {
+			continue
+		}
+		val := make([]byte, sz)
+		got, gerr := unix.Getxattr(path, name, val)
+		if gerr == nil && got >= 0 {
+			out[name] = append([]byte(nil), val[:got]...)
+		}
+	}
+	return out, nil
+}
+
+func isIgnorableXErr(err error) bool {
+	if err == nil {
+		return true
+	}
+	// On Linux ENOTSUP == EOPNOTSUPP; checking ENOTSUP covers both.
+	return errors.Is(err, unix.ENOTSUP) ||
+		errors.Is(err, unix.EPERM) ||
+		errors.Is(err, unix.EACCES) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENODATA)
+}
+
+func splitZ(b []byte) []string {
+	var out []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			out = append(out, string(b[:i]))
+		}
+		b = b[i+1:]
+	}
+	return out
+}
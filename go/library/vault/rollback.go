@@ -0,0 +1,224 @@
+package vault
+
+import (
+	datatypes "chaos-agent/library/types"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Failure is a path rollback could not restore.
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Report is the outcome of rolling back one session.
+type Report struct {
+	Token    string    `json:"token"`
+	Restored []string  `json:"restored,omitempty"`
+	Removed  []string  `json:"removed,omitempty"` // paths that did not exist before the session
+	Failed   []Failure `json:"failed,omitempty"`
+}
+
+// OK reports whether every path was restored.
+func (r Report) OK() bool { return len(r.Failed) == 0 }
+
+// ErrOutsideRoot is the failure for an entry whose path is outside the filesystem being rolled back.
+var ErrOutsideRoot = errors.New("path outside the filesystem root")
+
+// Rollback restores every original recorded for token, newest entry last, and verifies each
+// one. Metadata-only entries go after all content, so a path whose content was kept after its
+// metadata was sabotaged ends with its pre-session metadata. Paths that fail are listed in
+// the report; the error is only for an unreadable index. Entries whose path inside rejects
+// are left alone and reported as failed; nil accepts every path.
+func Rollback(key []byte, store Store, token string, inside func(path string) bool) (Report, error) {
+	rep := Report{Token: token}
+	entries, err := store.Entries(token)
+	if err != nil {
+		return rep, err
+	}
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		switch {
+		case a.MetaOnly == b.MetaOnly:
+			return 0
+		case a.MetaOnly:
+			return 1
+		}
+		return -1
+	})
+	for _, e := range entries {
+		if inside != nil && !inside(e.Path) {
+			rep.Failed = append(rep.Failed, Failure{Path: e.Path, Error: ErrOutsideRoot.Error()})
+			continue
+		}
+		blob, err := store.Blob(e)
+		if err == nil {
+			err = Restore(key, e, blob)
+			_ = blob.Close()
+		}
+		switch {
+		case err != nil:
+			rep.Failed = append(rep.Failed, Failure{Path: e.Path, Error: err.Error()})
+		case e.Absent:
+			rep.Removed = append(rep.Removed, e.Path)
+		default:
+			rep.Restored = append(rep.Restored, e.Path)
+		}
+	}
+	return rep, nil
+}
+
+// Restore puts e's original back at e.Path, content and metadata, and verifies the result.
+// An entry recorded as absent removes whatever now exists at the path. The blob is checked
+// as it streams into a temp file, so a damaged one leaves the path as it was.
+func Restore(key []byte, e Entry, blob io.Reader) error {
+	plain, err := Open(key, e, blob)
+	if err != nil {
+		return err
+	}
+	if e.Absent {
+		if _, err := io.Copy(io.Discard, plain); err != nil {
+			return err
+		}
+		if err := os.Remove(e.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if _, err := os.Lstat(e.Path); !errors.Is(err, fs.ErrNotExist) {
+			return errors.New("path still exists after removal")
+		}
+		return nil
+	}
+	if e.MetaOnly {
+		if _, err := io.Copy(io.Discard, plain); err != nil {
+			return err
+		}
+		if err := applyMeta(e.Path, e.Meta); err != nil {
+			return err
+		}
+		return Verify(e)
+	}
+
+	// A mutation may have left a directory in place, which rename cannot replace.
+	if fi, err := os.Lstat(e.Path); err == nil && fi.IsDir() {
+		if err := os.Remove(e.Path); err != nil {
+			return fmt.Errorf("remove directory left in place: %w", err)
+		}
+	}
+	if err := replaceFile(e, plain); err != nil {
+		return err
+	}
+	return Verify(e)
+}
+
+// replaceFile streams plain and e's metadata to a temp file beside e.Path and renames it over
+// the path, so a running binary is never written to (ETXTBSY) and a crash leaves either the
+// old file or the restored one, never a truncated mix.
+func replaceFile(e Entry, plain io.Reader) (err error) {
+	dir := filepath.Dir(e.Path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(e.Path)+".restore-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+	if _, err := io.Copy(f, plain); err != nil {
+		return err
+	}
+	if err := ApplyPreMeta(tmp, e.Meta); err != nil {
+		return fmt.Errorf("restore metadata: %w", err)
+	}
+	if err := DropExtraXattrs(tmp, e.Meta.XAttr); err != nil {
+		return fmt.Errorf("restore xattrs: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ApplyPostMeta(tmp, e.Meta); err != nil {
+		return fmt.Errorf("restore times: %w", err)
+	}
+	if err := os.Rename(tmp, e.Path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// applyMeta puts meta back on the file at path in place, leaving its content alone.
+func applyMeta(path string, meta datatypes.FileMeta) error {
+	if err := ApplyPreMeta(path, meta); err != nil {
+		return fmt.Errorf("restore metadata: %w", err)
+	}
+	if err := DropExtraXattrs(path, meta.XAttr); err != nil {
+		return fmt.Errorf("restore xattrs: %w", err)
+	}
+	if err := ApplyPostMeta(path, meta); err != nil {
+		return fmt.Errorf("restore times: %w", err)
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	// #nosec G304 -- parent directory of a path recorded in the vault index
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
+
+// Verify checks that e.Path matches the recorded original: content hash, mode, ownership and
+// mtime. A metadata-only entry checks the metadata alone.
+func Verify(e Entry) error {
+	if e.Absent {
+		if _, err := os.Lstat(e.Path); !errors.Is(err, fs.ErrNotExist) {
+			return errors.New("path exists but did not before the session")
+		}
+		return nil
+	}
+	if !e.MetaOnly {
+		n, sum, err := fileSHA256(e.Path)
+		if err != nil {
+			return err
+		}
+		if n != e.Size || sum != e.SHA256 {
+			return errors.New("content hash differs from the original")
+		}
+	}
+	now, err := CaptureMeta(e.Path)
+	if err != nil {
+		return err
+	}
+	var diffs []string
+	if now.Mode != e.Meta.Mode {
+		diffs = append(diffs, fmt.Sprintf("mode %v, want %v", now.Mode, e.Meta.Mode))
+	}
+	if now.UID != e.Meta.UID || now.GID != e.Meta.GID {
+		diffs = append(diffs, fmt.Sprintf("owner %d:%d, want %d:%d", now.UID, now.GID, e.Meta.UID, e.Meta.GID))
+	}
+	if !now.Mtime.Equal(e.Meta.Mtime) {
+		diffs = append(diffs, fmt.Sprintf("mtime %s, want %s", now.Mtime, e.Meta.Mtime))
+	}
+	for k, v := range e.Meta.XAttr {
+		if got, ok := now.XAttr[k]; !ok || !slices.Equal(got, v) {
+			diffs = append(diffs, "xattr "+k)
+		}
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("metadata differs: %v", diffs)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package vault
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultDir is where a testenv keeps its local vault.
+const DefaultDir = "/var/lib/chaos-vault"
+
+// Store persists sealed originals and their index.
+type Store interface {
+	// Create starts the sealed blob for path in token's vault.
+	Create(token, path string) (BlobWriter, error)
+	Entries(token string) ([]Entry, error)
+	Blob(e Entry) (io.ReadCloser, error)
+}
+
+// BlobWriter receives a sealed blob as it is produced.
+type BlobWriter interface {
+	io.Writer
+	// Commit stores the blob under e, which carries its final size and hash.
+	Commit(e Entry) error
+	// Abort discards the blob.
+	Abort() error
+}
+
+// DirStore keeps each token's entries under Root/<token>/: an append-only index.jsonl and one
+// .blob per path. It is used on the testenv (local vault) and on the monitor (streamed vault).
+type DirStore struct {
+	Root string
+
+	mu sync.Mutex
+}
+
+var _ Store = (*DirStore)(nil)
+
+// Dir is the directory holding token's index and blobs.
+func (s *DirStore) Dir(token string) (string, error) {
+	if !ValidToken(token) {
+		return "", fmt.Errorf("invalid vault token %q", token)
+	}
+	return filepath.Join(s.Root, token), nil
+}
+
+func blobName(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:16]) + ".blob"
+}
+
+// blobName is where e's blob is kept: a path has at most one content original and one
+// metadata-only original, each first-wins.
+func (e Entry) blobName() string {
+	if e.MetaOnly {
+		return blobName(e.Path + "\x00meta")
+	}
+	return blobName(e.Path)
+}
+
+// Create writes the blob to a temp file in token's directory; Commit renames it into place
+// and indexes it, unless an earlier original for the path is already there.
+func (s *DirStore) Create(token, path string) (BlobWriter, error) {
+	dir, err := s.Dir(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, blobName(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &dirBlob{s: s, dir: dir, f: f, hw: newHashWriter(f)}, nil
+}
+
+type dirBlob struct {
+	s   *DirStore
+	dir string
+	f   *os.File
+	hw  *hashWriter
+}
+
+func (b *dirBlob) Write(p []byte) (int, error) { return b.hw.Write(p) }
+
+func (b *dirBlob) Commit(e Entry) error {
+	if b.hw.n != e.BlobSize || b.hw.sum() != e.BlobSHA256 {
+		_ = b.Abort()
+		return fmt.Errorf("vault blob for %s does not match its index entry", e.Path)
+	}
+	if err := b.f.Sync(); err != nil {
+		_ = b.Abort()
+		return err
+	}
+	if err := b.f.Close(); err != nil {
+		_ = os.Remove(b.f.Name())
+		return err
+	}
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	return b.s.index(b.dir, b.f.Name(), e)
+}
+
+func (b *dirBlob) Abort() error {
+	_ = b.f.Close()
+	return os.Remove(b.f.Name())
+}
+
+// index moves a complete, checked blob into place and appends e to the index. The first
+// original of its kind stored for a path wins; a later one is discarded.
+func (s *DirStore) index(dir, blob string, e Entry) error {
+	final := filepath.Join(dir, e.blobName())
+	if _, err := os.Stat(final); err == nil {
+		return os.Remove(blob)
+	}
+	if err := os.Rename(blob, final); err != nil {
+		return err
+	}
+	return appendIndex(filepath.Join(dir, "index.jsonl"), e)
+}
+
+// PutChunk stores blob bytes at off. Once e.BlobSize bytes have arrived the blob is checked
+// against e.BlobSHA256 and indexed; complete reports that. Chunks may arrive in any order.
+func (s *DirStore) PutChunk(e Entry, off int64, data []byte) (complete bool, err error) {
+	if off < 0 || off+int64(len(data)) > e.BlobSize {
+		return false, fmt.Errorf("chunk [%d,%d) outside blob of %d bytes", off, off+int64(len(data)), e.BlobSize)
+	}
+	dir, err := s.Dir(e.Token)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return false, err
+	}
+	final := filepath.Join(dir, e.blobName())
+	if _, err := os.Stat(final); err == nil {
+		return true, nil // first original wins
+	}
+	part := final + ".part"
+	// #nosec G304 -- name derived from a hash under the vault root
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.WriteAt(data, off); err != nil {
+		_ = f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+
+	// Count received bytes in a sidecar so a sparse .part is not mistaken for a full one.
+	got, err := addReceived(part+".n", int64(len(data)))
+	if err != nil || got < e.BlobSize {
+		return false, err
+	}
+	n, sum, err := fileSHA256(part)
+	if err != nil {
+		return false, err
+	}
+	_ = os.Remove(part + ".n")
+	if n != e.BlobSize || sum != e.BlobSHA256 {
+		_ = os.Remove(part)
+		return false, fmt.Errorf("vault blob for %s failed its integrity hash", e.Path)
+	}
+	return true, s.index(dir, part, e)
+}
+
+func addReceived(path string, n int64) (int64, error) {
+	var got int64
+	// #nosec G304 -- sidecar under the vault root
+	if b, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(b, &got)
+	}
+	got += n
+	b, _ := json.Marshal(got)
+	return got, os.WriteFile(path, b, 0o600)
+}
+
+func appendIndex(path string, e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// #nosec G304 -- index under the vault root
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Entries returns token's index in the order originals were stored.
+func (s *DirStore) Entries(token string) ([]Entry, error) {
+	dir, err := s.Dir(token)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// #nosec G304 -- index under the vault root
+	f, err := os.Open(filepath.Join(dir, "index.jsonl"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var out []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return out, fmt.Errorf("vault index %s: %w", token, err)
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+// Blob opens the sealed blob for e.
+func (s *DirStore) Blob(e Entry) (io.ReadCloser, error) {
+	dir, err := s.Dir(e.Token)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- name derived from a hash under the vault root
+	return os.Open(filepath.Join(dir, e.blobName()))
+}
+
+// Remove deletes token's vault, once its session has been rolled back.
+func (s *DirStore) Remove(token string) error {
+	dir, err := s.Dir(token)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.RemoveAll(dir)
+}
+
+// Tokens lists the sessions that have a vault under Root.
+func (s *DirStore) Tokens() ([]string, error) {
+	ents, err := os.ReadDir(s.Root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, d := range ents {
+		if d.IsDir() && ValidToken(d.Name()) {
+			out = append(out, d.Name())
+		}
+	}
+	return out, nil
+}
+
+// StreamChunkSize keeps each streamed chunk well inside a single report frame.
+const StreamChunkSize = 512 * 1024
+
+// Chunk is the payload of a "vault" report: part of an entry's sealed blob.
+type Chunk struct {
+	Entry  Entry  `json:"entry"`
+	Offset int64  `json:"offset"`
+	Data   string `json:"data"` // base64
+}
+
+// ReporterStore streams sealed originals to the monitor as "vault" reports, so nothing needed
+// for rollback stays on the testenv. A blob is spooled, sealed, to a temp file until its hash is
+// known (every chunk carries the complete entry) and removed once sent. The monitor stores
+// the chunks in a DirStore; reading back happens there.
+type ReporterStore struct {
+	Report func(status, message string)
+}
+
+var _ Store = ReporterStore{}
+
+func (s ReporterStore) Create(token, path string) (BlobWriter, error) {
+	if !ValidToken(token) {
+		return nil, fmt.Errorf("invalid vault token %q", token)
+	}
+	f, err := os.CreateTemp("", "chaos-vault-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spooledBlob{report: s.Report, f: f}, nil
+}
+
+type spooledBlob struct {
+	report func(status, message string)
+	f      *os.File
+}
+
+func (b *spooledBlob) Write(p []byte) (int, error) { return b.f.Write(p) }
+
+func (b *spooledBlob) Commit(e Entry) error {
+	defer func() { _ = b.Abort() }()
+	if _, err := b.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, StreamChunkSize)
+	for off := int64(0); off < e.BlobSize; {
+		n, err := io.ReadFull(b.f, buf)
+		if n == 0 {
+			return fmt.Errorf("vault spool for %s ended at %d of %d bytes: %w", e.Path, off, e.BlobSize, err)
+		}
+		msg, err := json.Marshal(Chunk{Entry: e, Offset: off, Data: base64.StdEncoding.EncodeToString(buf[:n])})
+		if err != nil {
+			return err
+		}
+		b.report("vault", string(msg))
+		off += int64(n)
+	}
+	return nil
+}
+
+func (b *spooledBlob) Abort() error {
+	_ = b.f.Close()
+	return os.Remove(b.f.Name())
+}
+
+func (ReporterStore) Entries(string) ([]Entry, error) {
+	return nil, errors.New("streamed vault entries are kept on the monitor")
+}
+
+func (ReporterStore) Blob(Entry) (io.ReadCloser, error) {
+	return nil, errors.New("streamed vault blobs are kept on the monitor")
+}
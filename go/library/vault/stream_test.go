@@ -0,0 +1,110 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// sealed seals plain for (token, path) and returns its entry and blob.
+func sealed(t *testing.T, key []byte, path string, plain []byte) (Entry, []byte) {
+	t.Helper()
+	var blob bytes.Buffer
+	bw := newHashWriter(&blob)
+	sw, err := Seal(key, "tok", path, bw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw := newHashWriter(sw)
+	if _, err := pw.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e := Entry{Token: "tok", Path: path, Size: pw.n, SHA256: pw.sum(), BlobSize: bw.n, BlobSHA256: bw.sum()}
+	return e, blob.Bytes()
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	key := testKey(t)
+	// Random content does not compress, so these sizes land on and around segment boundaries.
+	for _, n := range []int{0, 1, segmentSize - 100, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		plain := make([]byte, n)
+		if _, err := rand.Read(plain); err != nil {
+			t.Fatal(err)
+		}
+		e, blob := sealed(t, key, "/usr/bin/ls", plain)
+		r, err := Open(key, e, bytes.NewReader(blob))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: round trip gave %d different bytes", n, len(got))
+		}
+	}
+}
+
+func TestOpenRejectsDamagedBlobs(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 3*segmentSize)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	e, blob := sealed(t, key, "/usr/bin/ls", plain)
+	seg := segmentSize + 16 // one sealed segment with its tag
+
+	// withEntry re-hashes a damaged blob into e, so only the AEAD stands between it and a restore.
+	withEntry := func(b []byte) Entry {
+		hw := newHashWriter(io.Discard)
+		_, _ = hw.Write(b)
+		d := e
+		d.BlobSize, d.BlobSHA256 = hw.n, hw.sum()
+		return d
+	}
+	flipped := bytes.Clone(blob)
+	flipped[prefixSize+seg+10] ^= 1
+	swapped := bytes.Clone(blob)
+	copy(swapped[prefixSize:], blob[prefixSize+seg:prefixSize+2*seg])
+	copy(swapped[prefixSize+seg:], blob[prefixSize:prefixSize+seg])
+	otherPath := e
+	otherPath.Path = "/usr/bin/cp"
+
+	for _, tc := range []struct {
+		name string
+		key  []byte
+		e    Entry
+		blob []byte
+	}{
+		{"index mismatch", key, e, flipped},
+		{"flipped bit", key, withEntry(flipped), flipped},
+		{"truncated at a segment boundary", key, withEntry(blob[:prefixSize+seg]), blob[:prefixSize+seg]},
+		{"segments reordered", key, withEntry(swapped), swapped},
+		{"other path", key, otherPath, blob},
+		{"wrong key", testKey(t), e, blob},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Open(tc.key, tc.e, bytes.NewReader(tc.blob))
+			if err == nil {
+				_, err = io.Copy(io.Discard, r)
+			}
+			if err == nil {
+				t.Fatal("damaged blob opened without error")
+			}
+		})
+	}
+}
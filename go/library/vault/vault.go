@@ -0,0 +1,210 @@
+// Package vault keeps the original content and metadata of every file a break mutates, so a
+// session can be rolled back without reinstalling the testenv. Originals are streamed through
+// gzip and sealed in XChaCha20-Poly1305 segments under a per-session key that only the monitor
+// keeps, and indexed by session token and path with SHA-256 hashes of both the original and
+// the sealed blob. No original is ever held in memory whole.
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	datatypes "chaos-agent/library/types"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const formatVersion = "chaos-vault/2"
+
+// Entry indexes one preserved original.
+type Entry struct {
+	Token      string             `json:"token"`
+	Path       string             `json:"path"`
+	Absent     bool               `json:"absent,omitempty"`    // path did not exist; rollback removes it
+	MetaOnly   bool               `json:"meta_only,omitempty"` // only Meta was kept; rollback leaves the content
+	Meta       datatypes.FileMeta `json:"meta"`
+	Size       int64              `json:"size"`
+	SHA256     string             `json:"sha256"`      // original content
+	BlobSize   int64              `json:"blob_size"`   // sealed blob as stored
+	BlobSHA256 string             `json:"blob_sha256"` // sealed blob as stored
+	StoredAt   time.Time          `json:"stored_at"`
+}
+
+// GenerateKey returns a new base64 vault key.
+func GenerateKey() (string, error) {
+	k := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(k); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k), nil
+}
+
+// ParseKey decodes a base64 vault key.
+func ParseKey(b64 string) ([]byte, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode vault key: %w", err)
+	}
+	if len(k) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("vault key must be %d bytes, got %d", chacha20poly1305.KeySize, len(k))
+	}
+	return k, nil
+}
+
+// ValidToken reports whether token is safe to use as a vault directory name.
+func ValidToken(token string) bool {
+	if token == "" || len(token) > 128 {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// additionalData binds a sealed blob to its token and path, so blobs cannot be swapped between entries.
+func additionalData(token, path string) []byte {
+	return []byte(formatVersion + "\x00" + token + "\x00" + path)
+}
+
+// Vault preserves originals for one session.
+type Vault struct {
+	token string
+	key   []byte
+	store Store
+
+	mu       sync.Mutex
+	kept     map[string]bool // paths whose content and metadata are kept
+	keptMeta map[string]bool // paths whose metadata alone is kept
+}
+
+// New returns a vault for token that seals with key and writes to store.
+func New(token string, key []byte, store Store) (*Vault, error) {
+	if !ValidToken(token) {
+		return nil, fmt.Errorf("invalid vault token %q", token)
+	}
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("vault key must be %d bytes", chacha20poly1305.KeySize)
+	}
+	return &Vault{token: token, key: key, store: store, kept: make(map[string]bool), keptMeta: make(map[string]bool)}, nil
+}
+
+// Token is the session token entries are indexed by.
+func (v *Vault) Token() string { return v.token }
+
+// Preserve stores path's current content and metadata unless this session already has it:
+// the first copy is the pre-session original, later mutations must not replace it.
+func (v *Vault) Preserve(path string) error {
+	return v.preserve(path, false)
+}
+
+// PreserveMeta stores path's current metadata alone, for a mutation that leaves the content
+// as it is. A content original kept later for the same path is stored beside it.
+func (v *Vault) PreserveMeta(path string) error {
+	return v.preserve(path, true)
+}
+
+func (v *Vault) preserve(path string, metaOnly bool) error {
+	path = filepath.Clean(path)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.kept[path] || (metaOnly && v.keptMeta[path]) {
+		return nil
+	}
+
+	e := Entry{Token: v.token, Path: path, StoredAt: time.Now().UTC()}
+	meta, err := CaptureMeta(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		e.Absent = true
+	case err != nil:
+		return fmt.Errorf("vault %s: %w", path, err)
+	default:
+		e.Meta, e.MetaOnly = meta, metaOnly
+	}
+	if err := v.seal(&e); err != nil {
+		return fmt.Errorf("vault %s: %w", path, err)
+	}
+	if e.MetaOnly {
+		v.keptMeta[path] = true
+	} else {
+		v.kept[path] = true
+	}
+	return nil
+}
+
+// seal streams e.Path (nothing, if e is Absent or MetaOnly) through Seal into the store and
+// commits e with the content and blob hashes filled in.
+func (v *Vault) seal(e *Entry) (err error) {
+	w, err := v.store.Create(e.Token, e.Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = w.Abort()
+		}
+	}()
+	blob := newHashWriter(w)
+	sw, err := Seal(v.key, e.Token, e.Path, blob)
+	if err != nil {
+		return err
+	}
+	plain := newHashWriter(sw)
+	if !e.Absent && !e.MetaOnly {
+		// #nosec G304 -- path is a mutation target chosen by the break
+		f, err := os.Open(e.Path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(plain, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := sw.Close(); err != nil {
+		return err
+	}
+	e.Size, e.SHA256 = plain.n, plain.sum()
+	e.BlobSize, e.BlobSHA256 = blob.n, blob.sum()
+	return w.Commit(*e)
+}
+
+// Revert restores path's content original from this session's vault (see Restore). It is
+// only possible where the store can be read back, i.e. a local vault.
+func (v *Vault) Revert(path string) error {
+	path = filepath.Clean(path)
+	entries, err := v.store.Entries(v.token)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Path != path || e.MetaOnly {
+			continue
+		}
+		blob, err := v.store.Blob(e)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = blob.Close() }()
+		return Restore(v.key, e, blob)
+	}
+	return fmt.Errorf("%s is not in vault %s", path, v.token)
+}
+
+// ErrNoVault is returned when a mutation must be reverted from a vault and its session has none.
+var ErrNoVault = errors.New("no vault for the session")
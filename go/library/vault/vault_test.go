@@ -0,0 +1,277 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testVault(t *testing.T) *Vault {
+	t.Helper()
+	b64, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := New("test-session", key, &DirStore{Root: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestPreserveAndRevert(t *testing.T) {
+	v := testVault(t)
+	path := filepath.Join(t.TempDir(), "target")
+	orig := bytes.Repeat([]byte("original content\n"), 1000)
+	if err := os.WriteFile(path, orig, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Preserve(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("first mutation"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Preserve(path); err != nil { // must keep the pre-session original
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Revert(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, orig) {
+		t.Errorf("reverted content is %d bytes, want the %d-byte original", len(got), len(orig))
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o640 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("reverted mode %v mtime %v, want 0640 %v", fi.Mode().Perm(), fi.ModTime(), mtime)
+	}
+}
+
+// TestRestoreDoesNotWriteThroughTheOldFile checks Restore replaces the path rather than
+// rewriting the file in place: a process holding the mutated file (a running binary) keeps
+// seeing it, and no stray temp file is left beside the target.
+func TestRestoreDoesNotWriteThroughTheOldFile(t *testing.T) {
+	v := testVault(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "busy")
+	if err := os.WriteFile(path, []byte("original"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Preserve(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("mutated!"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	held, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = held.Close() }()
+
+	if err := v.Revert(path); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, _ := held.ReadAt(buf, 0)
+	if string(buf[:n]) != "mutated!" {
+		t.Errorf("open handle reads %q: the old file was rewritten in place", buf[:n])
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		t.Errorf("directory holds %d entries after restore, want only the target", len(ents))
+	}
+}
+
+func TestRestoreRemovesAbsentPath(t *testing.T) {
+	v := testVault(t)
+	path := filepath.Join(t.TempDir(), "created")
+	if err := v.Preserve(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Revert(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("path created during the session still exists: %v", err)
+	}
+}
+
+// TestStreamedVaultRollsBack sends originals through a ReporterStore into the monitor's
+// DirStore, chunk by chunk, and rolls the session back from there.
+func TestStreamedVaultRollsBack(t *testing.T) {
+	key := testKey(t)
+	monitor := &DirStore{Root: t.TempDir()}
+	var chunks int
+	report := func(status, message string) {
+		var c Chunk
+		if err := json.Unmarshal([]byte(message), &c); err != nil {
+			t.Fatal(err)
+		}
+		data, err := base64.StdEncoding.DecodeString(c.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := monitor.PutChunk(c.Entry, c.Offset, data); err != nil {
+			t.Fatal(err)
+		}
+		chunks++
+	}
+	v, err := New("streamed", key, ReporterStore{Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "big")
+	orig := make([]byte, 2*StreamChunkSize+5)
+	if _, err := rand.Read(orig); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, orig, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Preserve(path); err != nil {
+		t.Fatal(err)
+	}
+	if chunks < 3 {
+		t.Errorf("%d-byte original went out in %d chunks", len(orig), chunks)
+	}
+	if err := os.WriteFile(path, []byte("clobbered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := Rollback(key, monitor, "streamed", nil)
+	if err != nil || !rep.OK() || len(rep.Restored) != 1 {
+		t.Fatalf("rollback %+v, %v", rep, err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, orig) {
+		t.Error("rolled-back content differs from the original")
+	}
+}
+
+func TestRollbackLeavesPathsOutsideRoot(t *testing.T) {
+	key := testKey(t)
+	store := &DirStore{Root: t.TempDir()}
+	v, err := New("confined", key, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(path, []byte("original"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Preserve(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("mutated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := Rollback(key, store, "confined", func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Failed) != 1 || rep.Failed[0].Error != ErrOutsideRoot.Error() {
+		t.Errorf("report %+v, want the path failed as outside the root", rep)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "mutated" {
+		t.Errorf("path outside the root was restored to %q", got)
+	}
+}
+
+// TestPreserveMetaRollsBackMetadata keeps a path's metadata alone, then its content after a
+// later mutation, and checks rollback restores both: the content from the content original,
+// then the pre-session metadata over it.
+func TestPreserveMetaRollsBackMetadata(t *testing.T) {
+	key := testKey(t)
+	store := &DirStore{Root: t.TempDir()}
+	v, err := New("meta", key, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "tool")
+	orig := bytes.Repeat([]byte("tool binary\n"), 1000)
+	if err := os.WriteFile(path, orig, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.PreserveMeta(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.PreserveMeta(path); err != nil { // must keep the pre-session metadata
+		t.Fatal(err)
+	}
+	if err := v.Preserve(path); err != nil { // content, with the sabotaged mode
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("clobbered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.PreserveMeta(path); err != nil { // content already kept: nothing to add
+		t.Fatal(err)
+	}
+
+	entries, err := store.Entries("meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].MetaOnly || entries[1].MetaOnly {
+		t.Fatalf("entries %+v, want a metadata-only one then a content one", entries)
+	}
+	if meta := entries[0]; meta.Size != 0 || meta.Meta.Mode.Perm() != 0o755 {
+		t.Errorf("metadata-only entry kept %d bytes of content, mode %v", meta.Size, meta.Meta.Mode)
+	}
+	if err := v.Revert(path); err != nil { // the content original, mode 0644
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Fatalf("revert left mode %v, %v; want the content original's 0644", fi.Mode(), err)
+	}
+
+	rep, err := Rollback(key, store, "meta", nil)
+	if err != nil || !rep.OK() {
+		t.Fatalf("rollback %+v, %v", rep, err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, orig) {
+		t.Error("rolled-back content differs from the original")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o755 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("rolled-back mode %v mtime %v, want 0755 %v", fi.Mode().Perm(), fi.ModTime(), mtime)
+	}
+}
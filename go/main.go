@@ -1,8 +1,9 @@
-// Description: This Go program connects to a remote VM via SSH, deploys a chaos testing binary,
+// Description: This Go program connects to a remote VM over a native, host-key-verified SSH transport, deploys a chaos testing binary,
 // and listens for encrypted messages from the binary to log chaos events and update configuration files.
 // It uses AES-GCM for encryption and handles various message types including general logs, chaos reports, variable updates, and operation completion signals.
 // It ensures secure communication using a randomly generated token and encryption key for each session.
-// It also compiles the chaos binary with embedded configuration parameters and manages its lifecycle on the remote VM.
+// It also builds (or reuses a cached) chaos binary, hands it a sealed per-session configuration and a single-use key file,
+// and manages its lifecycle on the remote VM.
 package main
 
 import (
@@ -20,20 +21,25 @@ import (
 	"math/big"
 	"net"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	//	"golang.org/x/crypto/ssh"
+	"chaos-agent/library"
+	"chaos-agent/library/remote"
 	cryptohelpers "chaos-agent/library/ssh"
 	datatypes "chaos-agent/library/types"
 
 	"golang.org/x/crypto/nacl/box"
 )
 
+// cycleTimeout bounds the remote part of one chaos cycle (upload, run, cleanup).
+const cycleTimeout = 10 * time.Minute
+
 var (
 	activeTokens = make(map[string]bool)
 	tokenMutex   sync.Mutex
@@ -66,52 +72,29 @@ func getActiveTokenCount() int {
 	return len(activeTokens)
 }
 
-// scp the binary to the remote host using SSH config
-func scpUsingSSHConfig(host, localPath, remotePath string) error {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-	cfg := filepath.Join(home, ".ssh", "config")
+// sshPool holds one warm, host-key-verified SSH connection per testenv alias.
+var sshPool = remote.NewPool()
 
-	scpBin, err := exec.LookPath("scp")
+// runRemote runs a remote command over the warm connection, feeding stdin (if non-nil).
+// Exit status and output are captured into sess (if non-nil); a non-zero exit is an error.
+func runRemote(ctx context.Context, sess *sessionRecord, host, remoteCmd string, stdin io.Reader) (remote.Result, error) {
+	c, err := sshPool.Get(ctx, host)
 	if err != nil {
-		return err
+		return remote.Result{Host: host, Command: remoteCmd, ExitStatus: -1}, err
 	}
-
-	// #nosec G204 -- arguments are not user-controlled; exec.Command does not use a shell
-	cmd := exec.Command(scpBin,
-		"-F", cfg,
-		localPath,
-		fmt.Sprintf("%s:%s", host, remotePath),
-	)
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	return cmd.Run()
-}
-
-// run a remote command using SSH config
-func runRemote(host, remoteCmd string) error {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return err
+	res, err := c.Run(ctx, remoteCmd, stdin)
+	if sess != nil {
+		recordRun(sess, res)
 	}
-	cfg := filepath.Join(home, ".ssh", "config")
-
-	sshBin, err := exec.LookPath("ssh")
+	_, _ = os.Stdout.Write(res.Stdout)
+	_, _ = os.Stderr.Write(res.Stderr)
 	if err != nil {
-		return err
+		return res, err
 	}
-
-	// #nosec G204 -- arguments are not user-controlled; exec.Command does not use a shell
-	cmd := exec.Command(sshBin,
-		"-F", cfg,
-		"-o", "BatchMode=yes",
-		host,
-		"--",
-		remoteCmd,
-	)
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	return cmd.Run()
+	if res.ExitStatus != 0 {
+		return res, fmt.Errorf("remote command exited with status %d", res.ExitStatus)
+	}
+	return res, nil
 }
 
 func pickRandomFile(dir string) (string, error) {
@@ -270,6 +253,11 @@ func startAcceptLoopTimer(listener net.Listener, timeout time.Duration, connAcce
 	for {
 		select {
 		case <-timer.C:
+			// Armed breaks stay silent until they fire; keep listening until then.
+			if until := armedGraceUntil(); time.Now().Before(until) {
+				timer.Reset(time.Until(until) + timeout)
+				continue
+			}
 			fmt.Println("⏰ No messages received in 30 seconds, closing listener.")
 			if err := listener.Close(); err != nil {
 				fmt.Fprintf(os.Stderr, "error closing connection: %v\n", err)
@@ -295,57 +283,6 @@ func handleConnection(conn net.Conn, pub, priv *[32]byte) bool {
 	return handleChaosMessage(decryptedConn)
 }
 
-func compileChaosBinary(sourcePath, monitorIP string, port int, encryptionKey string) (string, error) {
-	outputPath := filepath.Join("/tmp", "break_tool")
-	ldflags := fmt.Sprintf(
-		"-X=main.MonitorIP=%s -X=main.MonitorPortStr=%s -X=main.EncryptionKey=%s",
-		monitorIP, strconv.Itoa(port), encryptionKey,
-	)
-
-	// Guardrail 1: only build files under ./breaks and with .go extension
-	absSrc, err := filepath.Abs(sourcePath)
-	if err != nil {
-		return "", fmt.Errorf("abs source: %w", err)
-	}
-	breaksDir, err := filepath.Abs("breaks")
-	if err != nil {
-		return "", fmt.Errorf("abs breaks: %w", err)
-	}
-	if filepath.Ext(absSrc) != ".go" || !strings.HasPrefix(absSrc, breaksDir+string(os.PathSeparator)) {
-		return "", fmt.Errorf("refusing to build untrusted source path: %q", absSrc)
-	}
-
-	// Guardrail 2: resolve the go tool explicitly
-	goBin, err := exec.LookPath("go")
-	if err != nil {
-		return "", fmt.Errorf("go tool not found: %w", err)
-	}
-
-	// Optional: timeout so builds can’t hang this ephemeral service
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
-
-	// #nosec G204 -- argv validated (source restricted to ./breaks/*.go); explicit tool path; no shell used
-	cmd := exec.CommandContext(ctx, goBin, "build", "-o", outputPath, "-ldflags", ldflags, absSrc)
-
-	// Guardrail 3: explicit env (avoid inherited GOFLAGS/-toolexec/etc.)
-	cmd.Env = []string{
-		"GOOS=linux",
-		"GOARCH=amd64",
-		"CGO_ENABLED=0",
-		"HOME=/tmp",
-		// clear potentially dangerous vars
-		"GOFLAGS=",
-		"GOTOOLCHAIN=local",
-	}
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to compile chaos binary: %w", err)
-	}
-	return outputPath, nil
-}
-
 // nolint:cyclop // TODO: split into small handlers (general, report, variable, opComplete)
 func handleChaosMessage(plaintext string) bool {
 	// Step 1: Decode JSON
@@ -374,7 +311,7 @@ func handleChaosMessage(plaintext string) bool {
 		}
 		return false
 
-	case "chaos_report", "error":
+	case "chaos_report", "error", "corruption", "target_selection", "meta_change":
 		fmt.Printf("🐛 Chaos Report: %s\n", msg.Message)
 		logPath := "/tmp/chaos_reports.log"
 		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
@@ -388,6 +325,61 @@ func handleChaosMessage(plaintext string) bool {
 		_ = f.Close()
 		return false
 
+	case "armed":
+		if err := noteArmed(msg.Token, msg.Message); err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+			return false
+		}
+		fmt.Printf("⏳ Armed %s, detonates at %s\n", msg.Token, msg.Message)
+		return false
+
+	case "detonated":
+		firedAt, late, err := noteDetonated(msg.Token, msg.Message)
+		if err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+			return false
+		}
+		fmt.Printf("💥 Detonated %s at %s (%s after plan)\n", msg.Token, firedAt.Format(time.RFC3339Nano), late)
+		return false
+
+	case "vault":
+		if err := storeVaultChunk(msg.Token, msg.Message); err != nil {
+			fmt.Printf("⚠️ vault: %v\n", err)
+		}
+		return false
+
+	case "manifest":
+		if err := saveManifest(msg.Token, msg.Message); err != nil {
+			fmt.Printf("⚠️ manifest: %v\n", err)
+		}
+		return false
+
+	case "jumble_mapping":
+		if err := saveMapping(msg.Token, msg.Message); err != nil {
+			fmt.Printf("⚠️ jumble mapping: %v\n", err)
+		}
+		return false
+
+	case "unrecovered":
+		fmt.Printf("🩹 Agent could not recover session %s without its vault key; sending it\n", msg.Token)
+		return false
+
+	case "recovered":
+		fmt.Printf("🩹 Recovered %s: %s\n", msg.Token, msg.Message)
+		return false
+
+	case "rollback":
+		if _, err := noteRollback(msg.Token, msg.Message); err != nil {
+			fmt.Printf("⚠️ rollback: %v\n", err)
+		}
+		return false
+
+	case "vault_key":
+		if err := saveVaultKey(msg.Token, msg.Message); err != nil {
+			fmt.Printf("⚠️ vault key: %v\n", err)
+		}
+		return false
+
 	case "general":
 		fmt.Printf("📢 General: %s\n", msg.Message)
 		return false
@@ -404,7 +396,28 @@ func handleChaosMessage(plaintext string) bool {
 	}
 }
 
-func runChaosCycle(breaksDir string) {
+// sealSessionConfig builds the break configuration for sess and seals it.
+// It returns the config key and the sealed blob, both base64.
+func sealSessionConfig(monitorAddr string, port int, publicKey string, sess *sessionRecord) (keyB64, blobB64 string, err error) {
+	vaultKey, err := newSessionVaultKey(sess.ID)
+	if err != nil {
+		return "", "", err
+	}
+	cfg := datatypes.BreakConfig{
+		MonitorIP:     monitorAddr,
+		MonitorPort:   port,
+		EncryptionKey: publicKey,
+		Seed:          sess.Seed,
+		Token:         sess.ID,
+		Params:        map[string]string{},
+		DelaySeconds:  int64(sess.Delay / time.Second),
+		VaultKey:      vaultKey,
+		VaultMode:     vaultMode(),
+	}
+	return library.SealBreakConfig(cfg)
+}
+
+func runChaosCycle(ctx context.Context, breaksDir string) {
 	scriptPath, err := pickRandomFile(breaksDir)
 	if err != nil {
 		log.Printf("Failed to pick test file: %v", err)
@@ -412,6 +425,12 @@ func runChaosCycle(breaksDir string) {
 	}
 	fmt.Printf("🎯 Selected test script: %s\n", scriptPath)
 
+	// A connected resident agent runs the break from its own registry: no build, upload or SSH.
+	if ac := connectedAgent("testenv"); ac != nil {
+		runChaosCycleOnAgent(ctx, ac, scriptPath)
+		return
+	}
+
 	// privatKey, publicKey, err := cryptohelpers.GenerateEd25519KeyPair()
 	publicKey, privatKey, err := cryptohelpers.GenerateKeys()
 	if err != nil {
@@ -452,34 +471,107 @@ func runChaosCycle(breaksDir string) {
 	fmt.Println("LISTENING ON PORT:", port)
 	fmt.Println("listner:", listener)
 
-	localBin, err := compileChaosBinary(scriptPath, monitorAddr, port, publicKey)
+	platCtx, platCancel := context.WithTimeout(ctx, time.Minute)
+	plat, err := detectPlatform(platCtx, "testenv")
+	platCancel()
+	if err != nil {
+		log.Printf("refusing target: %v", err)
+		return
+	}
+
+	localBin, err := compileChaosBinary(scriptPath, plat)
 	if err != nil {
 		log.Printf("error when compling binary: %s", err)
 		return
 	}
 	fmt.Println("COMPILED BINARY AT:", localBin)
 
-	const remoteBin = "/tmp/break_tool"
+	sess, err := newSession("testenv", scriptPath, localBin, pickDetonationDelay())
+	if err != nil {
+		log.Printf("failed to create session: %v", err)
+		return
+	}
+	defer finishSession(sess)
+	fmt.Printf("SESSION %s: %s -> %s:%s (delay %s, seed %s)\n", sess.ID, sess.LocalBin, sess.Host, sess.RemoteBin, sess.Delay, sess.Seed)
 
-	if err := scpUsingSSHConfig("testenv", localBin, remoteBin); err != nil {
-		log.Printf("scp failed: %v", err)
+	keyB64, blobB64, err := sealSessionConfig(monitorAddr, port, publicKey, sess)
+	if err != nil {
+		log.Printf("failed to seal break config: %v", err)
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, cycleTimeout+sess.Delay)
+	defer cancel()
+
 	start := time.Now()
-	if err := runRemote("testenv", remoteBin); err != nil {
-		log.Printf("remote run failed: %v", err)
+	if err := launchBreakUnit(ctx, sess, keyB64, blobB64); err != nil {
+		log.Printf("launch failed: %v", err)
+		cleanupRemote(sess)
+		return
+	}
+	st, runErr := followBreakUnit(ctx, sess)
+	// ExecStopPost removes the binary and config; confirm, so nothing is left to reverse.
+	cleanupRemote(sess)
+	if runErr != nil {
+		log.Printf("remote run failed: %v", runErr)
 		return
 	}
-	dur := time.Since(start)
-	log.Printf("remote run finished in %s", dur)
+	log.Printf("unit %s finished in %s (result=%s status=%d)", sess.Unit, time.Since(start), st.Result, st.ExecMainStatus)
 
 	// Wait for the accept loop to finish (triggered by operation_complete)
-	// If runRemote fails above, we return, listener closes, acceptLoop exits, wg is Done.
+	// If the unit fails above, we return, listener closes, acceptLoop exits, wg is Done.
 	wg.Wait()
 }
 
+// runChaosCycleOnAgent runs the break at scriptPath through a resident agent.
+func runChaosCycleOnAgent(ctx context.Context, ac *agentConn, scriptPath string) {
+	sess, err := newSession(ac.ID, scriptPath, "", pickDetonationDelay())
+	if err != nil {
+		log.Printf("failed to create session: %v", err)
+		return
+	}
+	defer finishSession(sess)
+	fmt.Printf("SESSION %s: agent %s (delay %s, seed %s)\n", sess.ID, ac.ID, sess.Delay, sess.Seed)
+
+	ctx, cancel := context.WithTimeout(ctx, cycleTimeout+sess.Delay)
+	defer cancel()
+	start := time.Now()
+	if err := runAgentCycle(ctx, ac, filepath.Base(filepath.Dir(scriptPath)), sess); err != nil {
+		log.Printf("agent run failed: %v", err)
+		if cerr := cancelAgentBreak(ctx, sess); cerr != nil {
+			log.Printf("cancel on agent: %v", cerr)
+		}
+		return
+	}
+	log.Printf("agent %s finished %s in %s", ac.ID, sess.ID, time.Since(start))
+}
+
+// cleanupRemote confirms the session's remote artifacts are gone, removing any leftovers.
+func cleanupRemote(sess *sessionRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := ensureRemoteGone(ctx, sess.Host, sess.RemoteBin, sess.RemoteConfig, sess.RemoteKey); err != nil {
+		log.Printf("remote artifacts for %s not confirmed removed: %v", sess.ID, err)
+		return
+	}
+	markRemoteGone(sess)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install-agent" {
+		os.Exit(runInstallAgent(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		os.Exit(runRollback(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:]))
+	}
+
+	// SIGINT/SIGTERM abort the running cycle; its unit is stopped before we exit.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Pick initial random long interval (5–7 minutes)
 	longInterval, err := rand.Int(rand.Reader, big.NewInt(121)) // 0..120
 	if err != nil {
@@ -490,8 +582,42 @@ func main() {
 
 	counter := int64(0)
 
-	for {
-		runChaosCycle("./breaks/cheap")
+	defer sshPool.Close()
+
+	// SIGUSR1 disarms every break that is armed but has not fired yet.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			dctx, cancel := context.WithTimeout(ctx, time.Minute)
+			disarmAll(dctx)
+			cancel()
+		}
+	}()
+
+	// SIGUSR2 rolls the testenv back to before every outstanding session.
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			if n := rollbackAll(ctx, "testenv"); n > 0 {
+				log.Printf("rollback: %d session(s) not fully restored", n)
+			}
+		}
+	}()
+
+	if addr := os.Getenv("CHAOS_AGENT_LISTEN"); addr != "" {
+		if err := startAgentListener(ctx, addr); err != nil {
+			log.Printf("agent listener disabled: %v", err)
+		}
+	}
+
+	stopSweeper := make(chan struct{})
+	defer close(stopSweeper)
+	go startSweeper(sweepInterval, stopSweeper)
+
+	for ctx.Err() == nil {
+		runChaosCycle(ctx, "./breaks/cheap")
 
 		// Random sleep for short interval (60–120s)
 		n, err := rand.Int(rand.Reader, big.NewInt(61)) // 0..60
@@ -502,7 +628,11 @@ func main() {
 		shortSleepSecs := n.Int64() + 60
 		fmt.Printf("✅ Long interval %s", time.Duration(longIntervalSecs)*time.Second)
 		fmt.Printf("Sleeping for %d seconds...\n", shortSleepSecs)
-		time.Sleep(time.Duration(shortSleepSecs) * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(shortSleepSecs) * time.Second):
+		}
 
 		// Increment counter by short interval sleep
 		counter += shortSleepSecs
@@ -510,7 +640,7 @@ func main() {
 		// Check if long interval has been reached
 		if counter >= longIntervalSecs {
 			fmt.Println("✅ Long interval reached, running additional chaos cycle")
-			runChaosCycle("./breaks/expensive")
+			runChaosCycle(ctx, "./breaks/expensive")
 
 			// Reset counter and pick a new random long interval
 			counter = 0
@@ -0,0 +1,295 @@
+// Description: Keeps each session's hash manifest and verifies a testenv against it.
+// Breaks report the SHA-256, size, mode and owner of every path they mutated, before and
+// after. `chaos-agent verify [-host H] <token>` reads the same facts from the testenv now and
+// classifies each path as original (fixed), mutated (still broken) or modified (changed,
+// but to neither), naming the path whose original content it holds when that is another
+// mutated path's (a swap put back the wrong way), instead of guessing with rpm -V or file(1).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chaos-agent/library"
+	"chaos-agent/library/remote"
+	"chaos-agent/library/vault"
+)
+
+// sessionStatePath is token's JSON file in the monitor state subdirectory sub.
+func sessionStatePath(sub, token string) (string, error) {
+	if !vault.ValidToken(token) {
+		return "", fmt.Errorf("invalid session token %q", token)
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sub, token+".json"), nil
+}
+
+func manifestPath(token string) (string, error) {
+	return sessionStatePath("manifests", token)
+}
+
+// saveManifest stores a session's "manifest" report.
+func saveManifest(token, message string) error {
+	var m library.Manifest
+	if err := json.Unmarshal([]byte(message), &m); err != nil {
+		return fmt.Errorf("decode manifest: %w", err)
+	}
+	if m.Token != token {
+		return fmt.Errorf("manifest for %q sent by session %q", m.Token, token)
+	}
+	p, err := manifestPath(token)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🧾 Manifest %s: %d path(s)\n", token, len(m.Entries))
+	return os.WriteFile(p, append(b, '\n'), 0o600)
+}
+
+// saveMapping stores a session's "jumble_mapping" report. A session may jumble more than
+// once; the mappings accumulate.
+func saveMapping(token, message string) error {
+	var jm library.JumbleMapping
+	if err := json.Unmarshal([]byte(message), &jm); err != nil {
+		return fmt.Errorf("decode jumble mapping: %w", err)
+	}
+	p, err := sessionStatePath("mappings", token)
+	if err != nil {
+		return err
+	}
+	all, err := loadMappings(token)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	all = append(all, jm)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🔀 Jumble %s (%s): %d move(s)\n", token, jm.Mode, len(jm.Moves))
+	return os.WriteFile(p, append(b, '\n'), 0o600)
+}
+
+func loadMappings(token string) ([]library.JumbleMapping, error) {
+	p, err := sessionStatePath("mappings", token)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- monitor's own state file, token validated above
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var all []library.JumbleMapping
+	return all, json.Unmarshal(b, &all)
+}
+
+func loadManifest(token string) (library.Manifest, error) {
+	var m library.Manifest
+	p, err := manifestPath(token)
+	if err != nil {
+		return m, err
+	}
+	// #nosec G304 -- monitor's own state file, token validated above
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return m, err
+	}
+	return m, json.Unmarshal(b, &m)
+}
+
+// pathHistory is a path's state before the session's first mutation of it and after its last.
+type pathHistory struct {
+	Path          string
+	Before, After library.FileState
+}
+
+func manifestPaths(m library.Manifest) []pathHistory {
+	var out []pathHistory
+	idx := make(map[string]int)
+	for _, e := range m.Entries {
+		if i, ok := idx[e.Path]; ok {
+			out[i].After = e.After
+			continue
+		}
+		idx[e.Path] = len(out)
+		out = append(out, pathHistory{Path: e.Path, Before: e.Before, After: e.After})
+	}
+	return out
+}
+
+// remoteStates reads paths' current state on host, as CaptureFileState would there.
+func remoteStates(ctx context.Context, host string, paths []string) (map[string]library.FileState, error) {
+	res, err := runRemote(ctx, nil, host, "sh -s", strings.NewReader(remoteStateScript(paths)))
+	if err != nil {
+		return nil, err
+	}
+	return parseRemoteStates(res.Stdout), nil
+}
+
+// remoteStateScript prints a NUL-terminated record per path, so any path survives:
+// "<path> -" for a missing one, else "<path> <kind> <size perm uid gid> <hash>" where kind is
+// f (regular), l (symlink, whose target replaces the hash), d or o (anything else, no hash).
+func remoteStateScript(paths []string) string {
+	var script strings.Builder
+	script.WriteString("for p in")
+	for _, p := range paths {
+		script.WriteString(" " + remote.ShellQuote(p))
+	}
+	script.WriteString(`; do
+  if [ -L "$p" ]; then
+    t=$(readlink -- "$p"; echo x)
+    printf '%s\0l\0%s\0%s\0' "$p" "$(stat -c '%s %a %u %g' -- "$p")" "${t%?x}"
+  elif [ -f "$p" ]; then
+    printf '%s\0f\0%s\0%s\0' "$p" "$(stat -c '%s %a %u %g' -- "$p")" "$(sha256sum < "$p" | cut -d' ' -f1)"
+  elif [ -d "$p" ]; then
+    printf '%s\0d\0%s\0\0' "$p" "$(stat -c '%s %a %u %g' -- "$p")"
+  elif [ -e "$p" ]; then
+    printf '%s\0o\0%s\0\0' "$p" "$(stat -c '%s %a %u %g' -- "$p")"
+  else
+    printf '%s\0-\0' "$p"
+  fi
+done
+`)
+	return script.String()
+}
+
+// parseRemoteStates reads remoteStateScript's records.
+func parseRemoteStates(out []byte) map[string]library.FileState {
+	states := make(map[string]library.FileState)
+	f := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(f); {
+		path, kind := f[i], f[i+1]
+		if kind == "-" || i+3 >= len(f) {
+			states[path] = library.FileState{}
+			i += 2
+			continue
+		}
+		st := library.FileState{Exists: true}
+		var perm uint32
+		if _, err := fmt.Sscanf(f[i+2], "%d %o %d %d", &st.Size, &perm, &st.UID, &st.GID); err != nil {
+			st.Error = "unreadable stat: " + f[i+2]
+		}
+		st.Mode = os.FileMode(perm) // permission bits; compared through library.UnixMode
+		switch kind {
+		case "f":
+			st.SHA256 = f[i+3]
+		case "l":
+			st.Mode |= os.ModeSymlink
+			st.Link = f[i+3]
+		case "d":
+			st.Mode |= os.ModeDir
+		default:
+			st.Mode |= os.ModeIrregular
+		}
+		states[path] = st
+		i += 4
+	}
+	return states
+}
+
+// classifyPath says whether now is the original, the mutated result, or something else.
+func classifyPath(h pathHistory, now library.FileState) string {
+	switch {
+	case !now.Exists && !h.Before.Exists:
+		return "original"
+	case !now.Exists:
+		return "missing"
+	case now.SameContent(h.Before):
+		var drift []string
+		if library.UnixMode(now.Mode) != library.UnixMode(h.Before.Mode) {
+			drift = append(drift, fmt.Sprintf("mode %o, was %o", library.UnixMode(now.Mode), library.UnixMode(h.Before.Mode)))
+		}
+		if now.UID != h.Before.UID || now.GID != h.Before.GID {
+			drift = append(drift, fmt.Sprintf("owner %d:%d, was %d:%d", now.UID, now.GID, h.Before.UID, h.Before.GID))
+		}
+		if len(drift) > 0 {
+			return "original content (" + strings.Join(drift, "; ") + ")"
+		}
+		return "original"
+	case now.SameContent(h.After):
+		return "mutated"
+	default:
+		return "modified"
+	}
+}
+
+// verifyManifest reports each of token's mutated paths on host, and whether all are original.
+func verifyManifest(ctx context.Context, host, token string) (bool, error) {
+	m, err := loadManifest(token)
+	if err != nil {
+		return false, fmt.Errorf("manifest for %s: %w", token, err)
+	}
+	hist := manifestPaths(m)
+	paths := make([]string, len(hist))
+	for i, h := range hist {
+		paths[i] = h.Path
+	}
+	states, err := remoteStates(ctx, host, paths)
+	if err != nil {
+		return false, err
+	}
+	// Whose original content each hash is, so swapped content can be named.
+	owner := make(map[string]string, len(hist))
+	for _, h := range hist {
+		if h.Before.Exists && h.Before.SHA256 != "" {
+			owner[h.Before.SHA256] = h.Path
+		}
+	}
+	ok := true
+	for _, h := range hist {
+		now := states[h.Path]
+		status := classifyPath(h, now)
+		if status != "original" {
+			ok = false
+		}
+		if o := owner[now.SHA256]; now.SHA256 != "" && o != "" && o != h.Path {
+			fmt.Printf("%-10s %s (holds %s)\n", status, h.Path, o)
+			continue
+		}
+		fmt.Printf("%-10s %s\n", status, h.Path)
+	}
+	return ok, nil
+}
+
+// runVerify handles the verify subcommand.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	host := flags.String("host", "testenv", "testenv host alias")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: chaos-agent verify [-host H] <token>")
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	defer sshPool.Close()
+	ok, err := verifyManifest(ctx, *host, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 1
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
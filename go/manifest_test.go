@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"chaos-agent/library"
+)
+
+// TestRemoteStatesMatchCapture runs remoteStateScript with the local sh, over awkward names,
+// and checks it reads what CaptureFileState does.
+func TestRemoteStatesMatchCapture(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "plain file")
+	tabbed := filepath.Join(dir, "tab\there")
+	lined := filepath.Join(dir, "new\nline")
+	link := filepath.Join(dir, "link")
+	missing := filepath.Join(dir, "missing")
+	for i, p := range []string{file, tabbed, lined} {
+		if err := os.WriteFile(p, []byte(strings.Repeat("x", i+1)), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The target is relative and dangling, so nothing outside dir is read, and ends in a newline.
+	if err := os.Symlink("elsewhere/f'\n", link); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{file, tabbed, lined, link, missing, dir}
+
+	cmd := exec.Command("sh", "-s")
+	cmd.Stdin = strings.NewReader(remoteStateScript(paths))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parseRemoteStates(out)
+	if len(got) != len(paths) {
+		t.Errorf("read %d states, want %d: %+v", len(got), len(paths), got)
+	}
+	for _, p := range paths {
+		want := library.CaptureFileState(p)
+		g, ok := got[p]
+		switch {
+		case !ok:
+			t.Errorf("%q: no state", p)
+		case g.Exists != want.Exists || g.SHA256 != want.SHA256 || g.Link != want.Link ||
+			g.Mode.Type() != want.Mode.Type() || library.UnixMode(g.Mode) != library.UnixMode(want.Mode) ||
+			g.UID != want.UID || g.GID != want.GID || g.Error != "":
+			t.Errorf("%q: remote %+v, local %+v", p, g, want)
+		}
+		if p != dir && want.Exists && g.Size != want.Size {
+			t.Errorf("%q: remote size %d, local %d", p, g.Size, want.Size)
+		}
+	}
+
+	// A symlink put back as it was is the original, not modified.
+	h := pathHistory{Path: link, Before: library.CaptureFileState(link)}
+	if got := classifyPath(h, got[link]); got != "original" {
+		t.Errorf("restored symlink classified %q", got)
+	}
+}
@@ -0,0 +1,83 @@
+// Description: Target platform detection for break builds.
+// Each testenv's architecture is probed once (uname -m) and cached, so the break can be
+// built for the matching GOARCH/GOARM instead of assuming amd64.
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// targetPlatform is the Go build target for one testenv.
+type targetPlatform struct {
+	GOARCH string
+	GOARM  string // only for GOARCH=arm
+}
+
+func (p targetPlatform) String() string {
+	if p.GOARM != "" {
+		return p.GOARCH + "v" + p.GOARM
+	}
+	return p.GOARCH
+}
+
+// supportedMachines maps `uname -m` output to the build target we ship for it.
+// Anything not listed has no supported build and is rejected.
+var supportedMachines = map[string]targetPlatform{
+	"x86_64":  {GOARCH: "amd64"},
+	"amd64":   {GOARCH: "amd64"},
+	"aarch64": {GOARCH: "arm64"},
+	"arm64":   {GOARCH: "arm64"},
+	"armv7l":  {GOARCH: "arm", GOARM: "7"},
+	"armv6l":  {GOARCH: "arm", GOARM: "6"},
+	"i686":    {GOARCH: "386"},
+	"i386":    {GOARCH: "386"},
+	"ppc64le": {GOARCH: "ppc64le"},
+	"s390x":   {GOARCH: "s390x"},
+	"riscv64": {GOARCH: "riscv64"},
+}
+
+var (
+	hostPlatforms   = make(map[string]targetPlatform)
+	hostPlatformsMu sync.Mutex
+)
+
+// platformForMachine resolves a `uname -m` string to a build target.
+func platformForMachine(machine string) (targetPlatform, error) {
+	machine = strings.TrimSpace(machine)
+	p, ok := supportedMachines[machine]
+	if !ok {
+		return targetPlatform{}, fmt.Errorf("unsupported target architecture %q", machine)
+	}
+	return p, nil
+}
+
+// recordHostMachine caches a host's architecture learned some other way (e.g. agent init facts).
+func recordHostMachine(host, machine string) (targetPlatform, error) {
+	p, err := platformForMachine(machine)
+	if err != nil {
+		return p, fmt.Errorf("%s: %w", host, err)
+	}
+	hostPlatformsMu.Lock()
+	hostPlatforms[host] = p
+	hostPlatformsMu.Unlock()
+	return p, nil
+}
+
+// detectPlatform returns host's cached build target, probing it once with `uname -m`.
+func detectPlatform(ctx context.Context, host string) (targetPlatform, error) {
+	hostPlatformsMu.Lock()
+	p, ok := hostPlatforms[host]
+	hostPlatformsMu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	res, err := runRemote(ctx, nil, host, "uname -m", nil)
+	if err != nil {
+		return targetPlatform{}, fmt.Errorf("detect architecture of %s: %w", host, err)
+	}
+	return recordHostMachine(host, string(res.Stdout))
+}
@@ -0,0 +1,228 @@
+// Description: Rolls a testenv back to its state before one or more sessions.
+// Every file a break mutates has its original sealed into that session's vault first; rollback
+// restores content and metadata from it, verifies each path by hash and reports what it could
+// not restore. A connected resident agent does the work itself; otherwise a one-shot rollback
+// tool is uploaded and run over SSH. Originals streamed to the monitor ("monitor" vault mode)
+// are uploaded back to the testenv first.
+// Triggers: `chaos-agent rollback [-host H] <token>...|all`, or SIGUSR2 on a running monitor
+// (disarms armed breaks, then rolls back every outstanding session).
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chaos-agent/library/breaks"
+	"chaos-agent/library/channel"
+	"chaos-agent/library/remote"
+	datatypes "chaos-agent/library/types"
+	"chaos-agent/library/vault"
+)
+
+// rollbackTimeout bounds rolling back one session.
+const rollbackTimeout = 5 * time.Minute
+
+var (
+	rollbackReports   = make(map[string]vault.Report)
+	rollbackReportsMu sync.Mutex
+)
+
+// noteRollback records a session's rollback report and retires the session if it rolled back cleanly.
+func noteRollback(token, message string) (vault.Report, error) {
+	var rep vault.Report
+	if err := json.Unmarshal([]byte(message), &rep); err != nil {
+		return rep, fmt.Errorf("decode rollback report: %w", err)
+	}
+	if rep.Token != token {
+		return rep, fmt.Errorf("rollback report for %q sent by session %q", rep.Token, token)
+	}
+	rollbackReportsMu.Lock()
+	rollbackReports[token] = rep
+	rollbackReportsMu.Unlock()
+
+	fmt.Printf("⏪ Rollback %s: %d restored, %d removed, %d failed\n", token, len(rep.Restored), len(rep.Removed), len(rep.Failed))
+	for _, f := range rep.Failed {
+		fmt.Printf("   ❌ %s: %s\n", f.Path, f.Error)
+	}
+	if !rep.OK() {
+		return rep, nil
+	}
+	return rep, markRolledBack(token)
+}
+
+func takeRollbackReport(token string) (vault.Report, bool) {
+	rollbackReportsMu.Lock()
+	defer rollbackReportsMu.Unlock()
+	rep, ok := rollbackReports[token]
+	delete(rollbackReports, token)
+	return rep, ok
+}
+
+// rollbackSession rolls host back to its state before session token.
+func rollbackSession(ctx context.Context, host, token string) (vault.Report, error) {
+	key, err := sessionVaultKey(token)
+	if err != nil {
+		return vault.Report{Token: token}, err
+	}
+	if err := uploadMonitorVault(ctx, host, token); err != nil {
+		return vault.Report{Token: token}, fmt.Errorf("upload vault: %w", err)
+	}
+	cfg := datatypes.BreakConfig{Token: token, VaultKey: key}
+
+	if ac := connectedAgent(host); ac != nil {
+		err := runAgentInstruction(ctx, ac, channel.Instruction{Break: breaks.RollbackName, Token: token, VaultKey: key})
+		if err != nil {
+			return vault.Report{Token: token}, err
+		}
+		rep, ok := takeRollbackReport(token)
+		if !ok {
+			return rep, fmt.Errorf("agent %s sent no rollback report for %s", ac.ID, token)
+		}
+		return rep, nil
+	}
+	return rollbackOverSSH(ctx, host, cfg)
+}
+
+// rollbackOverSSH uploads the rollback tool, runs it with cfg on stdin and reads its report.
+func rollbackOverSSH(ctx context.Context, host string, cfg datatypes.BreakConfig) (vault.Report, error) {
+	plat, err := detectPlatform(ctx, host)
+	if err != nil {
+		return vault.Report{Token: cfg.Token}, err
+	}
+	bin, err := compileRollbackBinary(plat)
+	if err != nil {
+		return vault.Report{Token: cfg.Token}, fmt.Errorf("build rollback tool: %w", err)
+	}
+	c, err := sshPool.Get(ctx, host)
+	if err != nil {
+		return vault.Report{Token: cfg.Token}, err
+	}
+	remoteBin := remoteSessionPrefix + "rollback-" + cfg.Token
+	if err := c.Upload(ctx, bin, remoteBin, 0o700); err != nil {
+		return vault.Report{Token: cfg.Token}, fmt.Errorf("upload rollback tool: %w", err)
+	}
+	defer func() {
+		if err := c.Remove(remoteBin); err != nil {
+			log.Printf("remove %s on %s: %v", remoteBin, host, err)
+		}
+	}()
+
+	in, err := json.Marshal(cfg)
+	if err != nil {
+		return vault.Report{Token: cfg.Token}, err
+	}
+	// A non-zero exit with a report means some paths failed; the report says which.
+	res, runErr := runRemote(ctx, nil, host, remote.ShellQuote(remoteBin), bytes.NewReader(in))
+	out := bytes.TrimSpace(res.Stdout)
+	if len(out) == 0 {
+		if runErr == nil {
+			runErr = errors.New("rollback tool printed no report")
+		}
+		return vault.Report{Token: cfg.Token}, runErr
+	}
+	return noteRollback(cfg.Token, string(out))
+}
+
+// uploadMonitorVault copies a vault the testenv streamed to the monitor back into the
+// testenv's local vault, where the rollback runs. Sessions with a local vault have nothing here.
+func uploadMonitorVault(ctx context.Context, host, token string) error {
+	store, err := monitorVault()
+	if err != nil {
+		return err
+	}
+	entries, err := store.Entries(token)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	dir, err := store.Dir(token)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.blob"))
+	if err != nil {
+		return err
+	}
+	files = append(files, filepath.Join(dir, "index.jsonl"))
+
+	remoteDir := filepath.Join(vault.DefaultDir, token)
+	if _, err := runRemote(ctx, nil, host, "install -d -m 0700 "+remote.ShellQuote(remoteDir), nil); err != nil {
+		return err
+	}
+	c, err := sshPool.Get(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := c.Upload(ctx, f, filepath.Join(remoteDir, filepath.Base(f)), 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackSessions rolls host back for each token, returning how many did not roll back cleanly.
+func rollbackSessions(ctx context.Context, host string, tokens []string) int {
+	failed := 0
+	for _, token := range tokens {
+		sctx, cancel := context.WithTimeout(ctx, rollbackTimeout)
+		rep, err := rollbackSession(sctx, host, token)
+		cancel()
+		switch {
+		case err != nil:
+			log.Printf("rollback %s on %s: %v", token, host, err)
+			failed++
+		case !rep.OK():
+			failed++
+		}
+	}
+	return failed
+}
+
+// rollbackAll disarms armed breaks and rolls back every outstanding session on host.
+func rollbackAll(ctx context.Context, host string) int {
+	disarmAll(ctx)
+	tokens, err := outstandingSessions()
+	if err != nil {
+		log.Printf("list outstanding sessions: %v", err)
+		return 1
+	}
+	if len(tokens) == 0 {
+		fmt.Println("⏪ No outstanding sessions to roll back")
+		return 0
+	}
+	return rollbackSessions(ctx, host, tokens)
+}
+
+// runRollback handles the rollback subcommand.
+func runRollback(args []string) int {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	host := flags.String("host", "testenv", "testenv host alias")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: chaos-agent rollback [-host H] <token>...|all")
+		return 2
+	}
+	ctx := context.Background()
+	defer sshPool.Close()
+
+	var failed int
+	if flags.NArg() == 1 && strings.EqualFold(flags.Arg(0), "all") {
+		failed = rollbackAll(ctx, *host)
+	} else {
+		failed = rollbackSessions(ctx, *host, flags.Args())
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "rollback: %d session(s) not fully restored\n", failed)
+		return 1
+	}
+	return 0
+}
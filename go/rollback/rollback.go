@@ -0,0 +1,32 @@
+// Description: One-shot rollback tool for the testenv.
+// The monitor uploads it over SSH when no resident agent is connected and feeds it the
+// session token and vault key as JSON on stdin. It restores every original the session kept
+// in the local vault, prints the report as JSON on stdout, and exits non-zero if any path
+// could not be restored.
+package main
+
+import (
+	"chaos-agent/library/breaks"
+	datatypes "chaos-agent/library/types"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	var cfg datatypes.BreakConfig
+	if err := json.NewDecoder(io.LimitReader(os.Stdin, 64*1024)).Decode(&cfg); err != nil {
+		log.Fatalf("read rollback config: %v", err)
+	}
+	rep, err := breaks.RollbackLocal(cfg)
+	if err != nil {
+		log.Fatalf("rollback %s: %v", cfg.Token, err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(rep); err != nil {
+		log.Fatalf("write report: %v", err)
+	}
+	if !rep.OK() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,314 @@
+// Description: Per-session artifact bookkeeping for the monitor.
+// Every chaos cycle gets its own local staging directory and its own remote path, so
+// concurrent cycles never clobber each other. A sweeper removes stale local artifacts
+// and verifies the remote copies are gone.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chaos-agent/library"
+	"chaos-agent/library/remote"
+)
+
+const (
+	// sessionTTL is how long an unfinished session may overrun its own deadline before it is
+	// presumed dead, and how old an unowned session dir or partial build must be before it is
+	// swept. A finished session's artifacts go on the next sweep, whatever its age.
+	sessionTTL = 30 * time.Minute
+	// sweepInterval is how often the sweeper runs.
+	sweepInterval = 5 * time.Minute
+	// remoteSessionPrefix is the remote path prefix for per-session break binaries.
+	remoteSessionPrefix = "/tmp/.chaos-"
+)
+
+// sessionRecord tracks one chaos cycle's artifacts and outcome.
+type sessionRecord struct {
+	ID           string
+	Host         string
+	Source       string
+	LocalDir     string
+	LocalBin     string
+	RemoteBin    string
+	RemoteConfig string        // single-use sealed config file next to RemoteBin
+	RemoteKey    string        // single-use 0600 file holding the config key, next to RemoteBin
+	Seed         string        // keys every random choice the break makes; CHAOS_SEED replays one
+	Delay        time.Duration // detonation delay handed to the break
+	Started      time.Time
+	Finished     time.Time
+	RemoteGone   bool
+	Runs         []remote.Result // every remote command run for this session, with exit status and output
+
+	Agent      string            // resident agent running the break, instead of a unit
+	Unit       string            // transient systemd unit running the break
+	UnitStatus remote.UnitStatus // final unit state, once collected
+	UnitLogs   []byte            // the unit's journal, once collected
+}
+
+var (
+	sessions   = make(map[string]*sessionRecord)
+	sessionsMu sync.Mutex
+)
+
+// sessionRoot is where per-session local staging directories live.
+func sessionRoot() string {
+	if d := os.Getenv("CHAOS_SESSION_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(os.TempDir(), "chaos-sessions")
+}
+
+// newSession allocates unique local and remote paths for one cycle and stages
+// the cached binary into the session's local directory. An empty cachedBin means
+// the break runs on a resident agent and has no artifacts to stage.
+func newSession(host, source, cachedBin string, delay time.Duration) (*sessionRecord, error) {
+	id, err := library.GenerateToken(12)
+	if err != nil {
+		return nil, fmt.Errorf("generate session id: %w", err)
+	}
+	root := sessionRoot()
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("create session root: %w", err)
+	}
+	dir, err := os.MkdirTemp(root, "session-"+id+"-")
+	if err != nil {
+		return nil, fmt.Errorf("create session dir: %w", err)
+	}
+	seed := os.Getenv("CHAOS_SEED")
+	if seed == "" {
+		if seed, err = library.GenerateToken(32); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, fmt.Errorf("generate seed: %w", err)
+		}
+	}
+	s := &sessionRecord{
+		ID:       id,
+		Host:     host,
+		Source:   source,
+		LocalDir: dir,
+		Seed:     seed,
+		Delay:    delay,
+		Started:  time.Now(),
+	}
+	if cachedBin == "" {
+		// Run by a resident agent: nothing is staged or copied to the host.
+		s.RemoteGone = true
+	} else {
+		s.LocalBin = filepath.Join(dir, "break_tool")
+		if err := linkOrCopy(cachedBin, s.LocalBin); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, fmt.Errorf("stage binary: %w", err)
+		}
+		s.RemoteBin = remoteSessionPrefix + id
+		s.RemoteConfig = remoteSessionPrefix + id + ".cfg"
+		s.RemoteKey = remoteSessionPrefix + id + ".key"
+	}
+	sessionsMu.Lock()
+	sessions[id] = s
+	sessionsMu.Unlock()
+	return s, nil
+}
+
+// deadline is when s's cycle gives up: its detonation delay plus the cycle timeout.
+func (s *sessionRecord) deadline() time.Time {
+	return s.Started.Add(s.Delay + cycleTimeout)
+}
+
+// finishSession marks s finished; its artifacts become eligible for sweeping.
+func finishSession(s *sessionRecord) {
+	sessionsMu.Lock()
+	s.Finished = time.Now()
+	sessionsMu.Unlock()
+}
+
+// recordRun appends a remote command result to s.
+func recordRun(s *sessionRecord, res remote.Result) {
+	sessionsMu.Lock()
+	s.Runs = append(s.Runs, res)
+	sessionsMu.Unlock()
+}
+
+// setSessionUnit records the transient unit running s's break.
+func setSessionUnit(s *sessionRecord, unit string) {
+	sessionsMu.Lock()
+	s.Unit = unit
+	sessionsMu.Unlock()
+}
+
+// recordUnitStatus stores the unit's final state in s.
+func recordUnitStatus(s *sessionRecord, st remote.UnitStatus) {
+	sessionsMu.Lock()
+	s.UnitStatus = st
+	sessionsMu.Unlock()
+}
+
+// recordUnitLogs stores the unit's journal in s.
+func recordUnitLogs(s *sessionRecord, logs []byte) {
+	sessionsMu.Lock()
+	s.UnitLogs = logs
+	sessionsMu.Unlock()
+}
+
+// markRemoteGone records that s's remote binary was confirmed removed.
+func markRemoteGone(s *sessionRecord) {
+	sessionsMu.Lock()
+	s.RemoteGone = true
+	sessionsMu.Unlock()
+}
+
+// linkOrCopy hard-links src to dst, copying when a link is not possible (e.g. across filesystems).
+func linkOrCopy(src, dst string) (err error) {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	// #nosec G304 -- src is our own build cache entry
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	// #nosec G302 G304 -- executable staged for upload, owner-only
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o700)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil {
+			err = errors.Join(err, cerr)
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// ensureRemoteGone removes paths on host if they are still there and verifies they are gone.
+func ensureRemoteGone(ctx context.Context, host string, paths ...string) error {
+	c, err := sshPool.Get(ctx, host)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range paths {
+		if err := c.Remove(p); err != nil {
+			errs = append(errs, fmt.Errorf("remove %s: %w", p, err))
+			continue
+		}
+		present, err := c.Exists(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stat %s: %w", p, err))
+		} else if present {
+			errs = append(errs, fmt.Errorf("%s still present", p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startSweeper runs sweepOnce every interval until stop is closed.
+func startSweeper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sweepOnce(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweepOnce deletes stale local session artifacts (including ones left behind by
+// earlier monitor processes) and verifies remote copies of finished sessions are gone.
+// An unfinished session is left alone until sessionTTL past its deadline: a delayed break
+// may legitimately run longer than sessionTTL.
+func sweepOnce(now time.Time) {
+	sessionsMu.Lock()
+	var due []*sessionRecord
+	for id, s := range sessions {
+		if s.Finished.IsZero() && now.Before(s.deadline().Add(sessionTTL)) {
+			continue
+		}
+		due = append(due, s)
+		if s.RemoteGone {
+			delete(sessions, id)
+		}
+	}
+	sessionsMu.Unlock()
+
+	for _, s := range due {
+		if err := os.RemoveAll(s.LocalDir); err != nil {
+			log.Printf("sweeper: remove %s: %v", s.LocalDir, err)
+		}
+		if s.RemoteGone {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if s.Finished.IsZero() {
+			// Session overran its deadline without finishing: make sure its break is not still running.
+			if err := stopSessionUnit(ctx, s); err != nil {
+				log.Printf("sweeper: stop unit %s: %v", s.Unit, err)
+			}
+		}
+		err := ensureRemoteGone(ctx, s.Host, s.RemoteBin, s.RemoteConfig, s.RemoteKey)
+		cancel()
+		if err != nil {
+			log.Printf("sweeper: remote copy %s:%s not confirmed gone: %v", s.Host, s.RemoteBin, err)
+			continue
+		}
+		markRemoteGone(s)
+	}
+
+	sweepOrphans(sessionRoot(), now)
+	if root, err := buildCacheDir(); err == nil {
+		sweepPartialBuilds(root, now)
+	}
+}
+
+// sweepOrphans removes session directories older than sessionTTL that no live session owns.
+func sweepOrphans(root string, now time.Time) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	sessionsMu.Lock()
+	owned := make(map[string]struct{}, len(sessions))
+	for _, s := range sessions {
+		owned[s.LocalDir] = struct{}{}
+	}
+	sessionsMu.Unlock()
+
+	for _, e := range entries {
+		p := filepath.Join(root, e.Name())
+		if _, ok := owned[p]; ok || !strings.HasPrefix(e.Name(), "session-") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < sessionTTL {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			log.Printf("sweeper: remove orphan %s: %v", p, err)
+		}
+	}
+}
+
+// sweepPartialBuilds removes temp outputs of builds that never completed.
+func sweepPartialBuilds(root string, now time.Time) {
+	matches, _ := filepath.Glob(filepath.Join(root, "*", "*", "*", ".break_tool-*"))
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || now.Sub(info.ModTime()) < sessionTTL {
+			continue
+		}
+		_ = os.Remove(m)
+	}
+}
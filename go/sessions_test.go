@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSweepKeepsDelayedSessionUntilDeadline(t *testing.T) {
+	t.Setenv("CHAOS_SESSION_DIR", t.TempDir())
+	t.Setenv("CHAOS_BUILD_CACHE", t.TempDir())
+	s, err := newSession("agent-1", "breaks/cheap/file_swap", "", 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sessionsMu.Lock()
+		delete(sessions, s.ID)
+		sessionsMu.Unlock()
+	})
+
+	for _, after := range []time.Duration{sessionTTL, time.Hour, 2*time.Hour + cycleTimeout} {
+		sweepOnce(s.Started.Add(after))
+		if _, err := os.Stat(s.LocalDir); err != nil {
+			t.Fatalf("live session swept %s after start: %v", after, err)
+		}
+	}
+	sweepOnce(s.deadline().Add(sessionTTL))
+	if _, err := os.Stat(s.LocalDir); !os.IsNotExist(err) {
+		t.Errorf("session %s past deadline+TTL still has %s (%v)", s.ID, s.LocalDir, err)
+	}
+}
+
+func TestSweepRemovesFinishedSession(t *testing.T) {
+	t.Setenv("CHAOS_SESSION_DIR", t.TempDir())
+	t.Setenv("CHAOS_BUILD_CACHE", t.TempDir())
+	s, err := newSession("agent-1", "breaks/cheap/file_swap", "", 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finishSession(s)
+	sweepOnce(time.Now())
+	if _, err := os.Stat(s.LocalDir); !os.IsNotExist(err) {
+		t.Errorf("finished session still has %s (%v)", s.LocalDir, err)
+	}
+	sessionsMu.Lock()
+	_, kept := sessions[s.ID]
+	sessionsMu.Unlock()
+	if kept {
+		t.Errorf("finished session %s still tracked", s.ID)
+	}
+}
@@ -0,0 +1,159 @@
+// Description: Monitor side of the originals vault.
+// Each session gets its own vault key, generated here and kept only in the monitor's state
+// directory; the break seals every file's original under it before mutating the file.
+// In "monitor" vault mode (CHAOS_VAULT_MODE=monitor) the sealed originals stream back as
+// "vault" reports and are stored under the state directory instead of on the testenv.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"chaos-agent/library/vault"
+)
+
+// vaultMode is where breaks keep originals: "local" (default) or "monitor".
+func vaultMode() string {
+	if m := os.Getenv("CHAOS_VAULT_MODE"); m != "" {
+		return m
+	}
+	return "local"
+}
+
+func vaultKeyPath(token string) (string, error) {
+	if !vault.ValidToken(token) {
+		return "", fmt.Errorf("invalid session token %q", token)
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vault-keys", token), nil
+}
+
+// newSessionVaultKey generates and records the vault key for a session.
+func newSessionVaultKey(token string) (string, error) {
+	key, err := vault.GenerateKey()
+	if err != nil {
+		return "", fmt.Errorf("generate vault key: %w", err)
+	}
+	if err := saveVaultKey(token, key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// saveVaultKey records token's vault key unless one is already recorded.
+func saveVaultKey(token, key string) error {
+	if _, err := vault.ParseKey(key); err != nil {
+		return err
+	}
+	p, err := vaultKeyPath(token)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	// #nosec G304 -- monitor's own state file, token validated above
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sessionVaultKey returns the recorded base64 vault key for token.
+func sessionVaultKey(token string) (string, error) {
+	p, err := vaultKeyPath(token)
+	if err != nil {
+		return "", err
+	}
+	// #nosec G304 -- monitor's own state file, token validated above
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("vault key for %s: %w", token, err)
+	}
+	key := strings.TrimSpace(string(b))
+	if _, err := vault.ParseKey(key); err != nil {
+		return "", fmt.Errorf("vault key for %s: %w", token, err)
+	}
+	return key, nil
+}
+
+// outstandingSessions lists sessions with a vault key that have not been rolled back.
+func outstandingSessions() ([]string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(filepath.Join(dir, "vault-keys"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range ents {
+		if e.Type().IsRegular() && vault.ValidToken(e.Name()) {
+			out = append(out, e.Name())
+		}
+	}
+	return out, nil
+}
+
+// markRolledBack retires token's key so the session is no longer outstanding.
+// The key is kept (as <token>.done) in case the vault is still needed.
+func markRolledBack(token string) error {
+	p, err := vaultKeyPath(token)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p, p+".done")
+}
+
+// monitorVault is where streamed originals are kept. One store is shared so concurrent
+// reports for a session go through its lock.
+var monitorVault = sync.OnceValues(func() (*vault.DirStore, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	return &vault.DirStore{Root: filepath.Join(dir, "vault")}, nil
+})
+
+// storeVaultChunk stores one streamed "vault" report for token.
+func storeVaultChunk(token, message string) error {
+	var c vault.Chunk
+	if err := json.Unmarshal([]byte(message), &c); err != nil {
+		return fmt.Errorf("decode vault chunk: %w", err)
+	}
+	if c.Entry.Token != token {
+		return fmt.Errorf("vault chunk for %q sent by session %q", c.Entry.Token, token)
+	}
+	data, err := base64.StdEncoding.DecodeString(c.Data)
+	if err != nil {
+		return fmt.Errorf("decode vault chunk: %w", err)
+	}
+	store, err := monitorVault()
+	if err != nil {
+		return err
+	}
+	_, err = store.PutChunk(c.Entry, c.Offset, data)
+	return err
+}